@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/openvibe/agent/internal/opencode"
 	"github.com/openvibe/agent/internal/project"
@@ -19,12 +25,49 @@ func main() {
 	agentID := flag.String("id", "", "Agent ID (defaults to hostname)")
 	token := flag.String("token", "", "Authentication token (or use OPENVIBE_AGENT_TOKEN env)")
 	opencodeURL := flag.String("opencode", "http://localhost:4096", "OpenCode server URL (default for single-project mode)")
+	opencodeURLs := flag.String("opencode-urls", "", "Comma-separated additional OpenCode URLs clients may target explicitly")
+	promptRetries := flag.Int("prompt-retries", opencode.DefaultPromptRetries, "Retries of a prompt OpenCode answers with 429/502/503/504 (0 = never)")
+	promptBackoff := flag.Duration("prompt-retry-backoff", opencode.DefaultPromptRetryBackoff, "Delay before the first prompt retry, doubled after each")
+	sessionFormat := flag.String("session-create-format", opencode.SessionCreateFlat, "OpenCode session create body: flat {title, directory}, query (directory as query parameter) or nested {session: {...}}")
+	opencodeHeaders := flag.String("opencode-headers", "", "Extra headers for OpenCode requests as Name=value, comma-separated; {session} and {project} expand to the request's session ID and project path (or use OPENVIBE_OPENCODE_HEADERS env)")
+	maxHistory := flag.Int("max-history-bytes", opencode.DefaultMaxHistoryBytes, "Cap on a session.messages response; longer histories return their newest messages marked truncated, with a cursor for older ones (0 = no cap)")
+	maxSessions := flag.Int("max-sessions", 0, "Maximum sessions per OpenCode instance (0 = unlimited)")
+	sessionPolicy := flag.String("session-limit-policy", opencode.SessionPolicyReject, "At --max-sessions: reject new sessions, or evict the least recently active")
 
 	projectsFlag := flag.String("projects", "", "Comma-separated list of allowed project paths (or use OPENVIBE_PROJECTS env)")
 	portMin := flag.Int("port-min", 4096, "Minimum port for OpenCode instances")
 	portMax := flag.Int("port-max", 4105, "Maximum port for OpenCode instances")
+	deterministicPorts := flag.Bool("deterministic-ports", false, "Give each project a stable port derived from its path")
+	portState := flag.String("port-state-file", "", "File recording which project holds each port, so a restarted agent adopts containers still running (empty = don't persist)")
 	maxInstances := flag.Int("max-instances", 5, "Maximum concurrent OpenCode instances")
+	autoStart := flag.Bool("auto-start", true, "Start a stopped project's OpenCode on its first request; when false requests fail until project.start")
+	maxStarts := flag.Int("max-concurrent-starts", project.DefaultMaxConcurrentStarts, "Maximum OpenCode containers starting at once; further starts queue")
 	dockerImage := flag.String("docker-image", "openvibe/opencode:latest", "Docker image for OpenCode containers")
+	dockerBinary := flag.String("docker-binary", project.DefaultDockerBinary, "Docker-compatible CLI for OpenCode containers (e.g., /usr/bin/podman)")
+	opencodeCmd := flag.String("opencode-cmd", project.DefaultOpenCodeCommand, "Command OpenCode containers run; {port} is the instance port, appended as --port if absent")
+	restartPolicy := flag.String("restart-policy", "", "Docker restart policy for OpenCode containers: no, on-failure[:N] or unless-stopped (default docker's, no)")
+	dockerHost := flag.String("docker-host", "", "Docker daemon for OpenCode containers (default DOCKER_HOST env)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Stop unpinned OpenCode instances idle this long (0 = never)")
+	idleTimeouts := flag.String("idle-timeouts", "", "Per-project idle timeouts overriding --idle-timeout (e.g., ~/big=10m,~/main=0)")
+	opencodeConfig := flag.String("opencode-config", "", "OpenCode config file mounted into every OpenCode container (as OPENCODE_CONFIG)")
+	opencodeConfigs := flag.String("opencode-configs", "", "Per-project OpenCode config files overriding --opencode-config (e.g., ~/main=~/main-opencode.json)")
+	systemPreambles := flag.String("system-preambles", "", "Per-project files whose text is sent as a system instruction with every prompt (e.g., ~/main=~/main-preamble.md)")
+	pullTimeout := flag.Duration("image-pull-timeout", project.DefaultImagePullTimeout, "Kill a pull of the OpenCode image (before or during docker run) taking longer, failing the start")
+	healthTimeout := flag.Duration("health-timeout", project.DefaultHealthTimeout, "How long a started OpenCode container has to pass a health check")
+	probeTimeout := flag.Duration("health-probe-timeout", project.DefaultHealthProbeTimeout, "Timeout of each OpenCode health check request, within --health-timeout")
+	refreshInterval := flag.Duration("refresh-interval", 30*time.Second, "How often to check OpenCode containers are still running (0 = never)")
+	forwardSSHAgent := flag.Bool("forward-ssh-agent", false, "Mount the host's SSH agent ($SSH_AUTH_SOCK) into OpenCode containers so git over SSH works; any prompt can then use your keys")
+	gitCredentials := flag.String("git-credentials", "", "git-credentials file mounted read-only into OpenCode containers as git's credential store; any prompt can then read it")
+	leaveRunning := flag.Bool("leave-running", false, "Leave OpenCode containers running when the agent exits")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "Maximum time to wait for containers to stop on shutdown")
+	allowedActions := flag.String("allowed-actions", "", "Comma-separated actions this agent executes (default all; see AGENTS.md)")
+	maxAttempts := flag.Int("max-reconnect-attempts", 0, "Exit after this many consecutive failed hub connections (0 = retry forever)")
+	hubReadTimeout := flag.Duration("hub-read-timeout", tunnel.DefaultReadTimeout, "Reconnect when the hub connection receives nothing (not even a ping) for this long")
+	hubWriteTimeout := flag.Duration("hub-write-timeout", tunnel.DefaultWriteTimeout, "Reconnect when a write to the hub blocks this long")
+	connectTimeout := flag.Duration("connect-timeout", tunnel.DefaultDialTimeout, "Timeout for dialing and registering with the hub")
+	tunnelDebug := flag.Bool("tunnel-debug", false, "Log every hub tunnel message (debugging only, logs payload excerpts)")
+	maxRequests := flag.Int("max-concurrent-requests", 0, "Maximum requests handled at once; others wait, prompts ahead of history and file reads (0 = unlimited)")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Minute, "Maximum time to wait for in-flight requests after SIGUSR1")
 
 	flag.Parse()
 
@@ -48,52 +91,393 @@ func main() {
 	log.Printf("  Agent ID: %s", id)
 	log.Printf("  Hub URL: %s", *hubURL)
 
-	opencodeClient := opencode.NewClient(*opencodeURL)
+	extraURLs := splitList(*opencodeURLs)
+	for _, u := range extraURLs {
+		log.Printf("  Allowed OpenCode URL: %s", u)
+	}
+	opencodeClient := opencode.NewClient(*opencodeURL, extraURLs...)
+	opencodeClient.SetPromptRetry(*promptRetries, *promptBackoff)
+	if *sessionPolicy != opencode.SessionPolicyReject && *sessionPolicy != opencode.SessionPolicyEvict {
+		log.Fatalf("Invalid --session-limit-policy: %s (want reject or evict)", *sessionPolicy)
+	}
+	opencodeClient.SetSessionLimit(*maxSessions, *sessionPolicy)
+	if !slices.Contains(opencode.SessionCreateFormats, *sessionFormat) {
+		log.Fatalf("Invalid --session-create-format: %s (want %s)", *sessionFormat, strings.Join(opencode.SessionCreateFormats, ", "))
+	}
+	opencodeClient.SetSessionCreateFormat(*sessionFormat)
+	opencodeClient.SetMaxHistoryBytes(*maxHistory)
+	headerList := *opencodeHeaders
+	if headerList == "" {
+		headerList = os.Getenv("OPENVIBE_OPENCODE_HEADERS")
+	}
+	headers, err := parseHeaders(headerList)
+	if err != nil {
+		log.Fatalf("Invalid --opencode-headers: %v", err)
+	}
+	opencodeClient.SetHeaders(headers)
+	if len(headers) > 0 {
+		log.Printf("  OpenCode headers: %s", describeHeaders(headers))
+	}
+	if *maxSessions > 0 {
+		log.Printf("  Session limit: %d per instance (%s)", *maxSessions, *sessionPolicy)
+	}
 
 	var projectMgr *project.Manager
 	if projects != "" {
-		allowedPaths := parseProjectPaths(projects)
+		allowedPaths := project.DedupPaths(parseProjectPaths(projects))
 		log.Printf("  Multi-project mode: %d projects configured", len(allowedPaths))
 		for _, p := range allowedPaths {
 			log.Printf("    - %s", p)
 		}
 
+		overrides, err := parseIdleTimeouts(*idleTimeouts)
+		if err != nil {
+			log.Fatalf("Invalid --idle-timeouts: %v", err)
+		}
+
+		preambles, err := parsePreambles(*systemPreambles)
+		if err != nil {
+			log.Fatalf("Invalid --system-preambles: %v", err)
+		}
+
+		configFile := ""
+		if *opencodeConfig != "" {
+			if configFile, err = existingFile(*opencodeConfig); err != nil {
+				log.Fatalf("Invalid --opencode-config: %v", err)
+			}
+		}
+		configFiles, err := parseConfigFiles(*opencodeConfigs)
+		if err != nil {
+			log.Fatalf("Invalid --opencode-configs: %v", err)
+		}
+
+		dockerPath, err := project.ResolveDockerBinary(*dockerBinary)
+		if err != nil {
+			log.Fatalf("Invalid --docker-binary: %v", err)
+		}
+		log.Printf("  Docker binary: %s", dockerPath)
+
+		serveCommand, err := project.ParseOpenCodeCommand(*opencodeCmd)
+		if err != nil {
+			log.Fatalf("Invalid --opencode-cmd: %v", err)
+		}
+		if *dockerHost != "" {
+			log.Printf("  Docker host: %s", *dockerHost)
+		}
+		if err := project.ValidateRestartPolicy(*restartPolicy); err != nil {
+			log.Fatalf("Invalid --restart-policy: %v", err)
+		}
+
+		if *healthTimeout <= 0 || *probeTimeout <= 0 {
+			log.Fatalf("Invalid --health-timeout/--health-probe-timeout: must be positive")
+		}
+
+		sshAuthSock := ""
+		if *forwardSSHAgent {
+			sshAuthSock = os.Getenv("SSH_AUTH_SOCK")
+			if sshAuthSock == "" {
+				log.Fatalf("Invalid --forward-ssh-agent: SSH_AUTH_SOCK is not set")
+			}
+			if info, err := os.Stat(sshAuthSock); err != nil || info.Mode()&os.ModeSocket == 0 {
+				log.Fatalf("Invalid --forward-ssh-agent: %s is not a socket", sshAuthSock)
+			}
+			log.Printf("  WARNING: forwarding SSH agent %s into OpenCode containers", sshAuthSock)
+		}
+		credentialsFile := ""
+		if *gitCredentials != "" {
+			if credentialsFile, err = existingFile(*gitCredentials); err != nil {
+				log.Fatalf("Invalid --git-credentials: %v", err)
+			}
+			log.Printf("  WARNING: mounting git credentials %s into OpenCode containers", credentialsFile)
+		}
+
+		portStateFile := ""
+		if *portState != "" {
+			if portStateFile, err = expandPath(*portState); err != nil {
+				log.Fatalf("Invalid --port-state-file: %v", err)
+			}
+		}
+
 		projectMgr = project.NewManager(&project.Config{
 			AllowedPaths: allowedPaths,
 			PortMin:      *portMin,
 			PortMax:      *portMax,
 			MaxInstances: *maxInstances,
 			DockerImage:  *dockerImage,
+			DockerBinary: dockerPath,
+			DockerHost:   *dockerHost,
+			IdleTimeout:  *idleTimeout,
+			IdleTimeouts: overrides,
+
+			OpenCodeCommand:     serveCommand,
+			MaxConcurrentStarts: *maxStarts,
+			DeterministicPorts:  *deterministicPorts,
+			PortStateFile:       portStateFile,
+			HealthTimeout:       *healthTimeout,
+			HealthProbeTimeout:  *probeTimeout,
+			ImagePullTimeout:    *pullTimeout,
+			SSHAuthSock:         sshAuthSock,
+			GitCredentialsFile:  credentialsFile,
+			SystemPreambles:     preambles,
+			OpenCodeConfig:      configFile,
+			OpenCodeConfigs:     configFiles,
+			ManualStart:         !*autoStart,
+			RestartPolicy:       *restartPolicy,
 		})
 	} else {
 		log.Printf("  Single-project mode: %s", *opencodeURL)
 	}
 
 	client := tunnel.NewClient(*hubURL, id, authToken, opencodeClient, projectMgr)
+	client.SetReconnectPolicy(*maxAttempts, *connectTimeout)
+	client.SetTimeouts(*hubReadTimeout, *hubWriteTimeout)
+	client.SetMaxConcurrentRequests(*maxRequests)
+	if *tunnelDebug {
+		log.Println("WARNING: --tunnel-debug logs tunnel payload excerpts; do not use in production.")
+		client.SetDebug(true)
+	}
+	if actions := splitList(*allowedActions); len(actions) > 0 {
+		for _, action := range actions {
+			if !slices.Contains(tunnel.Actions, action) {
+				log.Fatalf("Unknown action in --allowed-actions: %s", action)
+			}
+		}
+		log.Printf("  Allowed actions: %s", strings.Join(actions, ", "))
+		client.SetAllowedActions(actions)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if projectMgr != nil && (*idleTimeout > 0 || *idleTimeouts != "") {
+		go projectMgr.RunCleanup(ctx, time.Minute)
+	}
+	if projectMgr != nil && *refreshInterval > 0 {
+		go projectMgr.RunRefresh(ctx, *refreshInterval)
+	}
+
 	go func() {
 		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-		log.Println("Shutting down...")
-		cancel()
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
+		for sig := range sigChan {
+			if sig == syscall.SIGUSR1 {
+				// Drain for rolling restarts: finish in-flight work, then exit
+				log.Println("Draining...")
+				go func() {
+					drainCtx, drainCancel := context.WithTimeout(ctx, *drainTimeout)
+					defer drainCancel()
+					if err := client.Drain(drainCtx); err != nil {
+						log.Printf("Drain incomplete: %v", err)
+					}
+					log.Println("Shutting down...")
+					cancel()
+				}()
+				continue
+			}
+
+			log.Println("Shutting down...")
+			cancel()
+			return
+		}
 	}()
 
-	if err := client.Run(ctx); err != nil {
-		log.Fatalf("Agent error: %v", err)
+	exitCode := 0
+	if err := client.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Agent error: %v", err)
+		exitCode = 1
+	}
+
+	if projectMgr != nil && !*leaveRunning {
+		log.Printf("Stopping OpenCode containers (timeout %v)...", *shutdownTimeout)
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		if err := projectMgr.StopAll(stopCtx); err != nil {
+			log.Printf("WARNING: Some containers failed to stop: %v", err)
+		}
+		stopCancel()
+	}
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
 
+// parseProjectPaths splits input and expands environment variables and a
+// leading ~ in each path, producing clean absolute paths.
 func parseProjectPaths(input string) []string {
 	var paths []string
-	for _, p := range strings.Split(input, ",") {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			paths = append(paths, p)
+	for _, p := range splitList(input) {
+		resolved, err := expandPath(p)
+		if err != nil {
+			log.Printf("WARNING: Cannot resolve project path %q: %v", p, err)
+			continue
 		}
+		if resolved != p {
+			log.Printf("  Resolved %s -> %s", p, resolved)
+		}
+		paths = append(paths, resolved)
 	}
 	return paths
 }
+
+// parseIdleTimeouts parses "path=duration" pairs separated by commas
+func parseIdleTimeouts(input string) (map[string]time.Duration, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	timeouts := make(map[string]time.Duration, len(items))
+	for _, item := range items {
+		path, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected path=duration, got %q", item)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid timeout for %s: %q", path, value)
+		}
+		resolved, err := expandPath(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", path, err)
+		}
+		timeouts[resolved] = timeout
+	}
+	return timeouts, nil
+}
+
+// parsePreambles parses "path=file" pairs separated by commas, reading each file
+func parsePreambles(input string) (map[string]string, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	preambles := make(map[string]string, len(items))
+	for _, item := range items {
+		path, file, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected path=file, got %q", item)
+		}
+		resolved, err := expandPath(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", path, err)
+		}
+		file, err = expandPath(strings.TrimSpace(file))
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", file, err)
+		}
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		preambles[resolved] = strings.TrimSpace(string(text))
+	}
+	return preambles, nil
+}
+
+// parseConfigFiles parses "path=file" pairs separated by commas, checking
+// each file exists
+func parseConfigFiles(input string) (map[string]string, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	files := make(map[string]string, len(items))
+	for _, item := range items {
+		path, file, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected path=file, got %q", item)
+		}
+		resolved, err := expandPath(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", path, err)
+		}
+		if files[resolved], err = existingFile(strings.TrimSpace(file)); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// existingFile expands file and checks it is a regular file
+func existingFile(file string) (string, error) {
+	resolved, err := expandPath(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", file, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file", resolved)
+	}
+	return resolved, nil
+}
+
+// parseHeaders parses "Name=value" pairs separated by commas
+func parseHeaders(input string) (map[string]string, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	headers := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " :\t") {
+			return nil, fmt.Errorf("expected Name=value, got %q", name)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// describeHeaders lists headers for the startup log, hiding the values of
+// ones that look like credentials
+func describeHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := headers[name]
+		lower := strings.ToLower(name)
+		for _, hint := range []string{"auth", "key", "token", "secret", "password", "cookie", "signature"} {
+			if strings.Contains(lower, hint) {
+				value = "<redacted>"
+				break
+			}
+		}
+		parts[i] = name + "=" + value
+	}
+	return strings.Join(parts, ", ")
+}
+
+func expandPath(p string) (string, error) {
+	p = os.ExpandEnv(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+	}
+	return filepath.Abs(p)
+}
+
+func splitList(input string) []string {
+	var items []string
+	for _, item := range strings.Split(input, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -8,19 +8,86 @@ import (
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+// CodeSessionNotFound is the error code returned when OpenCode has no such session
+const CodeSessionNotFound = "session_not_found"
+
+// providerCacheTTL is how long a provider.list result is reused; the list
+// only changes when OpenCode's config does
+const providerCacheTTL = time.Minute
+
+// Prompt retry defaults, see SetPromptRetry
+const (
+	DefaultPromptRetries      = 2
+	DefaultPromptRetryBackoff = time.Second
+
+	// maxRetryAfter caps how long an OpenCode Retry-After header can delay a retry
+	maxRetryAfter = 30 * time.Second
 )
 
 type Client struct {
-	defaultURL string
-	httpClient *http.Client
+	defaultURL  string
+	allowedURLs map[string]bool
+	httpClient  *http.Client
+
+	providers   map[string]cachedProviders // provider.list results by base URL
+	providersMu sync.Mutex
+
+	promptRetries int           // Retries of a prompt after a transient status
+	promptBackoff time.Duration // Delay before the first retry, doubled after each
+
+	sessionCreateFormat string // POST /session body shape, see SetSessionCreateFormat
+
+	maxHistoryBytes int // session.messages size cap, see SetMaxHistoryBytes
+
+	maxSessions    int    // Sessions per instance, 0 = unlimited
+	sessionPolicy  string // SessionPolicyReject or SessionPolicyEvict
+	sessionLimitMu sync.Mutex
 }
 
-func NewClient(defaultURL string) *Client {
-	return &Client{
-		defaultURL: strings.TrimSuffix(defaultURL, "/"),
-		httpClient: &http.Client{},
+type cachedProviders struct {
+	body    []byte
+	fetched time.Time
+}
+
+// NewClient creates a client for defaultURL. Requests may also explicitly
+// target any of allowedURLs; the default URL is always allowed.
+func NewClient(defaultURL string, allowedURLs ...string) *Client {
+	c := &Client{
+		defaultURL:  strings.TrimSuffix(defaultURL, "/"),
+		allowedURLs: make(map[string]bool),
+		httpClient:  &http.Client{},
+		providers:   make(map[string]cachedProviders),
+
+		promptRetries: DefaultPromptRetries,
+		promptBackoff: DefaultPromptRetryBackoff,
+
+		maxHistoryBytes: DefaultMaxHistoryBytes,
+	}
+	c.allowedURLs[c.defaultURL] = true
+	for _, u := range allowedURLs {
+		c.allowedURLs[strings.TrimSuffix(u, "/")] = true
 	}
+	return c
+}
+
+// SetPromptRetry sets how many times a prompt is retried when OpenCode answers
+// with a transient status (429 or 5xx gateway/unavailable), waiting backoff
+// before the first retry and doubling it after each. 0 retries disables it.
+func (c *Client) SetPromptRetry(retries int, backoff time.Duration) {
+	c.promptRetries = retries
+	c.promptBackoff = backoff
+}
+
+// AllowsURL reports whether a request may explicitly target baseURL
+func (c *Client) AllowsURL(baseURL string) bool {
+	return c.allowedURLs[strings.TrimSuffix(baseURL, "/")]
 }
 
 type SessionInfo struct {
@@ -30,6 +97,9 @@ type SessionInfo struct {
 
 type PromptRequest struct {
 	Parts []PromptPart `json:"parts"`
+	// System is a system instruction for this prompt. OpenCode doesn't keep
+	// it in the session, so it is sent with every prompt.
+	System string `json:"system,omitempty"`
 }
 
 type PromptPart struct {
@@ -39,6 +109,25 @@ type PromptPart struct {
 
 type PromptData struct {
 	Content string `json:"content"`
+	System  string `json:"system,omitempty"`
+
+	// Directory is OpenCode's working directory for this prompt only
+	Directory string `json:"directory,omitempty"`
+}
+
+// WithSystem adds a system instruction to prompt data, after any the data
+// already carries
+func WithSystem(data json.RawMessage, system string) json.RawMessage {
+	var prompt PromptData
+	if system == "" || json.Unmarshal(data, &prompt) != nil {
+		return data
+	}
+	if prompt.System != "" {
+		system = prompt.System + "\n\n" + system
+	}
+	prompt.System = system
+	merged, _ := json.Marshal(prompt)
+	return merged
 }
 
 type SessionCreateData struct {
@@ -46,9 +135,26 @@ type SessionCreateData struct {
 	Directory string `json:"directory,omitempty"`
 }
 
+// MessagesData selects a page of message history. A zero Limit returns everything.
+type MessagesData struct {
+	Limit  int    `json:"limit,omitempty"`
+	Before string `json:"before,omitempty"` // Cursor: return messages older than this message ID
+}
+
+// MessagesPage is a bounded page of message history, newest page first
+type MessagesPage struct {
+	Messages []json.RawMessage `json:"messages"`
+	Cursor   string            `json:"cursor,omitempty"` // Pass as Before to fetch the previous page
+	HasMore  bool              `json:"hasMore"`
+	// Truncated means the size cap, not the requested limit, ended the page:
+	// page through the rest with Cursor
+	Truncated bool `json:"truncated,omitempty"`
+}
+
 type OpenCodeResponse struct {
 	Info  json.RawMessage `json:"info"`
 	Parts []struct {
+		ID   string `json:"id,omitempty"`
 		Type string `json:"type"`
 		Text string `json:"text,omitempty"`
 	} `json:"parts"`
@@ -75,11 +181,17 @@ func (c *Client) HandleRequestWithURL(ctx context.Context, baseURL, sessionID, a
 		case "session.list":
 			c.handleSessionList(ctx, baseURL, ch)
 		case "session.messages":
-			c.handleSessionMessages(ctx, baseURL, sessionID, ch)
+			c.handleSessionMessages(ctx, baseURL, sessionID, data, ch)
 		case "session.delete":
 			c.handleSessionDelete(ctx, baseURL, sessionID, ch)
+		case "session.rename":
+			c.handleSessionRename(ctx, baseURL, sessionID, data, ch)
 		case "prompt":
 			c.handlePrompt(ctx, baseURL, sessionID, data, ch)
+		case "provider.list":
+			c.handleProviderList(ctx, baseURL, ch)
+		case "agent.stats":
+			c.handleStats(ctx, baseURL, ch)
 		default:
 			errPayload, _ := json.Marshal(map[string]string{"error": "unknown action: " + action})
 			ch <- errPayload
@@ -94,14 +206,20 @@ func (c *Client) handleSessionCreate(ctx context.Context, baseURL string, data j
 	var createData SessionCreateData
 	json.Unmarshal(data, &createData)
 
-	reqBody := map[string]string{"title": createData.Title}
-	if createData.Directory != "" {
-		reqBody["directory"] = createData.Directory
+	path, body := c.sessionCreateRequest(createData)
+
+	if c.maxSessions > 0 {
+		c.sessionLimitMu.Lock()
+		defer c.sessionLimitMu.Unlock()
+		if err := c.makeRoomForSession(ctx, baseURL); err != nil {
+			errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+			ch <- errPayload
+			return
+		}
 	}
-	body, _ := json.Marshal(reqBody)
 	log.Printf("[OpenCode] Creating session with body: %s", string(body))
 
-	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/session", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+path, bytes.NewReader(body))
 	if err != nil {
 		log.Printf("[OpenCode] Request creation failed: %v", err)
 		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
@@ -110,7 +228,7 @@ func (c *Client) handleSessionCreate(ctx context.Context, baseURL string, data j
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	log.Printf("[OpenCode] Sending request to %s", baseURL+"/session")
+	log.Printf("[OpenCode] Sending request to %s", baseURL+path)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		log.Printf("[OpenCode] HTTP request failed: %v", err)
@@ -123,39 +241,86 @@ func (c *Client) handleSessionCreate(ctx context.Context, baseURL string, data j
 	respBody, _ := io.ReadAll(resp.Body)
 	log.Printf("[OpenCode] Got response: %s", string(respBody))
 
+	if err := nonJSON(resp, respBody); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errPayload, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("session create failed: status %d: %s (try --session-create-format)", resp.StatusCode, respBody),
+		})
+		ch <- errPayload
+		return
+	}
+
+	session, err := sessionFromResponse(respBody)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
 	if createData.Directory != "" {
-		var respData map[string]interface{}
-		if err := json.Unmarshal(respBody, &respData); err == nil {
-			respData["directory"] = createData.Directory
-			modifiedResp, _ := json.Marshal(respData)
-			log.Printf("[OpenCode] Sending modified response to channel")
-			ch <- modifiedResp
-			return
-		}
+		session["directory"] = createData.Directory
 	}
+	payload, _ := json.Marshal(session)
 	log.Printf("[OpenCode] Sending response to channel")
-	ch <- respBody
+	ch <- payload
 }
 
 func (c *Client) handleSessionList(ctx context.Context, baseURL string, ch chan<- []byte) {
 	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/session", nil)
 	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
 		return
 	}
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": "session list failed: " + err.Error()})
+		ch <- errPayload
 		return
 	}
 	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": "session list failed: " + err.Error()})
+		ch <- errPayload
+		return
+	}
+	if err := nonJSON(resp, respBody); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		errPayload, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("session list failed: status %d: %s", resp.StatusCode, snippet(respBody)),
+		})
+		ch <- errPayload
+		return
+	}
 	ch <- respBody
 }
 
-func (c *Client) handleSessionMessages(ctx context.Context, baseURL, sessionID string, ch chan<- []byte) {
+func (c *Client) handleSessionMessages(ctx context.Context, baseURL, sessionID string, data json.RawMessage, ch chan<- []byte) {
+	var page MessagesData
+	if len(data) > 0 {
+		json.Unmarshal(data, &page)
+	}
+
 	url := fmt.Sprintf("%s/session/%s/message", baseURL, sessionID)
+	if page.Limit > 0 {
+		query := neturl.Values{}
+		query.Set("limit", strconv.Itoa(page.Limit))
+		if page.Before != "" {
+			query.Set("before", page.Before)
+		}
+		url += "?" + query.Encode()
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
@@ -173,14 +338,33 @@ func (c *Client) handleSessionMessages(ctx context.Context, baseURL, sessionID s
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		errBody, _ := io.ReadAll(resp.Body)
-		errPayload, _ := json.Marshal(map[string]string{"error": string(errBody)})
+		ch <- errorPayload(resp, sessionID)
+		return
+	}
+
+	// Decoded message by message so a huge history never sits in memory
+	payload, err := c.historyPayload(resp, page)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
 		ch <- errPayload
 		return
 	}
+	ch <- payload
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	ch <- respBody
+// messageID extracts the ID from an OpenCode message ({"info":{"id":...}} or {"id":...})
+func messageID(raw json.RawMessage) string {
+	var msg struct {
+		ID   string `json:"id"`
+		Info struct {
+			ID string `json:"id"`
+		} `json:"info"`
+	}
+	json.Unmarshal(raw, &msg)
+	if msg.Info.ID != "" {
+		return msg.Info.ID
+	}
+	return msg.ID
 }
 
 func (c *Client) handleSessionDelete(ctx context.Context, baseURL, sessionID string, ch chan<- []byte) {
@@ -201,9 +385,7 @@ func (c *Client) handleSessionDelete(ctx context.Context, baseURL, sessionID str
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		errBody, _ := io.ReadAll(resp.Body)
-		errPayload, _ := json.Marshal(map[string]string{"error": string(errBody)})
-		ch <- errPayload
+		ch <- errorPayload(resp, sessionID)
 		return
 	}
 
@@ -211,6 +393,98 @@ func (c *Client) handleSessionDelete(ctx context.Context, baseURL, sessionID str
 	ch <- successPayload
 }
 
+// handleProviderList returns OpenCode's configured providers and their
+// models, cached for providerCacheTTL
+func (c *Client) handleProviderList(ctx context.Context, baseURL string, ch chan<- []byte) {
+	c.providersMu.Lock()
+	cached, ok := c.providers[baseURL]
+	c.providersMu.Unlock()
+	if ok && time.Since(cached.fetched) < providerCacheTTL {
+		ch <- cached.body
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/config/providers", nil)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	// Checked before caching so a gateway's error page isn't served for
+	// providerCacheTTL
+	if err := nonJSON(resp, body); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		errPayload, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("opencode error: status %d, body: %s", resp.StatusCode, snippet(body)),
+		})
+		ch <- errPayload
+		return
+	}
+
+	c.providersMu.Lock()
+	c.providers[baseURL] = cachedProviders{body: body, fetched: time.Now()}
+	c.providersMu.Unlock()
+	ch <- body
+}
+
+func (c *Client) handleSessionRename(ctx context.Context, baseURL, sessionID string, data json.RawMessage, ch chan<- []byte) {
+	var renameData struct {
+		Title string `json:"title"`
+	}
+	json.Unmarshal(data, &renameData)
+
+	body, _ := json.Marshal(map[string]string{"title": renameData.Title})
+	url := fmt.Sprintf("%s/session/%s", baseURL, sessionID)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(body))
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		ch <- errorPayload(resp, sessionID)
+		return
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if err := nonJSON(resp, respBody); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
+	ch <- respBody
+}
+
 func (c *Client) handlePrompt(ctx context.Context, baseURL, sessionID string, data json.RawMessage, ch chan<- []byte) {
 	var promptData PromptData
 	json.Unmarshal(data, &promptData)
@@ -219,42 +493,134 @@ func (c *Client) handlePrompt(ctx context.Context, baseURL, sessionID string, da
 		Parts: []PromptPart{
 			{Type: "text", Text: promptData.Content},
 		},
+		System: promptData.System,
 	}
 
 	body, _ := json.Marshal(promptReq)
 	url := fmt.Sprintf("%s/session/%s/message", baseURL, sessionID)
+	if promptData.Directory != "" {
+		url += "?" + neturl.Values{"directory": {promptData.Directory}}.Encode()
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
-	if err != nil {
-		return
+	// fail reports a prompt that got no usable response, whatever earlier
+	// attempts returned
+	fail := func(err error) {
+		message := "prompt failed: " + err.Error()
+		if ctx.Err() != nil {
+			message = "request cancelled"
+		}
+		errPayload, _ := json.Marshal(map[string]string{"error": message})
+		ch <- errPayload
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return
+	// OpenCode answers with the whole reply at once, so nothing has been
+	// streamed when a transient failure is retried
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			fail(err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		resp, err = c.httpClient.Do(req)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !retryableStatus(resp.StatusCode) || attempt >= c.promptRetries {
+			break
+		}
+
+		delay := retryDelay(resp, c.promptBackoff<<attempt)
+		resp.Body.Close()
+		log.Printf("[OpenCode] Prompt for session %s got status %d, retrying in %v", sessionID, resp.StatusCode, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			fail(ctx.Err())
+			return
+		}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		errBody, _ := io.ReadAll(resp.Body)
-		errPayload, _ := json.Marshal(map[string]string{"error": string(errBody)})
-		ch <- errPayload
+		ch <- errorPayload(resp, sessionID)
 		return
 	}
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": "reading prompt response failed: " + err.Error()})
+		ch <- errPayload
+		return
+	}
+	if err := nonJSON(resp, respBody); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
+		ch <- errPayload
+		return
+	}
 	var ocResp OpenCodeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ocResp); err != nil {
+	if err := json.Unmarshal(respBody, &ocResp); err != nil {
+		errPayload, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("unexpected prompt response (%v): %s", err, snippet(respBody)),
+		})
+		ch <- errPayload
 		return
 	}
 
 	for _, part := range ocResp.Parts {
 		if part.Type == "text" && part.Text != "" {
-			textPayload, _ := json.Marshal(map[string]string{"text": part.Text})
+			// partId lets clients replace a revised part instead of appending it
+			textPayload, _ := json.Marshal(map[string]string{"text": part.Text, "partId": part.ID})
 			ch <- textPayload
 		}
 	}
+	if usage, ok := usagePayload(ocResp.Info); ok {
+		chunk, _ := json.Marshal(map[string]json.RawMessage{"usage": usage})
+		ch <- chunk
+	}
+}
+
+// retryableStatus reports whether an OpenCode status is worth retrying: rate
+// limiting and temporary unavailability, not permanent 4xx errors
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retrying resp: its Retry-After
+// seconds when given (capped at maxRetryAfter), else backoff
+func retryDelay(resp *http.Response, backoff time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return min(time.Duration(seconds)*time.Second, maxRetryAfter)
+	}
+	return backoff
+}
+
+// errorPayload builds the error payload for a non-200 OpenCode response.
+// A 404 is reported as a structured session_not_found error so clients can recover.
+func errorPayload(resp *http.Response, sessionID string) []byte {
+	errBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		payload, _ := json.Marshal(map[string]string{
+			"error":     "session not found: " + sessionID,
+			"code":      CodeSessionNotFound,
+			"sessionId": sessionID,
+		})
+		return payload
+	}
+	message := string(errBody)
+	if err := nonJSON(resp, errBody); err != nil {
+		message = err.Error()
+	}
+	payload, _ := json.Marshal(map[string]string{"error": message})
+	return payload
 }
 
 func (c *Client) Health(ctx context.Context) error {
@@ -282,3 +648,37 @@ func (c *Client) HealthWithURL(ctx context.Context, baseURL string) error {
 	}
 	return nil
 }
+
+// Version returns the default instance's OpenCode version as reported by its
+// health endpoint
+func (c *Client) Version(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.defaultURL+"/global/health", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("opencode unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("opencode unhealthy: status %d", resp.StatusCode)
+	}
+
+	var health struct {
+		Version string `json:"version"`
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err := nonJSON(resp, body); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &health); err != nil {
+		return "", fmt.Errorf("failed to decode health: %w", err)
+	}
+	return health.Version, nil
+}
@@ -0,0 +1,228 @@
+package opencode
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collect runs action against c and returns its chunks
+func collect(t *testing.T, ctx context.Context, c *Client, sessionID, action string, data string) []map[string]interface{} {
+	t.Helper()
+	ch, err := c.HandleRequest(ctx, sessionID, action, json.RawMessage(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []map[string]interface{}
+	for chunk := range ch {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(chunk, &decoded); err != nil {
+			// Lists pass through as arrays
+			decoded = map[string]interface{}{"raw": string(chunk)}
+		}
+		chunks = append(chunks, decoded)
+	}
+	return chunks
+}
+
+// onlyError returns the error of a reply that must be a single error chunk
+func onlyError(t *testing.T, chunks []map[string]interface{}) string {
+	t.Helper()
+	if len(chunks) != 1 {
+		t.Fatalf("got %d chunks %v, want one error", len(chunks), chunks)
+	}
+	msg, ok := chunks[0]["error"].(string)
+	if !ok {
+		t.Fatalf("got %v, want an error", chunks[0])
+	}
+	return msg
+}
+
+// jsonServer answers every request with status and body as JSON
+func jsonServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSessionListErrors(t *testing.T) {
+	ctx := context.Background()
+
+	srv := jsonServer(t, http.StatusInternalServerError, `{"error":"database locked"}`)
+	msg := onlyError(t, collect(t, ctx, NewClient(srv.URL), "", "session.list", ""))
+	if !strings.Contains(msg, "status 500") || !strings.Contains(msg, "database locked") {
+		t.Errorf("error = %q, want the status and body", msg)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	onlyError(t, collect(t, ctx, NewClient(down.URL), "", "session.list", ""))
+
+	srv = jsonServer(t, http.StatusOK, `[{"id":"ses_1"}]`)
+	chunks := collect(t, ctx, NewClient(srv.URL), "", "session.list", "")
+	if len(chunks) != 1 || chunks[0]["raw"] != `[{"id":"ses_1"}]` {
+		t.Errorf("session.list = %v", chunks)
+	}
+}
+
+func TestPromptUnexpectedShape(t *testing.T) {
+	srv := jsonServer(t, http.StatusOK, `{"info":{},"parts":"not a list"}`)
+	msg := onlyError(t, collect(t, context.Background(), NewClient(srv.URL), "ses_1", "prompt", `{"content":"hi"}`))
+	if !strings.Contains(msg, `"parts":"not a list"`) {
+		t.Errorf("error = %q, want it to quote the response", msg)
+	}
+}
+
+// flakyServer answers prompts with each of statuses in turn, then with a
+// reply, counting the requests it received
+func flakyServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		w.Header().Set("Content-Type", "application/json")
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			w.Write([]byte(`{"error":"try again"}`))
+			return
+		}
+		w.Write([]byte(`{"info":{},"parts":[{"id":"prt_1","type":"text","text":"done"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestPromptRetriesTransientStatus(t *testing.T) {
+	srv, requests := flakyServer(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c := NewClient(srv.URL)
+	c.SetPromptRetry(2, time.Millisecond)
+
+	chunks := collect(t, context.Background(), c, "ses_1", "prompt", `{"content":"hi"}`)
+	if len(chunks) != 1 || chunks[0]["text"] != "done" {
+		t.Errorf("prompt = %v, want the reply after two 503s", chunks)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestPromptRetriesExhausted(t *testing.T) {
+	srv, requests := flakyServer(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c := NewClient(srv.URL)
+	c.SetPromptRetry(2, time.Millisecond)
+
+	onlyError(t, collect(t, context.Background(), c, "ses_1", "prompt", `{"content":"hi"}`))
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestPromptPermanentStatusNotRetried(t *testing.T) {
+	srv, requests := flakyServer(t, "", http.StatusBadRequest)
+	c := NewClient(srv.URL)
+	c.SetPromptRetry(2, time.Millisecond)
+
+	onlyError(t, collect(t, context.Background(), c, "ses_1", "prompt", `{"content":"hi"}`))
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests for a 400, want 1", n)
+	}
+}
+
+func TestPromptHonorsRetryAfter(t *testing.T) {
+	srv, _ := flakyServer(t, "1", http.StatusTooManyRequests)
+	c := NewClient(srv.URL)
+	// Without Retry-After the backoff would outlast the test
+	c.SetPromptRetry(1, time.Minute)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	chunks := collect(t, ctx, c, "ses_1", "prompt", `{"content":"hi"}`)
+	if len(chunks) != 1 || chunks[0]["text"] != "done" {
+		t.Fatalf("prompt = %v", chunks)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("retried after %v, want Retry-After's 1s", elapsed)
+	}
+}
+
+func TestPromptTransportErrorAfterRetry(t *testing.T) {
+	srv, _ := flakyServer(t, "", http.StatusServiceUnavailable)
+	c := NewClient(srv.URL)
+	c.SetPromptRetry(2, 100*time.Millisecond)
+
+	// The server is gone by the time the retry goes out
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.CloseClientConnections()
+		srv.Close()
+	}()
+	msg := onlyError(t, collect(t, context.Background(), c, "ses_1", "prompt", `{"content":"hi"}`))
+	if !strings.HasPrefix(msg, "prompt failed") {
+		t.Errorf("error = %q", msg)
+	}
+}
+
+func TestPromptCancelledDuringBackoff(t *testing.T) {
+	srv, _ := flakyServer(t, "", http.StatusServiceUnavailable)
+	c := NewClient(srv.URL)
+	c.SetPromptRetry(2, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if msg := onlyError(t, collect(t, ctx, c, "ses_1", "prompt", `{"content":"hi"}`)); msg != "request cancelled" {
+		t.Errorf("error = %q, want request cancelled", msg)
+	}
+}
+
+func TestPromptCancelAbortsRequest(t *testing.T) {
+	entered := make(chan struct{})
+	aborted := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only watches for a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		close(entered)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-entered
+		cancel()
+	}()
+
+	start := time.Now()
+	msg := onlyError(t, collect(t, ctx, c, "ses_1", "prompt", `{"content":"hi"}`))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("prompt returned %v after cancel", elapsed)
+	}
+	if msg != "request cancelled" {
+		t.Errorf("error = %q, want request cancelled", msg)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OpenCode request still open after cancel")
+	}
+}
@@ -0,0 +1,55 @@
+package opencode
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// projectKey carries the project a request is for, see WithProject
+type projectKey struct{}
+
+// WithProject tags ctx with the project path its OpenCode requests serve,
+// for "{project}" in configured headers
+func WithProject(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, projectKey{}, path)
+}
+
+// headerTransport adds configured headers to every OpenCode request, for
+// deployments fronting OpenCode with a gateway. "{session}" in a value is
+// replaced with the session ID from the request path and "{project}" with
+// the path given to WithProject, each "" when unknown.
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	project, _ := req.Context().Value(projectKey{}).(string)
+	expand := strings.NewReplacer("{session}", sessionFromPath(req.URL.Path), "{project}", project)
+	for name, value := range t.headers {
+		req.Header.Set(name, expand.Replace(value))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// SetHeaders adds headers to every request sent to OpenCode, expanding
+// "{session}" and "{project}" in values
+func (c *Client) SetHeaders(headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	c.httpClient.Transport = &headerTransport{headers: headers, next: http.DefaultTransport}
+}
+
+// sessionFromPath returns the ID in an OpenCode "/session/{id}/..." path
+func sessionFromPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/session/")
+	if !ok {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
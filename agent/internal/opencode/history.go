@@ -0,0 +1,142 @@
+package opencode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DefaultMaxHistoryBytes caps a session.messages response so a long history
+// doesn't have to be read and sent whole; older messages are paged in with
+// the cursor
+const DefaultMaxHistoryBytes = 768 << 10
+
+// SetMaxHistoryBytes caps the messages returned by session.messages. A
+// history over the cap is cut to its most recent messages that fit and
+// marked truncated; 0 disables the cap.
+func (c *Client) SetMaxHistoryBytes(n int) {
+	c.maxHistoryBytes = n
+}
+
+// readHistory decodes OpenCode's message array from r one message at a time,
+// keeping only the window a session.messages request asks for: the page.Limit
+// (0 = all) messages before page.Before, cut further to the newest that fit in
+// maxBytes (0 = no cap). Memory stays around maxBytes however long the history.
+// cut reports that maxBytes, rather than the limit, dropped messages.
+func readHistory(r io.Reader, page MessagesData, maxBytes int) (result MessagesPage, cut bool, err error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return MessagesPage{}, false, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return MessagesPage{}, false, errors.New("message history is not an array")
+	}
+
+	var window []json.RawMessage
+	size := 0
+	var oldestDropped json.RawMessage
+	drop := func() {
+		oldestDropped = window[0]
+		size -= len(window[0])
+		window[0] = nil
+		window = window[1:]
+	}
+
+	for dec.More() {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			return MessagesPage{}, false, err
+		}
+		if page.Before != "" && messageID(msg) == page.Before {
+			break
+		}
+		window = append(window, msg)
+		size += len(msg)
+		if page.Limit > 0 && len(window) > page.Limit {
+			drop()
+		}
+		for maxBytes > 0 && size > maxBytes && len(window) > 0 {
+			drop()
+			cut = true
+		}
+	}
+
+	result.Messages = window
+	if result.Messages == nil {
+		result.Messages = []json.RawMessage{}
+	}
+	if oldestDropped != nil {
+		result.HasMore = true
+		// Older messages are fetched with Before set to the oldest kept one;
+		// if even the newest was too large, page past it
+		if len(window) > 0 {
+			result.Cursor = messageID(window[0])
+		} else {
+			result.Cursor = messageID(oldestDropped)
+		}
+	}
+	return result, cut, nil
+}
+
+// historyPayload reads a session.messages response body. Without a page
+// limit the history keeps OpenCode's plain array shape unless the cap cut
+// it; otherwise it is a MessagesPage, with Truncated set when cut.
+func (c *Client) historyPayload(resp *http.Response, page MessagesData) ([]byte, error) {
+	br := bufio.NewReader(resp.Body)
+	first, err := firstNonSpace(br)
+	if err == io.EOF {
+		return nil, nonJSON(resp, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first != '[' {
+		// Not a history (e.g. an error object): pass it on, bounded
+		limit := int64(c.maxHistoryBytes)
+		var data []byte
+		if limit <= 0 {
+			data, err = io.ReadAll(br)
+		} else {
+			data, err = io.ReadAll(io.LimitReader(br, limit+1))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := nonJSON(resp, data); err != nil {
+			return nil, err
+		}
+		if limit > 0 && int64(len(data)) > limit {
+			return nil, fmt.Errorf("history response exceeds %d bytes", limit)
+		}
+		return data, nil
+	}
+
+	result, cut, err := readHistory(br, page, c.maxHistoryBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message history: %w", err)
+	}
+	if page.Limit == 0 && page.Before == "" && !cut {
+		return json.Marshal(result.Messages)
+	}
+	result.Truncated = cut
+	return json.Marshal(result)
+}
+
+// firstNonSpace peeks past leading whitespace to the first byte of r
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		r.Discard(1)
+	}
+}
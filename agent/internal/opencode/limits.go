@@ -0,0 +1,126 @@
+package opencode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Session limit policies, see SetSessionLimit
+const (
+	SessionPolicyReject = "reject"
+	SessionPolicyEvict  = "evict"
+)
+
+// SetSessionLimit caps the sessions of each OpenCode instance at max (0 = no
+// limit). At the cap, session.create fails under SessionPolicyReject, or
+// first deletes the least recently active session under SessionPolicyEvict.
+func (c *Client) SetSessionLimit(max int, policy string) {
+	c.maxSessions = max
+	c.sessionPolicy = policy
+}
+
+// sessionSummary is the part of an OpenCode session the limit looks at.
+// OpenCode bumps time.updated on every message, so it tracks activity.
+type sessionSummary struct {
+	ID   string `json:"id"`
+	Time struct {
+		Updated int64 `json:"updated"`
+	} `json:"time"`
+}
+
+// listSessions fetches the sessions of the instance at baseURL
+func (c *Client) listSessions(ctx context.Context, baseURL string) ([]sessionSummary, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/session", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := nonJSON(resp, body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var sessions []sessionSummary
+	if err := json.Unmarshal(body, &sessions); err != nil {
+		return nil, fmt.Errorf("invalid session list: %w", err)
+	}
+	return sessions, nil
+}
+
+// makeRoomForSession enforces the session limit ahead of a session.create.
+// The caller holds sessionLimitMu so concurrent creates can't overshoot.
+func (c *Client) makeRoomForSession(ctx context.Context, baseURL string) error {
+	sessions, err := c.listSessions(ctx, baseURL)
+	if err != nil {
+		return fmt.Errorf("failed to count sessions: %w", err)
+	}
+	if len(sessions) < c.maxSessions {
+		return nil
+	}
+	if c.sessionPolicy != SessionPolicyEvict {
+		return fmt.Errorf("session limit reached (%d), delete a session first", c.maxSessions)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Time.Updated < sessions[j].Time.Updated
+	})
+	for _, s := range sessions[:len(sessions)-c.maxSessions+1] {
+		if err := c.deleteSession(ctx, baseURL, s.ID); err != nil {
+			return fmt.Errorf("failed to evict session %s: %w", s.ID, err)
+		}
+		log.Printf("[OpenCode] Evicted least recently active session %s (limit %d)", s.ID, c.maxSessions)
+	}
+	return nil
+}
+
+// deleteSession deletes sessionID from the instance at baseURL
+func (c *Client) deleteSession(ctx context.Context, baseURL, sessionID string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/session/%s", baseURL, sessionID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleStats reports the instance's session count against the limit
+func (c *Client) handleStats(ctx context.Context, baseURL string, ch chan<- []byte) {
+	sessions, err := c.listSessions(ctx, baseURL)
+	if err != nil {
+		errPayload, _ := json.Marshal(map[string]string{"error": "failed to count sessions: " + err.Error()})
+		ch <- errPayload
+		return
+	}
+
+	stats := map[string]interface{}{"sessions": len(sessions)}
+	if c.maxSessions > 0 {
+		stats["maxSessions"] = c.maxSessions
+		stats["sessionPolicy"] = c.sessionPolicy
+	}
+	payload, _ := json.Marshal(stats)
+	ch <- payload
+}
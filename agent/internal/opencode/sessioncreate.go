@@ -0,0 +1,74 @@
+package opencode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Session create request shapes, for OpenCode builds that disagree on the
+// POST /session body. See SetSessionCreateFormat.
+const (
+	// SessionCreateFlat sends {"title", "directory"}
+	SessionCreateFlat = "flat"
+	// SessionCreateQuery sends {"title"} with directory as a query parameter
+	SessionCreateQuery = "query"
+	// SessionCreateNested sends {"session": {"title", "directory"}}
+	SessionCreateNested = "nested"
+)
+
+// SessionCreateFormats lists the accepted session create formats
+var SessionCreateFormats = []string{SessionCreateFlat, SessionCreateQuery, SessionCreateNested}
+
+// SetSessionCreateFormat selects the POST /session request shape, one of
+// SessionCreateFormats (default SessionCreateFlat)
+func (c *Client) SetSessionCreateFormat(format string) {
+	c.sessionCreateFormat = format
+}
+
+// sessionCreateRequest returns the path and body of a POST /session in the
+// configured format
+func (c *Client) sessionCreateRequest(data SessionCreateData) (string, []byte) {
+	fields := map[string]string{"title": data.Title}
+	path := "/session"
+
+	switch c.sessionCreateFormat {
+	case SessionCreateQuery:
+		if data.Directory != "" {
+			path += "?directory=" + url.QueryEscape(data.Directory)
+		}
+	case SessionCreateNested:
+		if data.Directory != "" {
+			fields["directory"] = data.Directory
+		}
+		body, _ := json.Marshal(map[string]interface{}{"session": fields})
+		return path, body
+	default:
+		if data.Directory != "" {
+			fields["directory"] = data.Directory
+		}
+	}
+	body, _ := json.Marshal(fields)
+	return path, body
+}
+
+// sessionFromResponse returns the created session from a POST /session
+// response, which is either the session itself or wrapped under "session"
+// or "data"
+func sessionFromResponse(body []byte) (map[string]interface{}, error) {
+	var session map[string]interface{}
+	if err := json.Unmarshal(body, &session); err != nil {
+		return nil, fmt.Errorf("invalid session response: %w", err)
+	}
+	if _, ok := session["id"]; ok {
+		return session, nil
+	}
+	for _, key := range []string{"session", "data"} {
+		if inner, ok := session[key].(map[string]interface{}); ok {
+			if _, ok := inner["id"]; ok {
+				return inner, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("session response has no id: %s", body)
+}
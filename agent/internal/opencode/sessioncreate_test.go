@@ -0,0 +1,105 @@
+package opencode
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// createSession runs session.create against an OpenCode stand-in answering
+// with status and reply, returning what it received and the action's result
+func createSession(t *testing.T, format string, status int, reply string) (gotURI string, gotBody, result map[string]interface{}) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &gotBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.SetSessionCreateFormat(format)
+	ch, err := c.HandleRequest(context.Background(), "", "session.create",
+		json.RawMessage(`{"title":"Fix tests","directory":"/work/app"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for chunk := range ch {
+		if err := json.Unmarshal(chunk, &result); err != nil {
+			t.Fatalf("chunk %s: %v", chunk, err)
+		}
+	}
+	return gotURI, gotBody, result
+}
+
+func TestSessionCreateRequestShapes(t *testing.T) {
+	tests := []struct {
+		format string
+		uri    string
+		body   string
+	}{
+		{SessionCreateFlat, "/session", `{"directory":"/work/app","title":"Fix tests"}`},
+		{"", "/session", `{"directory":"/work/app","title":"Fix tests"}`},
+		{SessionCreateQuery, "/session?directory=%2Fwork%2Fapp", `{"title":"Fix tests"}`},
+		{SessionCreateNested, "/session", `{"session":{"directory":"/work/app","title":"Fix tests"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			uri, body, result := createSession(t, tt.format, http.StatusOK, `{"id":"ses_1"}`)
+			if uri != tt.uri {
+				t.Errorf("request URI = %s, want %s", uri, tt.uri)
+			}
+			if got, _ := json.Marshal(body); string(got) != tt.body {
+				t.Errorf("request body = %s, want %s", got, tt.body)
+			}
+			if result["id"] != "ses_1" {
+				t.Errorf("result = %v", result)
+			}
+		})
+	}
+}
+
+func TestSessionCreateResponseShapes(t *testing.T) {
+	for name, reply := range map[string]string{
+		"bare":    `{"id":"ses_1","title":"Fix tests"}`,
+		"session": `{"session":{"id":"ses_1","title":"Fix tests"}}`,
+		"data":    `{"data":{"id":"ses_1","title":"Fix tests"}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, result := createSession(t, SessionCreateFlat, http.StatusOK, reply)
+			if result["id"] != "ses_1" || result["title"] != "Fix tests" {
+				t.Errorf("result = %v, want the session", result)
+			}
+			// The directory is reported back even when OpenCode leaves it out
+			if result["directory"] != "/work/app" {
+				t.Errorf("directory = %v", result["directory"])
+			}
+		})
+	}
+}
+
+func TestSessionCreateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+	}{
+		{"bad status", http.StatusBadRequest, `{"message":"unknown field directory"}`},
+		{"no id", http.StatusOK, `{"session":{"title":"Fix tests"}}`},
+		{"not an object", http.StatusOK, `["ses_1"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, result := createSession(t, SessionCreateFlat, tt.status, tt.reply)
+			if _, ok := result["error"]; !ok {
+				t.Errorf("result = %v, want an error", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package opencode
+
+import "encoding/json"
+
+// messageInfo is the part of OpenCode's message info that reports usage
+type messageInfo struct {
+	Cost   float64 `json:"cost"`
+	Tokens *struct {
+		Input     int64 `json:"input"`
+		Output    int64 `json:"output"`
+		Reasoning int64 `json:"reasoning"`
+		Cache     struct {
+			Read  int64 `json:"read"`
+			Write int64 `json:"write"`
+		} `json:"cache"`
+	} `json:"tokens"`
+	ModelID    string `json:"modelID"`
+	ProviderID string `json:"providerID"`
+}
+
+// usagePayload returns the usage report for a reply's info, ok false when
+// OpenCode reported no token counts
+func usagePayload(info json.RawMessage) ([]byte, bool) {
+	var mi messageInfo
+	if len(info) == 0 || json.Unmarshal(info, &mi) != nil || mi.Tokens == nil {
+		return nil, false
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"cost":       mi.Cost,
+		"input":      mi.Tokens.Input,
+		"output":     mi.Tokens.Output,
+		"reasoning":  mi.Tokens.Reasoning,
+		"cacheRead":  mi.Tokens.Cache.Read,
+		"cacheWrite": mi.Tokens.Cache.Write,
+		"modelId":    mi.ModelID,
+		"providerId": mi.ProviderID,
+	})
+	return payload, true
+}
+
+// UsageFromChunk returns the usage report in a prompt chunk, ok false for a
+// text chunk
+func UsageFromChunk(chunk []byte) (json.RawMessage, bool) {
+	var c struct {
+		Usage json.RawMessage `json:"usage"`
+	}
+	if json.Unmarshal(chunk, &c) != nil || len(c.Usage) == 0 || string(c.Usage) == "null" {
+		return nil, false
+	}
+	return c.Usage, true
+}
@@ -0,0 +1,53 @@
+package project
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DedupPaths drops project paths that reach a project already listed: the
+// same directory through a symlink, or a directory nested in (or containing)
+// a listed one within the same git repository. Sibling directories of one
+// repository stay separate projects. The first path listed wins.
+func DedupPaths(paths []string) []string {
+	type listed struct{ path, real, repo string }
+	var kept []listed
+	result := make([]string, 0, len(paths))
+
+next:
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			real = path
+		}
+		repo, _ := gitTopLevel(real)
+
+		for _, k := range kept {
+			same := real == k.real
+			nested := repo != "" && repo == k.repo && (within(real, k.real) || within(k.real, real))
+			if same || nested {
+				log.Printf("[Project] Skipping %s: same project as %s", path, k.path)
+				continue next
+			}
+		}
+		kept = append(kept, listed{path, real, repo})
+		result = append(result, path)
+	}
+	return result
+}
+
+// gitTopLevel finds the nearest directory at or above dir holding a .git
+// entry (a directory, or a file for worktrees and submodules)
+func gitTopLevel(dir string) (string, bool) {
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,53 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDedupPaths(t *testing.T) {
+	root := t.TempDir()
+	mkdir := func(parts ...string) string {
+		dir := filepath.Join(append([]string{root}, parts...)...)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	repo := mkdir("repo")
+	mkdir("repo", ".git")
+	nested := mkdir("repo", "cmd", "tool")
+	web, api := mkdir("mono", "web"), mkdir("mono", "api")
+	mkdir("mono", ".git")
+	plain := mkdir("plain")
+	plainChild := mkdir("plain", "child")
+
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(repo, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"symlink to a listed directory", []string{repo, link}, []string{repo}},
+		{"directory nested in a listed repo", []string{repo, nested}, []string{repo}},
+		{"repo containing a listed directory", []string{nested, repo}, []string{nested}},
+		{"nested through a symlink", []string{filepath.Join(link, "cmd", "tool"), repo}, []string{filepath.Join(link, "cmd", "tool")}},
+		{"siblings in one repo stay", []string{web, api}, []string{web, api}},
+		{"nesting outside git stays", []string{plain, plainChild}, []string{plain, plainChild}},
+		{"exact duplicate", []string{plain, plain}, []string{plain}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DedupPaths(tt.paths); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DedupPaths(%v) = %v, want %v", tt.paths, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,36 +1,173 @@
 package project
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const DockerContainerPrefix = "openvibe-opencode-"
 
+// DefaultDockerBinary is the docker CLI used when none is configured
+const DefaultDockerBinary = "docker"
+
+// DefaultOpenCodeCommand is the command containers run to serve OpenCode
+const DefaultOpenCodeCommand = "opencode serve --port {port}"
+
+// PortPlaceholder is replaced by the instance's port in an OpenCode command
+const PortPlaceholder = "{port}"
+
+// DefaultImagePullTimeout bounds pulling the OpenCode image
+const DefaultImagePullTimeout = 10 * time.Minute
+
+// commandWaitDelay is how long a killed docker command may keep its output
+// open before the agent stops waiting on it
+const commandWaitDelay = 5 * time.Second
+
+// ErrImagePullTimeout means pulling the image, or a docker run pulling it,
+// was killed at its deadline, typically because the registry is unreachable
+var ErrImagePullTimeout = errors.New("image pull timed out")
+
 type DockerExecutor struct {
-	httpClient *http.Client
-	imageName  string
+	httpClient   *http.Client // Health probes; Timeout bounds each one
+	imageName    string
+	binary       string        // docker-compatible CLI (docker, podman, ...)
+	host         string        // DOCKER_HOST for every command, empty = inherit
+	serveCommand []string      // OpenCode command with PortPlaceholder
+	restart      string        // docker run --restart policy, empty = docker's default (no)
+	pullTimeout  time.Duration // Bounds docker pull and docker run, 0 = the caller's deadline only
+
+	sshAuthSock    string // Host SSH agent socket mounted into containers, empty = none
+	gitCredentials string // Host git-credentials file mounted read-only, empty = none
 }
 
-func NewDockerExecutor(imageName string) *DockerExecutor {
+// Where forwarded credentials appear inside containers
+const (
+	containerSSHAuthSock    = "/run/openvibe/ssh-agent.sock"
+	containerGitCredentials = "/run/openvibe/git-credentials"
+	// containerOpenCodeConfig is joined with the config file's extension
+	containerOpenCodeConfig = "/run/openvibe/opencode"
+	// containerProjectDir is where the project is mounted, the working directory
+	containerProjectDir = "/project"
+)
+
+// NewDockerExecutor runs containers from imageName with the CLI at binary,
+// against the daemon at host if set. serveCommand comes from
+// ParseOpenCodeCommand; nil runs DefaultOpenCodeCommand.
+func NewDockerExecutor(imageName, binary, host string, serveCommand []string) *DockerExecutor {
 	if imageName == "" {
 		imageName = "openvibe/opencode:latest"
 	}
+	if binary == "" {
+		binary = DefaultDockerBinary
+	}
+	if len(serveCommand) == 0 {
+		serveCommand, _ = ParseOpenCodeCommand(DefaultOpenCodeCommand)
+	}
 	return &DockerExecutor{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		imageName:  imageName,
+		httpClient:   &http.Client{Timeout: DefaultHealthProbeTimeout},
+		imageName:    imageName,
+		binary:       binary,
+		host:         host,
+		serveCommand: serveCommand,
+		pullTimeout:  DefaultImagePullTimeout,
+	}
+}
+
+// ParseOpenCodeCommand splits command on whitespace into the arguments
+// containers run. Without a PortPlaceholder, "--port {port}" is appended;
+// a command that fixes --port itself is rejected since every instance
+// needs its own port.
+func ParseOpenCodeCommand(command string) ([]string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	for _, arg := range args {
+		if strings.Contains(arg, PortPlaceholder) {
+			return args, nil
+		}
+	}
+	for _, arg := range args {
+		if arg == "--port" || strings.HasPrefix(arg, "--port=") {
+			return nil, fmt.Errorf("command sets --port itself; use %s for the instance port", PortPlaceholder)
+		}
+	}
+	return append(args, "--port", PortPlaceholder), nil
+}
+
+// serveArgs returns the OpenCode command for an instance on port
+func (d *DockerExecutor) serveArgs(port int) []string {
+	args := make([]string, len(d.serveCommand))
+	for i, arg := range d.serveCommand {
+		args[i] = strings.ReplaceAll(arg, PortPlaceholder, strconv.Itoa(port))
+	}
+	return args
+}
+
+// Container restart policies for Config.RestartPolicy
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// ValidateRestartPolicy checks policy is one docker run --restart accepts
+// and the agent supports: no, on-failure[:max-retries] or unless-stopped
+func ValidateRestartPolicy(policy string) error {
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	switch {
+	case policy == "" || policy == RestartNo || policy == RestartUnlessStopped:
+		return nil
+	case name == RestartOnFailure && !hasRetries:
+		return nil
+	case name == RestartOnFailure:
+		if n, err := strconv.Atoi(retries); err == nil && n > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported restart policy %q (want no, on-failure[:N] or unless-stopped)", policy)
+}
+
+// ResolveDockerBinary checks that binary is an executable, by path or on
+// PATH, and returns its full path
+func ResolveDockerBinary(binary string) (string, error) {
+	if binary == "" {
+		binary = DefaultDockerBinary
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", fmt.Errorf("docker binary %q not found: %w", binary, err)
+	}
+	return path, nil
+}
+
+// command builds a docker CLI invocation with the configured binary and host
+func (d *DockerExecutor) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, d.binary, args...)
+	cmd.WaitDelay = commandWaitDelay
+	if d.host != "" {
+		cmd.Env = append(os.Environ(), "DOCKER_HOST="+d.host)
 	}
+	return cmd
 }
 
-func (d *DockerExecutor) StartContainer(ctx context.Context, containerName, workdir string, port int) error {
+// StartContainer starts containerName serving workdir on port, with
+// configFile mounted as OpenCode's config if set. An existing container is
+// restarted as it was created.
+func (d *DockerExecutor) StartContainer(ctx context.Context, containerName, workdir string, port int, configFile string) error {
 	// Check if container already exists
 	if d.ContainerExists(ctx, containerName) {
 		// Try to start it if stopped
-		startCmd := exec.CommandContext(ctx, "docker", "start", containerName)
+		startCmd := d.command(ctx, "start", containerName)
 		if err := startCmd.Run(); err == nil {
 			return nil
 		}
@@ -38,31 +175,126 @@ func (d *DockerExecutor) StartContainer(ctx context.Context, containerName, work
 		d.StopContainer(ctx, containerName)
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "run",
+	// docker run pulls an image missing locally, which can hang on an
+	// unreachable registry
+	runCtx, cancel := d.pullContext(ctx)
+	defer cancel()
+	cmd := d.command(runCtx, d.runArgs(containerName, workdir, port, configFile)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if timedOut(runCtx) {
+			return fmt.Errorf("%w: docker run of %s did not finish in time, output: %s", ErrImagePullTimeout, d.imageName, string(output))
+		}
+		return fmt.Errorf("failed to start docker container: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// pullContext bounds a docker command that may pull the image by the pull
+// timeout, within ctx's own deadline
+func (d *DockerExecutor) pullContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.pullTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.pullTimeout)
+}
+
+// timedOut reports whether ctx ended at a deadline rather than being
+// cancelled
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// runArgs returns the docker run arguments for an instance container
+func (d *DockerExecutor) runArgs(containerName, workdir string, port int, configFile string) []string {
+	args := []string{"run",
 		"-d",
 		"--network", "host",
 		"--name", containerName,
-		"-v", fmt.Sprintf("%s:/project", workdir),
-		"-w", "/project",
+	}
+	if d.restart != "" {
+		args = append(args, "--restart", d.restart)
+	}
+	if d.sshAuthSock != "" {
+		args = append(args,
+			"-v", fmt.Sprintf("%s:%s", d.sshAuthSock, containerSSHAuthSock),
+			"-e", "SSH_AUTH_SOCK="+containerSSHAuthSock,
+		)
+	}
+	if d.gitCredentials != "" {
+		// GIT_CONFIG_* adds the helper without touching the image's gitconfig
+		args = append(args,
+			"-v", fmt.Sprintf("%s:%s:ro", d.gitCredentials, containerGitCredentials),
+			"-e", "GIT_CONFIG_COUNT=1",
+			"-e", "GIT_CONFIG_KEY_0=credential.helper",
+			"-e", "GIT_CONFIG_VALUE_0=store --file="+containerGitCredentials,
+		)
+	}
+	if configFile != "" {
+		// OPENCODE_CONFIG is merged over the image's global config
+		target := containerOpenCodeConfig + filepath.Ext(configFile)
+		args = append(args,
+			"-v", fmt.Sprintf("%s:%s:ro", configFile, target),
+			"-e", "OPENCODE_CONFIG="+target,
+		)
+	}
+	args = append(args,
+		"-v", fmt.Sprintf("%s:%s", workdir, containerProjectDir),
+		"-w", containerProjectDir,
 		d.imageName,
-		"opencode", "serve", "--port", fmt.Sprintf("%d", port),
 	)
+	return append(args, d.serveArgs(port)...)
+}
 
-	output, err := cmd.CombinedOutput()
+// ImagePresent reports whether the OpenCode image is available locally
+func (d *DockerExecutor) ImagePresent(ctx context.Context) bool {
+	cmd := d.command(ctx, "image", "inspect", d.imageName)
+	return cmd.Run() == nil
+}
+
+// PullImage pulls the OpenCode image, calling onLine with each line of
+// docker pull output as it arrives. A pull past the pull timeout is killed
+// and reported as ErrImagePullTimeout.
+func (d *DockerExecutor) PullImage(ctx context.Context, onLine func(string)) error {
+	ctx, cancel := d.pullContext(ctx)
+	defer cancel()
+	cmd := d.command(ctx, "pull", d.imageName)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to start docker container: %w, output: %s", err, string(output))
+		return fmt.Errorf("failed to pull docker image: %w", err)
 	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
 
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to pull docker image: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && onLine != nil {
+			onLine(line)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if timedOut(ctx) {
+			return fmt.Errorf("%w: docker pull of %s did not finish in time, output: %s", ErrImagePullTimeout, d.imageName, stderr.String())
+		}
+		return fmt.Errorf("failed to pull docker image %s: %w, output: %s", d.imageName, err, stderr.String())
+	}
 	return nil
 }
 
 func (d *DockerExecutor) StopContainer(ctx context.Context, containerName string) error {
 	// Stop the container
-	stopCmd := exec.CommandContext(ctx, "docker", "stop", containerName)
+	stopCmd := d.command(ctx, "stop", containerName)
 	stopCmd.Run() // Ignore error, container might not be running
 
 	// Remove the container
-	rmCmd := exec.CommandContext(ctx, "docker", "rm", containerName)
+	rmCmd := d.command(ctx, "rm", containerName)
 	output, err := rmCmd.CombinedOutput()
 	if err != nil {
 		outputStr := string(output)
@@ -76,7 +308,7 @@ func (d *DockerExecutor) StopContainer(ctx context.Context, containerName string
 }
 
 func (d *DockerExecutor) ContainerExists(ctx context.Context, containerName string) bool {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "-q", "-f", fmt.Sprintf("name=^%s$", containerName))
+	cmd := d.command(ctx, "ps", "-a", "-q", "-f", fmt.Sprintf("name=^%s$", containerName))
 	output, err := cmd.Output()
 	if err != nil {
 		return false
@@ -84,17 +316,19 @@ func (d *DockerExecutor) ContainerExists(ctx context.Context, containerName stri
 	return strings.TrimSpace(string(output)) != ""
 }
 
-func (d *DockerExecutor) ContainerRunning(ctx context.Context, containerName string) bool {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-q", "-f", fmt.Sprintf("name=^%s$", containerName))
+// ContainerRunning reports whether containerName is running. err is set when
+// docker could not be asked, so callers don't mistake an outage for an exit.
+func (d *DockerExecutor) ContainerRunning(ctx context.Context, containerName string) (bool, error) {
+	cmd := d.command(ctx, "ps", "-q", "-f", fmt.Sprintf("name=^%s$", containerName))
 	output, err := cmd.Output()
 	if err != nil {
-		return false
+		return false, fmt.Errorf("failed to query container %s: %w", containerName, err)
 	}
-	return strings.TrimSpace(string(output)) != ""
+	return strings.TrimSpace(string(output)) != "", nil
 }
 
 func (d *DockerExecutor) ListContainers(ctx context.Context) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-a",
+	cmd := d.command(ctx, "ps", "-a",
 		"--filter", fmt.Sprintf("name=%s", DockerContainerPrefix),
 		"--format", "{{.Names}}")
 	output, err := cmd.Output()
@@ -129,6 +363,9 @@ func (d *DockerExecutor) IsPortInUse(ctx context.Context, port int) bool {
 func (d *DockerExecutor) WaitForHealth(ctx context.Context, port int, timeout time.Duration) error {
 	healthURL := fmt.Sprintf("http://localhost:%d/global/health", port)
 	deadline := time.Now().Add(timeout)
+	// A probe started just before the deadline doesn't outlive it
+	probeCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
 
 	for time.Now().Before(deadline) {
 		select {
@@ -137,7 +374,7 @@ func (d *DockerExecutor) WaitForHealth(ctx context.Context, port int, timeout ti
 		default:
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+		req, err := http.NewRequestWithContext(probeCtx, "GET", healthURL, nil)
 		if err != nil {
 			time.Sleep(500 * time.Millisecond)
 			continue
@@ -161,7 +398,7 @@ func (d *DockerExecutor) WaitForHealth(ctx context.Context, port int, timeout ti
 }
 
 func (d *DockerExecutor) GetContainerLogs(ctx context.Context, containerName string, tail int) (string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "logs", "--tail", fmt.Sprintf("%d", tail), containerName)
+	cmd := d.command(ctx, "logs", "--tail", fmt.Sprintf("%d", tail), containerName)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("failed to get container logs: %w", err)
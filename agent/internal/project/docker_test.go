@@ -0,0 +1,292 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunArgsRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string // Restart flag expected, nil for none
+	}{
+		{"", nil},
+		{RestartNo, []string{"--restart", "no"}},
+		{RestartOnFailure, []string{"--restart", "on-failure"}},
+		{"on-failure:3", []string{"--restart", "on-failure:3"}},
+		{RestartUnlessStopped, []string{"--restart", "unless-stopped"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			d := NewDockerExecutor("opencode:test", "", "", nil)
+			d.restart = tt.policy
+			args := d.runArgs("openvibe-opencode-app", "/work/app", 4096, "")
+
+			want := append([]string{"run", "-d", "--network", "host", "--name", "openvibe-opencode-app"}, tt.want...)
+			if !reflect.DeepEqual(args[:len(want)], want) {
+				t.Errorf("runArgs = %v, want it to start %v", args, want)
+			}
+			if tt.want == nil && contains(args, "--restart") {
+				t.Errorf("runArgs = %v, want no --restart", args)
+			}
+			// The image and its command still come last
+			tail := []string{"opencode:test", "opencode", "serve", "--port", "4096"}
+			if !reflect.DeepEqual(args[len(args)-len(tail):], tail) {
+				t.Errorf("runArgs = %v, want it to end %v", args, tail)
+			}
+		})
+	}
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	for _, policy := range []string{"", "no", "on-failure", "on-failure:5", "unless-stopped"} {
+		if err := ValidateRestartPolicy(policy); err != nil {
+			t.Errorf("ValidateRestartPolicy(%q) = %v", policy, err)
+		}
+	}
+	for _, policy := range []string{"always", "on-failure:0", "on-failure:x", "unless-stopped:2", "No"} {
+		if err := ValidateRestartPolicy(policy); err == nil {
+			t.Errorf("ValidateRestartPolicy(%q) accepted", policy)
+		}
+	}
+}
+
+// slowHealthPort serves a health check that answers after delay
+func slowHealthPort(t *testing.T, delay time.Duration) int {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestHealthProbeTimeout(t *testing.T) {
+	port := slowHealthPort(t, 200*time.Millisecond)
+	d := NewDockerExecutor("", "", "", nil)
+	ctx := context.Background()
+
+	d.httpClient.Timeout = 50 * time.Millisecond
+	if d.IsPortInUse(ctx, port) {
+		t.Error("probe shorter than the endpoint's delay reported healthy")
+	}
+	if err := d.WaitForHealth(ctx, port, 500*time.Millisecond); err == nil {
+		t.Error("WaitForHealth passed with every probe timing out")
+	}
+
+	d.httpClient.Timeout = time.Second
+	if !d.IsPortInUse(ctx, port) {
+		t.Error("probe longer than the endpoint's delay reported unhealthy")
+	}
+	if err := d.WaitForHealth(ctx, port, 2*time.Second); err != nil {
+		t.Errorf("WaitForHealth: %v", err)
+	}
+}
+
+func TestHealthProbeWithinDeadline(t *testing.T) {
+	port := slowHealthPort(t, 5*time.Second)
+	d := NewDockerExecutor("", "", "", nil)
+	d.httpClient.Timeout = 10 * time.Second
+
+	start := time.Now()
+	if err := d.WaitForHealth(context.Background(), port, 300*time.Millisecond); err == nil {
+		t.Fatal("WaitForHealth passed")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("WaitForHealth took %v past a 300ms deadline", elapsed)
+	}
+}
+
+func contains(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseOpenCodeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string // nil for rejected
+	}{
+		{DefaultOpenCodeCommand, []string{"opencode", "serve", "--port", "{port}"}},
+		{"opencode serve --port={port} --hostname 0.0.0.0", []string{"opencode", "serve", "--port={port}", "--hostname", "0.0.0.0"}},
+		{"  opencode   serve ", []string{"opencode", "serve", "--port", "{port}"}},
+		// Wrappers that take the port some other way keep their placeholder
+		{"npx -y opencode-ai@latest serve --port {port}", []string{"npx", "-y", "opencode-ai@latest", "serve", "--port", "{port}"}},
+		{"env PORT={port} /opt/opencode/start.sh", []string{"env", "PORT={port}", "/opt/opencode/start.sh"}},
+		{"npx -y opencode-ai serve", []string{"npx", "-y", "opencode-ai", "serve", "--port", "{port}"}},
+		{"opencode serve --port 4096", nil},
+		{"opencode serve --port=4096", nil},
+		{"", nil},
+		{"   ", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseOpenCodeCommand(tt.command)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseOpenCodeCommand(%q) = %v, want rejected", tt.command, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOpenCodeCommand(%q) = %v, %v; want %v", tt.command, got, err, tt.want)
+		}
+	}
+}
+
+func TestServeArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{DefaultOpenCodeCommand, []string{"opencode", "serve", "--port", "4100"}},
+		{"opencode serve --port={port}", []string{"opencode", "serve", "--port=4100"}},
+		{"npx -y opencode-ai serve", []string{"npx", "-y", "opencode-ai", "serve", "--port", "4100"}},
+		{"sh -c opencode-on-{port}:{port}", []string{"sh", "-c", "opencode-on-4100:4100"}},
+	}
+	for _, tt := range tests {
+		command, err := ParseOpenCodeCommand(tt.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDockerExecutor("", "", "", command)
+		if got := d.serveArgs(4100); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("serveArgs for %q = %v, want %v", tt.command, got, tt.want)
+		}
+		// Each instance gets its own port from the same command
+		if got := d.serveArgs(4101); reflect.DeepEqual(got, tt.want) {
+			t.Errorf("serveArgs for %q ignores the port", tt.command)
+		}
+	}
+
+	// Without a command containers run the default
+	d := NewDockerExecutor("", "", "", nil)
+	if got, want := d.serveArgs(4100), []string{"opencode", "serve", "--port", "4100"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("default serveArgs = %v, want %v", got, want)
+	}
+}
+
+// scriptDocker writes a stand-in docker CLI running body and returns its path
+func scriptDocker(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommandBinaryAndHost(t *testing.T) {
+	binary := scriptDocker(t, `echo "$DOCKER_HOST $*"`)
+	d := NewDockerExecutor("", binary, "tcp://build-host:2375", nil)
+
+	cmd := d.command(context.Background(), "ps", "-q")
+	if cmd.Path != binary || !reflect.DeepEqual(cmd.Args, []string{binary, "ps", "-q"}) {
+		t.Errorf("command runs %s %v, want %s ps -q", cmd.Path, cmd.Args, binary)
+	}
+	if !contains(cmd.Env, "DOCKER_HOST=tcp://build-host:2375") {
+		t.Errorf("command env lacks DOCKER_HOST: %v", cmd.Env)
+	}
+	output, err := cmd.Output()
+	if err != nil || strings.TrimSpace(string(output)) != "tcp://build-host:2375 ps -q" {
+		t.Errorf("command printed %q, %v", output, err)
+	}
+
+	// Without a host the daemon is whatever the agent's environment names
+	d = NewDockerExecutor("", "", "", nil)
+	cmd = d.command(context.Background(), "ps")
+	if cmd.Env != nil {
+		t.Errorf("command env = %v, want the agent's own", cmd.Env)
+	}
+	if cmd.Args[0] != DefaultDockerBinary {
+		t.Errorf("command runs %s, want %s", cmd.Args[0], DefaultDockerBinary)
+	}
+}
+
+// hangingDocker is a docker CLI whose pull and run never finish, recording
+// the hung process's PID in the returned file
+func hangingDocker(t *testing.T) (binary, pidFile string) {
+	t.Helper()
+	pidFile = filepath.Join(t.TempDir(), "pid")
+	binary = scriptDocker(t, `case "$1" in
+pull|run) echo $$ > `+pidFile+`; echo "latest: Pulling from openvibe/opencode"; exec sleep 30 ;;
+*) exit 1 ;;
+esac`)
+	return binary, pidFile
+}
+
+// assertKilled fails unless the process recorded in pidFile has exited
+func assertKilled(t *testing.T, pidFile string) {
+	t.Helper()
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("docker never ran: %v", err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if p, err := os.FindProcess(pid); err == nil && p.Signal(syscall.Signal(0)) == nil {
+		p.Kill()
+		t.Errorf("docker process %d still running", pid)
+	}
+}
+
+func TestPullImageTimeout(t *testing.T) {
+	binary, pidFile := hangingDocker(t)
+	d := NewDockerExecutor("", binary, "", nil)
+	d.pullTimeout = 200 * time.Millisecond
+
+	var lines []string
+	start := time.Now()
+	err := d.PullImage(context.Background(), func(line string) { lines = append(lines, line) })
+	if !errors.Is(err, ErrImagePullTimeout) {
+		t.Errorf("PullImage = %v, want ErrImagePullTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("PullImage took %v with a 200ms timeout", elapsed)
+	}
+	if len(lines) != 1 {
+		t.Errorf("pull progress = %v, want the line printed before hanging", lines)
+	}
+	assertKilled(t, pidFile)
+}
+
+func TestStartContainerPullTimeout(t *testing.T) {
+	binary, pidFile := hangingDocker(t)
+	d := NewDockerExecutor("", binary, "", nil)
+	d.pullTimeout = 200 * time.Millisecond
+
+	err := d.StartContainer(context.Background(), "openvibe-opencode-app", "/work/app", 4096, "")
+	if !errors.Is(err, ErrImagePullTimeout) {
+		t.Errorf("StartContainer = %v, want ErrImagePullTimeout", err)
+	}
+	assertKilled(t, pidFile)
+}
+
+func TestPullImageCancelledIsNotTimeout(t *testing.T) {
+	binary, pidFile := hangingDocker(t)
+	d := NewDockerExecutor("", binary, "", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := d.PullImage(ctx, func(string) { cancel() })
+	if err == nil || errors.Is(err, ErrImagePullTimeout) {
+		t.Errorf("cancelled PullImage = %v, want a plain failure", err)
+	}
+	assertKilled(t, pidFile)
+}
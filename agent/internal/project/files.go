@@ -0,0 +1,75 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideProject is returned for file paths that escape their project root
+var ErrOutsideProject = errors.New("path is outside the project")
+
+// ResolveFile maps rel, a path relative to an allowed project, to an absolute
+// path. Symlinks are resolved before the containment check so a link can't
+// point outside the project.
+func (m *Manager) ResolveFile(projectPath, rel string) (string, error) {
+	if err := m.validatePath(projectPath); err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideProject, rel)
+	}
+
+	root, err := filepath.EvalSymlinks(projectPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve project root: %w", err)
+	}
+	joined := filepath.Join(root, rel)
+	if !within(root, joined) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideProject, rel)
+	}
+	target, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("no such file: %s", rel)
+		}
+		return "", fmt.Errorf("cannot access %s", rel)
+	}
+	if !within(root, target) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideProject, rel)
+	}
+	return target, nil
+}
+
+// ProjectForDirectory returns the allowed project containing dir, the
+// innermost if projects nest, and dir's path inside that project's
+// container, for running a prompt in a subdirectory
+func (m *Manager) ProjectForDirectory(dir string) (projectPath, containerDir string, err error) {
+	if !filepath.IsAbs(dir) {
+		return "", "", fmt.Errorf("directory must be absolute: %s", dir)
+	}
+	dir = filepath.Clean(dir)
+	for _, allowed := range m.config.AllowedPaths {
+		if within(allowed, dir) && len(allowed) > len(projectPath) {
+			projectPath = allowed
+		}
+	}
+	if projectPath == "" {
+		return "", "", fmt.Errorf("directory not in an allowed project: %s", dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", "", fmt.Errorf("no such directory: %s", dir)
+	}
+	rel, _ := filepath.Rel(projectPath, dir)
+	return projectPath, path.Join(containerProjectDir, filepath.ToSlash(rel)), nil
+}
+
+// within reports whether path is root or inside it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
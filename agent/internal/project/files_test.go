@@ -0,0 +1,55 @@
+package project
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveFile(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "src"), 0o755)
+	os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main"), 0o644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("key"), 0o644)
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "escape")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	os.Symlink(outside, filepath.Join(root, "escape-dir"))
+	os.Symlink(filepath.Join("src", "main.go"), filepath.Join(root, "inside"))
+
+	m := NewManager(&Config{AllowedPaths: []string{root}})
+	realRoot, _ := filepath.EvalSymlinks(root)
+
+	for _, rel := range []string{"src/main.go", "./src/../src/main.go", "inside"} {
+		got, err := m.ResolveFile(root, rel)
+		if want := filepath.Join(realRoot, "src", "main.go"); err != nil || got != want {
+			t.Errorf("ResolveFile(%q) = %q, %v; want %q", rel, got, err, want)
+		}
+	}
+	if got, err := m.ResolveFile(root, "."); err != nil || got != realRoot {
+		t.Errorf("ResolveFile(.) = %q, %v", got, err)
+	}
+
+	for _, rel := range []string{
+		"..",
+		"../" + filepath.Base(outside) + "/secret",
+		"src/../../etc/passwd",
+		filepath.Join(outside, "secret"),
+		"/etc/passwd",
+		"escape",
+		"escape-dir/secret",
+	} {
+		if got, err := m.ResolveFile(root, rel); !errors.Is(err, ErrOutsideProject) {
+			t.Errorf("ResolveFile(%q) = %q, %v; want ErrOutsideProject", rel, got, err)
+		}
+	}
+
+	if _, err := m.ResolveFile(root, "missing.go"); err == nil || errors.Is(err, ErrOutsideProject) {
+		t.Errorf("missing file: %v", err)
+	}
+	if _, err := m.ResolveFile(outside, "secret"); err == nil {
+		t.Error("resolved a file in a project that isn't allowed")
+	}
+}
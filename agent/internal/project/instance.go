@@ -19,6 +19,21 @@ type Instance struct {
 	Status        Status    `json:"status"`
 	Error         string    `json:"error,omitempty"`
 	StartedAt     time.Time `json:"startedAt,omitempty"`
+	LastUsed      time.Time `json:"lastUsed,omitempty"`
+	Pinned        bool      `json:"pinned"`                  // Exempt from idle cleanup
+	RestartCount  int       `json:"restartCount"`            // Starts following an unexpected exit
+	UptimeSeconds int64     `json:"uptimeSeconds,omitempty"` // Filled in by snapshot
+
+	crashed bool // Container exited without a Stop
+}
+
+// snapshot returns a copy of the instance with derived fields filled in
+func (i *Instance) snapshot() *Instance {
+	c := *i
+	if c.Status == StatusRunning && !c.StartedAt.IsZero() {
+		c.UptimeSeconds = int64(time.Since(c.StartedAt).Seconds())
+	}
+	return &c
 }
 
 func (i *Instance) IsRunning() bool {
@@ -2,14 +2,33 @@ package project
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
 
 const (
 	DefaultHealthTimeout = 30 * time.Second
+
+	// DefaultHealthProbeTimeout bounds a single health check request
+	DefaultHealthProbeTimeout = 5 * time.Second
+
+	// DefaultMaxConcurrentStarts is how many containers start at once by default
+	DefaultMaxConcurrentStarts = 2
+
+	// startCleanupTimeout bounds removing a container after a failed start
+	startCleanupTimeout = 30 * time.Second
+)
+
+var (
+	ErrStartCancelled = errors.New("project start cancelled")
+	ErrNotStarting    = errors.New("project is not starting")
+	ErrNotRunning     = errors.New("project not running, start it first")
 )
 
 type Config struct {
@@ -18,6 +37,64 @@ type Config struct {
 	PortMax      int
 	MaxInstances int
 	DockerImage  string
+	DockerBinary string        // docker-compatible CLI path (default "docker" on PATH)
+	DockerHost   string        // DOCKER_HOST for the CLI, empty = inherit the agent's
+	IdleTimeout  time.Duration // Stop unpinned instances idle this long (0 = never)
+
+	// OpenCodeCommand is the command containers run, from
+	// ParseOpenCodeCommand (nil = DefaultOpenCodeCommand)
+	OpenCodeCommand []string
+
+	// IdleTimeouts overrides IdleTimeout per project path (0 = never)
+	IdleTimeouts map[string]time.Duration
+
+	// HealthTimeout is how long a started container has to pass a health
+	// check (default DefaultHealthTimeout). HealthProbeTimeout bounds each
+	// check within it, and every port probe (default
+	// DefaultHealthProbeTimeout).
+	HealthTimeout      time.Duration
+	HealthProbeTimeout time.Duration
+
+	// ImagePullTimeout bounds pulling the image, before or during docker
+	// run; a pull past it is killed (default DefaultImagePullTimeout)
+	ImagePullTimeout time.Duration
+
+	// MaxConcurrentStarts bounds container starts in progress at once; more
+	// starts queue (default DefaultMaxConcurrentStarts)
+	MaxConcurrentStarts int
+
+	// DeterministicPorts assigns each project a stable port derived from its path
+	DeterministicPorts bool
+
+	// PortStateFile persists the port pool's mapping so SyncWithDocker can
+	// recover containers left running by a previous agent (empty = memory only)
+	PortStateFile string
+
+	// RestartPolicy is passed to docker run --restart (see
+	// ValidateRestartPolicy); empty leaves docker's default, no
+	RestartPolicy string
+
+	// SSHAuthSock is the host SSH agent socket to mount into containers, so
+	// git over SSH works inside them. GitCredentialsFile is a git-credentials
+	// file mounted read-only and used as git's credential store. Both are
+	// opt-in: any prompt can make OpenCode use them (empty = not mounted).
+	SSHAuthSock        string
+	GitCredentialsFile string
+
+	// ManualStart stops requests from starting stopped projects: they fail
+	// with ErrNotRunning until a project.start. Starts already in progress
+	// are still waited for.
+	ManualStart bool
+
+	// SystemPreambles holds a system instruction per project path, added to
+	// every prompt for that project
+	SystemPreambles map[string]string
+
+	// OpenCodeConfig is an OpenCode config file mounted read-only into every
+	// container; OpenCodeConfigs overrides it per project path. Files must
+	// exist when a project starts (empty = the image's config only).
+	OpenCodeConfig  string
+	OpenCodeConfigs map[string]string
 }
 
 type Manager struct {
@@ -25,9 +102,21 @@ type Manager struct {
 	instances map[string]*Instance
 	portPool  *PortPool
 	docker    *DockerExecutor
+	changes   chan *Instance
 	mu        sync.RWMutex
+
+	// starts tracks in-progress starts by path. startsMu is never held
+	// while taking mu.
+	starts   map[string]*startOp
+	startsMu sync.Mutex
+
+	// startSlots bounds concurrent starts to Config.MaxConcurrentStarts
+	startSlots chan struct{}
 }
 
+// statusChangeBuffer is the capacity of the Changes channel
+const statusChangeBuffer = 64
+
 func NewManager(cfg *Config) *Manager {
 	if cfg.PortMin == 0 {
 		cfg.PortMin = 4096
@@ -38,13 +127,42 @@ func NewManager(cfg *Config) *Manager {
 	if cfg.MaxInstances == 0 {
 		cfg.MaxInstances = 5
 	}
+	if cfg.HealthTimeout <= 0 {
+		cfg.HealthTimeout = DefaultHealthTimeout
+	}
+	if cfg.HealthProbeTimeout <= 0 {
+		cfg.HealthProbeTimeout = DefaultHealthProbeTimeout
+	}
+	if cfg.MaxConcurrentStarts <= 0 {
+		cfg.MaxConcurrentStarts = DefaultMaxConcurrentStarts
+	}
+	if cfg.ImagePullTimeout <= 0 {
+		cfg.ImagePullTimeout = DefaultImagePullTimeout
+	}
+
+	portPool := NewPortPool(cfg.PortMin, cfg.PortMax)
+	portPool.Deterministic = cfg.DeterministicPorts
+	if cfg.PortStateFile != "" {
+		if err := portPool.LoadState(cfg.PortStateFile); err != nil {
+			log.Printf("[Project] %v, starting with an empty port pool", err)
+		}
+	}
 
 	m := &Manager{
 		config:    cfg,
 		instances: make(map[string]*Instance),
-		portPool:  NewPortPool(cfg.PortMin, cfg.PortMax),
-		docker:    NewDockerExecutor(cfg.DockerImage),
+		portPool:  portPool,
+		docker:    NewDockerExecutor(cfg.DockerImage, cfg.DockerBinary, cfg.DockerHost, cfg.OpenCodeCommand),
+		changes:   make(chan *Instance, statusChangeBuffer),
+		starts:    make(map[string]*startOp),
+
+		startSlots: make(chan struct{}, cfg.MaxConcurrentStarts),
 	}
+	m.docker.restart = cfg.RestartPolicy
+	m.docker.sshAuthSock = cfg.SSHAuthSock
+	m.docker.gitCredentials = cfg.GitCredentialsFile
+	m.docker.httpClient.Timeout = cfg.HealthProbeTimeout
+	m.docker.pullTimeout = cfg.ImagePullTimeout
 
 	for _, path := range cfg.AllowedPaths {
 		name := filepath.Base(path)
@@ -59,90 +177,286 @@ func NewManager(cfg *Config) *Manager {
 	return m
 }
 
+// Changes returns snapshots of instances whose status changed. Changes are
+// dropped if nobody keeps up with the channel.
+func (m *Manager) Changes() <-chan *Instance {
+	return m.changes
+}
+
+// notifyLocked publishes a snapshot of inst on the Changes channel
+func (m *Manager) notifyLocked(inst *Instance) {
+	select {
+	case m.changes <- inst.snapshot():
+	default:
+		log.Printf("[Project] Status change channel full, dropping update for %s", inst.Path)
+	}
+}
+
 func (m *Manager) List() []*Instance {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	result := make([]*Instance, 0, len(m.instances))
 	for _, inst := range m.instances {
-		copy := *inst
-		result = append(result, &copy)
+		result = append(result, inst.snapshot())
 	}
 	return result
 }
 
+// Paths returns the configured project paths
+func (m *Manager) Paths() []string {
+	return slices.Clone(m.config.AllowedPaths)
+}
+
 func (m *Manager) GetByPath(path string) *Instance {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if inst, ok := m.instances[path]; ok {
-		copy := *inst
-		return &copy
+		return inst.snapshot()
 	}
 	return nil
 }
 
+// Start stages reported to a ProgressFunc
+const (
+	StageQueued        = "queued"
+	StageStarting      = "starting"
+	StagePullingImage  = "pulling image"
+	StageWaitingHealth = "waiting for health"
+	StageReady         = "ready"
+)
+
+// ProgressFunc receives start progress: a stage and optional detail such as
+// a line of docker pull output
+type ProgressFunc func(stage, detail string)
+
 func (m *Manager) Start(ctx context.Context, path string) (*Instance, error) {
+	return m.StartWithProgress(ctx, path, nil)
+}
+
+// StartWithProgress is Start, reporting each stage to progress as it is reached.
+// At most Config.MaxConcurrentStarts starts run at once; others report
+// StageQueued and wait their turn.
+func (m *Manager) StartWithProgress(ctx context.Context, path string, progress ProgressFunc) (*Instance, error) {
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
 	if err := m.validatePath(path); err != nil {
 		return nil, err
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	ctx, op, err := m.beginStart(ctx, path, progress)
+	if err != nil {
+		return nil, err
+	}
+	defer m.endStart(path, op)
+
+	return m.start(ctx, path, progress)
+}
+
+// startOp is an in-progress start of one project
+type startOp struct {
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the start returns
+}
+
+// beginStart registers a start of path, first waiting out any start of the
+// same path already in progress
+func (m *Manager) beginStart(ctx context.Context, path string, progress ProgressFunc) (context.Context, *startOp, error) {
+	for {
+		m.startsMu.Lock()
+		existing, busy := m.starts[path]
+		if !busy {
+			startCtx, cancel := context.WithCancel(ctx)
+			op := &startOp{cancel: cancel, done: make(chan struct{})}
+			m.starts[path] = op
+			m.startsMu.Unlock()
+			return startCtx, op, nil
+		}
+		m.startsMu.Unlock()
+
+		progress(StageQueued, "project is already starting")
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
 
+// endStart unregisters op and wakes anyone waiting on it
+func (m *Manager) endStart(path string, op *startOp) {
+	op.cancel()
+	m.startsMu.Lock()
+	delete(m.starts, path)
+	m.startsMu.Unlock()
+	close(op.done)
+}
+
+// start brings up path's container. The caller owns the path's start, so
+// only this goroutine moves the instance out of stopped or error.
+func (m *Manager) start(ctx context.Context, path string, progress ProgressFunc) (*Instance, error) {
+	m.mu.Lock()
 	inst, ok := m.instances[path]
 	if !ok {
+		m.mu.Unlock()
 		return nil, fmt.Errorf("project not found: %s", path)
 	}
-
 	if inst.Status == StatusRunning {
-		copy := *inst
-		return &copy, nil
+		snapshot := inst.snapshot()
+		m.mu.Unlock()
+		return snapshot, nil
 	}
+	m.mu.Unlock()
+
+	// Wait for a start slot so a burst of starts can't saturate Docker
+	select {
+	case m.startSlots <- struct{}{}:
+	default:
+		progress(StageQueued, "waiting for other projects to start")
+		select {
+		case m.startSlots <- struct{}{}:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return m.GetByPath(path), ErrStartCancelled
+			}
+			return nil, ctx.Err()
+		}
+	}
+	defer func() { <-m.startSlots }()
 
-	runningCount := 0
+	m.mu.Lock()
+	active := 0
 	for _, i := range m.instances {
-		if i.Status == StatusRunning {
-			runningCount++
+		if i.Status == StatusRunning || i.Status == StatusStarting {
+			active++
 		}
 	}
-	if runningCount >= m.config.MaxInstances {
+	if active >= m.config.MaxInstances {
+		m.mu.Unlock()
 		return nil, fmt.Errorf("max instances reached (%d), stop another project first", m.config.MaxInstances)
 	}
 
 	port, err := m.portPool.AcquireAvailable(ctx, path, m.docker)
 	if err != nil {
+		m.mu.Unlock()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return m.GetByPath(path), ErrStartCancelled
+		}
 		return nil, fmt.Errorf("failed to acquire port: %w", err)
 	}
 
 	inst.Status = StatusStarting
 	inst.Port = port
 	inst.Error = ""
+	m.notifyLocked(inst)
+	m.mu.Unlock()
+	progress(StageStarting, "")
+
+	// Docker calls run unlocked so other projects stay usable meanwhile
+	if !m.docker.ImagePresent(ctx) {
+		progress(StagePullingImage, "")
+		if err := m.docker.PullImage(ctx, func(line string) { progress(StagePullingImage, line) }); err != nil {
+			return m.abortStart(ctx, inst, err)
+		}
+	}
 
-	if err := m.docker.StartContainer(ctx, inst.ContainerName, path, port); err != nil {
-		inst.Status = StatusError
-		inst.Error = err.Error()
-		m.portPool.Release(port)
-		copy := *inst
-		return &copy, err
+	configFile := m.openCodeConfigFor(path)
+	if configFile != "" {
+		if _, err := os.Stat(configFile); err != nil {
+			return m.abortStart(ctx, inst, fmt.Errorf("opencode config: %w", err))
+		}
+	}
+
+	if err := m.docker.StartContainer(ctx, inst.ContainerName, path, port, configFile); err != nil {
+		return m.abortStart(ctx, inst, err)
 	}
 
-	if err := m.docker.WaitForHealth(ctx, port, DefaultHealthTimeout); err != nil {
-		inst.Status = StatusError
-		inst.Error = err.Error()
-		m.docker.StopContainer(ctx, inst.ContainerName)
-		m.portPool.Release(port)
-		copy := *inst
-		return &copy, err
+	progress(StageWaitingHealth, "")
+	if err := m.docker.WaitForHealth(ctx, port, m.config.HealthTimeout); err != nil {
+		return m.abortStart(ctx, inst, err)
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Starting again after the container died on its own counts as a restart
+	if inst.crashed {
+		inst.RestartCount++
+		inst.crashed = false
+	}
 	inst.Status = StatusRunning
 	inst.StartedAt = time.Now()
-	copy := *inst
-	return &copy, nil
+	inst.LastUsed = inst.StartedAt
+	m.notifyLocked(inst)
+	progress(StageReady, "")
+	return inst.snapshot(), nil
+}
+
+// abortStart undoes a start that failed with err: it removes any partially
+// started container and releases the port. A start cancelled via CancelStart
+// returns to stopped; any other failure is recorded as an error.
+func (m *Manager) abortStart(ctx context.Context, inst *Instance, err error) (*Instance, error) {
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), startCleanupTimeout)
+	defer cancel()
+	m.docker.StopContainer(cleanupCtx, inst.ContainerName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Printf("Start of %s cancelled", inst.Path)
+		m.markStoppedLocked(inst)
+		return inst.snapshot(), ErrStartCancelled
+	}
+
+	inst.Status = StatusError
+	inst.Error = err.Error()
+	m.portPool.Release(inst.Port)
+	m.notifyLocked(inst)
+	return inst.snapshot(), err
+}
+
+// CancelStart aborts an in-progress or queued start of path. The start
+// itself cleans up and returns ErrStartCancelled.
+func (m *Manager) CancelStart(path string) error {
+	m.startsMu.Lock()
+	defer m.startsMu.Unlock()
+	op, ok := m.starts[path]
+	if !ok {
+		return ErrNotStarting
+	}
+	op.cancel()
+	return nil
+}
+
+// cancelStartAndWait cancels any start of path and waits for it to clean up
+func (m *Manager) cancelStartAndWait(ctx context.Context, path string) {
+	m.startsMu.Lock()
+	op, ok := m.starts[path]
+	m.startsMu.Unlock()
+	if !ok {
+		return
+	}
+	op.cancel()
+	select {
+	case <-op.done:
+	case <-ctx.Done():
+	}
+}
+
+// starting reports whether a start of path is in progress
+func (m *Manager) starting(path string) bool {
+	m.startsMu.Lock()
+	defer m.startsMu.Unlock()
+	_, ok := m.starts[path]
+	return ok
 }
 
 func (m *Manager) Stop(ctx context.Context, path string) error {
+	// A start in progress is cancelled rather than raced
+	m.cancelStartAndWait(ctx, path)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -159,16 +473,49 @@ func (m *Manager) Stop(ctx context.Context, path string) error {
 		return err
 	}
 
+	m.markStoppedLocked(inst)
+	return nil
+}
+
+// markStoppedLocked releases the instance's port, resets it to stopped and
+// publishes the change
+func (m *Manager) markStoppedLocked(inst *Instance) {
 	if inst.Port > 0 {
 		m.portPool.Release(inst.Port)
 	}
-
 	inst.Status = StatusStopped
 	inst.Port = 0
 	inst.Error = ""
 	inst.StartedAt = time.Time{}
+	m.notifyLocked(inst)
+}
 
-	return nil
+// StopAll stops every instance that is not already stopped and releases its port.
+// It keeps going after individual failures and returns them joined.
+func (m *Manager) StopAll(ctx context.Context) error {
+	for _, path := range m.config.AllowedPaths {
+		m.cancelStartAndWait(ctx, path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for _, inst := range m.instances {
+		if inst.Status == StatusStopped {
+			continue
+		}
+
+		if err := m.docker.StopContainer(ctx, inst.ContainerName); err != nil {
+			log.Printf("[Project] Failed to stop container %s: %v", inst.ContainerName, err)
+			errs = append(errs, fmt.Errorf("%s: %w", inst.Path, err))
+			continue
+		}
+		log.Printf("[Project] Stopped container %s (%s)", inst.ContainerName, inst.Path)
+		m.markStoppedLocked(inst)
+	}
+
+	return errors.Join(errs...)
 }
 
 func (m *Manager) GetOpenCodeURL(path string) (string, error) {
@@ -189,16 +536,25 @@ func (m *Manager) GetOpenCodeURL(path string) (string, error) {
 
 // GetOrStartOpenCodeURL returns the OpenCode URL for a project, starting it if not running.
 // This is the preferred method for handling requests that need auto-start behavior.
+// With Config.ManualStart it only waits for a start already in progress.
 func (m *Manager) GetOrStartOpenCodeURL(ctx context.Context, path string) (string, error) {
-	// First check if already running (read lock only)
-	m.mu.RLock()
+	// First check if already running
+	m.mu.Lock()
 	inst, ok := m.instances[path]
 	if ok && inst.Status == StatusRunning {
+		inst.LastUsed = time.Now()
 		url := inst.OpenCodeURL()
-		m.mu.RUnlock()
+		m.mu.Unlock()
 		return url, nil
 	}
-	m.mu.RUnlock()
+	m.mu.Unlock()
+
+	if m.config.ManualStart && !m.starting(path) {
+		if !ok {
+			return "", m.validatePath(path)
+		}
+		return "", ErrNotRunning
+	}
 
 	// Not running, need to start (this acquires write lock internally)
 	startedInst, err := m.Start(ctx, path)
@@ -209,21 +565,140 @@ func (m *Manager) GetOrStartOpenCodeURL(ctx context.Context, path string) (strin
 	return startedInst.OpenCodeURL(), nil
 }
 
-func (m *Manager) RefreshStatus(ctx context.Context) {
+// SetPinned marks an instance as exempt from (or subject to) idle cleanup
+func (m *Manager) SetPinned(path string, pinned bool) (*Instance, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	inst, ok := m.instances[path]
+	if !ok {
+		return nil, fmt.Errorf("project not found: %s", path)
+	}
+
+	inst.Pinned = pinned
+	return inst.snapshot(), nil
+}
+
+// SystemPreamble returns the system instruction configured for a project
+func (m *Manager) SystemPreamble(path string) string {
+	return m.config.SystemPreambles[path]
+}
+
+// openCodeConfigFor returns the OpenCode config file for path, preferring a
+// per-path override
+func (m *Manager) openCodeConfigFor(path string) string {
+	if file, ok := m.config.OpenCodeConfigs[path]; ok {
+		return file
+	}
+	return m.config.OpenCodeConfig
+}
+
+// idleTimeoutFor returns the idle timeout for path, preferring a per-path override
+func (m *Manager) idleTimeoutFor(path string) time.Duration {
+	if timeout, ok := m.config.IdleTimeouts[path]; ok {
+		return timeout
+	}
+	return m.config.IdleTimeout
+}
+
+// Cleanup stops running instances that have been idle longer than their
+// idle timeout (Config.IdleTimeouts, else Config.IdleTimeout). Pinned
+// instances are never stopped.
+func (m *Manager) Cleanup(ctx context.Context) {
+	if m.config.IdleTimeout <= 0 && len(m.config.IdleTimeouts) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, inst := range m.instances {
+		if inst.Status != StatusRunning || inst.Pinned {
+			continue
+		}
+		timeout := m.idleTimeoutFor(inst.Path)
+		if timeout <= 0 || time.Since(inst.LastUsed) < timeout {
+			continue
+		}
+
+		if err := m.docker.StopContainer(ctx, inst.ContainerName); err != nil {
+			log.Printf("[Project] Failed to stop idle container %s: %v", inst.ContainerName, err)
+			continue
+		}
+		log.Printf("[Project] Stopped idle container %s (%s)", inst.ContainerName, inst.Path)
+		m.markStoppedLocked(inst)
+	}
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done
+func (m *Manager) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Cleanup(ctx)
+		}
+	}
+}
+
+// RefreshStatus marks instances whose container has exited as stopped, so
+// GetOpenCodeURL stops handing out their URL. Docker is queried without the
+// lock; an instance that was started, stopped or restarted meanwhile is left
+// alone, as is any instance when docker can't be reached.
+func (m *Manager) RefreshStatus(ctx context.Context) {
+	type candidate struct {
+		inst      *Instance
+		status    Status
+		startedAt time.Time
+	}
+
+	m.mu.RLock()
+	var candidates []candidate
 	for _, inst := range m.instances {
-		if inst.Status == StatusRunning || inst.Status == StatusStarting {
-			if !m.docker.ContainerRunning(ctx, inst.ContainerName) {
-				if inst.Port > 0 {
-					m.portPool.Release(inst.Port)
-				}
-				inst.Status = StatusStopped
-				inst.Port = 0
-				inst.Error = ""
-				inst.StartedAt = time.Time{}
+		// A start in progress owns its instance until it returns
+		if inst.Status == StatusRunning || (inst.Status == StatusStarting && !m.starting(inst.Path)) {
+			candidates = append(candidates, candidate{inst, inst.Status, inst.StartedAt})
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, c := range candidates {
+		running, err := m.docker.ContainerRunning(ctx, c.inst.ContainerName)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[Project] Status refresh skipped %s: %v", c.inst.Path, err)
 			}
+			continue
+		}
+		if running {
+			continue
+		}
+
+		m.mu.Lock()
+		if c.inst.Status == c.status && c.inst.StartedAt.Equal(c.startedAt) && !m.starting(c.inst.Path) {
+			log.Printf("[Project] Container %s exited, marking %s stopped", c.inst.ContainerName, c.inst.Path)
+			c.inst.crashed = true
+			m.markStoppedLocked(c.inst)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// RunRefresh calls RefreshStatus every interval until ctx is done
+func (m *Manager) RunRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.RefreshStatus(ctx)
 		}
 	}
 }
@@ -237,6 +712,55 @@ func (m *Manager) validatePath(path string) error {
 	return fmt.Errorf("path not in whitelist: %s", path)
 }
 
+// SyncWithDocker reconciles the port pool with the containers actually
+// running, for ports restored from Config.PortStateFile. A stopped instance
+// whose container still runs and answers health checks on its recorded port
+// is adopted as running; a recorded port whose container is gone is
+// released. Ports of running but unhealthy containers stay reserved so new
+// starts don't collide with them. Entries docker can't be asked about are
+// kept for the next sync.
 func (m *Manager) SyncWithDocker(ctx context.Context) error {
-	return nil
+	var errs []error
+	for port, path := range m.portPool.Mappings() {
+		m.mu.RLock()
+		inst, ok := m.instances[path]
+		managed := ok && (inst.Status != StatusStopped || m.starting(path))
+		m.mu.RUnlock()
+		if managed {
+			continue
+		}
+		if !ok {
+			log.Printf("[Project] Releasing port %d of unknown project %s", port, path)
+			m.portPool.Release(port)
+			continue
+		}
+
+		running, err := m.docker.ContainerRunning(ctx, inst.ContainerName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		healthy := running && m.docker.IsPortInUse(ctx, port)
+
+		m.mu.Lock()
+		switch {
+		case inst.Status != StatusStopped || m.starting(path):
+			// Started meanwhile; the start owns the port now
+		case !running:
+			log.Printf("[Project] Container %s is gone, releasing port %d", inst.ContainerName, port)
+			m.portPool.Release(port)
+		case !healthy:
+			log.Printf("[Project] Container %s is not healthy on port %d, keeping it reserved", inst.ContainerName, port)
+		default:
+			log.Printf("[Project] Adopted running container %s on port %d", inst.ContainerName, port)
+			inst.Status = StatusRunning
+			inst.Port = port
+			inst.Error = ""
+			inst.StartedAt = time.Now()
+			inst.LastUsed = inst.StartedAt
+			m.notifyLocked(inst)
+		}
+		m.mu.Unlock()
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,205 @@
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocker writes a docker CLI stand-in that answers "ps -f name=^X$" with
+// an ID when X is one of running, and fails every command if broken is set
+func fakeDocker(t *testing.T, broken bool, running ...string) string {
+	t.Helper()
+	script := "#!/bin/sh\n"
+	if broken {
+		script += "exit 1\n"
+	}
+	script += `for a; do case "$a" in name=*) n=${a#name=^}; n=${n%\$};; esac; done
+case "$n" in
+`
+	for _, name := range running {
+		script += "\t" + name + ") echo 1234abcd ;;\n"
+	}
+	script += "esac\n"
+
+	path := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// healthyPort serves OpenCode's health check and returns its port
+func healthyPort(t *testing.T) int {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/global/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// writePortState saves ports as PortPool.saveLocked does
+func writePortState(t *testing.T, ports map[int]string) string {
+	t.Helper()
+	saved := make(map[string]string, len(ports))
+	for port, path := range ports {
+		saved[strconv.Itoa(port)] = path
+	}
+	data, _ := json.Marshal(saved)
+	path := filepath.Join(t.TempDir(), "ports.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSyncWithDocker(t *testing.T) {
+	healthy := healthyPort(t)
+	// The other recorded ports sit just below the healthy one, with nothing
+	// answering health checks on them
+	gone, unhealthy, unknown := healthy-1, healthy-2, healthy-3
+
+	adopted, stopped, hung := "/work/adopted", "/work/stopped", "/work/hung"
+	m := NewManager(&Config{
+		AllowedPaths: []string{adopted, stopped, hung},
+		PortMin:      unknown,
+		PortMax:      healthy,
+		DockerBinary: fakeDocker(t, false, DockerContainerPrefix+"adopted", DockerContainerPrefix+"hung"),
+		PortStateFile: writePortState(t, map[int]string{
+			healthy:   adopted,
+			gone:      stopped,
+			unhealthy: hung,
+			unknown:   "/work/removed-from-config",
+		}),
+	})
+
+	if err := m.SyncWithDocker(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := m.portPool.Mappings()
+	want := map[int]string{healthy: adopted, unhealthy: hung}
+	if len(got) != len(want) || got[healthy] != adopted || got[unhealthy] != hung {
+		t.Errorf("ports after sync = %v, want %v", got, want)
+	}
+
+	inst := m.GetByPath(adopted)
+	if inst.Status != StatusRunning || inst.Port != healthy {
+		t.Errorf("%s: status %s port %d, want running on %d", adopted, inst.Status, inst.Port, healthy)
+	}
+	for _, path := range []string{stopped, hung} {
+		if inst := m.GetByPath(path); inst.Status != StatusStopped {
+			t.Errorf("%s: status %s, want stopped", path, inst.Status)
+		}
+	}
+
+	// A fresh start must not be handed the unhealthy container's port
+	if port, err := m.portPool.Acquire(stopped); err != nil || port == unhealthy || port == healthy {
+		t.Errorf("Acquire after sync = %d, %v", port, err)
+	}
+}
+
+func TestSyncWithDockerUnreachable(t *testing.T) {
+	recorded := map[int]string{5000: "/work/a", 5001: "/work/b"}
+	m := NewManager(&Config{
+		AllowedPaths:  []string{"/work/a", "/work/b"},
+		PortMin:       5000,
+		PortMax:       5009,
+		DockerBinary:  fakeDocker(t, true),
+		PortStateFile: writePortState(t, recorded),
+	})
+
+	err := m.SyncWithDocker(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to query container") {
+		t.Errorf("SyncWithDocker = %v, want a query error", err)
+	}
+	if got := m.portPool.Mappings(); len(got) != len(recorded) {
+		t.Errorf("ports after failed sync = %v, want %v kept", got, recorded)
+	}
+}
+
+func TestCleanupIdleTimeouts(t *testing.T) {
+	idle := map[string]time.Duration{
+		"/work/default-stale": 20 * time.Minute,
+		"/work/default-fresh": 5 * time.Minute,
+		"/work/fast":          2 * time.Minute,
+		"/work/slow":          20 * time.Minute,
+		"/work/never":         time.Hour,
+		"/work/pinned":        time.Hour,
+	}
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		overrides map[string]time.Duration
+		stopped   []string
+	}{
+		{
+			name:    "default only",
+			timeout: 10 * time.Minute,
+			stopped: []string{"/work/default-stale", "/work/slow", "/work/never"},
+		},
+		{
+			name:    "overrides beside the default",
+			timeout: 10 * time.Minute,
+			overrides: map[string]time.Duration{
+				"/work/fast":  time.Minute,
+				"/work/slow":  30 * time.Minute,
+				"/work/never": 0,
+			},
+			stopped: []string{"/work/default-stale", "/work/fast"},
+		},
+		{
+			name:      "overrides without a default",
+			overrides: map[string]time.Duration{"/work/fast": time.Minute},
+			stopped:   []string{"/work/fast"},
+		},
+		{
+			name: "no timeouts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make([]string, 0, len(idle))
+			for path := range idle {
+				paths = append(paths, path)
+			}
+			m := NewManager(&Config{
+				AllowedPaths: paths,
+				IdleTimeout:  tt.timeout,
+				IdleTimeouts: tt.overrides,
+				DockerBinary: fakeDocker(t, false),
+			})
+			for path, d := range idle {
+				inst := m.instances[path]
+				inst.Status = StatusRunning
+				inst.LastUsed = time.Now().Add(-d)
+				inst.Pinned = path == "/work/pinned"
+			}
+
+			m.Cleanup(context.Background())
+
+			want := make(map[string]bool)
+			for _, path := range tt.stopped {
+				want[path] = true
+			}
+			for path := range idle {
+				if stopped := m.GetByPath(path).Status == StatusStopped; stopped != want[path] {
+					t.Errorf("%s stopped = %v, want %v", path, stopped, want[path])
+				}
+			}
+		})
+	}
+}
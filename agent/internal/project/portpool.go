@@ -2,7 +2,14 @@ package project
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 )
 
@@ -22,6 +29,15 @@ type PortPool struct {
 	maxPort       int
 	portToProject map[int]string
 	mu            sync.Mutex
+
+	// Deterministic starts each project's search at a port derived from a hash
+	// of its path, so a project lands on the same port across restarts unless
+	// that port is taken
+	Deterministic bool
+
+	// statePath, when set, is where the port mapping is saved after every
+	// change so a restarted agent can find ports its containers still hold
+	statePath string
 }
 
 func NewPortPool(minPort, maxPort int) *PortPool {
@@ -42,9 +58,10 @@ func (p *PortPool) Acquire(projectPath string) (int, error) {
 		}
 	}
 
-	for port := p.minPort; port <= p.maxPort; port++ {
+	for _, port := range p.candidates(projectPath) {
 		if _, ok := p.portToProject[port]; !ok {
 			p.portToProject[port] = projectPath
+			p.saveLocked()
 			return port, nil
 		}
 	}
@@ -62,7 +79,7 @@ func (p *PortPool) AcquireAvailable(ctx context.Context, projectPath string, che
 		}
 	}
 
-	for port := p.minPort; port <= p.maxPort; port++ {
+	for _, port := range p.candidates(projectPath) {
 		if _, ok := p.portToProject[port]; ok {
 			continue
 		}
@@ -72,12 +89,35 @@ func (p *PortPool) AcquireAvailable(ctx context.Context, projectPath string, che
 		}
 
 		p.portToProject[port] = projectPath
+		p.saveLocked()
 		return port, nil
 	}
 
 	return 0, ErrAllPortsInUse
 }
 
+// candidates returns the ports to try for projectPath, in order. With
+// Deterministic set the range is rotated to start at the path's hashed port.
+func (p *PortPool) candidates(projectPath string) []int {
+	size := p.maxPort - p.minPort + 1
+	if size <= 0 {
+		return nil
+	}
+
+	start := 0
+	if p.Deterministic {
+		h := fnv.New32a()
+		h.Write([]byte(projectPath))
+		start = int(h.Sum32() % uint32(size))
+	}
+
+	ports := make([]int, size)
+	for i := range ports {
+		ports[i] = p.minPort + (start+i)%size
+	}
+	return ports
+}
+
 func (p *PortPool) Release(port int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -87,6 +127,7 @@ func (p *PortPool) Release(port int) error {
 	}
 
 	delete(p.portToProject, port)
+	p.saveLocked()
 	return nil
 }
 
@@ -118,4 +159,75 @@ func (p *PortPool) MarkInUse(port int, projectPath string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.portToProject[port] = projectPath
+	p.saveLocked()
+}
+
+// Mappings returns a copy of the port to project path mapping
+func (p *PortPool) Mappings() map[int]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mappings := make(map[int]string, len(p.portToProject))
+	for port, path := range p.portToProject {
+		mappings[port] = path
+	}
+	return mappings
+}
+
+// LoadState restores the mapping saved at path, ignoring ports outside the
+// pool's range, and saves every later change there. A missing file starts
+// empty.
+func (p *PortPool) LoadState(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statePath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read port state: %w", err)
+	}
+	var saved map[string]string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid port state %s: %w", path, err)
+	}
+	for key, projectPath := range saved {
+		port, err := strconv.Atoi(key)
+		if err != nil || port < p.minPort || port > p.maxPort {
+			continue
+		}
+		p.portToProject[port] = projectPath
+	}
+	return nil
+}
+
+// saveLocked writes the mapping to statePath, replacing the file atomically.
+// Failures are logged: the in-memory pool stays authoritative.
+func (p *PortPool) saveLocked() {
+	if p.statePath == "" {
+		return
+	}
+	saved := make(map[string]string, len(p.portToProject))
+	for port, path := range p.portToProject {
+		saved[strconv.Itoa(port)] = path
+	}
+	data, _ := json.MarshalIndent(saved, "", "  ")
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.statePath), ".ports-*")
+	if err != nil {
+		log.Printf("[Project] Failed to save port state: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.statePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[Project] Failed to save port state: %v", err)
+	}
 }
@@ -0,0 +1,129 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPortPoolStateRoundTrip(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "ports.json")
+
+	p := NewPortPool(5000, 5009)
+	if err := p.LoadState(state); err != nil {
+		t.Fatalf("missing state file: %v", err)
+	}
+	a, err := p.Acquire("/work/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Acquire("/work/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(a)
+
+	restored := NewPortPool(5000, 5009)
+	if err := restored.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	got := restored.Mappings()
+	if len(got) != 1 || got[b] != "/work/b" {
+		t.Errorf("restored %v, want only %d -> /work/b", got, b)
+	}
+	if port, err := restored.Acquire("/work/b"); err != nil || port != b {
+		t.Errorf("Acquire after restore = %d, %v, want %d", port, err, b)
+	}
+}
+
+func TestPortPoolStateOutsideRange(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "ports.json")
+	os.WriteFile(state, []byte(`{"4999": "/work/low", "5003": "/work/in", "6000": "/work/high", "x": "/work/bad"}`), 0600)
+
+	p := NewPortPool(5000, 5009)
+	if err := p.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	got := p.Mappings()
+	if len(got) != 1 || got[5003] != "/work/in" {
+		t.Errorf("mappings = %v, want only 5003 -> /work/in", got)
+	}
+}
+
+func TestPortPoolStateInvalid(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "ports.json")
+	os.WriteFile(state, []byte("not json"), 0600)
+
+	p := NewPortPool(5000, 5009)
+	if err := p.LoadState(state); err == nil {
+		t.Error("LoadState accepted an invalid file")
+	}
+	if n := p.UsedCount(); n != 0 {
+		t.Errorf("%d ports used after an invalid state file", n)
+	}
+}
+
+// busyPorts is a PortChecker reporting the listed ports as taken
+type busyPorts map[int]bool
+
+func (b busyPorts) IsPortInUse(ctx context.Context, port int) bool {
+	return b[port]
+}
+
+func deterministicPool() *PortPool {
+	p := NewPortPool(5000, 5099)
+	p.Deterministic = true
+	return p
+}
+
+func TestPortPoolDeterministic(t *testing.T) {
+	paths := []string{"/work/a", "/work/b", "/work/c"}
+	first := make(map[string]int)
+	p := deterministicPool()
+	for _, path := range paths {
+		port, err := p.Acquire(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first[path] = port
+	}
+	if first["/work/a"] == 5000 && first["/work/b"] == 5001 && first["/work/c"] == 5002 {
+		t.Error("ports handed out in order, not by path")
+	}
+
+	// Fresh pools acquiring in another order hand out the same ports
+	p = deterministicPool()
+	for i := len(paths) - 1; i >= 0; i-- {
+		port, err := p.AcquireAvailable(context.Background(), paths[i], busyPorts{})
+		if err != nil || port != first[paths[i]] {
+			t.Errorf("%s got %d, %v in a fresh pool, want %d", paths[i], port, err, first[paths[i]])
+		}
+	}
+}
+
+func TestPortPoolDeterministicCollision(t *testing.T) {
+	hashed, _ := deterministicPool().Acquire("/work/a")
+	next := hashed + 1
+	if next > 5099 {
+		next = 5000 // The search wraps around the range
+	}
+
+	// Another project already holds a's port
+	state := filepath.Join(t.TempDir(), "ports.json")
+	os.WriteFile(state, []byte(fmt.Sprintf(`{"%d": "/work/other"}`, hashed)), 0600)
+	p := deterministicPool()
+	if err := p.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	if port, err := p.Acquire("/work/a"); err != nil || port != next {
+		t.Errorf("Acquire with %d taken = %d, %v, want %d", hashed, port, err, next)
+	}
+
+	// Something outside the pool listens on it
+	p = deterministicPool()
+	if port, err := p.AcquireAvailable(context.Background(), "/work/a", busyPorts{hashed: true}); err != nil || port != next {
+		t.Errorf("AcquireAvailable with %d busy = %d, %v, want %d", hashed, port, err, next)
+	}
+}
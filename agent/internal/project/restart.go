@@ -0,0 +1,95 @@
+package project
+
+import (
+	"context"
+	"sync"
+)
+
+// Outcomes of one instance in RestartAll
+const (
+	RestartRestarted = "restarted"
+	RestartFailed    = "failed"
+	RestartSkipped   = "skipped"
+)
+
+// RestartResult is the outcome of restarting one instance
+type RestartResult struct {
+	Path   string `json:"path"`
+	Result string `json:"result"` // RestartRestarted, RestartFailed or RestartSkipped
+	Error  string `json:"error,omitempty"`
+}
+
+// RestartOptions controls RestartAll
+type RestartOptions struct {
+	// Rolling restarts one instance at a time, so the others keep serving
+	Rolling bool
+	// SkipPinned leaves pinned instances running on their current container
+	SkipPinned bool
+}
+
+// RestartAll stops and starts every running instance, recreating its
+// container from the current image. Instances that aren't running,
+// including errored ones, are left alone and not reported. report is
+// called with each instance's outcome as it is known; the same results are
+// returned in completion order.
+func (m *Manager) RestartAll(ctx context.Context, opts RestartOptions, report func(RestartResult)) []RestartResult {
+	if report == nil {
+		report = func(RestartResult) {}
+	}
+
+	results := []RestartResult{}
+	var mu sync.Mutex
+	record := func(r RestartResult) {
+		mu.Lock()
+		results = append(results, r)
+		report(r)
+		mu.Unlock()
+	}
+
+	var targets []string
+	for _, inst := range m.List() {
+		if inst.Status != StatusRunning {
+			continue
+		}
+		if opts.SkipPinned && inst.Pinned {
+			record(RestartResult{Path: inst.Path, Result: RestartSkipped, Error: "pinned"})
+			continue
+		}
+		targets = append(targets, inst.Path)
+	}
+
+	restart := func(path string) {
+		if err := m.Stop(ctx, path); err != nil {
+			record(RestartResult{Path: path, Result: RestartFailed, Error: err.Error()})
+			return
+		}
+		if _, err := m.Start(ctx, path); err != nil {
+			record(RestartResult{Path: path, Result: RestartFailed, Error: err.Error()})
+			return
+		}
+		record(RestartResult{Path: path, Result: RestartRestarted})
+	}
+
+	if opts.Rolling {
+		for _, path := range targets {
+			if ctx.Err() != nil {
+				record(RestartResult{Path: path, Result: RestartSkipped, Error: ctx.Err().Error()})
+				continue
+			}
+			restart(path)
+		}
+		return results
+	}
+
+	// Starts still queue behind Config.MaxConcurrentStarts
+	var wg sync.WaitGroup
+	for _, path := range targets {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			restart(path)
+		}(path)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+)
+
+// aggregation is how a non-streaming action's chunks make up its one response
+type aggregation int
+
+const (
+	// aggregateArray wraps the chunks in a JSON array, in order
+	aggregateArray aggregation = iota
+	// aggregateLast keeps the final chunk: each is a complete response
+	// superseding the one before
+	aggregateLast
+	// aggregateConcat merges chunks that are JSON arrays into one array, for
+	// lists delivered in parts
+	aggregateConcat
+)
+
+// actionAggregation sets how each non-streaming action's chunks combine. Every
+// action sends one chunk today, which goes through unchanged; the choice only
+// matters once one sends more. Unlisted actions use aggregateArray so nothing
+// is dropped.
+var actionAggregation = map[string]aggregation{
+	"session.create":   aggregateLast,
+	"session.delete":   aggregateLast,
+	"session.rename":   aggregateLast,
+	"provider.list":    aggregateLast,
+	"agent.stats":      aggregateLast,
+	"session.list":     aggregateConcat,
+	"session.messages": aggregateConcat,
+}
+
+// aggregateResponse combines the chunks a non-streaming action sent into its
+// response payload. A chunk carrying an error is the response, whatever came
+// before or after it.
+func aggregateResponse(action string, chunks [][]byte) []byte {
+	switch len(chunks) {
+	case 0:
+		return nil
+	case 1:
+		return chunks[0]
+	}
+	for _, chunk := range chunks {
+		if isErrorPayload(chunk) {
+			return chunk
+		}
+	}
+
+	switch actionAggregation[action] {
+	case aggregateLast:
+		log.Printf("[Agent] %s sent %d chunks, keeping the last", action, len(chunks))
+		return chunks[len(chunks)-1]
+	case aggregateConcat:
+		if merged, ok := concatArrays(chunks); ok {
+			return merged
+		}
+		// Parts that aren't all lists can't be merged; keep every one
+	}
+
+	parts := make([]json.RawMessage, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = chunk
+	}
+	payload, err := json.Marshal(parts)
+	if err != nil {
+		log.Printf("[Agent] %s sent %d chunks that aren't all JSON, keeping the last: %v", action, len(chunks), err)
+		return chunks[len(chunks)-1]
+	}
+	return payload
+}
+
+// concatArrays merges JSON array chunks into one array, reporting false if
+// any chunk is not an array
+func concatArrays(chunks [][]byte) ([]byte, bool) {
+	var merged []json.RawMessage
+	for _, chunk := range chunks {
+		if !bytes.HasPrefix(bytes.TrimSpace(chunk), []byte("[")) {
+			return nil, false
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(chunk, &items); err != nil {
+			return nil, false
+		}
+		merged = append(merged, items...)
+	}
+	if merged == nil {
+		merged = []json.RawMessage{}
+	}
+	payload, err := json.Marshal(merged)
+	return payload, err == nil
+}
+
+// isErrorPayload reports whether chunk is an {"error": ...} object
+func isErrorPayload(chunk []byte) bool {
+	var obj struct {
+		Error *string `json:"error"`
+	}
+	return json.Unmarshal(chunk, &obj) == nil && obj.Error != nil
+}
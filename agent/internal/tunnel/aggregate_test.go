@@ -0,0 +1,41 @@
+package tunnel
+
+import "testing"
+
+func TestAggregateResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		chunks []string
+		want   string
+	}{
+		{"no chunks", "session.list", nil, ""},
+		{"one chunk passes through", "prompt.sync", []string{`{"ok":true}`}, `{"ok":true}`},
+		{"one error chunk passes through", "session.create", []string{`{"error":"boom"}`}, `{"error":"boom"}`},
+
+		{"last keeps the final chunk", "session.create", []string{`{"id":"a"}`, `{"id":"b"}`}, `{"id":"b"}`},
+
+		{"concat merges arrays", "session.list", []string{`[1,2]`, `[3]`, ` [] `}, `[1,2,3]`},
+		{"concat of empty arrays", "session.messages", []string{`[]`, `[]`}, `[]`},
+		{"concat falls back to array", "session.list", []string{`[1]`, `{"more":true}`}, `[[1],{"more":true}]`},
+
+		{"unlisted actions wrap in an array", "file.read", []string{`{"a":1}`, `"b"`}, `[{"a":1},"b"]`},
+		{"array falls back to last for non-JSON", "file.read", []string{`{"a":1}`, `not json`}, `not json`},
+
+		{"error chunk wins over last", "session.create", []string{`{"id":"a"}`, `{"error":"gone"}`, `{"id":"b"}`}, `{"error":"gone"}`},
+		{"error chunk wins over concat", "session.list", []string{`[1]`, `{"error":"partial"}`}, `{"error":"partial"}`},
+		{"error chunk wins over array", "file.read", []string{`{"error":"denied"}`, `{"a":1}`}, `{"error":"denied"}`},
+		{"null error isn't an error", "session.create", []string{`{"id":"a","error":null}`, `{"id":"b"}`}, `{"id":"b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := make([][]byte, len(tt.chunks))
+			for i, c := range tt.chunks {
+				chunks[i] = []byte(c)
+			}
+			if got := string(aggregateResponse(tt.action, chunks)); got != tt.want {
+				t.Errorf("aggregateResponse(%s, %q) = %s, want %s", tt.action, tt.chunks, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,11 @@ package tunnel
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -12,17 +16,83 @@ import (
 )
 
 const (
-	MsgTypeRegister   = "agent.register"
-	MsgTypePong       = "agent.pong"
-	MsgTypeResponse   = "agent.response"
-	MsgTypeStream     = "agent.stream"
-	MsgTypeStreamEnd  = "agent.stream.end"
-	MsgTypeError      = "agent.error"
-	MsgTypeRegistered = "agent.registered"
-	MsgTypePing       = "agent.ping"
-	MsgTypeRequest    = "agent.request"
+	MsgTypeRegister    = "agent.register"
+	MsgTypePong        = "agent.pong"
+	MsgTypeResponse    = "agent.response"
+	MsgTypeStream      = "agent.stream"
+	MsgTypeStreamEnd   = "agent.stream.end"
+	MsgTypeStreamUsage = "agent.stream.usage"
+	MsgTypeError       = "agent.error"
+	MsgTypeRegistered  = "agent.registered"
+	MsgTypePing        = "agent.ping"
+	MsgTypeRequest     = "agent.request"
+	MsgTypeDraining    = "agent.draining"
+	MsgTypeDeregister  = "agent.deregister"
+	MsgTypeCancel      = "agent.cancel"
+
+	MsgTypeProjectStatus = "agent.project.status.changed"
+	MsgTypeChunk         = "agent.chunk"
+	MsgTypeInfo          = "agent.info"
 )
 
+// Version is this agent's release, reported to the hub at registration
+const Version = "0.2.0"
+
+// ProtocolVersion is the tunnel protocol this agent speaks
+const ProtocolVersion = 1
+
+const (
+	// versionTimeout bounds the OpenCode version query
+	versionTimeout = 5 * time.Second
+	// versionRefreshInterval is how often the OpenCode version is re-read so
+	// upgrades show up without reconnecting
+	versionRefreshInterval = 10 * time.Minute
+)
+
+const (
+	// maxFrameSize is the hub's read limit per frame
+	maxFrameSize = 1024 * 1024
+	// chunkDataSize is the payload bytes per chunk; base64 and the envelope
+	// keep each chunk frame well under maxFrameSize
+	chunkDataSize = 512 * 1024
+)
+
+// ChunkPayload carries one fragment of a message larger than maxFrameSize
+type ChunkPayload struct {
+	Type string `json:"type"`
+	Seq  int    `json:"seq"`
+	Last bool   `json:"last"`
+	Data []byte `json:"data"`
+}
+
+// Error codes sent in agent.error payloads
+const (
+	CodeAgentDraining      = "agent_draining"       // Request refused while draining
+	CodeActionNotPermitted = "action_not_permitted" // Action not in the allowlist
+	CodeProjectNotRunning  = "project_not_running"  // Project stopped and auto-start disabled
+)
+
+// Actions is every action the agent handles
+var Actions = []string{
+	"session.list",
+	"session.create",
+	"session.messages",
+	"session.rename",
+	"session.delete",
+	"prompt",
+	"provider.list",
+	"agent.stats",
+	"file.list",
+	"file.read",
+	"project.list",
+	"project.start",
+	"project.start.cancel",
+	"project.stop",
+	"project.pin",
+	"project.unpin",
+	"project.restart-all",
+}
+
 type Message struct {
 	Type    string          `json:"type"`
 	ID      string          `json:"id,omitempty"`
@@ -34,11 +104,23 @@ type RegisterPayload struct {
 	Token        string   `json:"token"`
 	Capabilities []string `json:"capabilities"`
 	Version      string   `json:"version"`
+
+	OpenCodeVersion string   `json:"opencodeVersion,omitempty"`
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Projects        []string `json:"projects,omitempty"` // Lets the hub fail sessions over between agents
+}
+
+// InfoPayload reports version changes after registration
+type InfoPayload struct {
+	Version         string `json:"version"`
+	OpenCodeVersion string `json:"opencodeVersion,omitempty"`
 }
 
 type RegisteredPayload struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+
+	ProtocolVersion int `json:"protocolVersion,omitempty"` // Newest protocol the hub speaks
 }
 
 type RequestPayload struct {
@@ -46,6 +128,8 @@ type RequestPayload struct {
 	Action      string          `json:"action"`
 	Data        json.RawMessage `json:"data"`
 	ProjectPath string          `json:"projectPath,omitempty"`
+	BaseURL     string          `json:"baseUrl,omitempty"`
+	Priority    string          `json:"priority,omitempty"` // PriorityHigh or PriorityLow, default by action
 }
 
 type Client struct {
@@ -55,8 +139,29 @@ type Client struct {
 	opencodeClient *opencode.Client
 	projectMgr     *project.Manager
 	conn           *websocket.Conn
+	writeMu        sync.Mutex
 	reconnectDelay time.Duration
 	maxReconnect   time.Duration
+
+	draining atomic.Bool
+	inflight sync.WaitGroup
+	drainMu  sync.Mutex // Makes checking draining and counting a request in inflight one step
+
+	cancels   map[string]context.CancelFunc // In-flight requests by ID
+	cancelsMu sync.Mutex
+	requests  scheduler // Bounds and orders request handling
+
+	maxAttempts int           // Consecutive failed connects before Run gives up (0 = never)
+	failures    int           // Consecutive failed connects
+	dialTimeout time.Duration // Bound on dialing and registering
+
+	readTimeout  time.Duration // Silence on the hub connection before reconnecting
+	writeTimeout time.Duration // Bound on each write to the hub
+
+	opencodeVersion atomic.Value // string, last version reported to the hub
+
+	allowedActions map[string]bool // nil = all actions permitted
+	debug          bool            // Log every tunnel message
 }
 
 func NewClient(hubURL, agentID, token string, opencodeClient *opencode.Client, projectMgr *project.Manager) *Client {
@@ -68,10 +173,83 @@ func NewClient(hubURL, agentID, token string, opencodeClient *opencode.Client, p
 		projectMgr:     projectMgr,
 		reconnectDelay: time.Second,
 		maxReconnect:   30 * time.Second,
+		dialTimeout:    DefaultDialTimeout,
+		readTimeout:    DefaultReadTimeout,
+		writeTimeout:   DefaultWriteTimeout,
+		cancels:        make(map[string]context.CancelFunc),
 	}
 }
 
+// SetMaxConcurrentRequests bounds how many requests are handled at once;
+// further requests wait, high priority first. 0 means no limit. Must be
+// called before Run.
+func (c *Client) SetMaxConcurrentRequests(n int) {
+	c.requests.limit = n
+}
+
+// SetAllowedActions restricts the actions this agent executes. An empty list
+// permits every action. Must be called before Run.
+func (c *Client) SetAllowedActions(actions []string) {
+	if len(actions) == 0 {
+		c.allowedActions = nil
+		return
+	}
+	c.allowedActions = make(map[string]bool, len(actions))
+	for _, action := range actions {
+		c.allowedActions[action] = true
+	}
+}
+
+// DefaultDialTimeout bounds dialing the hub and registering
+const DefaultDialTimeout = 10 * time.Second
+
+// SetReconnectPolicy makes Run return an error after maxAttempts consecutive
+// failed connections (0 = retry forever) and bounds each dial and
+// registration by dialTimeout (0 keeps DefaultDialTimeout). Must be called
+// before Run.
+func (c *Client) SetReconnectPolicy(maxAttempts int, dialTimeout time.Duration) {
+	c.maxAttempts = maxAttempts
+	if dialTimeout > 0 {
+		c.dialTimeout = dialTimeout
+	}
+}
+
+// SetDebug enables logging of every tunnel message (type, ID, truncated
+// payload). Must be called before Run.
+func (c *Client) SetDebug(debug bool) {
+	c.debug = debug
+}
+
+// dumpPayloadLimit caps how much of each payload a protocol dump logs
+const dumpPayloadLimit = 256
+
+// dump logs a tunnel message when debugging. dir is "<-" for messages from
+// the hub and "->" for messages to it. Register payloads carry the token and
+// are never logged.
+func (c *Client) dump(dir string, msg Message) {
+	if !c.debug {
+		return
+	}
+
+	payload := string(msg.Payload)
+	if msg.Type == MsgTypeRegister {
+		payload = "[redacted]"
+	} else if len(payload) > dumpPayloadLimit {
+		payload = payload[:dumpPayloadLimit] + "...(truncated)"
+	}
+	log.Printf("[tunnel] %s type=%s id=%s payload=%s", dir, msg.Type, msg.ID, payload)
+}
+
+func (c *Client) actionAllowed(action string) bool {
+	return c.allowedActions == nil || c.allowedActions[action]
+}
+
 func (c *Client) Run(ctx context.Context) error {
+	if c.projectMgr != nil {
+		go c.pushStatusChanges(ctx)
+	}
+	go c.refreshVersion(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -80,6 +258,10 @@ func (c *Client) Run(ctx context.Context) error {
 		}
 
 		if err := c.connectAndRun(ctx); err != nil {
+			c.failures++
+			if c.maxAttempts > 0 && c.failures >= c.maxAttempts {
+				return fmt.Errorf("giving up after %d failed connection attempts: %w", c.failures, err)
+			}
 			log.Printf("Connection error: %v, reconnecting in %v", err, c.reconnectDelay)
 
 			select {
@@ -95,22 +277,114 @@ func (c *Client) Run(ctx context.Context) error {
 	}
 }
 
+// pushStatusChanges forwards project status changes to the hub unprompted.
+// Changes while disconnected are dropped; clients re-list after reconnecting.
+func (c *Client) pushStatusChanges(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case inst := <-c.projectMgr.Changes():
+			payload, _ := json.Marshal(map[string]interface{}{"project": inst})
+			c.send(Message{Type: MsgTypeProjectStatus, Payload: payload})
+		}
+	}
+}
+
+// queryVersion asks OpenCode for its version, returning "" if unreachable
+func (c *Client) queryVersion(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+	v, err := c.opencodeClient.Version(ctx)
+	if err != nil {
+		log.Printf("Failed to query OpenCode version: %v", err)
+		return ""
+	}
+	return v
+}
+
+// reportedVersion returns the OpenCode version last sent to the hub
+func (c *Client) reportedVersion() string {
+	v, _ := c.opencodeVersion.Load().(string)
+	return v
+}
+
+// refreshVersion periodically re-reads the OpenCode version and tells the
+// hub when it changed
+func (c *Client) refreshVersion(ctx context.Context) {
+	ticker := time.NewTicker(versionRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v := c.queryVersion(ctx)
+			if v == "" || v == c.reportedVersion() {
+				continue
+			}
+			payload, _ := json.Marshal(InfoPayload{Version: Version, OpenCodeVersion: v})
+			if err := c.send(Message{Type: MsgTypeInfo, Payload: payload}); err != nil {
+				continue
+			}
+			c.opencodeVersion.Store(v)
+			log.Printf("OpenCode version changed to %s", v)
+		}
+	}
+}
+
 func (c *Client) connectAndRun(ctx context.Context) error {
 	log.Printf("Connecting to Hub: %s", c.hubURL)
-	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.hubURL, nil)
+	dialCtx, cancelDial := context.WithTimeout(ctx, c.dialTimeout)
+	defer cancelDial()
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, c.hubURL, nil)
 	if err != nil {
 		return err
 	}
+	c.writeMu.Lock()
 	c.conn = conn
+	c.writeMu.Unlock()
 	defer conn.Close()
 
+	// Unblock the read loop on shutdown with a deregistration, so the hub
+	// drops this agent at once instead of holding it as possibly offline,
+	// then a clean close frame
+	done := make(chan struct{})
+	defer close(done)
+	var joined atomic.Bool // Registered, so there is something to deregister
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.writeMu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if joined.Load() {
+				c.dump("->", Message{Type: MsgTypeDeregister})
+				conn.WriteJSON(Message{Type: MsgTypeDeregister})
+			}
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "agent shutting down"))
+			c.writeMu.Unlock()
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	ocVersion := c.queryVersion(ctx)
+	c.opencodeVersion.Store(ocVersion)
+	var projects []string
+	if c.projectMgr != nil {
+		projects = c.projectMgr.Paths()
+	}
 	regPayload, _ := json.Marshal(RegisterPayload{
-		AgentID:      c.agentID,
-		Token:        c.token,
-		Capabilities: []string{"opencode", "multi-project"},
-		Version:      "0.2.0",
+		AgentID:         c.agentID,
+		Token:           c.token,
+		Capabilities:    []string{"opencode", "multi-project", "file"},
+		Version:         Version,
+		OpenCodeVersion: ocVersion,
+		ProtocolVersion: ProtocolVersion,
+		Projects:        projects,
 	})
 
+	conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 	if err := conn.WriteJSON(Message{
 		Type:    MsgTypeRegister,
 		Payload: regPayload,
@@ -118,27 +392,38 @@ func (c *Client) connectAndRun(ctx context.Context) error {
 		return err
 	}
 
+	conn.SetReadDeadline(time.Now().Add(c.dialTimeout))
 	var regResp Message
 	if err := conn.ReadJSON(&regResp); err != nil {
 		return err
 	}
+	c.dump("<-", regResp)
 
 	if regResp.Type != MsgTypeRegistered {
-		return err
+		return fmt.Errorf("expected %s, got %s", MsgTypeRegistered, regResp.Type)
 	}
 
 	var registered RegisteredPayload
-	json.Unmarshal(regResp.Payload, &registered)
+	if err := json.Unmarshal(regResp.Payload, &registered); err != nil {
+		return fmt.Errorf("registration failed: malformed %s: %w", MsgTypeRegistered, err)
+	}
 	if !registered.Success {
-		log.Printf("Registration failed: %s", registered.Error)
-		return err
+		return fmt.Errorf("registration failed: %s", registered.Error)
 	}
 
 	log.Printf("Registered with Hub successfully")
+	joined.Store(true)
+	c.keepalive(conn, done)
+	c.failures = 0
+	if c.draining.Load() {
+		c.send(Message{Type: MsgTypeDraining})
+	}
 	c.reconnectDelay = time.Second
 
 	if c.projectMgr != nil {
-		c.projectMgr.SyncWithDocker(ctx)
+		if err := c.projectMgr.SyncWithDocker(ctx); err != nil {
+			log.Printf("Docker sync incomplete: %v", err)
+		}
 	}
 
 	return c.readLoop(ctx)
@@ -156,13 +441,49 @@ func (c *Client) readLoop(ctx context.Context) error {
 		if err := c.conn.ReadJSON(&msg); err != nil {
 			return err
 		}
+		c.extendReadDeadline(c.conn)
+		c.dump("<-", msg)
 
 		switch msg.Type {
 		case MsgTypePing:
-			c.conn.WriteJSON(Message{Type: MsgTypePong})
+			c.send(Message{Type: MsgTypePong})
 
 		case MsgTypeRequest:
-			go c.handleRequest(ctx, msg)
+			if !c.startRequest() {
+				c.sendCodedError(msg.ID, "agent draining, retry", CodeAgentDraining)
+				continue
+			}
+			// Each request gets its own context so agent.cancel can abort
+			// the in-flight OpenCode HTTP request
+			reqCtx, cancel := context.WithCancel(ctx)
+			c.cancelsMu.Lock()
+			c.cancels[msg.ID] = cancel
+			c.cancelsMu.Unlock()
+
+			c.requests.submit(requestPriority(msg.Payload), func() {
+				defer func() {
+					c.cancelsMu.Lock()
+					delete(c.cancels, msg.ID)
+					c.cancelsMu.Unlock()
+					cancel()
+					c.inflight.Done()
+				}()
+				// Cancelled while waiting for a slot
+				if reqCtx.Err() != nil {
+					c.sendError(msg.ID, "request cancelled")
+					return
+				}
+				c.handleRequest(reqCtx, msg)
+			})
+
+		case MsgTypeCancel:
+			c.cancelsMu.Lock()
+			cancel, ok := c.cancels[msg.ID]
+			c.cancelsMu.Unlock()
+			if ok {
+				log.Printf("[Agent] Cancelling request %s", msg.ID)
+				cancel()
+			}
 		}
 	}
 }
@@ -174,13 +495,29 @@ func (c *Client) handleRequest(ctx context.Context, msg Message) {
 		return
 	}
 
+	if !c.actionAllowed(req.Action) {
+		log.Printf("Refusing request %s: action %q not permitted", msg.ID, req.Action)
+		c.sendCodedError(msg.ID, "action not permitted: "+req.Action, CodeActionNotPermitted)
+		return
+	}
+
 	switch req.Action {
 	case "project.list":
 		c.handleProjectList(msg.ID)
 	case "project.start":
 		c.handleProjectStart(ctx, msg.ID, req.Data)
+	case "project.start.cancel":
+		c.handleProjectStartCancel(msg.ID, req.Data)
 	case "project.stop":
 		c.handleProjectStop(ctx, msg.ID, req.Data)
+	case "project.pin", "project.unpin":
+		c.handleProjectPin(msg.ID, req.Data, req.Action == "project.pin")
+	case "project.restart-all":
+		c.handleProjectRestartAll(ctx, msg.ID, req.Data)
+	case "file.list":
+		c.handleFileList(msg.ID, req)
+	case "file.read":
+		c.handleFileRead(ctx, msg.ID, req)
 	default:
 		c.handleOpenCodeRequest(ctx, msg.ID, req)
 	}
@@ -194,7 +531,7 @@ func (c *Client) handleProjectList(requestID string) {
 
 	projects := c.projectMgr.List()
 	payload, _ := json.Marshal(map[string]interface{}{"projects": projects})
-	c.conn.WriteJSON(Message{
+	c.send(Message{
 		Type:    MsgTypeResponse,
 		ID:      requestID,
 		Payload: payload,
@@ -215,14 +552,51 @@ func (c *Client) handleProjectStart(ctx context.Context, requestID string, data
 		return
 	}
 
-	inst, err := c.projectMgr.Start(ctx, req.Path)
+	// Stream each stage so the client sees progress during long starts
+	inst, err := c.projectMgr.StartWithProgress(ctx, req.Path, func(stage, detail string) {
+		payload, _ := json.Marshal(map[string]string{"path": req.Path, "stage": stage, "detail": detail})
+		c.send(Message{
+			Type:    MsgTypeStream,
+			ID:      requestID,
+			Payload: payload,
+		})
+	})
 	if err != nil {
 		c.sendError(requestID, err.Error())
 		return
 	}
 
 	payload, _ := json.Marshal(map[string]interface{}{"project": inst})
-	c.conn.WriteJSON(Message{
+	c.send(Message{
+		Type:    MsgTypeResponse,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+// handleProjectStartCancel aborts an in-progress project.start; that request
+// then fails with ErrStartCancelled
+func (c *Client) handleProjectStartCancel(requestID string, data json.RawMessage) {
+	if c.projectMgr == nil {
+		c.sendError(requestID, "project manager not configured")
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError(requestID, "invalid project.start.cancel payload")
+		return
+	}
+
+	if err := c.projectMgr.CancelStart(req.Path); err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]bool{"success": true})
+	c.send(Message{
 		Type:    MsgTypeResponse,
 		ID:      requestID,
 		Payload: payload,
@@ -249,7 +623,82 @@ func (c *Client) handleProjectStop(ctx context.Context, requestID string, data j
 	}
 
 	payload, _ := json.Marshal(map[string]bool{"success": true})
-	c.conn.WriteJSON(Message{
+	c.send(Message{
+		Type:    MsgTypeResponse,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+func (c *Client) handleProjectPin(requestID string, data json.RawMessage, pinned bool) {
+	if c.projectMgr == nil {
+		c.sendError(requestID, "project manager not configured")
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.sendError(requestID, "invalid project pin payload")
+		return
+	}
+
+	inst, err := c.projectMgr.SetPinned(req.Path, pinned)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{"project": inst})
+	c.send(Message{
+		Type:    MsgTypeResponse,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+// handleProjectRestartAll restarts every running instance, e.g. to pick up
+// a new image, streaming each instance's outcome as it finishes
+func (c *Client) handleProjectRestartAll(ctx context.Context, requestID string, data json.RawMessage) {
+	if c.projectMgr == nil {
+		c.sendError(requestID, "project manager not configured")
+		return
+	}
+
+	var opts project.RestartOptions
+	if len(data) > 0 {
+		var req struct {
+			Rolling    bool `json:"rolling"`
+			SkipPinned bool `json:"skipPinned"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.sendError(requestID, "invalid project.restart-all payload")
+			return
+		}
+		opts = project.RestartOptions{Rolling: req.Rolling, SkipPinned: req.SkipPinned}
+	}
+
+	results := c.projectMgr.RestartAll(ctx, opts, func(r project.RestartResult) {
+		payload, _ := json.Marshal(r)
+		c.send(Message{
+			Type:    MsgTypeStream,
+			ID:      requestID,
+			Payload: payload,
+		})
+	})
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Result]++
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"results":   results,
+		"restarted": counts[project.RestartRestarted],
+		"failed":    counts[project.RestartFailed],
+		"skipped":   counts[project.RestartSkipped],
+	})
+	c.send(Message{
 		Type:    MsgTypeResponse,
 		ID:      requestID,
 		Payload: payload,
@@ -259,21 +708,44 @@ func (c *Client) handleProjectStop(ctx context.Context, requestID string, data j
 func (c *Client) handleOpenCodeRequest(ctx context.Context, requestID string, req RequestPayload) {
 	var baseURL string
 
+	if req.Action == "prompt" {
+		if err := c.routePromptDirectory(&req); err != nil {
+			c.sendError(requestID, err.Error())
+			return
+		}
+	}
+
 	if c.projectMgr != nil && req.ProjectPath != "" {
 		log.Printf("[Agent] handleOpenCodeRequest: action=%s, projectPath=%s", req.Action, req.ProjectPath)
 		url, err := c.projectMgr.GetOrStartOpenCodeURL(ctx, req.ProjectPath)
 		if err != nil {
 			log.Printf("[Agent] GetOrStartOpenCodeURL failed: %v", err)
+			if errors.Is(err, project.ErrNotRunning) {
+				c.sendCodedError(requestID, err.Error(), CodeProjectNotRunning)
+				return
+			}
 			c.sendError(requestID, err.Error())
 			return
 		}
 		log.Printf("[Agent] Using OpenCode URL: %s", url)
 		baseURL = url
+		if req.Action == "prompt" {
+			req.Data = opencode.WithSystem(req.Data, c.projectMgr.SystemPreamble(req.ProjectPath))
+		}
+	} else if req.BaseURL != "" {
+		if !c.opencodeClient.AllowsURL(req.BaseURL) {
+			log.Printf("[Agent] Rejected OpenCode URL not in allowlist: %s", req.BaseURL)
+			c.sendError(requestID, "opencode URL not allowed: "+req.BaseURL)
+			return
+		}
+		baseURL = req.BaseURL
 	}
 
 	var streamCh <-chan []byte
 	var err error
 
+	// Lets configured OpenCode headers name the project
+	ctx = opencode.WithProject(ctx, req.ProjectPath)
 	if baseURL != "" {
 		streamCh, err = c.opencodeClient.HandleRequestWithURL(ctx, baseURL, req.SessionID, req.Action, req.Data)
 	} else {
@@ -289,38 +761,170 @@ func (c *Client) handleOpenCodeRequest(ctx context.Context, requestID string, re
 
 	if isStreaming {
 		for chunk := range streamCh {
-			c.conn.WriteJSON(Message{
-				Type:    MsgTypeStream,
+			msgType := MsgTypeStream
+			if usage, ok := opencode.UsageFromChunk(chunk); ok {
+				msgType, chunk = MsgTypeStreamUsage, usage
+			}
+			c.send(Message{
+				Type:    msgType,
 				ID:      requestID,
 				Payload: chunk,
 			})
 		}
-		c.conn.WriteJSON(Message{
+		if ctx.Err() != nil {
+			c.sendError(requestID, "request cancelled")
+			return
+		}
+		c.send(Message{
 			Type: MsgTypeStreamEnd,
 			ID:   requestID,
 		})
 	} else {
-		var responseData []byte
+		var chunks [][]byte
 		for chunk := range streamCh {
-			responseData = chunk
+			chunks = append(chunks, chunk)
 		}
-		c.conn.WriteJSON(Message{
+		c.send(Message{
 			Type:    MsgTypeResponse,
 			ID:      requestID,
-			Payload: responseData,
+			Payload: aggregateResponse(req.Action, chunks),
 		})
 	}
 }
 
 func (c *Client) sendError(requestID, errMsg string) {
 	payload, _ := json.Marshal(map[string]string{"error": errMsg})
-	c.conn.WriteJSON(Message{
+	c.send(Message{
+		Type:    MsgTypeError,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+func (c *Client) sendCodedError(requestID, errMsg, code string) {
+	payload, _ := json.Marshal(map[string]string{"error": errMsg, "code": code})
+	c.send(Message{
 		Type:    MsgTypeError,
 		ID:      requestID,
 		Payload: payload,
 	})
 }
 
+// routePromptDirectory sends a prompt naming a directory to the project
+// containing it, with the directory as the container sees it. The session
+// keeps its own project for later requests.
+func (c *Client) routePromptDirectory(req *RequestPayload) error {
+	var prompt opencode.PromptData
+	if json.Unmarshal(req.Data, &prompt) != nil || prompt.Directory == "" {
+		return nil
+	}
+	if c.projectMgr == nil {
+		return errors.New("prompt directories need project mode (--projects)")
+	}
+	projectPath, containerDir, err := c.projectMgr.ProjectForDirectory(prompt.Directory)
+	if err != nil {
+		return err
+	}
+	req.ProjectPath = projectPath
+	prompt.Directory = containerDir
+	req.Data, _ = json.Marshal(prompt)
+	return nil
+}
+
+// send writes msg to the hub; gorilla connections allow only one concurrent writer
+func (c *Client) send(msg Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return errors.New("not connected")
+	}
+	c.dump("->", msg)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) <= maxFrameSize {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		err = c.conn.WriteMessage(websocket.TextMessage, data)
+	} else {
+		err = c.sendChunked(msg)
+	}
+	if err != nil {
+		// A failed write leaves the connection unusable; closing it ends
+		// the read loop so the agent reconnects
+		c.conn.Close()
+	}
+	return err
+}
+
+// sendChunked splits msg's payload into agent.chunk frames the hub
+// reassembles. The caller holds writeMu, so the chunks go out contiguously.
+func (c *Client) sendChunked(msg Message) error {
+	payload := []byte(msg.Payload)
+	for seq := 0; len(payload) > 0 || seq == 0; seq++ {
+		n := len(payload)
+		if n > chunkDataSize {
+			n = chunkDataSize
+		}
+		chunk, _ := json.Marshal(ChunkPayload{
+			Type: msg.Type,
+			Seq:  seq,
+			Last: n == len(payload),
+			Data: payload[:n],
+		})
+		payload = payload[n:]
+
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		if err := c.conn.WriteJSON(Message{Type: MsgTypeChunk, ID: msg.ID, Payload: chunk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drain stops accepting new requests, tells the hub, and waits for in-flight
+// requests to finish or ctx to expire.
+func (c *Client) Drain(ctx context.Context) error {
+	c.drainMu.Lock()
+	already := c.draining.Swap(true)
+	c.drainMu.Unlock()
+	if already {
+		return nil
+	}
+
+	if err := c.send(Message{Type: MsgTypeDraining}); err != nil {
+		log.Printf("Failed to notify hub of draining: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("Drained all in-flight requests")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startRequest counts a new request in inflight unless the agent is
+// draining, reporting whether it may run. Once Drain has set draining no
+// request is added, so its Wait can't miss one.
+func (c *Client) startRequest() bool {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if c.draining.Load() {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
+
 func min(a, b time.Duration) time.Duration {
 	if a < b {
 		return a
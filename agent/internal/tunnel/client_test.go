@@ -0,0 +1,118 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// largePayload returns a JSON object of about size bytes
+func largePayload(size int) json.RawMessage {
+	var b strings.Builder
+	b.WriteString(`{"text":"`)
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "line %d of a long history. ", i)
+	}
+	b.WriteString(`"}`)
+	return json.RawMessage(b.String())
+}
+
+// hubFrames connects a client to a stand-in hub that reads with the hub's
+// frame limit, returning the client and the frames the hub received
+func hubFrames(t *testing.T) (*Client, <-chan []byte) {
+	t.Helper()
+	frames := make(chan []byte, 64)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(maxFrameSize)
+		defer close(frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if errors.Is(err, websocket.ErrReadLimit) {
+					t.Errorf("frame over the hub's %d byte limit", maxFrameSize)
+				}
+				return
+			}
+			frames <- data
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &Client{conn: conn, writeTimeout: 5 * time.Second}, frames
+}
+
+func TestSendChunkedRoundTrip(t *testing.T) {
+	c, frames := hubFrames(t)
+	payload := largePayload(5 * 1024 * 1024)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.send(Message{Type: MsgTypeResponse, ID: "req-1", Payload: payload})
+	}()
+
+	// Reassemble as the hub does
+	var data bytes.Buffer
+	for seq := 0; ; seq++ {
+		var frame []byte
+		select {
+		case frame = <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no chunk %d", seq)
+		}
+		var msg Message
+		var chunk ChunkPayload
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != MsgTypeChunk || msg.ID != "req-1" || chunk.Type != MsgTypeResponse || chunk.Seq != seq {
+			t.Fatalf("frame %d: %s %s, chunk %s seq %d", seq, msg.Type, msg.ID, chunk.Type, chunk.Seq)
+		}
+		data.Write(chunk.Data)
+		if chunk.Last {
+			if seq < 9 {
+				t.Errorf("5MB sent in %d chunks of at most %d bytes", seq+1, chunkDataSize)
+			}
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data.Bytes(), payload) {
+		t.Errorf("reassembled %d bytes differ from the %d sent", data.Len(), len(payload))
+	}
+}
+
+func TestSendSmallMessageUnchunked(t *testing.T) {
+	c, frames := hubFrames(t)
+	if err := c.send(Message{Type: MsgTypeResponse, ID: "req-1", Payload: json.RawMessage(`{"ok":true}`)}); err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	json.Unmarshal(<-frames, &msg)
+	if msg.Type != MsgTypeResponse || string(msg.Payload) != `{"ok":true}` {
+		t.Errorf("sent %s %s", msg.Type, msg.Payload)
+	}
+}
@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultReadTimeout is how long the hub connection may go without
+	// receiving anything before the agent reconnects. The hub pings every
+	// 54s, and the agent pings it every half timeout.
+	DefaultReadTimeout = 90 * time.Second
+	// DefaultWriteTimeout bounds each write to the hub
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// SetTimeouts sets how long the hub connection may stay silent before it is
+// considered dead and how long a write may block (0 keeps the defaults), so
+// a half-open connection ends in a reconnect rather than a hung agent. Must
+// be called before Run.
+func (c *Client) SetTimeouts(read, write time.Duration) {
+	if read > 0 {
+		c.readTimeout = read
+	}
+	if write > 0 {
+		c.writeTimeout = write
+	}
+}
+
+// extendReadDeadline gives conn another read timeout after traffic from the hub
+func (c *Client) extendReadDeadline(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
+// keepalive arms conn's read deadline, refreshed by pings and pongs as well
+// as messages, and pings the hub every half read timeout until done closes,
+// so a quiet but healthy connection never times out
+func (c *Client) keepalive(conn *websocket.Conn, done <-chan struct{}) {
+	c.extendReadDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline(conn)
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		c.extendReadDeadline(conn)
+		// As gorilla's default handler, but bounded by the write timeout
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(c.writeTimeout))
+		var netErr net.Error
+		if errors.Is(err, websocket.ErrCloseSent) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil
+		}
+		return err
+	})
+
+	go func() {
+		ticker := time.NewTicker(c.readTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
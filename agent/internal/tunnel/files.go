@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+const (
+	// maxFileReadSize is the largest file file.read returns
+	maxFileReadSize = 10 * 1024 * 1024
+	// fileChunkSize is the file bytes per streamed chunk, a multiple of 3 so
+	// base64 chunks concatenate into valid base64
+	fileChunkSize = 192 * 1024
+	// binarySniffSize is how much of a file is checked for binary content
+	binarySniffSize = 8 * 1024
+)
+
+// FileEntry is one item of a file.list response
+type FileEntry struct {
+	Name    string `json:"name"`
+	Dir     bool   `json:"dir"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // Unix milliseconds
+}
+
+// FileChunk is one streamed piece of a file.read. Binary files are sent
+// base64-encoded.
+type FileChunk struct {
+	Path     string `json:"path"`
+	Seq      int    `json:"seq"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding"` // "utf-8" or "base64"
+}
+
+type fileRequest struct {
+	Path string `json:"path"`
+}
+
+// resolveFileRequest decodes a file.* request and resolves its path within
+// the request's project
+func (c *Client) resolveFileRequest(req RequestPayload) (string, string, error) {
+	if c.projectMgr == nil {
+		return "", "", fmt.Errorf("project manager not configured")
+	}
+	var data fileRequest
+	if err := json.Unmarshal(req.Data, &data); err != nil {
+		return "", "", fmt.Errorf("invalid %s payload", req.Action)
+	}
+	if data.Path == "" {
+		data.Path = "."
+	}
+	resolved, err := c.projectMgr.ResolveFile(req.ProjectPath, data.Path)
+	if err != nil {
+		return "", "", err
+	}
+	return data.Path, resolved, nil
+}
+
+func (c *Client) handleFileList(requestID string, req RequestPayload) {
+	rel, dir, err := c.resolveFileRequest(req)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	files := make([]FileEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileEntry{
+			Name:    entry.Name(),
+			Dir:     entry.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixMilli(),
+		})
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{"path": rel, "entries": files})
+	c.send(Message{
+		Type:    MsgTypeResponse,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+// handleFileRead streams a file as FileChunk messages, then responds with
+// its size and encoding
+func (c *Client) handleFileRead(ctx context.Context, requestID string, req RequestPayload) {
+	rel, path, err := c.resolveFileRequest(req)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+	if info.IsDir() {
+		c.sendError(requestID, "path is a directory: "+rel)
+		return
+	}
+	if info.Size() > maxFileReadSize {
+		c.sendError(requestID, fmt.Sprintf("file too large: %d bytes (limit %d)", info.Size(), maxFileReadSize))
+		return
+	}
+
+	sniff := make([]byte, binarySniffSize)
+	n, _ := io.ReadFull(f, sniff)
+	encoding := "utf-8"
+	if isBinary(sniff[:n]) {
+		encoding = "base64"
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	buf := make([]byte, fileChunkSize)
+	seq := 0
+	var carry []byte // Incomplete UTF-8 sequence from the previous chunk
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		n, readErr := io.ReadFull(f, buf)
+		chunk := append(carry, buf[:n]...)
+		carry = nil
+
+		var data string
+		if encoding == "base64" {
+			data = base64.StdEncoding.EncodeToString(chunk)
+		} else {
+			// Keep multi-byte characters whole across chunks
+			if tail := utf8Tail(chunk); readErr == nil && tail < len(chunk) {
+				carry = append([]byte(nil), chunk[tail:]...)
+				chunk = chunk[:tail]
+			}
+			data = string(chunk)
+		}
+
+		if len(chunk) > 0 {
+			payload, _ := json.Marshal(FileChunk{Path: rel, Seq: seq, Data: data, Encoding: encoding})
+			c.send(Message{Type: MsgTypeStream, ID: requestID, Payload: payload})
+			seq++
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			c.sendError(requestID, readErr.Error())
+			return
+		}
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"path":     rel,
+		"size":     info.Size(),
+		"encoding": encoding,
+		"chunks":   seq,
+	})
+	c.send(Message{
+		Type:    MsgTypeResponse,
+		ID:      requestID,
+		Payload: payload,
+	})
+}
+
+// isBinary guesses whether a file is binary from its first bytes
+func isBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	// The sniffed prefix may end mid-character
+	return !utf8.Valid(head[:utf8Tail(head)])
+}
+
+// utf8Tail returns where an incomplete UTF-8 sequence ending b starts, or
+// len(b) if b ends on a character boundary
+func utf8Tail(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,163 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openvibe/agent/internal/project"
+)
+
+func TestUTF8Tail(t *testing.T) {
+	tests := []struct {
+		name string
+		b    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "abc", 3},
+		{"whole multi-byte", "a€", 4},
+		{"cut three-byte", "a\xe2\x82", 1},
+		{"cut four-byte", "ab\xf0\x9f\x98", 2},
+		{"lead byte only", "ab\xf0", 2},
+		{"stray continuation", "a\x80\x80", 3}, // Invalid, not incomplete
+	}
+	for _, tt := range tests {
+		if got := utf8Tail([]byte(tt.b)); got != tt.want {
+			t.Errorf("%s: utf8Tail(%q) = %d, want %d", tt.name, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{"text", "package main\n", false},
+		{"utf-8", "naïve café 😀", false},
+		{"cut mid-character", "café \xf0\x9f\x98", false},
+		{"nul byte", "abc\x00def", true},
+		{"latin-1", "caf\xe9 au lait", true},
+	}
+	for _, tt := range tests {
+		if got := isBinary([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: isBinary = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// readFile runs file.read for rel in project root, returning the streamed
+// chunks and the final message
+func readFile(t *testing.T, root, rel string) ([]FileChunk, Message) {
+	t.Helper()
+	c, frames := hubFrames(t)
+	c.projectMgr = project.NewManager(&project.Config{AllowedPaths: []string{root}})
+
+	data, _ := json.Marshal(fileRequest{Path: rel})
+	go c.handleFileRead(context.Background(), "req-1", RequestPayload{Action: "file.read", ProjectPath: root, Data: data})
+
+	var chunks []FileChunk
+	for {
+		var frame []byte
+		select {
+		case frame = <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("file.read of %s never finished", rel)
+		}
+		var msg Message
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != MsgTypeStream {
+			return chunks, msg
+		}
+		var chunk FileChunk
+		json.Unmarshal(msg.Payload, &chunk)
+		if chunk.Seq != len(chunks) {
+			t.Errorf("chunk %d has seq %d", len(chunks), chunk.Seq)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestFileReadText(t *testing.T) {
+	root := t.TempDir()
+	// A euro sign straddles the first chunk boundary
+	content := strings.Repeat("a", fileChunkSize-1) + "€" + strings.Repeat("b", fileChunkSize)
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte(content), 0o644)
+
+	chunks, done := readFile(t, root, "notes.txt")
+	if done.Type != MsgTypeResponse {
+		t.Fatalf("file.read: %s %s", done.Type, done.Payload)
+	}
+	var got strings.Builder
+	for _, chunk := range chunks {
+		if chunk.Encoding != "utf-8" {
+			t.Errorf("chunk %d encoded as %s", chunk.Seq, chunk.Encoding)
+		}
+		if !utf8.ValidString(chunk.Data) {
+			t.Errorf("chunk %d splits a character", chunk.Seq)
+		}
+		got.WriteString(chunk.Data)
+	}
+	if len(chunks) != 3 || got.String() != content {
+		t.Fatalf("got %d chunks of %d bytes, want the %d byte file in 3", len(chunks), got.Len(), len(content))
+	}
+	if first := len(chunks[0].Data); first != fileChunkSize-1 {
+		t.Errorf("first chunk %d bytes, want the euro sign carried over", first)
+	}
+}
+
+func TestFileReadBinary(t *testing.T) {
+	root := t.TempDir()
+	content := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff}, fileChunkSize/3)
+	os.WriteFile(filepath.Join(root, "logo.png"), content, 0o644)
+
+	chunks, done := readFile(t, root, "logo.png")
+	if done.Type != MsgTypeResponse {
+		t.Fatalf("file.read: %s %s", done.Type, done.Payload)
+	}
+	// Chunks concatenate into one valid base64 string
+	var encoded strings.Builder
+	for _, chunk := range chunks {
+		if chunk.Encoding != "base64" {
+			t.Errorf("chunk %d encoded as %s", chunk.Seq, chunk.Encoding)
+		}
+		encoded.WriteString(chunk.Data)
+	}
+	got, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("decoded %d bytes (%v), want the %d byte file", len(got), err, len(content))
+	}
+}
+
+func TestFileReadTooLarge(t *testing.T) {
+	root := t.TempDir()
+	f, err := os.Create(filepath.Join(root, "huge.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(maxFileReadSize + 1)
+	f.Close()
+
+	chunks, done := readFile(t, root, "huge.bin")
+	if len(chunks) != 0 || done.Type != MsgTypeError || !strings.Contains(string(done.Payload), "file too large") {
+		t.Errorf("got %d chunks then %s %s, want a size error", len(chunks), done.Type, done.Payload)
+	}
+}
+
+func TestFileReadOutsideProject(t *testing.T) {
+	chunks, done := readFile(t, t.TempDir(), "../etc/passwd")
+	if len(chunks) != 0 || done.Type != MsgTypeError || !strings.Contains(string(done.Payload), project.ErrOutsideProject.Error()) {
+		t.Errorf("got %d chunks then %s %s, want refused", len(chunks), done.Type, done.Payload)
+	}
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Request priorities carried in RequestPayload.Priority
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// lowPriorityActions default to PriorityLow: bulk reads that can wait behind
+// interactive requests
+var lowPriorityActions = map[string]bool{
+	"session.messages": true,
+	"file.read":        true,
+}
+
+// requestPriority returns a request's priority, defaulting by action
+func requestPriority(payload json.RawMessage) string {
+	var req struct {
+		Action   string `json:"action"`
+		Priority string `json:"priority"`
+	}
+	json.Unmarshal(payload, &req)
+	switch req.Priority {
+	case PriorityHigh, PriorityLow:
+		return req.Priority
+	}
+	if lowPriorityActions[req.Action] {
+		return PriorityLow
+	}
+	return PriorityHigh
+}
+
+// scheduler runs at most limit requests at once (0 = unlimited). Waiting
+// high-priority requests start before low-priority ones, each in arrival
+// order.
+type scheduler struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	high    []func()
+	low     []func()
+}
+
+// submit runs fn now if a slot is free, else queues it by priority
+func (s *scheduler) submit(priority string, fn func()) {
+	s.mu.Lock()
+	if s.limit <= 0 || s.running < s.limit {
+		s.running++
+		s.mu.Unlock()
+		go s.run(fn)
+		return
+	}
+	if priority == PriorityLow {
+		s.low = append(s.low, fn)
+	} else {
+		s.high = append(s.high, fn)
+	}
+	s.mu.Unlock()
+}
+
+// run calls fn, then hands its slot to the next queued request
+func (s *scheduler) run(fn func()) {
+	for fn != nil {
+		fn()
+
+		s.mu.Lock()
+		switch {
+		case len(s.high) > 0:
+			fn, s.high = s.high[0], s.high[1:]
+		case len(s.low) > 0:
+			fn, s.low = s.low[0], s.low[1:]
+		default:
+			fn = nil
+			s.running--
+		}
+		s.mu.Unlock()
+	}
+}
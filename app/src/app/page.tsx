@@ -10,9 +10,10 @@ import { useMessageHandler } from '@/hooks/useMessageHandler';
 import { generateId } from '@/lib/utils';
 import type { Message, ClientMessage, ServerMessage, Project } from '@/types';
 
+const BASE_PATH = process.env.NEXT_PUBLIC_BASE_PATH || '';
 const WS_URL = process.env.NEXT_PUBLIC_WS_URL ||
   (typeof window !== 'undefined' && window.location.hostname !== 'localhost'
-    ? `ws://${window.location.host}/ws`
+    ? `ws://${window.location.host}${BASE_PATH}/ws`
     : 'ws://localhost:8080/ws');
 
 type SendFn = (msg: ClientMessage, handler?: (response: ServerMessage) => void) => boolean;
@@ -42,9 +43,12 @@ export default function Home() {
     loading: projectsLoading,
     listProjects,
     startProject,
+    cancelStartProject,
     stopProject,
     handleResponse: handleProjectResponse,
     handleError: handleProjectError,
+    handleProgress: handleProjectProgress,
+    handleStatusChange: handleProjectStatus,
   } = useProjects({ 
   send: (msg) => {
     const result = sendRef.current?.({ ...msg, payload: msg.payload } as ClientMessage);
@@ -70,6 +74,8 @@ export default function Home() {
     onError: (error) => addToast('error', error),
     onResponse: handleProjectResponse,
     onErrorById: handleProjectError,
+    onProgress: handleProjectProgress,
+    onProjectStatus: handleProjectStatus,
   });
 
   const { state, send } = useWebSocket({
@@ -144,6 +150,14 @@ export default function Home() {
     }
   }, [startProject, addToast]);
 
+  const handleCancelStartProject = useCallback(async (path: string) => {
+    try {
+      await cancelStartProject(path);
+    } catch (err) {
+      addToast('error', err instanceof Error ? err.message : 'Failed to cancel project start');
+    }
+  }, [cancelStartProject, addToast]);
+
   const handleStopProject = useCallback(async (path: string) => {
     try {
       await stopProject(path);
@@ -282,6 +296,7 @@ export default function Home() {
                   onSelect={selectProject}
                   onStart={handleStartProject}
                   onStop={handleStopProject}
+                  onCancelStart={handleCancelStartProject}
                   disabled={!isConnected}
                   loading={projectsLoading}
                 />
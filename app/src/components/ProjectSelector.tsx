@@ -10,11 +10,12 @@ interface ProjectSelectorProps {
   onSelect: (path: string) => void;
   onStart?: (path: string) => void;
   onStop?: (path: string) => void;
+  onCancelStart?: (path: string) => void;
   disabled?: boolean;
   loading?: boolean;
 }
 
-function StatusIndicator({ status }: { status: ProjectStatus }) {
+function StatusIndicator({ status, stage }: { status: ProjectStatus; stage?: string }) {
   const statusConfig: Record<ProjectStatus, { color: string; label: string; animate?: boolean }> = {
     running: { color: 'bg-[var(--accent-primary)]', label: 'Running', animate: true },
     starting: { color: 'bg-yellow-400', label: 'Starting', animate: true },
@@ -27,7 +28,9 @@ function StatusIndicator({ status }: { status: ProjectStatus }) {
   return (
     <div className="flex items-center gap-1.5">
       <div className={`w-2 h-2 rounded-full ${config.color} ${config.animate ? 'animate-pulse' : ''}`} />
-      <span className="text-xs text-[var(--text-muted)]">{config.label}</span>
+      <span className="text-xs text-[var(--text-muted)]">
+        {status === 'starting' && stage ? `${config.label} (${stage})` : config.label}
+      </span>
     </div>
   );
 }
@@ -38,12 +41,14 @@ function ProjectItem({
   onSelect,
   onStart,
   onStop,
+  onCancelStart,
 }: {
   project: Project;
   isActive: boolean;
   onSelect: () => void;
   onStart?: () => void;
   onStop?: () => void;
+  onCancelStart?: () => void;
 }) {
   const handleAction = useCallback((e: React.MouseEvent) => {
     e.stopPropagation();
@@ -54,6 +59,11 @@ function ProjectItem({
     }
   }, [project.status, onStart, onStop]);
 
+  const handleCancel = useCallback((e: React.MouseEvent) => {
+    e.stopPropagation();
+    onCancelStart?.();
+  }, [onCancelStart]);
+
   const canStart = project.status === 'stopped' || project.status === 'error';
   const canStop = project.status === 'running';
   const isLoading = project.status === 'starting';
@@ -77,7 +87,7 @@ function ProjectItem({
         <div className="text-sm font-medium text-[var(--text-primary)] truncate">
           {project.name}
         </div>
-        <StatusIndicator status={project.status} />
+        <StatusIndicator status={project.status} stage={project.stage} />
       </div>
       {(canStart || canStop) && (
         <button
@@ -95,6 +105,14 @@ function ProjectItem({
           {canStart ? 'Start' : 'Stop'}
         </button>
       )}
+      {isLoading && onCancelStart && (
+        <button
+          onClick={handleCancel}
+          className="px-2 py-1 text-xs font-medium rounded-md transition-all bg-[var(--bg-tertiary)] text-[var(--text-secondary)] hover:bg-[var(--accent-error)] hover:text-white"
+        >
+          Cancel
+        </button>
+      )}
       {isLoading && (
         <div className="w-4 h-4 border-2 border-[var(--accent-primary)] border-t-transparent rounded-full animate-spin" />
       )}
@@ -108,6 +126,7 @@ export const ProjectSelector = memo(function ProjectSelector({
   onSelect,
   onStart,
   onStop,
+  onCancelStart,
   disabled = false,
   loading = false,
 }: ProjectSelectorProps) {
@@ -189,6 +208,7 @@ export const ProjectSelector = memo(function ProjectSelector({
                   onSelect={() => handleSelect(project.path)}
                   onStart={() => onStart?.(project.path)}
                   onStop={() => onStop?.(project.path)}
+                  onCancelStart={onCancelStart && (() => onCancelStart(project.path))}
                 />
               ))}
             </div>
@@ -61,7 +61,7 @@ function SessionItem({
             {session.title || 'New Chat'}
           </div>
           <div className="text-xs text-[var(--text-muted)] mt-0.5">
-            {formatDate(session.createdAt)}
+            {formatDate(session.lastActivity ?? session.createdAt)}
           </div>
         </div>
       </div>
@@ -14,6 +14,8 @@ interface ServerSession {
   title: string;
   directory?: string;
   time?: { created: number; updated: number };
+  metadata?: Record<string, unknown>;
+  lastActivity?: number;
 }
 
 interface UseMessageHandlerOptions {
@@ -31,6 +33,10 @@ interface UseMessageHandlerOptions {
   onResponse?: (msgId: string, payload: unknown) => boolean;
   /** External error handler for specific request IDs */
   onErrorById?: (msgId: string, error: string) => boolean;
+  /** Progress updates ahead of a response (e.g., project.start stages) */
+  onProgress?: (msgId: string, payload: unknown) => void;
+  /** Unsolicited project status pushed by an agent */
+  onProjectStatus?: (payload: unknown) => void;
 }
 
 function convertOpenCodeMessages(ocMessages: OpenCodeMessage[]): Message[] {
@@ -50,16 +56,35 @@ function convertOpenCodeMessages(ocMessages: OpenCodeMessage[]): Message[] {
 }
 
 function mapServerSessions(serverSessions: ServerSession[]): Session[] {
-  return serverSessions.map(s => ({
+  const sorted = [...serverSessions].sort(
+    (a, b) => (b.lastActivity ?? b.time?.created ?? 0) - (a.lastActivity ?? a.time?.created ?? 0)
+  );
+  return sorted.map(s => ({
     id: s.id,
     title: s.title || 'New Chat',
     createdAt: s.time?.created || Date.now(),
     messages: [],
     directory: s.directory,
     time: s.time,
+    metadata: s.metadata,
+    lastActivity: s.lastActivity,
   }));
 }
 
+// A chunk repeating a part ID replaces that part rather than appending to it
+function applyStreamChunk(message: Message, payload: StreamPayload): Message {
+  if (!payload.partId) {
+    return { ...message, content: message.content + payload.text };
+  }
+
+  const parts = message.parts ?? [];
+  const seen = parts.some(p => p.id === payload.partId);
+  const updated = seen
+    ? parts.map(p => (p.id === payload.partId ? { ...p, text: payload.text } : p))
+    : [...parts, { id: payload.partId, text: payload.text }];
+  return { ...message, parts: updated, content: updated.map(p => p.text).join('\n') };
+}
+
 export function useMessageHandler(options: UseMessageHandlerOptions) {
   const {
     currentSessionId,
@@ -74,6 +99,8 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
     onError,
     onResponse,
     onErrorById,
+    onProgress,
+    onProjectStatus,
   } = options;
 
   const streamingMessageId = useRef<string | null>(null);
@@ -92,6 +119,21 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
           return;
         }
 
+        // A history over the agent's size cap comes as a page of its newest messages
+        const page = payload as { messages?: unknown; truncated?: boolean } | null;
+        if (page && !Array.isArray(page) && Array.isArray(page.messages) && page.truncated) {
+          const pending = pendingRequest.current;
+          if (pending && msg.id === pending.requestId) {
+            const converted = convertOpenCodeMessages(page.messages as OpenCodeMessage[]);
+            if (pending.sessionId === currentSessionId) {
+              setMessages(converted);
+              updateSessionMessages(pending.sessionId, converted);
+            }
+            pendingRequest.current = null;
+            return;
+          }
+        }
+
         if (Array.isArray(payload)) {
           const pending = pendingRequest.current;
           if (pending && msg.id === pending.requestId) {
@@ -138,6 +180,18 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
         break;
       }
 
+      case 'progress': {
+        if (msg.id) {
+          onProgress?.(msg.id, msg.payload);
+        }
+        break;
+      }
+
+      case 'project.status.changed': {
+        onProjectStatus?.(msg.payload);
+        break;
+      }
+
       case 'stream': {
         const payload = msg.payload as StreamPayload;
         const messageId = msg.id;
@@ -154,6 +208,7 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
                 content: payload.text,
                 timestamp: Date.now(),
                 streaming: true,
+                parts: payload.partId ? [{ id: payload.partId, text: payload.text }] : undefined,
               },
             ];
             if (currentSessionId) {
@@ -164,7 +219,7 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
         } else {
           setMessages(prev => {
             const updated = prev.map(m =>
-              m.id === messageId ? { ...m, content: m.content + payload.text } : m
+              m.id === messageId ? applyStreamChunk(m, payload) : m
             );
             if (currentSessionId) {
               updateSessionMessages(currentSessionId, updated);
@@ -227,6 +282,8 @@ export function useMessageHandler(options: UseMessageHandlerOptions) {
     onError,
     onResponse,
     onErrorById,
+    onProgress,
+    onProjectStatus,
   ]);
 
   return { handleMessage, setPendingRequest };
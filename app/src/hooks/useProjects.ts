@@ -54,6 +54,19 @@ export function useProjects({ send }: UseProjectsOptions) {
     });
   }, [send]);
 
+  const cancelStartProject = useCallback((path: string) => {
+    const id = generateId();
+    send({
+      type: 'project.start.cancel',
+      id,
+      payload: { path },
+    });
+
+    return new Promise<boolean>((resolve, reject) => {
+      pendingRequests.current.set(id, { resolve: resolve as (value: unknown) => void, reject });
+    });
+  }, [send]);
+
   const stopProject = useCallback((path: string) => {
     setProjects(prev => prev.map(p => 
       p.path === path ? { ...p, status: 'starting' as const } : p
@@ -110,6 +123,26 @@ export function useProjects({ send }: UseProjectsOptions) {
     return true;
   }, [listProjects]);
 
+  const handleProgress = useCallback((msgId: string, payload: unknown) => {
+    if (!pendingRequests.current.has(msgId)) return;
+
+    const { path, stage } = payload as { path?: string; stage?: string };
+    if (!path || !stage) return;
+
+    setProjects(prev => prev.map(p =>
+      p.path === path ? { ...p, stage } : p
+    ));
+  }, []);
+
+  const handleStatusChange = useCallback((payload: unknown) => {
+    const { project } = payload as { project?: Project };
+    if (!project?.path) return;
+
+    setProjects(prev => prev.map(p =>
+      p.path === project.path ? { ...p, ...project } : p
+    ));
+  }, []);
+
   const handleError = useCallback((msgId: string, errorMsg: string) => {
     const pending = pendingRequests.current.get(msgId);
     if (!pending) return false;
@@ -132,8 +165,11 @@ export function useProjects({ send }: UseProjectsOptions) {
     error,
     listProjects,
     startProject,
+    cancelStartProject,
     stopProject,
     handleResponse,
     handleError,
+    handleProgress,
+    handleStatusChange,
   };
 }
@@ -147,11 +147,12 @@ export function useWebSocket({ url, sessionId, onMessage, onConnect, onDisconnec
           return;
         }
 
-        const handler = messageHandlersRef.current.get(msg.id ?? '');
-        if (handler) {
+        // Notifications and other pushed messages carry no id
+        const handler = msg.id ? messageHandlersRef.current.get(msg.id) : undefined;
+        if (msg.id && handler) {
           handler(msg);
           if (msg.type !== 'stream') {
-            messageHandlersRef.current.delete(msg.id ?? '');
+            messageHandlersRef.current.delete(msg.id);
           }
         }
 
@@ -5,6 +5,7 @@ export interface Message {
   timestamp: number;
   streaming?: boolean;
   msgId?: number; // Buffer message ID for sync
+  parts?: Array<{ id: string; text: string }>; // Streamed parts by OpenCode part ID
 }
 
 export interface Session {
@@ -14,10 +15,14 @@ export interface Session {
   messages: Message[];
   directory?: string;
   time?: { created: number; updated: number };
+  /** Hub-stored metadata (session.setmeta), e.g. tags */
+  metadata?: Record<string, unknown>;
+  /** Latest OpenCode update or buffered message, Unix ms */
+  lastActivity?: number;
 }
 
 export interface ClientMessage {
-  type: 'ping' | 'session.create' | 'session.list' | 'session.messages' | 'session.delete' | 'prompt' | 'sync' | 'ack' | 'project.list' | 'project.start' | 'project.stop';
+  type: 'ping' | 'session.create' | 'session.list' | 'provider.list' | 'agent.list' | 'agent.stats' | 'session.export' | 'session.search' | 'file.list' | 'file.read' | 'session.messages' | 'session.delete' | 'session.acquire' | 'session.release' | 'prompt' | 'sync' | 'sync.stats' | 'ack' | 'project.list' | 'project.start' | 'project.start.cancel' | 'project.stop' | 'project.restart-all';
   id: string;
   payload: {
     sessionId?: string;
@@ -32,18 +37,45 @@ export interface ClientMessage {
 }
 
 export interface ServerMessage {
-  type: 'pong' | 'response' | 'stream' | 'stream.end' | 'error' | 'sync.batch';
+  type: 'pong' | 'response' | 'progress' | 'stream' | 'stream.usage' | 'stream.end' | 'error' | 'sync.batch' | 'project.status.changed' | 'export.chunk' | 'file.chunk' | 'notification' | 'session.created';
   id?: string;
   msgId?: number;
   payload: unknown;
+  /** Agent that served the request; absent in direct mode */
+  agentId?: string;
 }
 
 export interface StreamPayload {
   text: string;
+  /** OpenCode part ID; a chunk repeating one replaces that part's text */
+  partId?: string;
+}
+
+/** Tokens and cost: one reply's, or a session's running total */
+export interface Usage {
+  /** In the provider's currency, as OpenCode reports it */
+  cost: number;
+  input: number;
+  output: number;
+  reasoning: number;
+  cacheRead: number;
+  cacheWrite: number;
+}
+
+/** Sent just before a prompt's stream.end */
+export interface StreamUsagePayload extends Usage {
+  modelId?: string;
+  providerId?: string;
+  /** Including this reply; absent when the hub keeps no totals (Redis buffer only) */
+  sessionTotal?: Usage;
 }
 
 export interface ErrorPayload {
   error: string;
+  /** e.g. 'session_agent_offline': the session's agent must reconnect; 'read_only': action refused */
+  code?: string;
+  /** The offline agent, with code 'session_agent_offline' */
+  agentId?: string;
 }
 
 export interface SyncBatchPayload {
@@ -51,12 +83,68 @@ export interface SyncBatchPayload {
   latestId: number;
 }
 
+/** Pushed unprompted, without a request id */
+export interface NotificationPayload {
+  kind: 'agent.online' | 'agent.offline' | 'broadcast';
+  message?: string;
+  agentId?: string;
+  time: number;
+}
+
+export interface SearchResult {
+  id: string;
+  role: string;
+  created?: number;
+  matches: number;
+  /** Matches with surrounding text */
+  snippets: string[];
+}
+
+/** Response to session.search; pass cursor as before for older matches */
+export interface SearchResponse {
+  sessionId: string;
+  results: SearchResult[];
+  hasMore: boolean;
+  cursor?: string;
+  /** Part of a long history couldn't be fetched, so older matches may be missing */
+  truncated?: boolean;
+}
+
+export interface BufferStats {
+  count: number;
+  latestId: number;
+  /** Oldest ID still buffered; earlier IDs were trimmed or expired */
+  oldestId: number;
+  ttlMs: number;
+}
+
 export interface BufferedMessage {
   id: number;
   type: string;
   requestId: string;
   payload: unknown;
   timestamp: number;
+  /** Group of the prompting client's token; absent for the default token */
+  actor?: string;
+}
+
+/** One agent in an agent.list response */
+export interface AgentListEntry {
+  id: string;
+  version: string;
+  opencodeVersion?: string;
+  draining: boolean;
+  /** Project paths the agent registered with */
+  paths?: string[];
+  /** The agent's project.list; absent when it failed or was too slow */
+  projects?: Project[];
+  error?: string;
+}
+
+/** Response to agent.list; partial when some agent's projects are missing */
+export interface AgentListResponse {
+  agents: AgentListEntry[];
+  partial: boolean;
 }
 
 export type ConnectionState = 'connecting' | 'connected' | 'disconnected' | 'error';
@@ -71,6 +159,8 @@ export interface Project {
   port: number;
   tmuxSession: string;
   status: ProjectStatus;
+  /** Current start stage while status is 'starting' */
+  stage?: string;
   error?: string;
   startedAt?: string;
   sessionCount?: number;
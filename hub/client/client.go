@@ -0,0 +1,431 @@
+// Package client provides a typed Go client for the OpenVibe hub WebSocket protocol
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Errors
+var (
+	ErrClosed       = errors.New("client closed")
+	ErrDisconnected = errors.New("connection lost")
+)
+
+const (
+	writeWait         = 10 * time.Second
+	maxReconnectDelay = 30 * time.Second
+	pendingBuffer     = 100
+)
+
+// Session is a hub session as returned by session.create and session.list
+type Session struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// StreamChunk is one piece of a streamed prompt response.
+// Err is set on the last chunk if the stream failed.
+type StreamChunk struct {
+	MsgID int64           // Buffer message ID, used for sync
+	Text  string          // Text content, if the chunk carried any
+	Data  json.RawMessage // Raw chunk payload
+	Err   error
+}
+
+// BufferedMessage is a message replayed by Sync
+type BufferedMessage struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// SyncBatch is the result of a Sync call
+type SyncBatch struct {
+	Messages []BufferedMessage `json:"messages"`
+	LatestID int64             `json:"latestId"`
+}
+
+// ServerError is an error returned by the hub for a request
+type ServerError struct {
+	Message      string `json:"error"`
+	Code         string `json:"code,omitempty"`
+	Retryable    bool   `json:"retryable,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+}
+
+func (e *ServerError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("hub error (%s): %s", e.Code, e.Message)
+	}
+	return "hub error: " + e.Message
+}
+
+type clientMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id"`
+	Payload interface{} `json:"payload"`
+}
+
+type serverMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	MsgID   int64           `json:"msgId,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Client is a connection to an OpenVibe hub. It correlates responses with
+// requests by ID and reconnects automatically when the connection drops.
+type Client struct {
+	url    string
+	token  string
+	dialer *websocket.Dialer
+
+	conn    *websocket.Conn
+	connMu  sync.RWMutex
+	writeMu sync.Mutex
+
+	pending   map[string]chan *serverMessage
+	pendingMu sync.Mutex
+	nextID    atomic.Uint64
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial connects to the hub WebSocket endpoint at hubURL (e.g. ws://host:8080/ws)
+func Dial(hubURL, token string) (*Client, error) {
+	c := &Client{
+		url:     hubURL,
+		token:   token,
+		dialer:  websocket.DefaultDialer,
+		pending: make(map[string]chan *serverMessage),
+		closed:  make(chan struct{}),
+	}
+
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	go c.readLoop(conn)
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hub url: %w", err)
+	}
+	if c.token != "" {
+		q := u.Query()
+		q.Set("token", c.token)
+		u.RawQuery = q.Encode()
+	}
+
+	conn, _, err := c.dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to hub: %w", err)
+	}
+	return conn, nil
+}
+
+// Close closes the connection and stops reconnecting
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.connMu.RLock()
+		conn := c.conn
+		c.connMu.RUnlock()
+		err = conn.Close()
+	})
+	return err
+}
+
+// CreateSession creates a new session, optionally bound to a project directory
+func (c *Client) CreateSession(ctx context.Context, title, directory string) (*Session, error) {
+	payload := map[string]string{"title": title}
+	if directory != "" {
+		payload["directory"] = directory
+	}
+
+	resp, err := c.call(ctx, "session.create", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(resp, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &session, nil
+}
+
+// CreateProjectSession creates a new session in one of the agent's
+// projects, starting the project if needed. Later prompts for the session
+// go to that project without naming it.
+func (c *Client) CreateProjectSession(ctx context.Context, title, projectPath string) (*Session, error) {
+	resp, err := c.call(ctx, "session.create", map[string]string{"title": title, "projectPath": projectPath})
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(resp, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &session, nil
+}
+
+// ListSessions returns all sessions known to the hub's backend
+func (c *Client) ListSessions(ctx context.Context) ([]Session, error) {
+	resp, err := c.call(ctx, "session.list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	if err := json.Unmarshal(resp, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Sync returns the buffered messages for sessionID after lastAckID
+func (c *Client) Sync(ctx context.Context, sessionID string, lastAckID int64) (*SyncBatch, error) {
+	resp, err := c.call(ctx, "sync", map[string]interface{}{
+		"sessionId": sessionID,
+		"lastAckId": lastAckID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var batch SyncBatch
+	if err := json.Unmarshal(resp, &batch); err != nil {
+		return nil, fmt.Errorf("failed to decode sync batch: %w", err)
+	}
+	return &batch, nil
+}
+
+// Prompt sends content to sessionID and streams the response. The channel is
+// closed after stream.end, an error chunk, or ctx cancellation; once ctx is
+// done, chunks the caller isn't reading are dropped.
+func (c *Client) Prompt(ctx context.Context, sessionID, content string) (<-chan StreamChunk, error) {
+	id, respCh, err := c.send(ctx, "prompt", map[string]string{
+		"sessionId": sessionID,
+		"content":   content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan StreamChunk, pendingBuffer)
+	// emit delivers chunk unless ctx ends first, so a caller that stops
+	// reading can't strand this goroutine
+	emit := func(chunk StreamChunk) bool {
+		select {
+		case out <- chunk:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		defer c.removePending(id)
+
+		for {
+			select {
+			case msg, ok := <-respCh:
+				if !ok {
+					emit(StreamChunk{Err: ErrDisconnected})
+					return
+				}
+
+				switch msg.Type {
+				case "stream":
+					var text struct {
+						Text string `json:"text"`
+					}
+					json.Unmarshal(msg.Payload, &text)
+					if !emit(StreamChunk{MsgID: msg.MsgID, Text: text.Text, Data: msg.Payload}) {
+						return
+					}
+				case "stream.end":
+					return
+				case "error":
+					emit(StreamChunk{Err: decodeError(msg.Payload)})
+					return
+				}
+
+			case <-ctx.Done():
+				select {
+				case out <- StreamChunk{Err: ctx.Err()}:
+				default:
+				}
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// call sends a request and waits for its single response
+func (c *Client) call(ctx context.Context, msgType string, payload interface{}) (json.RawMessage, error) {
+	id, respCh, err := c.send(ctx, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer c.removePending(id)
+
+	select {
+	case msg, ok := <-respCh:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if msg.Type == "error" {
+			return nil, decodeError(msg.Payload)
+		}
+		return msg.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, ErrClosed
+	}
+}
+
+// send registers a pending request and writes it to the connection
+func (c *Client) send(ctx context.Context, msgType string, payload interface{}) (string, <-chan *serverMessage, error) {
+	select {
+	case <-c.closed:
+		return "", nil, ErrClosed
+	default:
+	}
+
+	id := "req-" + strconv.FormatUint(c.nextID.Add(1), 10)
+	respCh := make(chan *serverMessage, pendingBuffer)
+
+	c.pendingMu.Lock()
+	c.pending[id] = respCh
+	c.pendingMu.Unlock()
+
+	c.connMu.RLock()
+	conn := c.conn
+	c.connMu.RUnlock()
+
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := conn.WriteJSON(clientMessage{Type: msgType, ID: id, Payload: payload})
+	c.writeMu.Unlock()
+
+	if err != nil {
+		c.removePending(id)
+		return "", nil, fmt.Errorf("failed to send %s: %w", msgType, err)
+	}
+	return id, respCh, nil
+}
+
+func (c *Client) removePending(id string) {
+	c.pendingMu.Lock()
+	delete(c.pending, id)
+	c.pendingMu.Unlock()
+}
+
+// failPending closes every pending request channel after a disconnect
+func (c *Client) failPending() {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			c.failPending()
+			c.reconnect()
+			return
+		}
+
+		var msg serverMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if msg.ID == "" {
+			continue
+		}
+
+		c.pendingMu.Lock()
+		ch, ok := c.pending[msg.ID]
+		if ok {
+			select {
+			case ch <- &msg:
+			default:
+				log.Printf("openvibe client: response channel full for request %s", msg.ID)
+			}
+		}
+		c.pendingMu.Unlock()
+	}
+}
+
+// reconnect redials with exponential backoff until it succeeds or the client is closed
+func (c *Client) reconnect() {
+	delay := time.Second
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := c.dial(context.Background())
+		if err != nil {
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+
+		c.connMu.Lock()
+		c.conn = conn
+		c.connMu.Unlock()
+
+		// Close may have raced with the dial
+		select {
+		case <-c.closed:
+			conn.Close()
+			return
+		default:
+		}
+
+		go c.readLoop(conn)
+		return
+	}
+}
+
+func decodeError(payload json.RawMessage) error {
+	var serverErr ServerError
+	if err := json.Unmarshal(payload, &serverErr); err != nil || serverErr.Message == "" {
+		return &ServerError{Message: string(payload)}
+	}
+	return &serverErr
+}
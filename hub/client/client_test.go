@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeHub answers every prompt with chunks stream messages and a
+// stream.end, and every other request with an empty response
+func fakeHub(t *testing.T, chunks int) string {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var req clientMessage
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Type != "prompt" {
+				conn.WriteJSON(map[string]interface{}{"type": "response", "id": req.ID, "payload": map[string]interface{}{}})
+				continue
+			}
+			for i := 1; i <= chunks; i++ {
+				conn.WriteJSON(map[string]interface{}{
+					"type": "stream", "id": req.ID, "msgId": i,
+					"payload": map[string]string{"text": "part "},
+				})
+			}
+			conn.WriteJSON(map[string]interface{}{"type": "stream.end", "id": req.ID})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func pendingCount(c *Client) int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	return len(c.pending)
+}
+
+func TestPrompt(t *testing.T) {
+	c, err := Dial(fakeHub(t, 3), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	chunks, err := c.Prompt(context.Background(), "ses_1", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	var lastID int64
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			t.Fatal(chunk.Err)
+		}
+		text.WriteString(chunk.Text)
+		lastID = chunk.MsgID
+	}
+	if text.String() != "part part part " || lastID != 3 {
+		t.Errorf("streamed %q up to message %d", text.String(), lastID)
+	}
+}
+
+// TestPromptAbandoned stops reading a long stream and cancels, which must
+// still end the stream's goroutine
+func TestPromptAbandoned(t *testing.T) {
+	c, err := Dial(fakeHub(t, 3*pendingBuffer), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks, err := c.Prompt(ctx, "ses_1", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-chunks
+	// Let the stream fill the unread channel
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for pendingCount(c) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt still pending after its context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,46 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/client"
+)
+
+func ExampleClient_Prompt() {
+	c, err := client.Dial("ws://localhost:8080/ws", "hub-token")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	session, err := c.CreateSession(ctx, "Fix the build", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	chunks, err := c.Prompt(ctx, session.ID, "Why does go vet fail?")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var lastID int64
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			log.Fatal(chunk.Err)
+		}
+		fmt.Print(chunk.Text)
+		lastID = chunk.MsgID
+	}
+
+	// After a reconnect, replay whatever arrived past the last chunk seen
+	batch, err := c.Sync(ctx, session.ID, lastID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("\n%d messages missed\n", len(batch.Messages))
+}
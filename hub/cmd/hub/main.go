@@ -1,20 +1,29 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/openvibe/hub/internal/audit"
 	"github.com/openvibe/hub/internal/buffer"
 	"github.com/openvibe/hub/internal/config"
+	"github.com/openvibe/hub/internal/metrics"
 	"github.com/openvibe/hub/internal/proxy"
 	"github.com/openvibe/hub/internal/server"
 	"github.com/openvibe/hub/internal/tunnel"
+	"github.com/openvibe/hub/internal/webhooks"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
@@ -25,15 +34,98 @@ func main() {
 
 	// Phase 2 flags
 	agentToken := flag.String("agent-token", "", "Agent authentication token (or use OPENVIBE_AGENT_TOKEN env)")
+	tokenFile := flag.String("token-file", "", "File of OPENVIBE_TOKEN=... and OPENVIBE_AGENT_TOKEN=... lines, overriding --token/--agent-token and watched for rotation (or use OPENVIBE_TOKEN_FILE env)")
+	tokenGrace := flag.Duration("token-grace", 10*time.Minute, "How long a token replaced in --token-file keeps working")
 	redisAddr := flag.String("redis", "", "Redis address (e.g., localhost:6379)")
 	redisPass := flag.String("redis-pass", "", "Redis password (or use REDIS_PASSWORD env)")
 	redisDB := flag.Int("redis-db", 0, "Redis database number")
+	bufferBackends := flag.String("buffer", "", "Buffer backends to try in order: redis, memory, file, noop, comma-separated (default redis,noop with --redis, else noop)")
+	bufferPath := flag.String("buffer-path", "openvibe-buffer.db", "Database file of the file buffer backend (--buffer=file)")
+	bufferFallback := flag.Bool("buffer-fallback", false, "Buffer messages in memory while Redis is unreachable so sync keeps working on this instance")
+	redisPrefix := flag.String("redis-prefix", buffer.DefaultKeyPrefix, "Namespace for Redis keys and channels, to share one Redis between deployments")
+	bufferTTLs := flag.String("buffer-ttls", "", "Per-message-type buffer TTLs (e.g., stream=2m,stream.end=15m)")
+	sendQueue := flag.Int("agent-send-queue", tunnel.DefaultSendQueueSize, "Outbound message buffer per agent")
+	responseQueue := flag.Int("agent-response-queue", tunnel.DefaultResponseQueueSize, "Response buffer per forwarded agent request")
+	maxAgents := flag.Int("max-agents", 0, "Maximum concurrently connected agents (0 = unlimited)")
+	requestLifetime := flag.Duration("agent-request-lifetime", tunnel.DefaultRequestLifetime, "Give up on a forwarded agent request (a prompt included) still pending after this long")
+	offlineGrace := flag.Duration("agent-offline-grace", 0, "Hold a dropped agent's in-flight requests this long so a quick reconnect resumes them (0 = fail them at once)")
+	rejectDupAgents := flag.Bool("reject-duplicate-agents", false, "Reject agents registering with an already-connected ID instead of replacing the old connection")
+	sessionTitle := flag.String("session-title", "timestamp", "Default title for untitled sessions: none, timestamp, or first-prompt")
+	deleteGrace := flag.Duration("session-delete-grace", 0, "Soft-delete sessions for this long so they can be restored (requires Redis, 0 = delete immediately)")
+	lockTTL := flag.Duration("session-lock-ttl", 2*time.Minute, "Lease of a session.acquire lock before it frees itself unless renewed (requires Redis)")
+	actionTimeout := flag.Duration("action-timeout", 10*time.Second, "Deadline for quick requests (session list/create/delete, sync)")
+	longActionTimeout := flag.Duration("long-action-timeout", 30*time.Second, "Deadline for slower requests (message history, project stop)")
+	projectStartTimeout := flag.Duration("project-start-timeout", 10*time.Minute, "Deadline for project.start, including image pulls")
+	streamIdleTimeout := flag.Duration("stream-idle-timeout", 5*time.Minute, "Fail a prompt whose stream is silent this long (0 = never)")
+	opencodeHeaders := flag.String("opencode-headers", "", "Extra headers for direct-mode OpenCode requests as Name=value, comma-separated; {session} expands to the session ID (or use OPENVIBE_OPENCODE_HEADERS env)")
+	systemPreamble := flag.String("system-preamble-file", "", "File whose text is sent as a system instruction with every prompt")
+	breakerThreshold := flag.Int("breaker-threshold", proxy.DefaultBreakerThreshold, "Consecutive OpenCode failures before direct-mode calls fail fast (0 = never)")
+	breakerCooldown := flag.Duration("breaker-cooldown", proxy.DefaultBreakerCooldown, "How long direct-mode calls fail fast before probing OpenCode again")
+	webhookURL := flag.String("webhook-url", "", "POST agent, client and prompt events here as JSON (or use OPENVIBE_WEBHOOK_URL env)")
+	broadcast := flag.Bool("broadcast", false, "Enable POST /broadcast {message, group?} (client token) to push a notification to connected clients")
+	slowPrompt := flag.Duration("slow-prompt-threshold", 0, "Log prompts taking at least this long end to end (0 = never)")
+	agentFailover := flag.Bool("agent-failover", false, "Move sessions of an offline agent to another agent with the same project (OpenCode state must be shared between them)")
+	autoSession := flag.Bool("auto-create-session", false, "Create a session for a prompt sent with no session, instead of rejecting it")
+	auditLog := flag.String("audit-log", "", "Record prompt content as JSON lines to this file for audit (\"-\" = stdout; off by default)")
+	auditResponses := flag.Bool("audit-responses", false, "Also record replies in --audit-log")
+	auditRedact := flag.String("audit-redact-file", "", "File of regexes, one per line, whose matches are masked in --audit-log")
+	readOnly := flag.Bool("read-only", false, "Refuse prompts and session/project changes from every client")
+	readOnlyToken := flag.String("read-only-token", "", "Extra client token with read-only access to the default group (or use OPENVIBE_READ_ONLY_TOKEN env)")
+	adminToken := flag.String("admin-token", "", "Token for the /admin WebSocket feed of agent and instance status across all groups (or use OPENVIBE_ADMIN_TOKEN env; empty = disabled)")
+	groups := flag.String("groups", "", "Agent groups as name:clientToken:agentToken, comma-separated (or use OPENVIBE_GROUPS env)")
+	tunnelDebug := flag.Bool("tunnel-debug", false, "Log every agent tunnel message (debugging only, logs payload excerpts)")
+	agentRetryWindow := flag.Duration("agent-retry-window", 30*time.Second, "How long after an agent disconnects to tell clients to retry")
+	tlsCert := flag.String("tls-cert", "", "Serve HTTPS/WSS with this certificate file (requires --tls-key)")
+	tlsKey := flag.String("tls-key", "", "Private key for --tls-cert")
+	tlsAuto := flag.String("tls-auto", "", "Comma-separated domains to serve HTTPS/WSS for with Let's Encrypt certificates (needs --port 443 reachable)")
+	tlsCacheDir := flag.String("tls-cache-dir", "autocert-cache", "Directory caching --tls-auto certificates")
+	pathPrefix := flag.String("path-prefix", "", "Mount every endpoint under this path (e.g., /openvibe) for reverse-proxy subpaths")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated origin allowlist for CORS and WebSocket (or use OPENVIBE_ALLOWED_ORIGINS env)")
 
 	flag.Parse()
 
 	cfg := config.New()
 	cfg.Port = *port
 	cfg.OpenCodeURL = *opencodeURL
+	cfg.SessionTitle = *sessionTitle
+	cfg.AgentRetryWindow = *agentRetryWindow
+	cfg.SessionDeleteGrace = *deleteGrace
+	if *lockTTL <= 0 {
+		log.Fatalf("--session-lock-ttl must be positive")
+	}
+	cfg.SessionLockTTL = *lockTTL
+	cfg.AutoCreateSession = *autoSession
+	cfg.AgentFailover = *agentFailover
+	prefix, err := parsePathPrefix(*pathPrefix)
+	if err != nil {
+		log.Fatalf("Invalid --path-prefix: %v", err)
+	}
+	cfg.PathPrefix = prefix
+	cfg.SlowPromptThreshold = *slowPrompt
+	cfg.ActionTimeout = *actionTimeout
+	cfg.LongActionTimeout = *longActionTimeout
+	cfg.ProjectStartTimeout = *projectStartTimeout
+	cfg.StreamIdleTimeout = *streamIdleTimeout
+	cfg.BreakerThreshold = *breakerThreshold
+	cfg.BreakerCooldown = *breakerCooldown
+	if *systemPreamble != "" {
+		text, err := os.ReadFile(*systemPreamble)
+		if err != nil {
+			log.Fatalf("Invalid --system-preamble-file: %v", err)
+		}
+		cfg.SystemPreamble = strings.TrimSpace(string(text))
+	}
+
+	// OpenCode header configuration
+	headerList := *opencodeHeaders
+	if headerList == "" {
+		headerList = os.Getenv("OPENVIBE_OPENCODE_HEADERS")
+	}
+	headers, err := parseHeaders(headerList)
+	if err != nil {
+		log.Fatalf("Invalid --opencode-headers: %v", err)
+	}
+	cfg.OpenCodeHeaders = headers
 
 	// Token configuration
 	if *token != "" {
@@ -49,6 +141,88 @@ func main() {
 		cfg.AgentToken = envToken
 	}
 
+	// Token file configuration: its tokens win over flags and env
+	cfg.TokenFile = *tokenFile
+	if cfg.TokenFile == "" {
+		cfg.TokenFile = os.Getenv("OPENVIBE_TOKEN_FILE")
+	}
+	cfg.TokenGrace = *tokenGrace
+	if cfg.TokenFile != "" {
+		client, agent, err := config.ReadTokenFile(cfg.TokenFile)
+		if err != nil {
+			log.Fatalf("Invalid --token-file: %v", err)
+		}
+		if client != "" {
+			cfg.Token = client
+		}
+		if agent != "" {
+			cfg.AgentToken = agent
+		}
+	}
+
+	// Read-only configuration
+	cfg.ReadOnly = *readOnly
+	if *readOnlyToken != "" {
+		cfg.ReadOnlyToken = *readOnlyToken
+	} else if envToken := os.Getenv("OPENVIBE_READ_ONLY_TOKEN"); envToken != "" {
+		cfg.ReadOnlyToken = envToken
+	}
+	if cfg.ReadOnlyToken != "" && (cfg.ReadOnlyToken == cfg.Token || cfg.ReadOnlyToken == cfg.AgentToken) {
+		log.Fatalf("Invalid --read-only-token: must differ from the client and agent tokens")
+	}
+	if cfg.ReadOnly {
+		log.Println("Read-only mode: prompts and session/project changes are refused")
+	}
+
+	// Admin feed configuration
+	if *adminToken != "" {
+		cfg.AdminToken = *adminToken
+	} else if envToken := os.Getenv("OPENVIBE_ADMIN_TOKEN"); envToken != "" {
+		cfg.AdminToken = envToken
+	}
+	if cfg.AdminToken != "" && (cfg.AdminToken == cfg.Token || cfg.AdminToken == cfg.AgentToken || cfg.AdminToken == cfg.ReadOnlyToken) {
+		log.Fatalf("Invalid --admin-token: must differ from the client, agent and read-only tokens")
+	}
+
+	// Agent group configuration
+	groupList := *groups
+	if groupList == "" {
+		groupList = os.Getenv("OPENVIBE_GROUPS")
+	}
+	groupCfg, err := parseGroups(groupList, cfg.Token, cfg.AgentToken)
+	if err != nil {
+		log.Fatalf("Invalid --groups: %v", err)
+	}
+	cfg.Groups = groupCfg
+	for _, g := range cfg.Groups {
+		if cfg.ReadOnlyToken != "" && (g.Token == cfg.ReadOnlyToken || g.AgentToken == cfg.ReadOnlyToken) {
+			log.Fatalf("Invalid --read-only-token: also used by group %s", g.Name)
+		}
+		if cfg.AdminToken != "" && (g.Token == cfg.AdminToken || g.AgentToken == cfg.AdminToken) {
+			log.Fatalf("Invalid --admin-token: also used by group %s", g.Name)
+		}
+	}
+	if cfg.ReadOnlyToken != "" && cfg.Token == "" {
+		log.Println("WARNING: --read-only-token has no effect without --token; every client has full access.")
+	}
+	agentGroups := make(map[string]string, len(cfg.Groups))
+	for _, g := range cfg.Groups {
+		agentGroups[g.AgentToken] = g.Name
+	}
+	if cfg.TokenFile != "" {
+		cfg.LiveToken = config.NewRotatingToken(cfg.Token)
+		cfg.LiveAgentToken = config.NewRotatingToken(cfg.AgentToken)
+		stop, err := config.WatchTokenFile(cfg.TokenFile, func(client, agent string) {
+			rotateToken(cfg, "client", cfg.LiveToken, client)
+			rotateToken(cfg, "agent", cfg.LiveAgentToken, agent)
+		})
+		if err != nil {
+			log.Fatalf("Invalid --token-file: %v", err)
+		}
+		defer stop()
+		log.Printf("Token file: %s (rotation grace %s)", cfg.TokenFile, cfg.TokenGrace)
+	}
+
 	// Redis configuration
 	cfg.RedisAddr = *redisAddr
 	if *redisPass != "" {
@@ -57,67 +231,175 @@ func main() {
 		cfg.RedisPass = envPass
 	}
 	cfg.RedisDB = *redisDB
+	cfg.RedisPrefix = *redisPrefix
+	cfg.BufferFallback = *bufferFallback
+	backends, err := buffer.ParseBackends(*bufferBackends)
+	if err != nil {
+		log.Fatalf("Invalid --buffer: %v", err)
+	}
+	cfg.BufferBackends = backends
+	cfg.BufferPath = *bufferPath
+	typeTTLs, err := parseTTLs(*bufferTTLs)
+	if err != nil {
+		log.Fatalf("Invalid --buffer-ttls: %v", err)
+	}
+	cfg.BufferTypeTTLs = typeTTLs
+
+	// Webhook configuration
+	cfg.WebhookURL = *webhookURL
+	if cfg.WebhookURL == "" {
+		cfg.WebhookURL = os.Getenv("OPENVIBE_WEBHOOK_URL")
+	}
+	hooks := webhooks.New(cfg.WebhookURL)
+	if hooks != nil {
+		log.Printf("Webhooks enabled: %s", cfg.WebhookURL)
+	}
+
+	// Audit configuration
+	cfg.AuditLog = *auditLog
+	cfg.AuditResponses = *auditResponses
+	if *auditRedact != "" {
+		patterns, err := readPatterns(*auditRedact)
+		if err != nil {
+			log.Fatalf("Invalid --audit-redact-file: %v", err)
+		}
+		cfg.AuditRedact = patterns
+	}
+	var auditLogger *audit.Logger
+	if cfg.AuditLog != "" {
+		auditLogger, err = audit.Open(cfg.AuditLog, cfg.AuditRedact, cfg.AuditResponses)
+		if err != nil {
+			log.Fatalf("Invalid --audit-log: %v", err)
+		}
+		defer auditLogger.Close()
+		log.Printf("Audit log: %s (responses: %v, %d redaction patterns)", cfg.AuditLog, cfg.AuditResponses, len(cfg.AuditRedact))
+	} else if cfg.AuditResponses || len(cfg.AuditRedact) > 0 {
+		log.Println("WARNING: --audit-responses and --audit-redact-file have no effect without --audit-log")
+	}
+
+	// Origin allowlist configuration
+	origins := *allowedOrigins
+	if origins == "" {
+		origins = os.Getenv("OPENVIBE_ALLOWED_ORIGINS")
+	}
+	cfg.AllowedOrigins = splitList(origins)
+
+	// TLS configuration
+	tlsDomains := splitList(*tlsAuto)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("--tls-cert and --tls-key must be set together")
+	}
+	if *tlsCert != "" && len(tlsDomains) > 0 {
+		log.Fatal("--tls-auto cannot be combined with --tls-cert")
+	}
+
+	if *tunnelDebug {
+		log.Println("WARNING: --tunnel-debug logs tunnel payload excerpts; do not use in production.")
+	}
 
 	if cfg.Token == "" {
 		log.Println("WARNING: No authentication token set. Use --token or OPENVIBE_TOKEN env var.")
 	}
 
-	// Initialize buffer (Redis or Noop)
-	var msgBuffer buffer.Buffer
-	if cfg.RedisAddr != "" {
-		log.Printf("Connecting to Redis: %s", cfg.RedisAddr)
-		rb, err := buffer.NewRedisBuffer(buffer.RedisConfig{
+	// Initialize buffer, the first of the backend chain that starts
+	msgBuffer, bufferBackend, err := buffer.New(buffer.Config{
+		Backends: cfg.BufferBackends,
+		Redis: buffer.RedisConfig{
 			Addr:     cfg.RedisAddr,
 			Password: cfg.RedisPass,
 			DB:       cfg.RedisDB,
-		})
-		if err != nil {
-			log.Printf("WARNING: Redis connection failed: %v, running without message buffer", err)
-			msgBuffer = buffer.NewNoopBuffer()
-		} else {
-			log.Printf("Redis connected successfully")
-			msgBuffer = rb
-		}
-	} else {
-		log.Println("Running without Redis (no message buffering)")
-		msgBuffer = buffer.NewNoopBuffer()
+			TypeTTLs: cfg.BufferTypeTTLs,
+
+			KeyPrefix: cfg.RedisPrefix,
+			Fallback:  cfg.BufferFallback,
+		},
+		Path: cfg.BufferPath,
+	})
+	if err != nil {
+		log.Fatalf("Buffer: %v", err)
 	}
 	defer msgBuffer.Close()
 
 	// Initialize tunnel manager
 	tunnelMgr := tunnel.NewManager(&tunnel.Config{
-		AgentToken: cfg.AgentToken,
+		AgentToken:        cfg.AgentToken,
+		LiveAgentToken:    cfg.LiveAgentToken,
+		AgentGroups:       agentGroups,
+		SendQueueSize:     *sendQueue,
+		ResponseQueueSize: *responseQueue,
+		MaxAgents:         *maxAgents,
+
+		RejectDuplicateIDs: *rejectDupAgents,
+		OfflineGrace:       *offlineGrace,
+		RequestLifetime:    *requestLifetime,
+		Debug:              *tunnelDebug,
+		Webhooks:           hooks,
 	})
 
 	// Initialize OpenCode proxy (fallback for direct mode)
 	opencodeProxy := proxy.NewOpenCodeProxy(cfg.OpenCodeURL)
+	opencodeProxy.SetBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	opencodeProxy.SetSystemPreamble(cfg.SystemPreamble)
+	opencodeProxy.SetHeaders(cfg.OpenCodeHeaders)
 
 	// Initialize server
-	wsServer := server.NewServer(cfg, opencodeProxy, msgBuffer, tunnelMgr)
+	wsServer := server.NewServer(cfg, opencodeProxy, msgBuffer, tunnelMgr, hooks)
+	wsServer.SetAuditLog(auditLogger)
 
 	mux := http.NewServeMux()
 
+	// Every endpoint is mounted under cfg.PathPrefix; routes collects them
+	// so the static handler leaves them alone
+	var routes []string
+	handle := func(path string, handler http.Handler) {
+		route := cfg.PathPrefix + path
+		routes = append(routes, route)
+		mux.Handle(route, handler)
+	}
+
 	// WebSocket endpoints
-	mux.HandleFunc("/ws", wsServer.HandleWebSocket)
-	mux.HandleFunc("/agent", tunnelMgr.HandleAgentWebSocket)
+	handle("/ws", http.HandlerFunc(wsServer.HandleWebSocket))
+	handle("/agent", http.HandlerFunc(tunnelMgr.HandleAgentWebSocket))
 
-	// Health endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	})
+	// Admin feed, only with its own token
+	if cfg.AdminToken != "" {
+		handle("/admin", server.RequireToken(cfg.AdminToken, http.HandlerFunc(wsServer.HandleAdminWebSocket)))
+	}
 
-	// Agents endpoint (list connected agents)
-	mux.HandleFunc("/agents", func(w http.ResponseWriter, r *http.Request) {
-		agents := tunnelMgr.ListAgents()
+	// Health endpoint. A degraded buffer is reported but keeps the hub
+	// healthy: prompts still work, only sync suffers.
+	handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bufferStatus := "ok"
+		if bufferBackend == buffer.BackendNoop {
+			bufferStatus = "disabled"
+		} else if h, ok := msgBuffer.(buffer.Health); ok && h.Degraded() {
+			bufferStatus = "degraded"
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if len(agents) == 0 {
-			w.Write([]byte(`{"agents":[]}`))
-		} else {
-			w.Write([]byte(`{"agents":["` + strings.Join(agents, `","`) + `"]}`))
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "buffer": bufferStatus, "bufferBackend": bufferBackend})
+	}))
+
+	// Agents endpoint (list connected agents), behind the client token
+	handle("/agents", wsServer.RequireClientToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		infos := tunnelMgr.ListAgentInfo()
+		ids := make([]string, 0, len(infos))
+		for _, info := range infos {
+			ids = append(ids, info.ID)
 		}
-	})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		// agents stays a plain ID list for existing callers
+		json.NewEncoder(w).Encode(map[string]interface{}{"agents": ids, "info": infos})
+	})))
+
+	// Metrics endpoint, behind the client token; /health stays open for probes
+	handle("/metrics", wsServer.RequireClientToken(metrics.Handler()))
+
+	// Admin broadcast endpoint, behind the client token
+	if *broadcast {
+		handle("/broadcast", wsServer.RequireClientToken(http.HandlerFunc(wsServer.HandleBroadcast)))
+	}
 
 	if *staticDir != "" {
 		log.Printf("Serving static files from: %s", *staticDir)
@@ -127,12 +409,11 @@ func main() {
 		}
 
 		fs := http.FileServer(http.Dir(staticRoot))
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, "/ws") ||
-				strings.HasPrefix(r.URL.Path, "/agent") ||
-				strings.HasPrefix(r.URL.Path, "/health") ||
-				strings.HasPrefix(r.URL.Path, "/agents") {
-				return
+		static := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, route := range routes {
+				if strings.HasPrefix(cfg.PathPrefix+r.URL.Path, route+"/") {
+					return
+				}
 			}
 
 			requestPath := filepath.Clean(r.URL.Path)
@@ -164,13 +445,21 @@ func main() {
 				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
 			}
 
+			// Prefer a .br/.gz built alongside the file over compressing it
+			if server.ServePrecompressed(w, r, resolvedPath) {
+				return
+			}
 			fs.ServeHTTP(w, r)
 		})
+		mux.Handle(cfg.PathPrefix+"/", http.StripPrefix(cfg.PathPrefix, server.Gzip(static)))
 	}
 
 	addr := "0.0.0.0:" + cfg.Port
 	log.Printf("OpenVibe Hub starting on %s", addr)
 	log.Printf("OpenCode backend: %s", cfg.OpenCodeURL)
+	if len(cfg.OpenCodeHeaders) > 0 {
+		log.Printf("OpenCode headers: %s", describeHeaders(cfg.OpenCodeHeaders))
+	}
 	if cfg.AgentToken != "" {
 		log.Printf("Agent authentication: enabled")
 	}
@@ -178,9 +467,29 @@ func main() {
 		log.Printf("Static files: %s", *staticDir)
 	}
 
+	if cfg.PathPrefix != "" {
+		log.Printf("Path prefix: %s", cfg.PathPrefix)
+	}
+	if len(cfg.AllowedOrigins) > 0 {
+		log.Printf("Allowed origins: %s", strings.Join(cfg.AllowedOrigins, ", "))
+	}
+
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: server.CORS(cfg.AllowedOrigins, mux),
+	}
+
+	switch {
+	case len(tlsDomains) > 0:
+		certs := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(tlsDomains...),
+			Cache:      autocert.DirCache(*tlsCacheDir),
+		}
+		srv.TLSConfig = certs.TLSConfig()
+		log.Printf("TLS: Let's Encrypt for %s (cache %s)", strings.Join(tlsDomains, ", "), *tlsCacheDir)
+	case *tlsCert != "":
+		log.Printf("TLS: certificate %s", *tlsCert)
 	}
 
 	go func() {
@@ -188,10 +497,176 @@ func main() {
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 		log.Println("Shutting down...")
+		wsServer.Shutdown()
 		srv.Close()
 	}()
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	// Close stops TLS and plain listeners alike, so shutdown is unchanged
+	if srv.TLSConfig != nil || *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+func splitList(input string) []string {
+	var items []string
+	for _, item := range strings.Split(input, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readPatterns reads one regular expression per line, skipping blank lines
+// and # comments. Patterns are compiled by their user.
+func readPatterns(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// parsePathPrefix normalizes a route prefix to a leading slash and no
+// trailing slash; "" and "/" mean no prefix
+func parsePathPrefix(input string) (string, error) {
+	prefix := strings.Trim(strings.TrimSpace(input), "/")
+	if prefix == "" {
+		return "", nil
+	}
+	prefix = "/" + prefix
+	if path.Clean(prefix) != prefix || strings.ContainsAny(prefix, "?#*{}") {
+		return "", fmt.Errorf("%q is not a plain path", input)
+	}
+	return prefix, nil
+}
+
+// parseTTLs parses "type=duration" pairs separated by commas
+func parseTTLs(input string) (map[string]time.Duration, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ttls := make(map[string]time.Duration, len(items))
+	for _, item := range items {
+		msgType, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected type=duration, got %q", item)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid TTL for %s: %q", msgType, value)
+		}
+		ttls[strings.TrimSpace(msgType)] = ttl
+	}
+	return ttls, nil
+}
+
+// parseHeaders parses "Name=value" pairs separated by commas
+func parseHeaders(input string) (map[string]string, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	headers := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " :\t") {
+			return nil, fmt.Errorf("expected Name=value, got %q", name)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// describeHeaders lists headers for the startup log, hiding the values of
+// ones that look like credentials
+func describeHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		value := headers[name]
+		lower := strings.ToLower(name)
+		for _, hint := range []string{"auth", "key", "token", "secret", "password", "cookie", "signature"} {
+			if strings.Contains(lower, hint) {
+				value = "<redacted>"
+				break
+			}
+		}
+		parts[i] = name + "=" + value
+	}
+	return strings.Join(parts, ", ")
+}
+
+// rotateToken moves live to token, read from the token file. An empty token
+// leaves it alone rather than disabling auth, and one already used by
+// another role is refused. Connections made with the old token stay up.
+func rotateToken(cfg *config.Config, role string, live *config.RotatingToken, token string) {
+	if token == "" || token == live.Current() {
+		return
+	}
+	taken := token == cfg.ReadOnlyToken || token == cfg.AdminToken
+	for _, g := range cfg.Groups {
+		taken = taken || token == g.Token || token == g.AgentToken
+	}
+	if taken {
+		log.Printf("Token file: new %s token is already in use by another role, keeping the current one", role)
+		return
+	}
+	if live.Rotate(token, cfg.TokenGrace) {
+		log.Printf("Token file: %s token rotated, previous token valid for %s", role, cfg.TokenGrace)
+	}
+}
+
+// parseGroups parses "name:clientToken:agentToken" entries separated by
+// commas. Every token must be unique, including the default group's.
+func parseGroups(input, token, agentToken string) ([]config.Group, error) {
+	items := splitList(input)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	names := make(map[string]bool, len(items))
+	tokens := map[string]bool{token: true, agentToken: true}
+	groups := make([]config.Group, 0, len(items))
+	for _, item := range items {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("expected name:clientToken:agentToken, got %q", parts[0])
+		}
+		g := config.Group{Name: parts[0], Token: parts[1], AgentToken: parts[2]}
+		if names[g.Name] {
+			return nil, fmt.Errorf("duplicate group %q", g.Name)
+		}
+		if tokens[g.Token] || tokens[g.AgentToken] || g.Token == g.AgentToken {
+			return nil, fmt.Errorf("group %q reuses a token", g.Name)
+		}
+		names[g.Name] = true
+		tokens[g.Token] = true
+		tokens[g.AgentToken] = true
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
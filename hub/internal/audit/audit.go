@@ -0,0 +1,171 @@
+// Package audit records prompt content, and optionally replies, to a
+// separate append-only log for deployments that must keep it
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+// Record kinds
+const (
+	KindPrompt   = "prompt"
+	KindResponse = "response"
+)
+
+const (
+	// queueSize is how many records wait for the sink before new ones are
+	// dropped; the prompt path never waits on the sink
+	queueSize = 1024
+	// redacted replaces every match of a redaction pattern
+	redacted = "[REDACTED]"
+)
+
+var (
+	written = metrics.NewCounter("audit_records_total")
+	dropped = metrics.NewCounter("audit_dropped_total")
+	failed  = metrics.NewCounter("audit_write_errors_total")
+)
+
+// Record is one JSON line of the audit log
+type Record struct {
+	Kind      string `json:"kind"`
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	RequestID string `json:"requestId"`
+	SessionID string `json:"sessionId,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Client    string `json:"client,omitempty"` // Client remote address
+	Content   string `json:"content"`
+	// Outcome ends a response: "completed", or why it stopped short
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Logger writes records in the background. A nil Logger ignores records,
+// so callers need not check whether auditing is on.
+type Logger struct {
+	sink      io.WriteCloser
+	redact    []*regexp.Regexp
+	responses bool
+	queue     chan Record
+	done      chan struct{}
+
+	mu     sync.RWMutex // Guards closed against Log racing Close
+	closed bool
+}
+
+// Open returns a Logger appending to path ("-" for stdout), masking every
+// match of the redact patterns. responses enables KindResponse records.
+func Open(path string, redact []string, responses bool) (*Logger, error) {
+	patterns := make([]*regexp.Regexp, 0, len(redact))
+	for _, expr := range redact {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", expr, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	var sink io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		sink = f
+	}
+
+	l := &Logger{
+		sink:      sink,
+		redact:    patterns,
+		responses: responses,
+		queue:     make(chan Record, queueSize),
+		done:      make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Responses reports whether replies should be recorded
+func (l *Logger) Responses() bool {
+	return l != nil && l.responses
+}
+
+// Log queues r without blocking, dropping it if the queue is full. Content
+// is redacted in the background, before it reaches the sink.
+func (l *Logger) Log(r Record) {
+	if l == nil {
+		return
+	}
+	if r.Kind == KindResponse && !l.responses {
+		return
+	}
+	if r.Timestamp == 0 {
+		r.Timestamp = time.Now().UnixMilli()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- r:
+	default:
+		dropped.Inc()
+		log.Printf("Audit queue full, dropping %s record for %s", r.Kind, r.RequestID)
+	}
+}
+
+// Redact masks every match of the logger's patterns in s
+func (l *Logger) Redact(s string) string {
+	if l == nil {
+		return s
+	}
+	for _, re := range l.redact {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+// Close writes out queued records and closes the sink
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+	<-l.done
+	return l.sink.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for r := range l.queue {
+		r.Content = l.Redact(r.Content)
+		line, _ := json.Marshal(r)
+		if _, err := l.sink.Write(append(line, '\n')); err != nil {
+			failed.Inc()
+			log.Printf("Audit write failed: %v", err)
+			continue
+		}
+		written.Inc()
+	}
+}
+
+// nopCloser keeps Close from closing stdout
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
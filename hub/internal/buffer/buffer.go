@@ -4,6 +4,7 @@ package buffer
 import (
 	"context"
 	"encoding/json"
+	"time"
 )
 
 // Message represents a buffered message
@@ -13,6 +14,10 @@ type Message struct {
 	RequestID string          `json:"requestId"` // Original request ID
 	Payload   json.RawMessage `json:"payload"`   // Message payload
 	Timestamp int64           `json:"timestamp"` // Unix milliseconds
+
+	// Actor identifies who caused the message: the group of the prompting
+	// client's token, empty for the default token
+	Actor string `json:"actor,omitempty"`
 }
 
 // Buffer interface for message buffering
@@ -29,10 +34,135 @@ type Buffer interface {
 	// Trim removes old messages, keeping only recent ones
 	Trim(ctx context.Context, sessionID string) error
 
+	// Stats describes what is buffered for a session
+	Stats(ctx context.Context, sessionID string) (Stats, error)
+
 	// Close releases resources
 	Close() error
 }
 
+// Stats describes a session's buffer, for diagnosing resyncs that return
+// fewer messages than expected
+type Stats struct {
+	Count    int64 `json:"count"`    // Messages held, including expired ones not yet trimmed
+	LatestID int64 `json:"latestId"` // Last ID assigned, even if since trimmed
+	OldestID int64 `json:"oldestId"` // Oldest ID held, 0 if none; earlier IDs were trimmed or expired
+	TTL      int64 `json:"ttlMs"`    // Milliseconds until the whole buffer expires, 0 if empty
+}
+
+// RemoteMessage is a message pushed to a session by another hub instance
+type RemoteMessage struct {
+	SessionID string
+	Message   Message
+}
+
+// Fanout is implemented by buffers that share pushed messages between hub instances
+type Fanout interface {
+	// Subscribe starts delivering messages other instances push for sessionID
+	Subscribe(ctx context.Context, sessionID string) error
+
+	// Unsubscribe stops delivery for sessionID
+	Unsubscribe(ctx context.Context, sessionID string) error
+
+	// Remote returns the channel of messages pushed by other instances
+	Remote() <-chan RemoteMessage
+}
+
+// Tombstone is a soft-deleted session awaiting its real delete
+type Tombstone struct {
+	SessionID   string `json:"sessionId"`
+	AgentID     string `json:"agentId,omitempty"`     // Agent that owns the session, if known
+	ProjectPath string `json:"projectPath,omitempty"` // Agent project the session lives in, if known
+	BaseURL     string `json:"baseUrl,omitempty"`
+	DeleteAt    int64  `json:"deleteAt"` // Unix milliseconds when the grace period ends
+}
+
+// Tombstones is implemented by buffers that can hold soft-deleted sessions
+type Tombstones interface {
+	// Tombstone hides a session until t.DeleteAt
+	Tombstone(ctx context.Context, t Tombstone) error
+
+	// Restore removes a session's tombstone, reporting whether one existed
+	Restore(ctx context.Context, sessionID string) (bool, error)
+
+	// Tombstoned returns the IDs of all soft-deleted sessions
+	Tombstoned(ctx context.Context) (map[string]bool, error)
+
+	// ClaimExpired removes and returns tombstones past their DeleteAt. Each
+	// tombstone is returned to exactly one caller across hub instances.
+	ClaimExpired(ctx context.Context) ([]Tombstone, error)
+}
+
+// Metadata is implemented by buffers that can store per-session metadata.
+// Values are arbitrary JSON.
+type Metadata interface {
+	// SetMeta merges meta into a session's metadata; null values delete keys
+	SetMeta(ctx context.Context, sessionID string, meta map[string]json.RawMessage) error
+
+	// GetMeta returns a session's metadata
+	GetMeta(ctx context.Context, sessionID string) (map[string]json.RawMessage, error)
+
+	// GetMetaMany returns metadata for several sessions, omitting sessions without any
+	GetMetaMany(ctx context.Context, sessionIDs []string) (map[string]map[string]json.RawMessage, error)
+}
+
+// Activity is implemented by buffers that can report when sessions last had messages
+type Activity interface {
+	// LastActivity returns the newest buffered message timestamp (Unix
+	// milliseconds) per session, omitting sessions with nothing buffered
+	LastActivity(ctx context.Context, sessionIDs []string) (map[string]int64, error)
+}
+
+// Lock is a session's exclusive prompt lock
+type Lock struct {
+	SessionID string `json:"sessionId"`
+	Token     string `json:"-"`         // Held by whoever acquired it; never sent to other clients
+	ExpiresAt int64  `json:"expiresAt"` // Unix milliseconds when the lease ends
+}
+
+// Locks is implemented by buffers that can hold session locks, shared
+// between hub instances
+type Locks interface {
+	// AcquireLock takes a session's lock for token, or renews it if token
+	// already holds it, for ttl. It returns the lock as it stands and
+	// whether token holds it.
+	AcquireLock(ctx context.Context, sessionID, token string, ttl time.Duration) (Lock, bool, error)
+
+	// ReleaseLock frees a session's lock if token holds it, reporting whether it did
+	ReleaseLock(ctx context.Context, sessionID, token string) (bool, error)
+
+	// GetLock returns a session's lock, ok false if it is unlocked
+	GetLock(ctx context.Context, sessionID string) (lock Lock, ok bool, err error)
+}
+
+// Usage is what prompts cost in tokens and money: one reply's, or a
+// session's running total
+type Usage struct {
+	Cost       float64 `json:"cost"` // In the provider's currency, as OpenCode reports it
+	Input      int64   `json:"input"`
+	Output     int64   `json:"output"`
+	Reasoning  int64   `json:"reasoning"`
+	CacheRead  int64   `json:"cacheRead"`
+	CacheWrite int64   `json:"cacheWrite"`
+}
+
+// UsageTotals is implemented by buffers that keep a running usage total per
+// session, shared between hub instances
+type UsageTotals interface {
+	// AddUsage adds one reply's usage to a session's total and returns the new total
+	AddUsage(ctx context.Context, sessionID string, u Usage) (Usage, error)
+
+	// GetUsage returns a session's total, zero if nothing was recorded
+	GetUsage(ctx context.Context, sessionID string) (Usage, error)
+}
+
+// Purger is implemented by buffers that keep more about a session than its
+// messages, such as metadata and usage totals
+type Purger interface {
+	// PurgeSession removes everything kept for a deleted session
+	PurgeSession(ctx context.Context, sessionID string) error
+}
+
 // NoopBuffer is a no-op implementation for when Redis is unavailable
 type NoopBuffer struct{}
 
@@ -56,6 +186,10 @@ func (b *NoopBuffer) Trim(ctx context.Context, sessionID string) error {
 	return nil
 }
 
+func (b *NoopBuffer) Stats(ctx context.Context, sessionID string) (Stats, error) {
+	return Stats{}, nil
+}
+
 func (b *NoopBuffer) Close() error {
 	return nil
 }
@@ -0,0 +1,112 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Backends selectable in Config.Backends
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendNoop   = "noop"
+)
+
+// Config selects the buffer backend
+type Config struct {
+	// Backends are tried in order and the first that starts is used, so
+	// "redis", "memory" keeps sync working on this instance when Redis is
+	// unreachable at startup. noop and memory always start. Empty means
+	// redis then noop when Redis.Addr is set, else noop.
+	Backends []string
+
+	Redis RedisConfig
+
+	// Path is the database file of the file backend. TTL, MaxCount and
+	// TypeTTLs are shared with Redis.
+	Path string
+}
+
+// ParseBackends parses a comma-separated backend chain such as "redis,memory"
+func ParseBackends(s string) ([]string, error) {
+	var backends []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch name {
+		case BackendRedis, BackendMemory, BackendFile, BackendNoop:
+		default:
+			return nil, fmt.Errorf("unknown buffer backend %q (want redis, memory, file or noop)", name)
+		}
+		backends = append(backends, name)
+	}
+	return backends, nil
+}
+
+// New starts the first backend of cfg.Backends that comes up, logging each
+// failure and the backend chosen. It returns the buffer and its backend name.
+func New(cfg Config) (Buffer, string, error) {
+	backends := cfg.Backends
+	if len(backends) == 0 {
+		backends = []string{BackendNoop}
+		if cfg.Redis.Addr != "" {
+			backends = []string{BackendRedis, BackendNoop}
+		}
+	}
+
+	var errs []error
+	for i, name := range backends {
+		b, err := newBackend(name, cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			if i < len(backends)-1 {
+				log.Printf("WARNING: %s buffer unavailable: %v, trying %s", name, err, backends[i+1])
+			}
+			continue
+		}
+		logBackend(name)
+		return b, name, nil
+	}
+	return nil, "", fmt.Errorf("no buffer backend started: %w", errors.Join(errs...))
+}
+
+func newBackend(name string, cfg Config) (Buffer, error) {
+	switch name {
+	case BackendRedis:
+		if cfg.Redis.Addr == "" {
+			return nil, errors.New("no Redis address (--redis)")
+		}
+		log.Printf("Connecting to Redis: %s", cfg.Redis.Addr)
+		return NewRedisBuffer(cfg.Redis)
+	case BackendMemory:
+		return NewMemoryBuffer(cfg.Redis.TTL, int(cfg.Redis.MaxCount)), nil
+	case BackendFile:
+		return NewFileBuffer(FileConfig{
+			Path:     cfg.Path,
+			TTL:      cfg.Redis.TTL,
+			MaxCount: int(cfg.Redis.MaxCount),
+			TypeTTLs: cfg.Redis.TypeTTLs,
+		})
+	case BackendNoop:
+		return NewNoopBuffer(), nil
+	}
+	return nil, fmt.Errorf("unknown buffer backend %q", name)
+}
+
+func logBackend(name string) {
+	switch name {
+	case BackendRedis:
+		log.Printf("Redis connected successfully")
+	case BackendMemory:
+		log.Println("Buffering messages in memory (this instance only, lost on restart)")
+	case BackendFile:
+		log.Println("Buffering messages in a local file (this instance only)")
+	case BackendNoop:
+		log.Println("Running without a message buffer (no sync)")
+	}
+}
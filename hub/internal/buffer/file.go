@@ -0,0 +1,344 @@
+package buffer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultCompactInterval is how often FileBuffer removes expired messages
+const DefaultCompactInterval = time.Minute
+
+// fileOpenTimeout bounds waiting for the database lock, so a second hub on
+// the same file fails to start instead of hanging
+const fileOpenTimeout = time.Second
+
+var (
+	bucketSessions = []byte("sessions")
+	bucketMessages = []byte("messages")
+	keyFileLastID  = []byte("lastId")
+)
+
+// FileConfig configures the file buffer
+type FileConfig struct {
+	Path     string
+	TTL      time.Duration
+	MaxCount int
+
+	// TypeTTLs overrides TTL per message type, as in RedisConfig
+	TypeTTLs map[string]time.Duration
+
+	// CompactInterval is how often expired messages are removed from the
+	// file (default DefaultCompactInterval)
+	CompactInterval time.Duration
+}
+
+// FileBuffer implements Buffer in a local bbolt database, so a single hub
+// instance keeps its buffered messages across restarts without Redis. The
+// file is locked while open and cannot be shared between instances.
+type FileBuffer struct {
+	db       *bolt.DB
+	ttl      time.Duration
+	typeTTLs map[string]time.Duration
+	maxCount int
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewFileBuffer opens or creates the database at cfg.Path and starts
+// compacting it in the background
+func NewFileBuffer(cfg FileConfig) (*FileBuffer, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("no buffer file path (--buffer-path)")
+	}
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: fileOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", cfg.Path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSessions)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init %s: %w", cfg.Path, err)
+	}
+
+	b := &FileBuffer{
+		db:       db,
+		ttl:      cfg.TTL,
+		typeTTLs: cfg.TypeTTLs,
+		maxCount: cfg.MaxCount,
+		done:     make(chan struct{}),
+	}
+	if b.ttl == 0 {
+		b.ttl = DefaultTTL
+	}
+	if b.maxCount == 0 {
+		b.maxCount = DefaultMaxCount
+	}
+	interval := cfg.CompactInterval
+	if interval <= 0 {
+		interval = DefaultCompactInterval
+	}
+
+	// Drop whatever expired while the hub was down before serving syncs
+	b.compact()
+	b.wg.Add(1)
+	go b.compactLoop(interval)
+	return b, nil
+}
+
+// Push adds a message to the buffer
+func (b *FileBuffer) Push(ctx context.Context, sessionID string, msg Message) (int64, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		s, err := tx.Bucket(bucketSessions).CreateBucketIfNotExists([]byte(sessionID))
+		if err != nil {
+			return err
+		}
+		msgs, err := s.CreateBucketIfNotExists(bucketMessages)
+		if err != nil {
+			return err
+		}
+
+		msg.ID = fileLastID(s) + 1
+		if msg.Timestamp == 0 {
+			msg.Timestamp = time.Now().UnixMilli()
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := msgs.Put(fileKey(msg.ID), data); err != nil {
+			return err
+		}
+		if err := s.Put(keyFileLastID, fileKey(msg.ID)); err != nil {
+			return err
+		}
+
+		// Keep only the newest maxCount messages
+		excess := fileCount(msgs) - b.maxCount
+		c := msgs.Cursor()
+		for k, _ := c.First(); k != nil && excess > 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			excess--
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to push message: %w", err)
+	}
+	return msg.ID, nil
+}
+
+// GetSince retrieves unexpired messages after the specified ID
+func (b *FileBuffer) GetSince(ctx context.Context, sessionID string, afterID int64) ([]Message, error) {
+	var messages []Message
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		msgs := fileMessages(tx, sessionID)
+		if msgs == nil {
+			return nil
+		}
+		c := msgs.Cursor()
+		for k, v := c.Seek(fileKey(afterID + 1)); k != nil; k, v = c.Next() {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				log.Printf("Skipping corrupt buffered message %d of session %s: %v", binary.BigEndian.Uint64(k), sessionID, err)
+				continue
+			}
+			if !b.expired(msg, now) {
+				messages = append(messages, msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return messages, nil
+}
+
+// GetLatestID returns the latest message ID
+func (b *FileBuffer) GetLatestID(ctx context.Context, sessionID string) (int64, error) {
+	var id int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if s := tx.Bucket(bucketSessions).Bucket([]byte(sessionID)); s != nil {
+			id = fileLastID(s)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// Trim drops expired messages, and the session once none are left
+func (b *FileBuffer) Trim(ctx context.Context, sessionID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.trim(tx, sessionID, time.Now())
+	})
+}
+
+func (b *FileBuffer) trim(tx *bolt.Tx, sessionID string, now time.Time) error {
+	sessions := tx.Bucket(bucketSessions)
+	s := sessions.Bucket([]byte(sessionID))
+	if s == nil {
+		return nil
+	}
+	if msgs := s.Bucket(bucketMessages); msgs != nil {
+		c := msgs.Cursor()
+		for k, v := c.First(); k != nil; {
+			var msg Message
+			if json.Unmarshal(v, &msg) != nil || b.expired(msg, now) {
+				next := append([]byte(nil), k...)
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				// Delete can leave the cursor before the next key
+				k, v = c.Seek(next)
+				continue
+			}
+			k, v = c.Next()
+		}
+		if k, _ := msgs.Cursor().First(); k != nil {
+			return nil
+		}
+	}
+	return sessions.DeleteBucket([]byte(sessionID))
+}
+
+// Stats describes what is buffered for a session
+func (b *FileBuffer) Stats(ctx context.Context, sessionID string) (Stats, error) {
+	var stats Stats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		s := tx.Bucket(bucketSessions).Bucket([]byte(sessionID))
+		if s == nil {
+			return nil
+		}
+		stats.LatestID = fileLastID(s)
+		msgs := s.Bucket(bucketMessages)
+		if msgs == nil {
+			return nil
+		}
+		stats.Count = int64(fileCount(msgs))
+		c := msgs.Cursor()
+		if k, _ := c.First(); k != nil {
+			stats.OldestID = int64(binary.BigEndian.Uint64(k))
+		}
+		if _, v := c.Last(); v != nil {
+			var newest Message
+			if err := json.Unmarshal(v, &newest); err == nil {
+				expiry := time.UnixMilli(newest.Timestamp).Add(b.ttlFor(newest.Type))
+				if left := time.Until(expiry); left > 0 {
+					stats.TTL = left.Milliseconds()
+				}
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Close stops compaction and closes the database
+func (b *FileBuffer) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.wg.Wait()
+		err = b.db.Close()
+	})
+	return err
+}
+
+// compactLoop removes expired messages every interval until Close
+func (b *FileBuffer) compactLoop(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.compact()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// compact trims every session, one transaction each so pushes aren't held
+// up behind a large file
+func (b *FileBuffer) compact() {
+	var ids []string
+	b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).ForEach(func(k, v []byte) error {
+			if v == nil {
+				ids = append(ids, string(k))
+			}
+			return nil
+		})
+	})
+	now := time.Now()
+	for _, id := range ids {
+		if err := b.db.Update(func(tx *bolt.Tx) error {
+			return b.trim(tx, id, now)
+		}); err != nil {
+			log.Printf("Failed to compact buffer of session %s: %v", id, err)
+		}
+	}
+}
+
+// ttlFor returns how long messages of msgType are kept
+func (b *FileBuffer) ttlFor(msgType string) time.Duration {
+	if ttl, ok := b.typeTTLs[msgType]; ok {
+		return ttl
+	}
+	return b.ttl
+}
+
+// expired reports whether msg has outlived its type's TTL
+func (b *FileBuffer) expired(msg Message, now time.Time) bool {
+	return time.UnixMilli(msg.Timestamp).Add(b.ttlFor(msg.Type)).Before(now)
+}
+
+// fileMessages returns sessionID's message bucket, nil if it has none
+func fileMessages(tx *bolt.Tx, sessionID string) *bolt.Bucket {
+	s := tx.Bucket(bucketSessions).Bucket([]byte(sessionID))
+	if s == nil {
+		return nil
+	}
+	return s.Bucket(bucketMessages)
+}
+
+// fileCount returns the number of keys in b, including ones written in the
+// current transaction, which Bucket.Stats misses
+func fileCount(b *bolt.Bucket) int {
+	n := 0
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// fileLastID returns the last ID assigned in session bucket s
+func fileLastID(s *bolt.Bucket) int64 {
+	if v := s.Get(keyFileLastID); len(v) == 8 {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+// fileKey encodes a message ID so keys sort in ID order
+func fileKey(id int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
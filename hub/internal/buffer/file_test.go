@@ -0,0 +1,100 @@
+package buffer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openFile(t *testing.T, cfg FileConfig) *FileBuffer {
+	t.Helper()
+	b, err := NewFileBuffer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFileSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	cfg := FileConfig{Path: filepath.Join(t.TempDir(), "buffer.db"), TTL: time.Hour}
+
+	b := openFile(t, cfg)
+	for _, typ := range []string{"stream", "stream", "stream.end"} {
+		if _, err := b.Push(ctx, "ses_a", Message{Type: typ, RequestID: "req-1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted hub resyncs the client that had acked message 1
+	b = openFile(t, cfg)
+	defer b.Close()
+	messages, err := b.GetSince(ctx, "ses_a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != 2 || messages[1].ID != 3 || messages[1].Type != "stream.end" {
+		t.Fatalf("GetSince after restart = %+v, want messages 2 and 3", messages)
+	}
+
+	// IDs carry on from where they were, not from 1
+	id, err := b.Push(ctx, "ses_a", Message{Type: "stream"})
+	if err != nil || id != 4 {
+		t.Errorf("Push after restart = %d, %v, want ID 4", id, err)
+	}
+}
+
+func TestFileDropsExpiredOnOpen(t *testing.T) {
+	ctx := context.Background()
+	cfg := FileConfig{
+		Path:     filepath.Join(t.TempDir(), "buffer.db"),
+		TTL:      time.Hour,
+		TypeTTLs: map[string]time.Duration{"stream": time.Minute},
+	}
+
+	b := openFile(t, cfg)
+	old := time.Now().Add(-2 * time.Minute).UnixMilli()
+	b.Push(ctx, "ses_a", Message{Type: "stream", Timestamp: old})
+	b.Push(ctx, "ses_a", Message{Type: "stream.end", Timestamp: old})
+	b.Push(ctx, "ses_b", Message{Type: "stream", Timestamp: old})
+	b.Close()
+
+	b = openFile(t, cfg)
+	defer b.Close()
+	stats, _ := b.Stats(ctx, "ses_a")
+	if stats.Count != 1 || stats.OldestID != 2 || stats.LatestID != 2 {
+		t.Errorf("ses_a after reopening = %+v, want only the end marker", stats)
+	}
+	if stats, _ := b.Stats(ctx, "ses_b"); stats != (Stats{}) {
+		t.Errorf("ses_b after reopening = %+v, want it gone", stats)
+	}
+}
+
+func TestFileMaxCount(t *testing.T) {
+	ctx := context.Background()
+	b := openFile(t, FileConfig{Path: filepath.Join(t.TempDir(), "buffer.db"), MaxCount: 3})
+	defer b.Close()
+
+	for i := 0; i < 5; i++ {
+		b.Push(ctx, "ses_a", Message{Type: "stream"})
+	}
+	messages, _ := b.GetSince(ctx, "ses_a", 0)
+	if len(messages) != 3 || messages[0].ID != 3 {
+		t.Errorf("kept %+v, want messages 3 to 5", messages)
+	}
+}
+
+func TestFileLocked(t *testing.T) {
+	cfg := FileConfig{Path: filepath.Join(t.TempDir(), "buffer.db")}
+	b := openFile(t, cfg)
+	defer b.Close()
+
+	if second, err := NewFileBuffer(cfg); err == nil {
+		second.Close()
+		t.Error("a second buffer opened the same file")
+	}
+}
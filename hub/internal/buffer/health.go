@@ -0,0 +1,105 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+// ErrBufferUnavailable is returned without contacting Redis while the buffer
+// is degraded and has no fallback
+var ErrBufferUnavailable = errors.New("message buffer unavailable")
+
+// Degradation defaults
+const (
+	// DefaultFailureThreshold is how many consecutive Redis errors mark the
+	// buffer degraded
+	DefaultFailureThreshold = 3
+	// DefaultRetryInterval is how long a degraded buffer waits before trying
+	// Redis again
+	DefaultRetryInterval = 5 * time.Second
+)
+
+var (
+	bufferErrors    = metrics.NewCounter("buffer_errors_total")
+	bufferDegraded  = metrics.NewGauge("buffer_degraded")
+	bufferFallbacks = metrics.NewCounter("buffer_fallback_ops_total")
+)
+
+// Health is implemented by buffers that can report whether their backend is
+// reachable
+type Health interface {
+	// Degraded reports whether recent operations have been failing
+	Degraded() bool
+}
+
+// health tracks consecutive backend errors. Once degraded, calls skip the
+// backend until retryInterval has passed, then one call probes it.
+type health struct {
+	threshold     int
+	retryInterval time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	degraded  bool
+	lastProbe time.Time
+}
+
+func newHealth(threshold int, retryInterval time.Duration) *health {
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	return &health{threshold: threshold, retryInterval: retryInterval}
+}
+
+// allow reports whether a call should try the backend
+func (h *health) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.degraded || time.Since(h.lastProbe) >= h.retryInterval {
+		h.lastProbe = time.Now()
+		return true
+	}
+	return false
+}
+
+// record reports a backend call's outcome. Calls canceled by the caller say
+// nothing about the backend.
+func (h *health) record(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.failures = 0
+		if h.degraded {
+			log.Printf("Redis buffer recovered")
+			h.degraded = false
+			bufferDegraded.Set(0)
+		}
+		return
+	}
+
+	bufferErrors.Inc()
+	h.failures++
+	if !h.degraded && h.failures >= h.threshold {
+		log.Printf("WARNING: Redis buffer failing (%d consecutive errors, last: %v), sync is degraded", h.failures, err)
+		h.degraded = true
+		bufferDegraded.Set(1)
+	}
+}
+
+// Degraded reports whether the backend is considered down
+func (h *health) Degraded() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.degraded
+}
@@ -0,0 +1,144 @@
+package buffer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBuffer implements Buffer in process memory. It is not shared between
+// hub instances and is lost on restart; RedisBuffer uses it as a fallback
+// while Redis is unreachable.
+type MemoryBuffer struct {
+	ttl      time.Duration
+	maxCount int
+
+	mu       sync.Mutex
+	sessions map[string]*memorySession
+}
+
+type memorySession struct {
+	lastID   int64
+	messages []Message
+}
+
+// NewMemoryBuffer creates an in-memory buffer keeping up to maxCount
+// messages per session for ttl
+func NewMemoryBuffer(ttl time.Duration, maxCount int) *MemoryBuffer {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if maxCount == 0 {
+		maxCount = DefaultMaxCount
+	}
+	return &MemoryBuffer{ttl: ttl, maxCount: maxCount, sessions: make(map[string]*memorySession)}
+}
+
+// Push adds a message to the buffer
+func (b *MemoryBuffer) Push(ctx context.Context, sessionID string, msg Message) (int64, error) {
+	return b.pushAfter(sessionID, msg, 0), nil
+}
+
+// pushAfter adds a message with an ID above both the session's last ID and
+// floor, so IDs continue from another buffer's
+func (b *MemoryBuffer) pushAfter(sessionID string, msg Message, floor int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.sessions[sessionID]
+	if s == nil {
+		s = &memorySession{}
+		b.sessions[sessionID] = s
+	}
+	if floor > s.lastID {
+		s.lastID = floor
+	}
+	s.lastID++
+	msg.ID = s.lastID
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixMilli()
+	}
+	s.messages = append(s.messages, msg)
+	if len(s.messages) > b.maxCount {
+		s.messages = s.messages[len(s.messages)-b.maxCount:]
+	}
+	return msg.ID
+}
+
+// GetSince retrieves unexpired messages after the specified ID
+func (b *MemoryBuffer) GetSince(ctx context.Context, sessionID string, afterID int64) ([]Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.sessions[sessionID]
+	if s == nil {
+		return nil, nil
+	}
+	cutoff := time.Now().Add(-b.ttl).UnixMilli()
+	var messages []Message
+	for _, msg := range s.messages {
+		if msg.ID > afterID && msg.Timestamp >= cutoff {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// GetLatestID returns the latest message ID
+func (b *MemoryBuffer) GetLatestID(ctx context.Context, sessionID string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s := b.sessions[sessionID]; s != nil {
+		return s.lastID, nil
+	}
+	return 0, nil
+}
+
+// Trim drops expired messages, and the session once none are left
+func (b *MemoryBuffer) Trim(ctx context.Context, sessionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.sessions[sessionID]
+	if s == nil {
+		return nil
+	}
+	cutoff := time.Now().Add(-b.ttl).UnixMilli()
+	kept := s.messages[:0]
+	for _, msg := range s.messages {
+		if msg.Timestamp >= cutoff {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages = kept
+	if len(kept) == 0 {
+		delete(b.sessions, sessionID)
+	}
+	return nil
+}
+
+// Stats describes what is buffered for a session
+func (b *MemoryBuffer) Stats(ctx context.Context, sessionID string) (Stats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.sessions[sessionID]
+	if s == nil {
+		return Stats{}, nil
+	}
+	stats := Stats{Count: int64(len(s.messages)), LatestID: s.lastID}
+	if len(s.messages) > 0 {
+		stats.OldestID = s.messages[0].ID
+		newest := time.UnixMilli(s.messages[len(s.messages)-1].Timestamp)
+		if left := time.Until(newest.Add(b.ttl)); left > 0 {
+			stats.TTL = left.Milliseconds()
+		}
+	}
+	return stats, nil
+}
+
+// Close releases resources
+func (b *MemoryBuffer) Close() error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package buffer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStats(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBuffer(time.Minute, 3)
+
+	if stats, err := b.Stats(ctx, "ses_none"); err != nil || stats != (Stats{}) {
+		t.Errorf("Stats of an unknown session = %+v, %v, want zero", stats, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		b.Push(ctx, "ses_a", Message{Type: "stream"})
+	}
+	stats, err := b.Stats(ctx, "ses_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// maxCount trimmed messages 1 and 2
+	if stats.Count != 3 || stats.OldestID != 3 || stats.LatestID != 5 {
+		t.Errorf("Stats = %+v, want count 3, oldest 3, latest 5", stats)
+	}
+	if stats.TTL <= 0 || stats.TTL > time.Minute.Milliseconds() {
+		t.Errorf("TTL = %dms, want within a minute", stats.TTL)
+	}
+}
+
+func TestMemoryStatsAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBuffer(time.Minute, 10)
+
+	old := time.Now().Add(-2 * time.Minute).UnixMilli()
+	b.Push(ctx, "ses_a", Message{Type: "stream", Timestamp: old})
+	b.Push(ctx, "ses_a", Message{Type: "stream", Timestamp: old})
+	b.Push(ctx, "ses_a", Message{Type: "stream"})
+	b.Trim(ctx, "ses_a")
+
+	stats, _ := b.Stats(ctx, "ses_a")
+	if stats.Count != 1 || stats.OldestID != 3 || stats.LatestID != 3 {
+		t.Errorf("Stats after trim = %+v, want count 1, oldest 3, latest 3", stats)
+	}
+}
@@ -3,9 +3,15 @@ package buffer
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,13 +22,35 @@ const (
 	DefaultTTL = 5 * time.Minute
 	// DefaultMaxCount is maximum messages per session
 	DefaultMaxCount = 100
+	// DefaultKeyPrefix namespaces every Redis key and channel
+	DefaultKeyPrefix = "openvibe"
+	// remoteBuffer is the capacity of the fan-out delivery channel
+	remoteBuffer = 256
 )
 
-// RedisBuffer implements Buffer using Redis sorted sets
+// RedisBuffer implements Buffer using Redis sorted sets. Pushed messages are
+// also published on a per-session channel so other hub instances can fan them
+// out to their own clients.
 type RedisBuffer struct {
-	client   *redis.Client
-	ttl      time.Duration
-	maxCount int64
+	client     *redis.Client
+	ttl        time.Duration
+	typeTTLs   map[string]time.Duration
+	maxCount   int64
+	prefix     string // Key namespace, see RedisConfig.KeyPrefix
+	instanceID string
+	pubsub     *redis.PubSub
+	remote     chan RemoteMessage
+
+	health   *health
+	fallback *MemoryBuffer // Serves messages while Redis is down, nil = disabled
+	lastIDs  sync.Map      // Session ID -> last ID Redis assigned, for fallback IDs
+}
+
+// envelope wraps a published message with the instance that pushed it
+type envelope struct {
+	Origin    string  `json:"origin"`
+	SessionID string  `json:"sessionId"`
+	Message   Message `json:"message"`
 }
 
 // RedisConfig holds Redis connection configuration
@@ -32,6 +60,18 @@ type RedisConfig struct {
 	DB       int
 	TTL      time.Duration
 	MaxCount int64
+
+	// TypeTTLs overrides TTL per message type, e.g. keeping "stream.end"
+	// markers longer than "stream" chunks. Unlisted types use TTL.
+	TypeTTLs map[string]time.Duration
+
+	// KeyPrefix namespaces keys and pub/sub channels so deployments can share
+	// a Redis (default DefaultKeyPrefix)
+	KeyPrefix string
+
+	// Fallback buffers messages in memory while Redis is unreachable so
+	// sync keeps working for clients of this instance
+	Fallback bool
 }
 
 // NewRedisBuffer creates a new Redis-backed buffer
@@ -59,28 +99,97 @@ func NewRedisBuffer(cfg RedisConfig) (*RedisBuffer, error) {
 		maxCount = DefaultMaxCount
 	}
 
-	return &RedisBuffer{
-		client:   client,
-		ttl:      ttl,
-		maxCount: maxCount,
-	}, nil
+	b := &RedisBuffer{
+		client:     client,
+		ttl:        ttl,
+		typeTTLs:   cfg.TypeTTLs,
+		maxCount:   maxCount,
+		prefix:     keyPrefix(cfg.KeyPrefix),
+		instanceID: newInstanceID(),
+		pubsub:     client.Subscribe(context.Background()),
+		remote:     make(chan RemoteMessage, remoteBuffer),
+		health:     newHealth(0, 0),
+	}
+	if cfg.Fallback {
+		b.fallback = NewMemoryBuffer(ttl, int(maxCount))
+	}
+	go b.receive()
+
+	return b, nil
+}
+
+// keyPrefix returns the key namespace for a configured RedisConfig.KeyPrefix
+func keyPrefix(configured string) string {
+	if prefix := strings.TrimSuffix(configured, ":"); prefix != "" {
+		return prefix
+	}
+	return DefaultKeyPrefix
+}
+
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
 
 func (b *RedisBuffer) keyMessages(sessionID string) string {
-	return fmt.Sprintf("openvibe:session:%s:messages", sessionID)
+	return fmt.Sprintf("%s:session:%s:messages", b.prefix, sessionID)
 }
 
 func (b *RedisBuffer) keyMsgID(sessionID string) string {
-	return fmt.Sprintf("openvibe:session:%s:msgid", sessionID)
+	return fmt.Sprintf("%s:session:%s:msgid", b.prefix, sessionID)
+}
+
+func (b *RedisBuffer) keyChannel(sessionID string) string {
+	return fmt.Sprintf("%s:session:%s:events", b.prefix, sessionID)
 }
 
-// Push adds a message to the buffer
+// Push adds a message to the buffer, or to the fallback while Redis is down
 func (b *RedisBuffer) Push(ctx context.Context, sessionID string, msg Message) (int64, error) {
+	floor := b.fallbackLatestID(sessionID)
+	if b.health.allow() {
+		id, err := b.push(ctx, sessionID, msg, floor)
+		b.health.record(err)
+		if err == nil || b.fallback == nil {
+			return id, err
+		}
+	} else if b.fallback == nil {
+		return 0, ErrBufferUnavailable
+	}
+
+	bufferFallbacks.Inc()
+	if last, ok := b.lastIDs.Load(sessionID); ok && last.(int64) > floor {
+		floor = last.(int64)
+	}
+	return b.fallback.pushAfter(sessionID, msg, floor), nil
+}
+
+// raiseAndIncr bumps a message ID counter to at least ARGV[1] before
+// incrementing it, so IDs issued by the fallback are never reused
+var raiseAndIncr = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return redis.call('INCR', KEYS[1])
+`)
+
+// push adds a message to Redis with an ID above floor
+func (b *RedisBuffer) push(ctx context.Context, sessionID string, msg Message, floor int64) (int64, error) {
 	// Get next ID
-	id, err := b.client.Incr(ctx, b.keyMsgID(sessionID)).Result()
+	var id int64
+	var err error
+	if floor > 0 {
+		id, err = raiseAndIncr.Run(ctx, b.client, []string{b.keyMsgID(sessionID)}, floor).Int64()
+	} else {
+		id, err = b.client.Incr(ctx, b.keyMsgID(sessionID)).Result()
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to get next id: %w", err)
 	}
+	if b.fallback != nil {
+		b.lastIDs.Store(sessionID, id)
+	}
 
 	msg.ID = id
 	if msg.Timestamp == 0 {
@@ -103,15 +212,133 @@ func (b *RedisBuffer) Push(ctx context.Context, sessionID string, msg Message) (
 		return 0, fmt.Errorf("failed to push message: %w", err)
 	}
 
-	// Set TTL
-	b.client.Expire(ctx, key, b.ttl)
-	b.client.Expire(ctx, b.keyMsgID(sessionID), b.ttl)
+	// Keep the keys alive as long as their longest-lived message
+	ttl := b.ttlFor(msg.Type)
+	b.extendTTL(ctx, key, ttl)
+	b.extendTTL(ctx, b.keyMsgID(sessionID), ttl)
+
+	// Drop what has aged out, so short-lived types don't sit in Redis until
+	// the key itself expires
+	if err := b.trim(ctx, sessionID); err != nil {
+		log.Printf("Buffer trim failed for session %s: %v", sessionID, err)
+	}
+
+	// Fan out to other hub instances
+	event, _ := json.Marshal(envelope{Origin: b.instanceID, SessionID: sessionID, Message: msg})
+	if err := b.client.Publish(ctx, b.keyChannel(sessionID), event).Err(); err != nil {
+		log.Printf("Buffer publish failed for session %s: %v", sessionID, err)
+	}
 
 	return id, nil
 }
 
-// GetSince retrieves messages after the specified ID
+// fallbackLatestID returns the last ID the fallback assigned for sessionID
+func (b *RedisBuffer) fallbackLatestID(sessionID string) int64 {
+	if b.fallback == nil {
+		return 0
+	}
+	id, _ := b.fallback.GetLatestID(context.Background(), sessionID)
+	return id
+}
+
+// Degraded reports whether Redis has been failing
+func (b *RedisBuffer) Degraded() bool {
+	return b.health.Degraded()
+}
+
+// ttlFor returns the retention for messages of msgType
+func (b *RedisBuffer) ttlFor(msgType string) time.Duration {
+	if ttl, ok := b.typeTTLs[msgType]; ok {
+		return ttl
+	}
+	return b.ttl
+}
+
+// expired reports whether msg has outlived its type's TTL
+func (b *RedisBuffer) expired(msg Message, now time.Time) bool {
+	return now.Sub(time.UnixMilli(msg.Timestamp)) > b.ttlFor(msg.Type)
+}
+
+// extendTTL sets key to expire after ttl unless it already lives longer
+func (b *RedisBuffer) extendTTL(ctx context.Context, key string, ttl time.Duration) {
+	if current, err := b.client.PTTL(ctx, key).Result(); err == nil && current >= ttl {
+		return
+	}
+	b.client.Expire(ctx, key, ttl)
+}
+
+// Subscribe starts receiving messages other instances push for sessionID
+func (b *RedisBuffer) Subscribe(ctx context.Context, sessionID string) error {
+	if err := b.pubsub.Subscribe(ctx, b.keyChannel(sessionID)); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	return nil
+}
+
+// Unsubscribe stops receiving messages for sessionID
+func (b *RedisBuffer) Unsubscribe(ctx context.Context, sessionID string) error {
+	if err := b.pubsub.Unsubscribe(ctx, b.keyChannel(sessionID)); err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	return nil
+}
+
+// Remote returns messages pushed by other hub instances
+func (b *RedisBuffer) Remote() <-chan RemoteMessage {
+	return b.remote
+}
+
+// receive decodes published messages, dropping the ones this instance pushed
+func (b *RedisBuffer) receive() {
+	defer close(b.remote)
+
+	for msg := range b.pubsub.Channel() {
+		var env envelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			continue
+		}
+		if env.Origin == b.instanceID {
+			continue
+		}
+
+		select {
+		case b.remote <- RemoteMessage{SessionID: env.SessionID, Message: env.Message}:
+		default:
+			log.Printf("Buffer fan-out channel full, dropping message for session %s", env.SessionID)
+		}
+	}
+}
+
+// GetSince retrieves messages after the specified ID, including any the
+// fallback holds from a Redis outage
 func (b *RedisBuffer) GetSince(ctx context.Context, sessionID string, afterID int64) ([]Message, error) {
+	if !b.health.allow() {
+		if b.fallback == nil {
+			return nil, ErrBufferUnavailable
+		}
+		bufferFallbacks.Inc()
+		return b.fallback.GetSince(ctx, sessionID, afterID)
+	}
+
+	messages, err := b.getSince(ctx, sessionID, afterID)
+	b.health.record(err)
+	if b.fallback == nil {
+		return messages, err
+	}
+	held, _ := b.fallback.GetSince(ctx, sessionID, afterID)
+	if err != nil {
+		bufferFallbacks.Inc()
+		return held, nil
+	}
+	if len(held) > 0 {
+		messages = append(messages, held...)
+		sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	}
+	return messages, nil
+}
+
+// getSince retrieves messages after the specified ID from Redis
+func (b *RedisBuffer) getSince(ctx context.Context, sessionID string, afterID int64) ([]Message, error) {
 	key := b.keyMessages(sessionID)
 
 	// ZRANGEBYSCORE key (afterID +inf
@@ -123,12 +350,17 @@ func (b *RedisBuffer) GetSince(ctx context.Context, sessionID string, afterID in
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 
+	// Messages expire individually by type; the key only expires with the last of them
+	now := time.Now()
 	messages := make([]Message, 0, len(results))
 	for _, data := range results {
 		var msg Message
 		if err := json.Unmarshal([]byte(data), &msg); err != nil {
 			continue // Skip corrupted messages
 		}
+		if b.expired(msg, now) {
+			continue
+		}
 		messages = append(messages, msg)
 	}
 
@@ -137,26 +369,429 @@ func (b *RedisBuffer) GetSince(ctx context.Context, sessionID string, afterID in
 
 // GetLatestID returns the latest message ID
 func (b *RedisBuffer) GetLatestID(ctx context.Context, sessionID string) (int64, error) {
+	held := b.fallbackLatestID(sessionID)
+	if !b.health.allow() {
+		if b.fallback == nil {
+			return 0, ErrBufferUnavailable
+		}
+		return held, nil
+	}
+
 	result, err := b.client.Get(ctx, b.keyMsgID(sessionID)).Result()
 	if err == redis.Nil {
-		return 0, nil
+		err = nil
 	}
+	b.health.record(err)
 	if err != nil {
+		if b.fallback != nil {
+			return held, nil
+		}
 		return 0, fmt.Errorf("failed to get latest id: %w", err)
 	}
 
 	id, _ := strconv.ParseInt(result, 10, 64)
+	if held > id {
+		id = held
+	}
 	return id, nil
 }
 
-// Trim removes old messages, keeping only the most recent ones
+// Stats describes what is buffered for a session, from the fallback while
+// Redis is down
+func (b *RedisBuffer) Stats(ctx context.Context, sessionID string) (Stats, error) {
+	if !b.health.allow() {
+		if b.fallback == nil {
+			return Stats{}, ErrBufferUnavailable
+		}
+		return b.fallback.Stats(ctx, sessionID)
+	}
+
+	key := b.keyMessages(sessionID)
+	pipe := b.client.Pipeline()
+	count := pipe.ZCard(ctx, key)
+	oldest := pipe.ZRangeWithScores(ctx, key, 0, 0)
+	latest := pipe.Get(ctx, b.keyMsgID(sessionID))
+	ttl := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err == redis.Nil {
+		err = nil // No msgid key yet
+	}
+	b.health.record(err)
+	if err != nil {
+		if b.fallback != nil {
+			return b.fallback.Stats(ctx, sessionID)
+		}
+		return Stats{}, fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	stats := Stats{Count: count.Val()}
+	stats.LatestID, _ = strconv.ParseInt(latest.Val(), 10, 64)
+	if z := oldest.Val(); len(z) > 0 {
+		stats.OldestID = int64(z[0].Score)
+	}
+	if t := ttl.Val(); t > 0 {
+		stats.TTL = t.Milliseconds()
+	}
+	return stats, nil
+}
+
+// Trim removes old messages, keeping only the most recent ones, and drops
+// messages past their type's TTL
 func (b *RedisBuffer) Trim(ctx context.Context, sessionID string) error {
+	if b.fallback != nil {
+		b.fallback.Trim(ctx, sessionID)
+	}
+	if !b.health.allow() {
+		return ErrBufferUnavailable
+	}
+	err := b.trim(ctx, sessionID)
+	b.health.record(err)
+	return err
+}
+
+// trim applies Trim to the Redis copy
+func (b *RedisBuffer) trim(ctx context.Context, sessionID string) error {
 	key := b.keyMessages(sessionID)
 	// Keep the latest maxCount messages, remove the rest
-	return b.client.ZRemRangeByRank(ctx, key, 0, -b.maxCount-1).Err()
+	if err := b.client.ZRemRangeByRank(ctx, key, 0, -b.maxCount-1).Err(); err != nil {
+		return err
+	}
+	if len(b.typeTTLs) == 0 {
+		return nil // Every message shares the key's TTL
+	}
+
+	results, err := b.client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to scan messages: %w", err)
+	}
+
+	stale := b.stale(results, time.Now())
+	if len(stale) == 0 {
+		return nil
+	}
+	return b.client.ZRem(ctx, key, stale...).Err()
+}
+
+// stale returns the members that are past their type's TTL or unreadable
+func (b *RedisBuffer) stale(members []string, now time.Time) []interface{} {
+	var stale []interface{}
+	for _, data := range members {
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil || b.expired(msg, now) {
+			stale = append(stale, data)
+		}
+	}
+	return stale
 }
 
-// Close closes the Redis connection
+// Close closes the subscription and the Redis connection
 func (b *RedisBuffer) Close() error {
+	b.pubsub.Close()
 	return b.client.Close()
 }
+
+func (b *RedisBuffer) keyTombstones() string {
+	return b.prefix + ":tombstones"
+}
+
+func (b *RedisBuffer) keyTombstone(sessionID string) string {
+	return fmt.Sprintf("%s:session:%s:tombstone", b.prefix, sessionID)
+}
+
+// Tombstone records a soft delete. The sorted set orders tombstones by
+// DeleteAt for ClaimExpired; the per-session key holds the details and
+// expires on its own an hour after the grace period.
+func (b *RedisBuffer) Tombstone(ctx context.Context, t Tombstone) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tombstone: %w", err)
+	}
+
+	ttl := time.Until(time.UnixMilli(t.DeleteAt)) + time.Hour
+	pipe := b.client.TxPipeline()
+	pipe.Set(ctx, b.keyTombstone(t.SessionID), data, ttl)
+	pipe.ZAdd(ctx, b.keyTombstones(), redis.Z{Score: float64(t.DeleteAt), Member: t.SessionID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store tombstone: %w", err)
+	}
+	return nil
+}
+
+// Restore removes a session's tombstone
+func (b *RedisBuffer) Restore(ctx context.Context, sessionID string) (bool, error) {
+	removed, err := b.client.ZRem(ctx, b.keyTombstones(), sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to restore session: %w", err)
+	}
+	b.client.Del(ctx, b.keyTombstone(sessionID))
+	return removed == 1, nil
+}
+
+// Tombstoned returns the IDs of all soft-deleted sessions
+func (b *RedisBuffer) Tombstoned(ctx context.Context) (map[string]bool, error) {
+	ids, err := b.client.ZRange(ctx, b.keyTombstones(), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+
+	result := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result, nil
+}
+
+// ClaimExpired removes and returns tombstones whose grace period has ended.
+// ZREM succeeds for only one instance, which then owns the real delete.
+func (b *RedisBuffer) ClaimExpired(ctx context.Context) ([]Tombstone, error) {
+	ids, err := b.client.ZRangeByScore(ctx, b.keyTombstones(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired tombstones: %w", err)
+	}
+
+	var claimed []Tombstone
+	for _, id := range ids {
+		removed, err := b.client.ZRem(ctx, b.keyTombstones(), id).Result()
+		if err != nil || removed == 0 {
+			continue // Another instance claimed it
+		}
+
+		t := Tombstone{SessionID: id}
+		if data, err := b.client.GetDel(ctx, b.keyTombstone(id)).Bytes(); err == nil {
+			json.Unmarshal(data, &t)
+		}
+		claimed = append(claimed, t)
+	}
+	return claimed, nil
+}
+
+func (b *RedisBuffer) keyMeta(sessionID string) string {
+	return fmt.Sprintf("%s:session:%s:meta", b.prefix, sessionID)
+}
+
+// SetMeta merges meta into the session's metadata hash. The hash expires
+// after the buffer TTL without a change.
+func (b *RedisBuffer) SetMeta(ctx context.Context, sessionID string, meta map[string]json.RawMessage) error {
+	key := b.keyMeta(sessionID)
+	pipe := b.client.TxPipeline()
+	for field, value := range meta {
+		if len(value) == 0 || string(value) == "null" {
+			pipe.HDel(ctx, key, field)
+			continue
+		}
+		pipe.HSet(ctx, key, field, string(value))
+	}
+	pipe.Expire(ctx, key, b.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to set metadata: %w", err)
+	}
+	return nil
+}
+
+// GetMeta returns the session's metadata hash
+func (b *RedisBuffer) GetMeta(ctx context.Context, sessionID string) (map[string]json.RawMessage, error) {
+	fields, err := b.client.HGetAll(ctx, b.keyMeta(sessionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	meta := make(map[string]json.RawMessage, len(fields))
+	for field, value := range fields {
+		meta[field] = json.RawMessage(value)
+	}
+	return meta, nil
+}
+
+// GetMetaMany fetches several sessions' metadata in one round trip
+func (b *RedisBuffer) GetMetaMany(ctx context.Context, sessionIDs []string) (map[string]map[string]json.RawMessage, error) {
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(sessionIDs))
+	for i, id := range sessionIDs {
+		cmds[i] = pipe.HGetAll(ctx, b.keyMeta(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	result := make(map[string]map[string]json.RawMessage)
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+		meta := make(map[string]json.RawMessage, len(fields))
+		for field, value := range fields {
+			meta[field] = json.RawMessage(value)
+		}
+		result[sessionIDs[i]] = meta
+	}
+	return result, nil
+}
+
+// LastActivity reads each session's newest buffered message in one round trip
+func (b *RedisBuffer) LastActivity(ctx context.Context, sessionIDs []string) (map[string]int64, error) {
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(sessionIDs))
+	for i, id := range sessionIDs {
+		cmds[i] = pipe.ZRevRange(ctx, b.keyMessages(id), 0, 0)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get last activity: %w", err)
+	}
+
+	result := make(map[string]int64)
+	for i, cmd := range cmds {
+		members := cmd.Val()
+		if len(members) == 0 {
+			continue
+		}
+		var msg Message
+		if json.Unmarshal([]byte(members[0]), &msg) == nil && msg.Timestamp > 0 {
+			result[sessionIDs[i]] = msg.Timestamp
+		}
+	}
+	return result, nil
+}
+
+func (b *RedisBuffer) keyLock(sessionID string) string {
+	return fmt.Sprintf("%s:session:%s:lock", b.prefix, sessionID)
+}
+
+// acquireLock sets KEYS[1] to token ARGV[1] for ARGV[2] ms unless another
+// token holds it, returning {acquired, holder, remaining ms}
+var acquireLock = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if not holder or holder == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return {1, ARGV[1], tonumber(ARGV[2])}
+end
+return {0, holder, redis.call('PTTL', KEYS[1])}
+`)
+
+// releaseLock deletes KEYS[1] if token ARGV[1] holds it
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock takes or renews a session's lock. The key expires with the
+// lease, so an abandoned lock frees itself.
+func (b *RedisBuffer) AcquireLock(ctx context.Context, sessionID, token string, ttl time.Duration) (Lock, bool, error) {
+	result, err := acquireLock.Run(ctx, b.client, []string{b.keyLock(sessionID)}, token, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return Lock{}, false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if len(result) != 3 {
+		return Lock{}, false, fmt.Errorf("failed to acquire lock: unexpected reply %v", result)
+	}
+	acquired, _ := result[0].(int64)
+	holder, _ := result[1].(string)
+	remaining, _ := result[2].(int64)
+	lock := Lock{
+		SessionID: sessionID,
+		Token:     holder,
+		ExpiresAt: time.Now().Add(time.Duration(remaining) * time.Millisecond).UnixMilli(),
+	}
+	return lock, acquired == 1, nil
+}
+
+// ReleaseLock frees a session's lock held by token
+func (b *RedisBuffer) ReleaseLock(ctx context.Context, sessionID, token string) (bool, error) {
+	released, err := releaseLock.Run(ctx, b.client, []string{b.keyLock(sessionID)}, token).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to release lock: %w", err)
+	}
+	return released == 1, nil
+}
+
+// GetLock returns a session's lock and its remaining lease
+func (b *RedisBuffer) GetLock(ctx context.Context, sessionID string) (Lock, bool, error) {
+	key := b.keyLock(sessionID)
+	pipe := b.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return Lock{}, false, fmt.Errorf("failed to get lock: %w", err)
+	}
+	if get.Err() == redis.Nil {
+		return Lock{}, false, nil
+	}
+	return Lock{
+		SessionID: sessionID,
+		Token:     get.Val(),
+		ExpiresAt: time.Now().Add(pttl.Val()).UnixMilli(),
+	}, true, nil
+}
+
+func (b *RedisBuffer) keyUsage(sessionID string) string {
+	return fmt.Sprintf("%s:session:%s:usage", b.prefix, sessionID)
+}
+
+// AddUsage increments the session's usage hash and reads it back in one
+// transaction, so concurrent prompts on other instances aren't lost. The
+// hash expires after the buffer TTL without a prompt.
+func (b *RedisBuffer) AddUsage(ctx context.Context, sessionID string, u Usage) (Usage, error) {
+	key := b.keyUsage(sessionID)
+	pipe := b.client.TxPipeline()
+	pipe.HIncrByFloat(ctx, key, "cost", u.Cost)
+	pipe.HIncrBy(ctx, key, "input", u.Input)
+	pipe.HIncrBy(ctx, key, "output", u.Output)
+	pipe.HIncrBy(ctx, key, "reasoning", u.Reasoning)
+	pipe.HIncrBy(ctx, key, "cacheRead", u.CacheRead)
+	pipe.HIncrBy(ctx, key, "cacheWrite", u.CacheWrite)
+	pipe.Expire(ctx, key, b.ttl)
+	all := pipe.HGetAll(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Usage{}, fmt.Errorf("failed to add usage: %w", err)
+	}
+	return parseUsage(all.Val()), nil
+}
+
+// GetUsage returns the session's usage hash
+func (b *RedisBuffer) GetUsage(ctx context.Context, sessionID string) (Usage, error) {
+	fields, err := b.client.HGetAll(ctx, b.keyUsage(sessionID)).Result()
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to get usage: %w", err)
+	}
+	return parseUsage(fields), nil
+}
+
+// parseUsage reads a usage hash; missing or malformed fields count as zero
+func parseUsage(fields map[string]string) Usage {
+	count := func(field string) int64 {
+		n, _ := strconv.ParseInt(fields[field], 10, 64)
+		return n
+	}
+	cost, _ := strconv.ParseFloat(fields["cost"], 64)
+	return Usage{
+		Cost:       cost,
+		Input:      count("input"),
+		Output:     count("output"),
+		Reasoning:  count("reasoning"),
+		CacheRead:  count("cacheRead"),
+		CacheWrite: count("cacheWrite"),
+	}
+}
+
+// PurgeSession deletes a session's messages, metadata, usage total and lock
+func (b *RedisBuffer) PurgeSession(ctx context.Context, sessionID string) error {
+	if b.fallback != nil {
+		b.fallback.Trim(ctx, sessionID)
+	}
+	err := b.client.Del(ctx,
+		b.keyMessages(sessionID),
+		b.keyMsgID(sessionID),
+		b.keyMeta(sessionID),
+		b.keyUsage(sessionID),
+		b.keyLock(sessionID),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to purge session: %w", err)
+	}
+	return nil
+}
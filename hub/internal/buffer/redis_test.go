@@ -0,0 +1,182 @@
+package buffer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// typeTTLs keeps stream chunks briefly and their end markers longer
+var typeTTLs = map[string]time.Duration{
+	"stream":     time.Minute,
+	"stream.end": time.Hour,
+}
+
+func member(t *testing.T, msg Message) string {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRedisStaleByType(t *testing.T) {
+	b := &RedisBuffer{ttl: 5 * time.Minute, typeTTLs: typeTTLs}
+	now := time.Now()
+	ago := func(d time.Duration) int64 { return now.Add(-d).UnixMilli() }
+
+	chunk := member(t, Message{ID: 1, Type: "stream", Timestamp: ago(2 * time.Minute)})
+	end := member(t, Message{ID: 2, Type: "stream.end", Timestamp: ago(2 * time.Minute)})
+	other := member(t, Message{ID: 3, Type: "stream.start", Timestamp: ago(2 * time.Minute)})
+	oldOther := member(t, Message{ID: 4, Type: "stream.start", Timestamp: ago(10 * time.Minute)})
+	fresh := member(t, Message{ID: 5, Type: "stream", Timestamp: ago(time.Second)})
+
+	stale := b.stale([]string{chunk, end, other, oldOther, fresh, "not json"}, now)
+	want := []interface{}{chunk, oldOther, "not json"}
+	if len(stale) != len(want) {
+		t.Fatalf("stale = %v, want %v", stale, want)
+	}
+	for i := range want {
+		if stale[i] != want[i] {
+			t.Errorf("stale[%d] = %v, want %v", i, stale[i], want[i])
+		}
+	}
+}
+
+// testRedis connects to REDIS_ADDR, skipping the test when it isn't set
+func testRedis(t *testing.T) *RedisBuffer {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	b, err := NewRedisBuffer(RedisConfig{
+		Addr:      addr,
+		TypeTTLs:  typeTTLs,
+		KeyPrefix: "openvibe-test-" + newInstanceID(),
+	})
+	if err != nil {
+		t.Skipf("redis unavailable: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestRedisPushDropsExpiredTypes(t *testing.T) {
+	b := testRedis(t)
+	ctx := context.Background()
+	const session = "ses_trim"
+	t.Cleanup(func() {
+		b.client.Del(ctx, b.keyMessages(session), b.keyMsgID(session))
+	})
+
+	old := time.Now().Add(-2 * time.Minute).UnixMilli()
+	if _, err := b.Push(ctx, session, Message{Type: "stream", Timestamp: old}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Push(ctx, session, Message{Type: "stream.end", Timestamp: old}); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := b.client.ZRange(ctx, b.keyMessages(session), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("%d messages left in redis, want only the end marker", len(members))
+	}
+	var msg Message
+	json.Unmarshal([]byte(members[0]), &msg)
+	if msg.Type != "stream.end" {
+		t.Errorf("kept %s, want stream.end", msg.Type)
+	}
+}
+
+func TestRedisStats(t *testing.T) {
+	b := testRedis(t)
+	ctx := context.Background()
+	const session = "ses_stats"
+	t.Cleanup(func() {
+		b.client.Del(ctx, b.keyMessages(session), b.keyMsgID(session))
+	})
+
+	if stats, err := b.Stats(ctx, session); err != nil || stats != (Stats{}) {
+		t.Errorf("Stats of an empty session = %+v, %v, want zero", stats, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := b.Push(ctx, session, Message{Type: "stream.end"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := b.Stats(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count != 3 || stats.OldestID != 1 || stats.LatestID != 3 || stats.TTL <= 0 {
+		t.Errorf("Stats = %+v, want count 3, oldest 1, latest 3 and a TTL", stats)
+	}
+}
+
+func TestRedisSessionDataExpiresAndPurges(t *testing.T) {
+	b := testRedis(t)
+	ctx := context.Background()
+	const session = "ses_purge"
+
+	if err := b.SetMeta(ctx, session, map[string]json.RawMessage{"pinned": json.RawMessage("true")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddUsage(ctx, session, Usage{Input: 10, Output: 5}); err != nil {
+		t.Fatal(err)
+	}
+	b.Push(ctx, session, Message{Type: "stream"})
+
+	for _, key := range []string{b.keyMeta(session), b.keyUsage(session)} {
+		ttl, err := b.client.PTTL(ctx, key).Result()
+		if err != nil || ttl <= 0 || ttl > b.ttl {
+			t.Errorf("%s TTL = %v, %v, want within %v", key, ttl, err, b.ttl)
+		}
+	}
+
+	if err := b.PurgeSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{b.keyMessages(session), b.keyMsgID(session), b.keyMeta(session), b.keyUsage(session)}
+	if n, err := b.client.Exists(ctx, keys...).Result(); err != nil || n != 0 {
+		t.Errorf("%d session keys left after purge (%v)", n, err)
+	}
+}
+
+func TestRedisKeys(t *testing.T) {
+	b := &RedisBuffer{prefix: "x"}
+	keys := []struct{ got, want string }{
+		{b.keyMessages("ses_a"), "x:session:ses_a:messages"},
+		{b.keyMsgID("ses_a"), "x:session:ses_a:msgid"},
+		{b.keyChannel("ses_a"), "x:session:ses_a:events"},
+		{b.keyMeta("ses_a"), "x:session:ses_a:meta"},
+		{b.keyUsage("ses_a"), "x:session:ses_a:usage"},
+		{b.keyLock("ses_a"), "x:session:ses_a:lock"},
+		{b.keyTombstone("ses_a"), "x:session:ses_a:tombstone"},
+		{b.keyTombstones(), "x:tombstones"},
+	}
+	for _, key := range keys {
+		if key.got != key.want {
+			t.Errorf("key %q, want %q", key.got, key.want)
+		}
+	}
+
+	for configured, want := range map[string]string{"": DefaultKeyPrefix, "team-a": "team-a", "team-a:": "team-a"} {
+		b := &RedisBuffer{prefix: keyPrefix(configured)}
+		if got := b.keyMessages("ses_a"); got != want+":session:ses_a:messages" {
+			t.Errorf("KeyPrefix %q: messages key %q", configured, got)
+		}
+		if got := b.keyMsgID("ses_a"); got != want+":session:ses_a:msgid" {
+			t.Errorf("KeyPrefix %q: message ID key %q", configured, got)
+		}
+	}
+	if got := (&RedisBuffer{prefix: keyPrefix("")}).keyMessages("ses_a"); got != "openvibe:session:ses_a:messages" {
+		t.Errorf("default messages key %q", got)
+	}
+}
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // Config holds the hub configuration
 type Config struct {
 	Port        string
@@ -11,6 +13,122 @@ type Config struct {
 	RedisAddr  string // Redis address (empty = disabled)
 	RedisPass  string // Redis password
 	RedisDB    int    // Redis database number
+
+	// RedisPrefix namespaces Redis keys so hubs can share one Redis
+	RedisPrefix string
+
+	// SystemPreamble is a system instruction added to every prompt, ahead of
+	// any per-project preamble the agent adds
+	SystemPreamble string
+
+	// BufferFallback buffers messages in memory while Redis is unreachable
+	BufferFallback bool
+
+	// BufferBackends is the buffer backend chain, see buffer.Config.Backends
+	BufferBackends []string
+
+	// BufferPath is the database file of the file buffer backend
+	BufferPath string
+
+	// BufferTypeTTLs overrides the buffer TTL per message type
+	BufferTypeTTLs map[string]time.Duration
+
+	// AllowedOrigins is the origin allowlist for CORS and WebSocket upgrades.
+	// Empty means no CORS headers and any WebSocket origin.
+	AllowedOrigins []string
+
+	// SessionTitle picks the default title for untitled sessions:
+	// "none", "timestamp", or "first-prompt"
+	SessionTitle string
+
+	// SessionDeleteGrace soft-deletes sessions for this long before the real
+	// delete, allowing session.restore. 0 (or no Redis) deletes immediately.
+	SessionDeleteGrace time.Duration
+
+	// SessionLockTTL is the lease of a session.acquire lock; the holder
+	// renews it by acquiring again (requires Redis)
+	SessionLockTTL time.Duration
+
+	// Request deadlines. ActionTimeout covers quick requests (list, create,
+	// delete, sync), LongActionTimeout slower ones (message history, project
+	// stop). A prompt has no overall deadline but fails once its stream has
+	// been silent for StreamIdleTimeout.
+	ActionTimeout       time.Duration
+	LongActionTimeout   time.Duration
+	ProjectStartTimeout time.Duration
+	StreamIdleTimeout   time.Duration
+
+	// After BreakerThreshold consecutive OpenCode failures, direct-mode calls
+	// fail fast for BreakerCooldown. A threshold of 0 disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// WebhookURL receives a JSON POST for agent, client and prompt events
+	// (empty = off)
+	WebhookURL string
+
+	// Groups isolate tenants sharing the hub: a client using a group's Token
+	// only reaches agents registered with its AgentToken. Token and AgentToken
+	// keep serving the default group.
+	Groups []Group
+
+	// ReadOnly rejects mutating client actions (prompts, session and project
+	// changes) for every client. ReadOnlyToken grants read-only access to
+	// the default group alongside Token.
+	ReadOnly      bool
+	ReadOnlyToken string
+
+	// SlowPromptThreshold logs completed prompts taking at least this long,
+	// with their request and session IDs (0 = never)
+	SlowPromptThreshold time.Duration
+
+	// AgentFailover moves a session whose agent went offline to another
+	// connected agent of its group registering the session's project.
+	// OpenCode state stays behind unless the agents share it.
+	AgentFailover bool
+
+	// AutoCreateSession creates a session for a prompt when neither the
+	// prompt nor the client names one, instead of rejecting it
+	AutoCreateSession bool
+
+	// PathPrefix mounts every endpoint under it, e.g. "/openvibe/ws", for
+	// serving behind a reverse proxy subpath ("" = at the root)
+	PathPrefix string
+
+	// LiveToken and LiveAgentToken are Token and AgentToken as currently in
+	// effect. With TokenFile set they rotate when the file changes, the old
+	// token staying valid for TokenGrace. Nil means Token/AgentToken as is.
+	LiveToken      *RotatingToken
+	LiveAgentToken *RotatingToken
+	TokenFile      string
+	TokenGrace     time.Duration
+
+	// AdminToken enables the /admin feed of every agent and instance across
+	// all groups; it grants nothing on /ws (empty = no admin feed)
+	AdminToken string
+
+	// OpenCodeHeaders are added to every direct-mode OpenCode request, e.g.
+	// for an API gateway in front of it; "{session}" in a value expands to
+	// the request's session ID
+	OpenCodeHeaders map[string]string
+
+	// AuditLog records every prompt's content as JSON lines to this file
+	// ("-" = stdout, "" = off), with replies too if AuditResponses is set.
+	// Matches of the AuditRedact regexes are masked first.
+	AuditLog       string
+	AuditResponses bool
+	AuditRedact    []string
+
+	// AgentRetryWindow is how long after the last agent disconnect
+	// "no agent" errors are reported as retryable
+	AgentRetryWindow time.Duration
+}
+
+// Group is an agent group and the tokens that join it
+type Group struct {
+	Name       string
+	Token      string // Client token
+	AgentToken string
 }
 
 // New creates a default configuration
@@ -23,5 +141,17 @@ func New() *Config {
 		RedisAddr:   "",
 		RedisPass:   "",
 		RedisDB:     0,
+
+		SessionTitle:     "timestamp",
+		AgentRetryWindow: 30 * time.Second,
+		SessionLockTTL:   2 * time.Minute,
+
+		ActionTimeout:       10 * time.Second,
+		LongActionTimeout:   30 * time.Second,
+		ProjectStartTimeout: 10 * time.Minute,
+		StreamIdleTimeout:   5 * time.Minute,
+
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
 	}
 }
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Keys read from a token file, named after the matching environment variables
+const (
+	TokenFileClientKey = "OPENVIBE_TOKEN"
+	TokenFileAgentKey  = "OPENVIBE_AGENT_TOKEN"
+)
+
+// RotatingToken is a token that can be replaced while the hub runs. After a
+// rotation the previous token stays valid for a grace window, so clients and
+// agents can move to the new one without being locked out.
+type RotatingToken struct {
+	mu       sync.RWMutex
+	current  string
+	previous string
+	expires  time.Time // When previous stops being valid
+}
+
+// NewRotatingToken creates a RotatingToken starting at token
+func NewRotatingToken(token string) *RotatingToken {
+	return &RotatingToken{current: token}
+}
+
+// Current returns the token in effect
+func (t *RotatingToken) Current() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current
+}
+
+// Valid reports whether token is the current token, or the previous one
+// within its grace window. An empty current token disables auth.
+func (t *RotatingToken) Valid(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.current == "" {
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(t.current)) == 1 {
+		return true
+	}
+	return t.previous != "" && time.Now().Before(t.expires) &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(t.previous)) == 1
+}
+
+// Rotate replaces the token, keeping the old one valid for grace, and
+// reports whether it changed. Only one previous token is kept: rotating
+// again within the window ends the older token's grace early.
+func (t *RotatingToken) Rotate(token string, grace time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token == t.current {
+		return false
+	}
+	t.previous = t.current
+	t.expires = time.Now().Add(grace)
+	t.current = token
+	return true
+}
+
+// ReadTokenFile reads the client and agent tokens from a file of KEY=value
+// lines using TokenFileClientKey and TokenFileAgentKey. Blank lines and #
+// comments are skipped; a missing key comes back empty.
+func ReadTokenFile(path string) (client, agent string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return "", "", fmt.Errorf("%s:%d: expected KEY=value", path, line)
+		}
+		switch strings.TrimSpace(key) {
+		case TokenFileClientKey:
+			client = strings.TrimSpace(value)
+		case TokenFileAgentKey:
+			agent = strings.TrimSpace(value)
+		default:
+			return "", "", fmt.Errorf("%s:%d: unknown key %q", path, line, key)
+		}
+	}
+	return client, agent, scanner.Err()
+}
+
+// WatchTokenFile calls reload with the file's tokens each time it changes,
+// until stop is called. The directory is watched rather than the file, so
+// editors and secret mounts that replace the file by rename are seen too.
+// Changes that leave the file unreadable are logged and skipped.
+func WatchTokenFile(path string, reload func(client, agent string)) (stop func(), err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				client, agent, err := ReadTokenFile(path)
+				if err != nil {
+					// Mid-replace the file can be briefly missing; the
+					// create that follows triggers another read
+					if !os.IsNotExist(err) {
+						log.Printf("Token file reload failed: %v", err)
+					}
+					continue
+				}
+				reload(client, agent)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Token file watch error: %v", err)
+			}
+		}
+	}()
+
+	return func() { watcher.Close() }, nil
+}
@@ -0,0 +1,191 @@
+// Package metrics provides lightweight in-process counters, gauges and
+// histograms exposed as JSON
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set replaces the gauge value
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Add adjusts the gauge by n (which may be negative)
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+// SetMax raises the gauge to n if n is larger, for high-water marks
+func (g *Gauge) SetMax(n int64) {
+	for {
+		cur := g.v.Load()
+		if n <= cur || g.v.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// Histogram counts observations into buckets by upper bound, for
+// percentile estimates
+type Histogram struct {
+	bounds []int64        // Ascending bucket upper bounds, inclusive
+	counts []atomic.Int64 // One per bound, plus one for larger values
+	count  atomic.Int64
+	sum    atomic.Int64
+	max    Gauge
+}
+
+// Observe records v
+func (h *Histogram) Observe(v int64) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return v <= h.bounds[i] })
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(v)
+	h.max.SetMax(v)
+}
+
+// Quantile estimates the q quantile (0 < q <= 1) as the upper bound of the
+// bucket holding it, or the largest observation past the last bound. It is
+// 0 with no observations.
+func (h *Histogram) Quantile(q float64) int64 {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, bound := range h.bounds {
+		seen += h.counts[i].Load()
+		if seen >= rank {
+			return bound
+		}
+	}
+	return h.max.Value()
+}
+
+// snapshot adds the histogram's count, sum, max, p50/p90/p99 and
+// cumulative buckets to snap under name
+func (h *Histogram) snapshot(name string, snap map[string]int64) {
+	snap[name+"_count"] = h.count.Load()
+	snap[name+"_sum"] = h.sum.Load()
+	snap[name+"_max"] = h.max.Value()
+	snap[name+"_p50"] = h.Quantile(0.5)
+	snap[name+"_p90"] = h.Quantile(0.9)
+	snap[name+"_p99"] = h.Quantile(0.99)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		snap[name+"_le_"+strconv.FormatInt(bound, 10)] = cumulative
+	}
+}
+
+var (
+	counters   = make(map[string]*Counter)
+	gauges     = make(map[string]*Gauge)
+	histograms = make(map[string]*Histogram)
+	mu         sync.Mutex
+)
+
+// NewCounter returns the counter registered under name, creating it if needed
+func NewCounter(name string) *Counter {
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := counters[name]; ok {
+		return c
+	}
+	c := &Counter{}
+	counters[name] = c
+	return c
+}
+
+// NewGauge returns the gauge registered under name, creating it if needed
+func NewGauge(name string) *Gauge {
+	mu.Lock()
+	defer mu.Unlock()
+	if g, ok := gauges[name]; ok {
+		return g
+	}
+	g := &Gauge{}
+	gauges[name] = g
+	return g
+}
+
+// NewHistogram returns the histogram registered under name, creating it
+// with the given ascending bucket bounds if needed
+func NewHistogram(name string, bounds []int64) *Histogram {
+	mu.Lock()
+	defer mu.Unlock()
+	if h, ok := histograms[name]; ok {
+		return h
+	}
+	h := &Histogram{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+	histograms[name] = h
+	return h
+}
+
+// Snapshot returns the current value of every registered metric. A
+// histogram appears as several values: see Histogram.snapshot.
+func Snapshot() map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	snap := make(map[string]int64, len(counters)+len(gauges))
+	for name, c := range counters {
+		snap[name] = c.Value()
+	}
+	for name, g := range gauges {
+		snap[name] = g.Value()
+	}
+	for name, h := range histograms {
+		h.snapshot(name, snap)
+	}
+	return snap
+}
+
+// Handler serves all registered metrics as a JSON object
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Snapshot())
+	})
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+// ErrBackendUnavailable is returned without contacting OpenCode while the
+// circuit breaker is open
+var ErrBackendUnavailable = errors.New("backend unavailable")
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// Breaker states, as reported by the proxy_breaker_state gauge
+const (
+	breakerClosed   = 0
+	breakerOpen     = 1
+	breakerHalfOpen = 2
+)
+
+var (
+	breakerState    = metrics.NewGauge("proxy_breaker_state")
+	breakerTrips    = metrics.NewCounter("proxy_breaker_trips_total")
+	breakerRejected = metrics.NewCounter("proxy_breaker_rejected_total")
+)
+
+// breaker fast-fails calls after threshold consecutive failures. Once
+// cooldown has passed it lets a single probe through: success closes it,
+// failure reopens it for another cooldown.
+type breaker struct {
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may proceed
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			breakerRejected.Inc()
+			return ErrBackendUnavailable
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			breakerRejected.Inc()
+			return ErrBackendUnavailable
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record reports a call's outcome
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if b.state != breakerClosed {
+			log.Printf("OpenCode backend recovered, closing circuit breaker")
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		if b.state != breakerOpen {
+			breakerTrips.Inc()
+			log.Printf("OpenCode backend failing (%d consecutive errors), opening circuit breaker for %v", b.failures, b.cooldown)
+		}
+		b.setState(breakerOpen)
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends a call whose outcome says nothing about the backend, such as
+// one canceled by the caller
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	breakerState.Set(int64(state))
+}
+
+// do sends req through the breaker. Transport errors and 5xx responses
+// count as failures; other statuses show the backend is up.
+func (p *OpenCodeProxy) do(req *http.Request) (*http.Response, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		p.breaker.release()
+	case err != nil:
+		p.breaker.record(false)
+	default:
+		p.breaker.record(resp.StatusCode < http.StatusInternalServerError)
+	}
+	return resp, err
+}
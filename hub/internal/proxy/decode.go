@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxSnippet bounds how much of an unexpected body is quoted in errors
+const maxSnippet = 200
+
+// decodeJSON reads an OpenCode response into v. A body that isn't JSON,
+// such as an HTML error page from a proxy in front of OpenCode, or a
+// non-2xx status is reported with the start of the body rather than as a
+// decode error.
+func decodeJSON(resp *http.Response, v any) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := nonJSON(resp, body); err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, snippet(body))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid backend response (status %d): %w", resp.StatusCode, err)
+	}
+	return nil
+}
+
+// nonJSON returns an error quoting body if resp is declared as something
+// other than JSON or its body doesn't start like a JSON object or array
+func nonJSON(resp *http.Response, body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	if jsonContentType(resp.Header.Get("Content-Type")) && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return nil
+	}
+	return fmt.Errorf("backend returned non-JSON (status %d): %s", resp.StatusCode, snippet(trimmed))
+}
+
+// jsonContentType reports whether a Content-Type allows JSON; a missing one
+// does, since the body is checked as well
+func jsonContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.Contains(mediaType, "json")
+}
+
+// snippet returns the start of body on one line, for error messages
+func snippet(body []byte) string {
+	if len(body) > 2*maxSnippet {
+		body = body[:2*maxSnippet]
+	}
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if s == "" {
+		return "(empty body)"
+	}
+	if len(s) > maxSnippet {
+		s = strings.ToValidUTF8(s[:maxSnippet], "") + "..."
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerTransport adds configured headers to every OpenCode request, for
+// deployments fronting OpenCode with a gateway. "{session}" in a value is
+// replaced with the session ID from the request path, or "" if none.
+type headerTransport struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	session := sessionFromPath(req.URL.Path)
+	for name, value := range t.headers {
+		req.Header.Set(name, strings.ReplaceAll(value, "{session}", session))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// SetHeaders adds headers to every request sent to OpenCode, expanding
+// "{session}" in values. Must be called before the proxy is used.
+func (p *OpenCodeProxy) SetHeaders(headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	p.httpClient.Transport = &headerTransport{headers: headers, next: http.DefaultTransport}
+}
+
+// sessionFromPath returns the ID in an OpenCode "/session/{id}/..." path
+func sessionFromPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/session/")
+	if !ok {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
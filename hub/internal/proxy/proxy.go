@@ -16,6 +16,8 @@ import (
 type OpenCodeProxy struct {
 	baseURL    string
 	httpClient *http.Client
+	breaker    *breaker
+	system     string // System preamble sent with every prompt
 }
 
 // NewOpenCodeProxy creates a new OpenCode proxy
@@ -25,13 +27,36 @@ func NewOpenCodeProxy(baseURL string) *OpenCodeProxy {
 		httpClient: &http.Client{
 			Timeout: 0, // No timeout for streaming
 		},
+		breaker: newBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
 	}
 }
 
+// SetBreaker configures the circuit breaker: after threshold consecutive
+// failures, calls fail fast for cooldown. A threshold of 0 disables it.
+// Must be called before the proxy is used.
+func (p *OpenCodeProxy) SetBreaker(threshold int, cooldown time.Duration) {
+	p.breaker = newBreaker(threshold, cooldown)
+}
+
+// SetSystemPreamble sets a system instruction sent with every prompt.
+// Must be called before the proxy is used.
+func (p *OpenCodeProxy) SetSystemPreamble(text string) {
+	p.system = text
+}
+
 // SessionInfo represents a session
 type SessionInfo struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
+	ID    string       `json:"id"`
+	Title string       `json:"title"`
+	Time  *SessionTime `json:"time,omitempty"`
+	// LastActivity is filled in by the hub from Time and buffered messages
+	LastActivity int64 `json:"lastActivity,omitempty"`
+}
+
+// SessionTime holds OpenCode's session timestamps in Unix milliseconds
+type SessionTime struct {
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
 }
 
 // Message represents a chat message
@@ -50,6 +75,9 @@ type CreateSessionRequest struct {
 type PromptRequest struct {
 	Parts []PromptPart `json:"parts"`
 	Model *ModelInfo   `json:"model,omitempty"`
+	// System is a system instruction for this prompt. OpenCode doesn't keep
+	// it in the session, so it is sent with every prompt.
+	System string `json:"system,omitempty"`
 }
 
 // PromptPart represents a part of the prompt
@@ -74,7 +102,7 @@ func (p *OpenCodeProxy) Health(ctx context.Context) error {
 		return err
 	}
 
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("opencode unreachable: %w", err)
 	}
@@ -93,14 +121,14 @@ func (p *OpenCodeProxy) ListSessions(ctx context.Context) ([]SessionInfo, error)
 		return nil, err
 	}
 
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var sessions []SessionInfo
-	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+	if err := decodeJSON(resp, &sessions); err != nil {
 		return nil, err
 	}
 	return sessions, nil
@@ -115,34 +143,107 @@ func (p *OpenCodeProxy) CreateSession(ctx context.Context, title string) (*Sessi
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var session SessionInfo
-	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+	if err := decodeJSON(resp, &session); err != nil {
 		return nil, err
 	}
 	return &session, nil
 }
 
+// RenameSession sets a session's title
+func (p *OpenCodeProxy) RenameSession(ctx context.Context, sessionID, title string) error {
+	body, _ := json.Marshal(map[string]string{"title": title})
+	url := fmt.Sprintf("%s/session/%s", p.baseURL, sessionID)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// DeleteSession permanently deletes a session
+func (p *OpenCodeProxy) DeleteSession(ctx context.Context, sessionID string) error {
+	url := fmt.Sprintf("%s/session/%s", p.baseURL, sessionID)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// ListProviders returns OpenCode's configured providers and their models
+func (p *OpenCodeProxy) ListProviders(ctx context.Context) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/config/providers", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := nonJSON(resp, body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, snippet(body))
+	}
+	return body, nil
+}
+
 // OpenCodeResponse represents the full response from OpenCode
 type OpenCodeResponse struct {
 	Info  json.RawMessage `json:"info"`
 	Parts []struct {
+		ID   string `json:"id,omitempty"`
 		Type string `json:"type"`
 		Text string `json:"text,omitempty"`
 	} `json:"parts"`
 }
 
-// SendMessage sends a message and streams the response
+// SendMessage sends a message and streams the response: "message" events
+// with each text part, then a "usage" event when OpenCode reports token counts
 func (p *OpenCodeProxy) SendMessage(ctx context.Context, sessionID string, content string, callback StreamCallback) error {
 	promptReq := PromptRequest{
 		Parts: []PromptPart{
 			{Type: "text", Text: content},
 		},
+		System: p.system,
 	}
 
 	body, _ := json.Marshal(promptReq)
@@ -155,30 +256,29 @@ func (p *OpenCodeProxy) SendMessage(ctx context.Context, sessionID string, conte
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, string(bodyBytes))
-	}
-
 	var ocResp OpenCodeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ocResp); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
+	if err := decodeJSON(resp, &ocResp); err != nil {
+		return err
 	}
 
 	for _, part := range ocResp.Parts {
 		if part.Type == "text" && part.Text != "" {
-			textPayload, _ := json.Marshal(map[string]string{"text": part.Text})
+			// partId lets clients replace a revised part instead of appending it
+			textPayload, _ := json.Marshal(map[string]string{"text": part.Text, "partId": part.ID})
 			if err := callback("message", textPayload); err != nil {
 				return err
 			}
 		}
 	}
+	if usage, ok := usagePayload(ocResp.Info); ok {
+		return callback("usage", usage)
+	}
 
 	return nil
 }
@@ -192,11 +292,8 @@ func (p *OpenCodeProxy) SubscribeEvents(ctx context.Context, callback StreamCall
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
 
-	client := &http.Client{
-		Timeout: 0, // No timeout for SSE
-	}
-
-	resp, err := client.Do(req)
+	// httpClient has no timeout either, and carries any configured headers
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -245,6 +342,34 @@ func (p *OpenCodeProxy) SubscribeEvents(ctx context.Context, callback StreamCall
 	}
 }
 
+// GetMessageHistory returns a session's full message history as OpenCode
+// sends it ({info, parts} per message)
+func (p *OpenCodeProxy) GetMessageHistory(ctx context.Context, sessionID string) (json.RawMessage, error) {
+	url := fmt.Sprintf("%s/session/%s/message", p.baseURL, sessionID)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := nonJSON(resp, body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opencode error: status %d, body: %s", resp.StatusCode, snippet(body))
+	}
+	return body, nil
+}
+
 // GetMessages retrieves message history for a session
 func (p *OpenCodeProxy) GetMessages(ctx context.Context, sessionID string) ([]Message, error) {
 	url := fmt.Sprintf("%s/session/%s/message", p.baseURL, sessionID)
@@ -260,7 +385,7 @@ func (p *OpenCodeProxy) GetMessages(ctx context.Context, sessionID string) ([]Me
 	defer resp.Body.Close()
 
 	var messages []Message
-	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+	if err := decodeJSON(resp, &messages); err != nil {
 		return nil, err
 	}
 	return messages, nil
@@ -0,0 +1,39 @@
+package proxy
+
+import "encoding/json"
+
+// messageInfo is the part of OpenCode's message info that reports usage
+type messageInfo struct {
+	Cost   float64 `json:"cost"`
+	Tokens *struct {
+		Input     int64 `json:"input"`
+		Output    int64 `json:"output"`
+		Reasoning int64 `json:"reasoning"`
+		Cache     struct {
+			Read  int64 `json:"read"`
+			Write int64 `json:"write"`
+		} `json:"cache"`
+	} `json:"tokens"`
+	ModelID    string `json:"modelID"`
+	ProviderID string `json:"providerID"`
+}
+
+// usagePayload returns the stream.usage payload for a reply's info, ok false
+// when OpenCode reported no token counts
+func usagePayload(info json.RawMessage) ([]byte, bool) {
+	var mi messageInfo
+	if len(info) == 0 || json.Unmarshal(info, &mi) != nil || mi.Tokens == nil {
+		return nil, false
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"cost":       mi.Cost,
+		"input":      mi.Tokens.Input,
+		"output":     mi.Tokens.Output,
+		"reasoning":  mi.Tokens.Reasoning,
+		"cacheRead":  mi.Tokens.Cache.Read,
+		"cacheWrite": mi.Tokens.Cache.Write,
+		"modelId":    mi.ModelID,
+		"providerId": mi.ProviderID,
+	})
+	return payload, true
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// Admin feed message types, sent on /admin only
+const (
+	AdminMsgSnapshot = "admin.snapshot" // Sent once on connect: AdminSnapshotPayload
+	AdminMsgAgent    = "admin.agent"    // An agent connected or disconnected: AdminAgentPayload
+	AdminMsgProject  = "admin.project"  // An instance changed status: AdminProjectPayload
+)
+
+// adminSendQueue is the outbound buffer per admin connection. A feed that
+// falls this far behind is disconnected rather than stalled.
+const adminSendQueue = 256
+
+// AdminAgent is one agent in an admin snapshot. Projects is the agent's
+// project.list response, omitted if the agent didn't answer in time.
+type AdminAgent struct {
+	tunnel.AgentInfo
+	Projects json.RawMessage `json:"projects,omitempty"`
+}
+
+// AdminSnapshotPayload is the state of every agent when the feed starts
+type AdminSnapshotPayload struct {
+	Agents []AdminAgent `json:"agents"`
+	Time   int64        `json:"time"` // Unix milliseconds
+}
+
+// AdminAgentPayload reports an agent connecting or disconnecting
+type AdminAgentPayload struct {
+	AgentID string `json:"agentId"`
+	Group   string `json:"group,omitempty"`
+	Online  bool   `json:"online"`
+	Time    int64  `json:"time"`
+}
+
+// AdminProjectPayload reports an instance status change on any agent
+type AdminProjectPayload struct {
+	AgentID string          `json:"agentId"`
+	Group   string          `json:"group,omitempty"`
+	Project json.RawMessage `json:"project"`
+	Time    int64           `json:"time"`
+}
+
+// adminConn is a connection to the admin feed. It shares nothing with
+// Client: admins can't send actions, only receive the feed.
+type adminConn struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	closeOnce sync.Once
+}
+
+// close stops the connection's write pump
+func (a *adminConn) close() {
+	a.closeOnce.Do(func() { close(a.send) })
+}
+
+// HandleAdminWebSocket serves the admin feed: a snapshot of every agent and
+// its instances, then agent presence and instance status changes across all
+// groups. Callers must wrap it in admin token auth.
+func (s *Server) HandleAdminWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Admin WebSocket upgrade error: %v", err)
+		return
+	}
+
+	admin := &adminConn{conn: conn, send: make(chan []byte, adminSendQueue)}
+	s.adminMu.Lock()
+	s.admins[admin] = true
+	s.adminMu.Unlock()
+	log.Printf("Admin feed connected: %s", conn.RemoteAddr())
+
+	go admin.writePump()
+	go s.adminReadPump(admin)
+
+	// Registered before the snapshot is taken, so no change is missed; one
+	// arriving ahead of the snapshot is already reflected in it
+	s.sendAdmin(admin, ServerMessage{Type: AdminMsgSnapshot, Payload: s.adminSnapshot(r.Context())})
+}
+
+// adminSnapshot collects every connected agent and its projects
+func (s *Server) adminSnapshot(ctx context.Context) AdminSnapshotPayload {
+	infos := s.tunnelMgr.ListAgentInfo()
+	agents := make([]AdminAgent, len(infos))
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		agents[i].AgentInfo = info
+		wg.Add(1)
+		go func(agent *AdminAgent) {
+			defer wg.Done()
+			if projects, err := s.fetchProjectList(ctx, agent.ID); err == nil {
+				agent.Projects = projects
+			}
+		}(&agents[i])
+	}
+	wg.Wait()
+	return AdminSnapshotPayload{Agents: agents, Time: time.Now().UnixMilli()}
+}
+
+// adminReadPump discards anything admins send and unregisters the
+// connection once it closes
+func (s *Server) adminReadPump(admin *adminConn) {
+	defer func() {
+		s.adminMu.Lock()
+		delete(s.admins, admin)
+		s.adminMu.Unlock()
+		admin.close()
+		log.Printf("Admin feed disconnected: %s", admin.conn.RemoteAddr())
+	}()
+
+	admin.conn.SetReadLimit(maxMessageSize)
+	admin.conn.SetReadDeadline(time.Now().Add(pongWait))
+	admin.conn.SetPongHandler(func(string) error {
+		admin.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := admin.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *adminConn) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		a.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-a.send:
+			a.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				a.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := a.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			a.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := a.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendAdmin queues msg for one admin, disconnecting an admin too slow to
+// keep up
+func (s *Server) sendAdmin(admin *adminConn, msg ServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal admin message: %v", err)
+		return
+	}
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	if !s.admins[admin] {
+		return
+	}
+	select {
+	case admin.send <- data:
+	default:
+		log.Printf("Admin feed %s fell behind, disconnecting", admin.conn.RemoteAddr())
+		delete(s.admins, admin)
+		admin.close()
+	}
+}
+
+// publishAdmin sends msg to every admin feed
+func (s *Server) publishAdmin(msg ServerMessage) {
+	s.adminMu.Lock()
+	admins := make([]*adminConn, 0, len(s.admins))
+	for admin := range s.admins {
+		admins = append(admins, admin)
+	}
+	s.adminMu.Unlock()
+
+	for _, admin := range admins {
+		s.sendAdmin(admin, msg)
+	}
+}
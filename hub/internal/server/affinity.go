@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/openvibe/hub/internal/metrics"
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+var sessionFailovers = metrics.NewCounter("server_session_failovers_total")
+
+var (
+	errSessionAgentOffline = errors.New("session's agent is offline")
+	errSessionNotFound     = errors.New("session not found")
+)
+
+// agentOfflineError reports that a session's bound agent is not connected.
+// It matches errSessionAgentOffline.
+type agentOfflineError struct {
+	agentID string
+}
+
+func (e *agentOfflineError) Error() string {
+	return errSessionAgentOffline.Error() + ": " + e.agentID
+}
+
+func (e *agentOfflineError) Unwrap() error {
+	return errSessionAgentOffline
+}
+
+// bindSession records that sessionID lives on agentID
+func (s *Server) bindSession(sessionID, agentID string) {
+	if sessionID == "" || agentID == "" {
+		return
+	}
+	s.affinityMu.Lock()
+	s.sessionAgents[sessionID] = agentID
+	if agent, ok := s.tunnelMgr.GetAgent(agentID); ok {
+		s.sessionGroups[sessionID] = agent.Group
+	}
+	s.affinityMu.Unlock()
+}
+
+// unbindSession forgets the agent binding for sessionID
+func (s *Server) unbindSession(sessionID string) {
+	s.affinityMu.Lock()
+	delete(s.sessionAgents, sessionID)
+	delete(s.sessionGroups, sessionID)
+	delete(s.sessionPaths, sessionID)
+	s.affinityMu.Unlock()
+}
+
+// forgetSession drops everything the hub keeps about a deleted session: its
+// bindings, and its buffered messages, metadata and usage total
+func (s *Server) forgetSession(sessionID string) {
+	s.unbindSession(sessionID)
+	if s.purger == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ActionTimeout)
+	defer cancel()
+	if err := s.purger.PurgeSession(ctx, sessionID); err != nil {
+		log.Printf("Failed to purge buffered data of session %s: %v", sessionID, err)
+	}
+}
+
+// bindProject records the agent project sessionID was created in
+func (s *Server) bindProject(sessionID, projectPath string) {
+	if sessionID == "" || projectPath == "" {
+		return
+	}
+	s.affinityMu.Lock()
+	s.sessionPaths[sessionID] = projectPath
+	s.affinityMu.Unlock()
+}
+
+// sessionTarget returns tgt, or the project sessionID was created in when
+// tgt doesn't name an OpenCode instance itself
+func (s *Server) sessionTarget(sessionID string, tgt target) target {
+	if tgt.ProjectPath != "" || tgt.BaseURL != "" {
+		return tgt
+	}
+	s.affinityMu.RLock()
+	defer s.affinityMu.RUnlock()
+	tgt.ProjectPath = s.sessionPaths[sessionID]
+	return tgt
+}
+
+// boundAgent returns the agent ID sessionID is bound to, if any
+func (s *Server) boundAgent(sessionID string) (string, bool) {
+	s.affinityMu.RLock()
+	defer s.affinityMu.RUnlock()
+	agentID, ok := s.sessionAgents[sessionID]
+	return agentID, ok
+}
+
+// offlineAgent returns the agent sessionID is bound to when that agent is
+// not connected, so a resuming client can be told to bring it back
+func (s *Server) offlineAgent(sessionID string) (string, bool) {
+	agentID, bound := s.boundAgent(sessionID)
+	if !bound {
+		return "", false
+	}
+	if _, ok := s.tunnelMgr.GetAgent(agentID); ok {
+		return "", false
+	}
+	// The next request will move the session instead
+	s.affinityMu.RLock()
+	group := s.sessionGroups[sessionID]
+	s.affinityMu.RUnlock()
+	if _, ok := s.failoverAgent(group, sessionID); ok {
+		return "", false
+	}
+	return agentID, true
+}
+
+// sessionInGroup reports whether group may use sessionID. Sessions are only
+// known to belong to a group once bound; unbound sessions are allowed.
+func (s *Server) sessionInGroup(group, sessionID string) bool {
+	s.affinityMu.RLock()
+	defer s.affinityMu.RUnlock()
+	owner, ok := s.sessionGroups[sessionID]
+	return !ok || owner == group
+}
+
+// agentForSession returns the agent of group that should serve sessionID.
+// Bound sessions always go to their agent; unbound sessions fall back to any
+// connected agent of the group. ok is false when no agent can serve the
+// request, and err is set when the session's agent is no longer connected or
+// belongs to another group. With config.AgentFailover, a session whose agent
+// is gone moves to another agent serving its project.
+func (s *Server) agentForSession(group, sessionID string) (*tunnel.Agent, bool, error) {
+	if sessionID != "" {
+		if !s.sessionInGroup(group, sessionID) {
+			return nil, false, errSessionNotFound
+		}
+		if agentID, bound := s.boundAgent(sessionID); bound {
+			if agent, ok := s.tunnelMgr.GetGroupAgent(group, agentID); ok {
+				return agent, true, nil
+			}
+			if agent, ok := s.failoverAgent(group, sessionID); ok {
+				log.Printf("Session %s moved from offline agent %s to %s", sessionID, agentID, agent.ID)
+				sessionFailovers.Inc()
+				s.bindSession(sessionID, agent.ID)
+				return agent, true, nil
+			}
+			return nil, false, &agentOfflineError{agentID}
+		}
+	}
+
+	agent, ok := s.tunnelMgr.GetAnyAgent(group)
+	return agent, ok, nil
+}
+
+// failoverAgent returns a connected agent of group serving the project
+// sessionID was created in, when config.AgentFailover allows moving it
+func (s *Server) failoverAgent(group, sessionID string) (*tunnel.Agent, bool) {
+	if !s.config.AgentFailover {
+		return nil, false
+	}
+	s.affinityMu.RLock()
+	projectPath := s.sessionPaths[sessionID]
+	s.affinityMu.RUnlock()
+	if projectPath == "" {
+		return nil, false
+	}
+	return s.tunnelMgr.GetAgentForProject(group, projectPath)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openvibe/hub/internal/buffer"
+	"github.com/openvibe/hub/internal/config"
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// recordingPurger is a buffer.Purger noting the sessions it was asked to purge
+type recordingPurger []string
+
+func (p *recordingPurger) PurgeSession(ctx context.Context, sessionID string) error {
+	*p = append(*p, sessionID)
+	return nil
+}
+
+func TestForgetSession(t *testing.T) {
+	purged := &recordingPurger{}
+	s := &Server{
+		config:        &config.Config{ActionTimeout: time.Second},
+		purger:        purged,
+		sessionAgents: map[string]string{"ses_a": "agent-1", "ses_b": "agent-1"},
+		sessionGroups: map[string]string{"ses_a": "team-a", "ses_b": "team-a"},
+		sessionPaths:  map[string]string{"ses_a": "/work/app"},
+	}
+
+	s.forgetSession("ses_a")
+
+	if _, ok := s.sessionAgents["ses_a"]; ok {
+		t.Error("ses_a still bound to its agent")
+	}
+	if _, ok := s.sessionPaths["ses_a"]; ok {
+		t.Error("ses_a still bound to its project")
+	}
+	if s.sessionGroups["ses_b"] != "team-a" {
+		t.Error("ses_b unbound along with ses_a")
+	}
+	if len(*purged) != 1 || (*purged)[0] != "ses_a" {
+		t.Errorf("purged %v, want [ses_a]", *purged)
+	}
+
+	// Without a buffer keeping session data there's nothing to purge
+	s.purger = nil
+	s.forgetSession("ses_b")
+}
+
+// affinityServer returns a server routing through mgr, and a client of the
+// default group on it
+func affinityServer(t *testing.T, mgr *tunnel.Manager) (*Server, *Client) {
+	t.Helper()
+	s := &Server{
+		config:        &config.Config{ActionTimeout: 2 * time.Second, StreamIdleTimeout: time.Minute},
+		buffer:        &buffer.NoopBuffer{},
+		tunnelMgr:     mgr,
+		sessionAgents: make(map[string]string),
+		sessionGroups: make(map[string]string),
+		sessionPaths:  make(map[string]string),
+		untitled:      make(map[string]bool),
+	}
+	conn, _ := wsPair(t)
+	return s, &Client{server: s, conn: conn, send: make(chan []byte, 64), prompts: make(map[string]context.CancelFunc)}
+}
+
+// nextRequest returns the next request forwarded to an agent
+func nextRequest(t *testing.T, requests <-chan tunnel.Message, agentID string) tunnel.Message {
+	t.Helper()
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no request reached %s", agentID)
+		return tunnel.Message{}
+	}
+}
+
+// noRequest fails if a request reaches an agent that shouldn't serve it
+func noRequest(t *testing.T, requests <-chan tunnel.Message, agentID string) {
+	t.Helper()
+	select {
+	case req := <-requests:
+		var payload tunnel.RequestPayload
+		json.Unmarshal(req.Payload, &payload)
+		t.Errorf("%s got %s for session %s", agentID, payload.Action, payload.SessionID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// createOn creates a session through c while agent-a is the only agent
+// connected, answering as agent-a with sessionID
+func createOn(t *testing.T, c *Client, conn *websocket.Conn, requests <-chan tunnel.Message, sessionID string) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.handleSessionCreate("req-create", SessionPayload{Title: "work"})
+	}()
+	req := nextRequest(t, requests, "agent-a")
+	conn.WriteJSON(tunnel.Message{
+		Type:    tunnel.MsgTypeResponse,
+		ID:      req.ID,
+		Payload: tunnel.MustMarshal(map[string]string{"id": sessionID}),
+	})
+	<-done
+	if msg := nextMessage(t, c); msg.Type != "response" {
+		t.Fatalf("session.create: got %s %v", msg.Type, msg.Payload)
+	}
+}
+
+func TestPromptRoutesToCreatingAgent(t *testing.T) {
+	mgr := tunnel.NewManager(&tunnel.Config{})
+	connA, requestsA := connectFakeAgent(t, mgr, "agent-a")
+	s, c := affinityServer(t, mgr)
+	createOn(t, c, connA, requestsA, "ses_new")
+
+	// B may well be the one GetAnyAgent picks now
+	_, requestsB := connectFakeAgent(t, mgr, "agent-b")
+	for i := 0; i < 5; i++ {
+		requestID := fmt.Sprintf("req-prompt-%d", i)
+		c.handlePrompt(requestID, PromptPayload{SessionID: "ses_new", Content: "hello"})
+		req := nextRequest(t, requestsA, "agent-a")
+		var payload tunnel.RequestPayload
+		json.Unmarshal(req.Payload, &payload)
+		if payload.Action != "prompt" || payload.SessionID != "ses_new" {
+			t.Errorf("agent-a got %s for %s, want the prompt", payload.Action, payload.SessionID)
+		}
+		c.handlePromptCancel("req-cancel", requestID)
+		nextMessage(t, c)
+	}
+	noRequest(t, requestsB, "agent-b")
+
+	if agentID, _ := s.boundAgent("ses_new"); agentID != "agent-a" {
+		t.Errorf("ses_new bound to %q, want agent-a", agentID)
+	}
+}
+
+func TestPromptSessionAgentOffline(t *testing.T) {
+	mgr := tunnel.NewManager(&tunnel.Config{})
+	connA, requestsA := connectFakeAgent(t, mgr, "agent-a")
+	_, c := affinityServer(t, mgr)
+	createOn(t, c, connA, requestsA, "ses_new")
+
+	_, requestsB := connectFakeAgent(t, mgr, "agent-b")
+	connA.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := mgr.GetAgent("agent-a"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("agent-a still registered after disconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.handlePrompt("req-prompt", PromptPayload{SessionID: "ses_new", Content: "hello"})
+	msg := nextMessage(t, c)
+	var reply ErrorPayload
+	decodeInto(t, msg.Payload, &reply)
+	if msg.Type != "error" || reply.Code != CodeSessionAgentOffline || reply.AgentID != "agent-a" {
+		t.Errorf("prompt to offline agent: got %s %+v, want %s for agent-a", msg.Type, reply, CodeSessionAgentOffline)
+	}
+	// The session stays on its agent rather than silently moving to B
+	noRequest(t, requestsB, "agent-b")
+}
+
+func TestAgentForSessionOtherGroup(t *testing.T) {
+	mgr := tunnel.NewManager(&tunnel.Config{})
+	connectFakeAgent(t, mgr, "agent-a") // Joins the default group
+	s, _ := affinityServer(t, mgr)
+	s.bindSession("ses_a", "agent-a")
+
+	if agent, ok, err := s.agentForSession("", "ses_a"); err != nil || !ok || agent.ID != "agent-a" {
+		t.Errorf("own group: %v %v %v", agent, ok, err)
+	}
+	if _, ok, err := s.agentForSession("team-b", "ses_a"); ok || !errors.Is(err, errSessionNotFound) {
+		t.Errorf("team-b reached ses_a: %v %v", ok, err)
+	}
+	if agent, ok, _ := s.agentForSession("team-b", ""); ok {
+		t.Errorf("team-b got %s for a new session", agent.ID)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/openvibe/hub/internal/audit"
+)
+
+// SetAuditLog enables recording prompts, and replies if the logger allows,
+// to l (nil = off)
+func (s *Server) SetAuditLog(l *audit.Logger) {
+	s.audit = l
+}
+
+// auditPrompt records a prompt's content as it is sent
+func (c *Client) auditPrompt(requestID, sessionID, content string) {
+	c.server.audit.Log(audit.Record{
+		Kind:      audit.KindPrompt,
+		RequestID: requestID,
+		SessionID: sessionID,
+		Group:     c.group,
+		Client:    c.conn.RemoteAddr().String(),
+		Content:   content,
+	})
+}
+
+// newReplyRecorder returns a recorder for a prompt's reply, or nil when
+// replies aren't audited
+func (c *Client) newReplyRecorder() *replyRecorder {
+	if !c.server.audit.Responses() {
+		return nil
+	}
+	return &replyRecorder{index: make(map[string]int)}
+}
+
+// auditReply records the reply collected by r once its prompt finishes
+func (c *Client) auditReply(requestID, sessionID string, r *replyRecorder) {
+	if r == nil {
+		return
+	}
+	outcome := "incomplete"
+	if r.ended {
+		outcome = "completed"
+	}
+	c.server.audit.Log(audit.Record{
+		Kind:      audit.KindResponse,
+		RequestID: requestID,
+		SessionID: sessionID,
+		Group:     c.group,
+		Client:    c.conn.RemoteAddr().String(),
+		Content:   r.text(),
+		Outcome:   outcome,
+	})
+}
+
+// replyRecorder rebuilds a reply's text from its stream chunks the way the
+// app does: a chunk naming a partId replaces that part's text, one without
+// appends. A nil recorder records nothing.
+type replyRecorder struct {
+	parts []string
+	index map[string]int // partId -> position in parts
+	ended bool           // stream.end arrived
+}
+
+// chunk adds a stream payload to the reply
+func (r *replyRecorder) chunk(payload []byte) {
+	if r == nil {
+		return
+	}
+	var p struct {
+		Text   string `json:"text"`
+		PartID string `json:"partId"`
+	}
+	if json.Unmarshal(payload, &p) != nil {
+		return
+	}
+	if p.PartID == "" {
+		if len(r.parts) == 0 {
+			r.parts = append(r.parts, "")
+		}
+		r.parts[len(r.parts)-1] += p.Text
+		return
+	}
+	if i, ok := r.index[p.PartID]; ok {
+		r.parts[i] = p.Text
+		return
+	}
+	r.index[p.PartID] = len(r.parts)
+	r.parts = append(r.parts, p.Text)
+}
+
+// end marks the reply complete
+func (r *replyRecorder) end() {
+	if r != nil {
+		r.ended = true
+	}
+}
+
+func (r *replyRecorder) text() string {
+	return strings.Join(r.parts, "\n")
+}
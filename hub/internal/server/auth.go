@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requestToken returns the token from the "token" query parameter or a
+// "Bearer" Authorization header
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// tokenValid reports whether r carries token. An empty token disables auth.
+func tokenValid(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) == 1
+}
+
+// clientGroup returns the group the request's token grants: a group token
+// selects that group, the hub token the default group "". The read-only
+// token also grants the default group, with readOnly set. ok is false when
+// the token matches none of them.
+func (s *Server) clientGroup(r *http.Request) (group string, readOnly, ok bool) {
+	token := requestToken(r)
+	for _, g := range s.config.Groups {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.Token)) == 1 {
+			return g.Name, false, true
+		}
+	}
+	if s.config.ReadOnlyToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.ReadOnlyToken)) == 1 {
+		return "", true, true
+	}
+	return "", false, s.clientTokenValid(r)
+}
+
+// clientTokenValid reports whether r carries the default group's client
+// token, honoring rotation
+func (s *Server) clientTokenValid(r *http.Request) bool {
+	if s.config.LiveToken != nil {
+		return s.config.LiveToken.Valid(requestToken(r))
+	}
+	return tokenValid(r, s.config.Token)
+}
+
+// RequireClientToken wraps next so it answers 401 unless the request
+// carries the client token, honoring rotation
+func (s *Server) RequireClientToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.clientTokenValid(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mutatingActions are the client actions refused in read-only mode
+var mutatingActions = map[string]bool{
+	"prompt":               true,
+	"prompt.cancel":        true,
+	"session.create":       true,
+	"session.rename":       true,
+	"session.delete":       true,
+	"session.restore":      true,
+	"session.setmeta":      true,
+	"session.acquire":      true,
+	"session.release":      true,
+	"project.start":        true,
+	"project.start.cancel": true,
+	"project.stop":         true,
+	"project.pin":          true,
+	"project.unpin":        true,
+	"project.restart-all":  true,
+}
+
+// adminActions are the client actions that affect a whole agent rather than
+// one project, refused to group token clients
+var adminActions = map[string]bool{
+	"project.restart-all": true,
+}
+
+// readOnly reports whether the client may only read
+func (c *Client) readOnly() bool {
+	return c.server.config.ReadOnly || c.readOnlyToken
+}
+
+// admin reports whether the client holds the hub token, which may act on
+// whole agents
+func (c *Client) admin() bool {
+	return c.group == "" && !c.readOnly()
+}
+
+// RequireToken wraps next so it answers 401 unless the request carries token
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokenValid(r, token) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// autoCreateSession creates a session for a prompt that names none, when
+// config.AutoCreateSession allows it, and makes it the client's session.
+// The client learns the ID from a "session.created" message sent under the
+// prompt's request ID ahead of the stream.
+func (c *Client) autoCreateSession(requestID string, payload PromptPayload) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	title := c.server.defaultTitle(time.Now())
+	var session json.RawMessage
+	var sessionID string
+
+	agent, ok, _ := c.server.agentForSession(c.group, "")
+	if ok {
+		data, _ := json.Marshal(map[string]string{"title": title, "directory": payload.ProjectPath})
+		resp, err := c.server.forwardForResponse(ctx, agent.ID, fmt.Sprintf("autosession-%s", requestID), &tunnel.RequestPayload{
+			Action:      "session.create",
+			Data:        data,
+			ProjectPath: payload.ProjectPath,
+			BaseURL:     payload.BaseURL,
+		})
+		if err != nil {
+			return "", err
+		}
+		var created struct {
+			ID    string `json:"id"`
+			Error string `json:"error"`
+		}
+		json.Unmarshal(resp, &created)
+		if created.ID == "" {
+			if created.Error != "" {
+				return "", errors.New(created.Error)
+			}
+			return "", errors.New("agent returned no session")
+		}
+		c.server.bindSession(created.ID, agent.ID)
+		c.server.bindProject(created.ID, payload.ProjectPath)
+		session, sessionID = resp, created.ID
+	} else {
+		if !c.directAvailable(ctx) {
+			return "", errors.New("no agent connected")
+		}
+		created, err := c.server.proxy.CreateSession(ctx, title)
+		if err != nil {
+			return "", err
+		}
+		session, _ = json.Marshal(created)
+		sessionID = created.ID
+	}
+
+	if title == "" {
+		c.server.markUntitled(sessionID)
+	}
+	c.setSession(sessionID)
+	c.sendMessage(ServerMessage{Type: "session.created", ID: requestID, Payload: session})
+	return sessionID, nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Application close codes sent to clients in the WebSocket close frame.
+// 4000-4999 is the private-use range; the last three digits mirror the
+// closest HTTP status so clients can classify them at a glance.
+const (
+	CloseSlowClient     = 4008 // Client could not keep up with outgoing messages
+	CloseServerShutdown = 4503 // Hub is shutting down, reconnect later
+)
+
+// closeWithReason sends a close frame carrying code and reason, then closes the connection
+func (c *Client) closeWithReason(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
+// Shutdown closes every client connection with CloseServerShutdown
+func (s *Server) Shutdown() {
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeWithReason(CloseServerShutdown, "server shutting down")
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// precompressed lists the encodings ServePrecompressed looks for, preferred
+// first, with their file suffixes
+var precompressed = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// compressibleTypes are the Content-Type prefixes Gzip compresses. Images,
+// fonts and archives are already compressed.
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/manifest+json",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// acceptsEncoding reports whether r's Accept-Encoding allows encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(name) != encoding {
+			continue
+		}
+		// A zero quality refuses the encoding
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+		return true
+	}
+	return false
+}
+
+// ServePrecompressed serves path's .br or .gz sibling when one exists and r
+// accepts its encoding, reporting whether it did. Range requests are left
+// to the caller, which serves the uncompressed file.
+func ServePrecompressed(w http.ResponseWriter, r *http.Request, path string) bool {
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	for _, p := range precompressed {
+		if !acceptsEncoding(r, p.encoding) {
+			continue
+		}
+		f, err := os.Open(path + p.suffix)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		ctype := mime.TypeByExtension(filepath.Ext(path))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", p.encoding)
+		addVary(w.Header())
+		http.ServeContent(w, r, path, info.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// Gzip compresses next's responses on the fly for clients accepting gzip,
+// when they are compressible and not already encoded (e.g. by
+// ServePrecompressed). Range requests pass through untouched.
+func Gzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addVary(w.Header())
+		if !acceptsEncoding(r, "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter decides at WriteHeader whether to compress the body
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.Header()
+	if status == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Close flushes the compressed stream, if any
+func (g *gzipResponseWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+	return g.gz.Close()
+}
+
+// addVary marks a response as depending on Accept-Encoding, once
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		if strings.EqualFold(v, "Accept-Encoding") {
+			return
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// compressible reports whether a response of ctype is worth compressing
+func compressible(ctype string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(ctype, prefix) {
+			return true
+		}
+	}
+	return false
+}
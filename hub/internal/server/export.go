@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// exportChunkSize is the most transcript bytes sent per export.chunk
+const exportChunkSize = 64 * 1024
+
+// ExportMessage is one message of an exported transcript
+type ExportMessage struct {
+	ID      string `json:"id"`
+	Role    string `json:"role"`
+	Created int64  `json:"created,omitempty"` // Unix milliseconds
+	Text    string `json:"text"`
+}
+
+// ExportChunkPayload carries part of a transcript; clients concatenate Data
+// in Seq order until the final response arrives
+type ExportChunkPayload struct {
+	Seq  int    `json:"seq"`
+	Data string `json:"data"`
+}
+
+// handleSessionExport sends a session's history as a markdown or JSON
+// transcript. The transcript streams as export.chunk messages followed by a
+// response summarizing the export.
+func (c *Client) handleSessionExport(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.LongActionTimeout)
+	defer cancel()
+
+	sessionID := payload.SessionID
+	if sessionID == "" {
+		sessionID = c.currentSession()
+	}
+	if !sessionIDPattern.MatchString(sessionID) {
+		c.sendError(requestID, "Invalid session ID format")
+		return
+	}
+
+	format := payload.Format
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "json" {
+		c.sendError(requestID, "format must be markdown or json")
+		return
+	}
+
+	history, truncated, err := c.server.messageHistory(ctx, c.group, sessionID, payload.BaseURL)
+	if err != nil {
+		if errors.Is(err, errSessionAgentOffline) {
+			c.sendSessionError(requestID, err)
+			return
+		}
+		c.sendError(requestID, "Failed to export session: "+err.Error())
+		return
+	}
+	messages, err := parseHistory(history)
+	if err != nil {
+		c.sendError(requestID, "Failed to export session: "+err.Error())
+		return
+	}
+
+	var transcript string
+	if format == "json" {
+		data, _ := json.MarshalIndent(messages, "", "  ")
+		transcript = string(data)
+	} else {
+		transcript = renderMarkdown(sessionID, messages)
+	}
+
+	chunks := 0
+	for len(transcript) > 0 {
+		n := chunkBoundary(transcript, exportChunkSize)
+		c.sendMessage(ServerMessage{
+			Type:    "export.chunk",
+			ID:      requestID,
+			Payload: ExportChunkPayload{Seq: chunks, Data: transcript[:n]},
+		})
+		transcript = transcript[n:]
+		chunks++
+	}
+
+	response := map[string]interface{}{
+		"sessionId": sessionID,
+		"format":    format,
+		"messages":  len(messages),
+		"chunks":    chunks,
+	}
+	if truncated {
+		response["truncated"] = true
+	}
+	c.sendMessage(ServerMessage{Type: "response", ID: requestID, Payload: response})
+}
+
+// maxHistoryPages bounds how many pages messageHistory follows back through
+// one history
+const maxHistoryPages = 1000
+
+// historyPage is a session.messages page, sent by agents for a history over
+// their --max-history-bytes
+type historyPage struct {
+	Messages  []json.RawMessage `json:"messages"`
+	Cursor    string            `json:"cursor"`
+	HasMore   bool              `json:"hasMore"`
+	Truncated bool              `json:"truncated"`
+}
+
+// messageHistory fetches a session's full history from its agent in group,
+// or from OpenCode directly when the default group has no agent connected.
+// A history the agent sends in pages is followed back to its first message;
+// truncated reports that some of it could still not be fetched.
+func (s *Server) messageHistory(ctx context.Context, group, sessionID, baseURL string) (history json.RawMessage, truncated bool, err error) {
+	agent, ok, err := s.agentForSession(group, sessionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok && group != "" {
+		return nil, false, errors.New("no agent connected")
+	}
+	if !ok {
+		history, err := s.proxy.GetMessageHistory(ctx, sessionID)
+		return history, false, err
+	}
+
+	tgt := s.sessionTarget(sessionID, target{BaseURL: baseURL})
+	var pages [][]json.RawMessage
+	before := ""
+	for {
+		req := map[string]string{"sessionId": sessionID}
+		if before != "" {
+			req["before"] = before
+		}
+		data, _ := json.Marshal(req)
+		requestID := fmt.Sprintf("export-%s-%d", sessionID, time.Now().UnixNano())
+		resp, err := s.forwardForResponse(ctx, agent.ID, requestID, &tunnel.RequestPayload{
+			SessionID:   sessionID,
+			Action:      "session.messages",
+			Data:        data,
+			ProjectPath: tgt.ProjectPath,
+			BaseURL:     tgt.BaseURL,
+			Priority:    tunnel.PriorityLow,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+
+		var page historyPage
+		if json.Unmarshal(resp, &page) != nil || page.Messages == nil {
+			// The whole history as a plain array, or an error for
+			// parseHistory to report
+			return resp, false, nil
+		}
+		pages = append(pages, page.Messages)
+		if page.Truncated && len(page.Messages) == 0 {
+			// A message too large for the agent's cap was skipped
+			truncated = true
+		}
+		if !page.HasMore || page.Cursor == "" {
+			break
+		}
+		if len(pages) == maxHistoryPages || page.Cursor == before {
+			truncated = true
+			break
+		}
+		before = page.Cursor
+	}
+
+	// Pages arrive newest first
+	var all []json.RawMessage
+	for i := len(pages) - 1; i >= 0; i-- {
+		all = append(all, pages[i]...)
+	}
+	if all == nil {
+		all = []json.RawMessage{}
+	}
+	history, err = json.Marshal(all)
+	return history, truncated, err
+}
+
+// parseHistory converts OpenCode's {info, parts} messages to transcript
+// messages, keeping only text parts
+func parseHistory(history json.RawMessage) ([]ExportMessage, error) {
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(history, &failure) == nil && failure.Error != "" {
+		return nil, errors.New(failure.Error)
+	}
+	var raw []struct {
+		Info struct {
+			ID   string `json:"id"`
+			Role string `json:"role"`
+			Time struct {
+				Created int64 `json:"created"`
+			} `json:"time"`
+		} `json:"info"`
+		Parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(history, &raw); err != nil {
+		return nil, fmt.Errorf("invalid message history: %w", err)
+	}
+
+	messages := make([]ExportMessage, 0, len(raw))
+	for _, m := range raw {
+		var texts []string
+		for _, part := range m.Parts {
+			if part.Type == "text" && part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		messages = append(messages, ExportMessage{
+			ID:      m.Info.ID,
+			Role:    m.Info.Role,
+			Created: m.Info.Time.Created,
+			Text:    strings.Join(texts, "\n\n"),
+		})
+	}
+	return messages, nil
+}
+
+// renderMarkdown renders a transcript with a header per message. Text is
+// copied verbatim; a code fence left open by a message is closed so it
+// can't swallow the rest of the transcript.
+func renderMarkdown(sessionID string, messages []ExportMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s\n", sessionID)
+	for _, m := range messages {
+		role := "Assistant"
+		if m.Role == "user" {
+			role = "User"
+		}
+		b.WriteString("\n## " + role)
+		if m.Created > 0 {
+			b.WriteString(" · " + time.UnixMilli(m.Created).UTC().Format(time.RFC3339))
+		}
+		b.WriteString("\n\n")
+		b.WriteString(strings.TrimRight(m.Text, "\n"))
+		b.WriteString("\n")
+		if openFence(m.Text) {
+			b.WriteString("```\n")
+		}
+	}
+	return b.String()
+}
+
+// openFence reports whether text leaves a ``` code fence open
+func openFence(text string) bool {
+	open := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			open = !open
+		}
+	}
+	return open
+}
+
+// chunkBoundary returns how many bytes of s to send in one chunk: at most
+// limit, without splitting a UTF-8 sequence
+func chunkBoundary(s string, limit int) int {
+	if len(s) <= limit {
+		return len(s)
+	}
+	n := limit
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
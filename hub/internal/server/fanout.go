@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/internal/buffer"
+)
+
+// watchSession makes c receive messages other hub instances push for sessionID.
+// A client watches at most one session; watching a new one drops the old one.
+func (c *Client) watchSession(sessionID string) {
+	if c.server.fanout == nil || sessionID == "" || sessionID == c.watched {
+		return
+	}
+	c.unwatchSession()
+	c.watched = sessionID
+
+	s := c.server
+	s.mu.Lock()
+	watchers, ok := s.watchers[sessionID]
+	if !ok {
+		watchers = make(map[*Client]bool)
+		s.watchers[sessionID] = watchers
+	}
+	watchers[c] = true
+	s.mu.Unlock()
+
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.fanout.Subscribe(ctx, sessionID); err != nil {
+			log.Printf("Fan-out subscribe failed for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// unwatchSession stops fan-out delivery for the client's watched session
+func (c *Client) unwatchSession() {
+	if c.server.fanout == nil || c.watched == "" {
+		return
+	}
+	sessionID := c.watched
+	c.watched = ""
+
+	s := c.server
+	s.mu.Lock()
+	watchers := s.watchers[sessionID]
+	delete(watchers, c)
+	last := len(watchers) == 0
+	if last {
+		delete(s.watchers, sessionID)
+	}
+	s.mu.Unlock()
+
+	if last {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.fanout.Unsubscribe(ctx, sessionID); err != nil {
+			log.Printf("Fan-out unsubscribe failed for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// deliverRemote forwards messages pushed by other hub instances to local watchers
+func (s *Server) deliverRemote() {
+	for remote := range s.fanout.Remote() {
+		s.mu.RLock()
+		clients := make([]*Client, 0, len(s.watchers[remote.SessionID]))
+		for client := range s.watchers[remote.SessionID] {
+			clients = append(clients, client)
+		}
+		s.mu.RUnlock()
+
+		for _, client := range clients {
+			client.sendMessage(remoteServerMessage(remote.Message))
+		}
+	}
+}
+
+func remoteServerMessage(msg buffer.Message) ServerMessage {
+	var payload interface{}
+	if len(msg.Payload) > 0 {
+		payload = json.RawMessage(msg.Payload)
+	}
+	return ServerMessage{
+		Type:    msg.Type,
+		ID:      msg.RequestID,
+		MsgID:   msg.ID,
+		Payload: payload,
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// idleTimer fires when Reset has not been called for a timeout. A zero
+// timeout never fires.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	if timeout > 0 {
+		t.timer = time.NewTimer(timeout)
+	}
+	return t
+}
+
+// C returns the channel that receives when the timer fires, nil if disabled
+func (t *idleTimer) C() <-chan time.Time {
+	if t.timer == nil {
+		return nil
+	}
+	return t.timer.C
+}
+
+// Reset restarts the idle period
+func (t *idleTimer) Reset() {
+	if t.timer == nil {
+		return
+	}
+	t.mu.Lock()
+	t.timer.Reset(t.timeout)
+	t.mu.Unlock()
+}
+
+// Stop releases the timer
+func (t *idleTimer) Stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+// promptLatencyBuckets are the histogram bounds for prompt timings, in ms
+var promptLatencyBuckets = []int64{100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000, 600000}
+
+var (
+	// promptFirstChunk is the time from receiving a prompt to its first
+	// stream chunk, including agent queueing and the model's first token
+	promptFirstChunk = metrics.NewHistogram("prompt_first_chunk_ms", promptLatencyBuckets)
+	// promptDuration is the time from receiving a prompt to its stream.end,
+	// for prompts that complete
+	promptDuration = metrics.NewHistogram("prompt_duration_ms", promptLatencyBuckets)
+)
+
+// promptTimer measures one prompt. A nil timer records nothing.
+type promptTimer struct {
+	requestID string
+	sessionID string
+	start     time.Time
+	first     time.Duration // Time to first chunk, 0 until it arrives
+}
+
+func newPromptTimer(requestID, sessionID string) *promptTimer {
+	return &promptTimer{requestID: requestID, sessionID: sessionID, start: time.Now()}
+}
+
+// chunk records the arrival of a stream chunk
+func (t *promptTimer) chunk() {
+	if t == nil || t.first != 0 {
+		return
+	}
+	t.first = time.Since(t.start)
+	promptFirstChunk.Observe(t.first.Milliseconds())
+}
+
+// done records a completed prompt and logs it if it took at least slow
+// (0 = never log)
+func (t *promptTimer) done(slow time.Duration) {
+	if t == nil {
+		return
+	}
+	total := time.Since(t.start)
+	promptDuration.Observe(total.Milliseconds())
+	if slow > 0 && total >= slow {
+		log.Printf("Slow prompt %s (session %s): %v total, %v to first chunk",
+			t.requestID, t.sessionID, total.Round(time.Millisecond), t.first.Round(time.Millisecond))
+	}
+}
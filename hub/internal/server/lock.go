@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/internal/buffer"
+)
+
+// handleSessionLock serves session.acquire and session.release. A lock is
+// opt-in per session: while one client holds it, prompts from every other
+// client are refused until it is released or its lease runs out. The holder
+// renews the lease by acquiring again, passing its lockToken after a
+// reconnect.
+func (c *Client) handleSessionLock(requestID, action string, payload SessionPayload) {
+	if c.server.locks == nil {
+		c.sendError(requestID, "Session locking requires a buffer backend")
+		return
+	}
+	if !sessionIDPattern.MatchString(payload.SessionID) {
+		c.sendError(requestID, "Invalid session ID format")
+		return
+	}
+	if !c.server.sessionInGroup(c.group, payload.SessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	token := payload.LockToken
+	if token == "" {
+		token = c.lockTokens[payload.SessionID]
+	}
+
+	if action == "session.release" {
+		released := false
+		if token != "" {
+			var err error
+			released, err = c.server.locks.ReleaseLock(ctx, payload.SessionID, token)
+			if err != nil {
+				c.sendError(requestID, err.Error())
+				return
+			}
+		}
+		delete(c.lockTokens, payload.SessionID)
+		c.sendMessage(ServerMessage{
+			Type:    "response",
+			ID:      requestID,
+			Payload: map[string]interface{}{"sessionId": payload.SessionID, "released": released},
+		})
+		return
+	}
+
+	if token == "" {
+		token = newLockToken()
+	}
+	lock, acquired, err := c.server.locks.AcquireLock(ctx, payload.SessionID, token, c.server.config.SessionLockTTL)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+	if !acquired {
+		delete(c.lockTokens, payload.SessionID)
+		c.sendSessionLocked(requestID, lock)
+		return
+	}
+	c.lockTokens[payload.SessionID] = token
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: map[string]interface{}{"sessionId": payload.SessionID, "lockToken": token, "expiresAt": lock.ExpiresAt},
+	})
+}
+
+// lockedByOther returns the lock on sessionID if a client other than c holds
+// it. A lock store failure doesn't block prompts.
+func (c *Client) lockedByOther(sessionID string) (buffer.Lock, bool) {
+	if c.server.locks == nil {
+		return buffer.Lock{}, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+	lock, ok, err := c.server.locks.GetLock(ctx, sessionID)
+	if err != nil {
+		log.Printf("Failed to check lock on session %s: %v", sessionID, err)
+		return buffer.Lock{}, false
+	}
+	if !ok || lock.Token == c.lockTokens[sessionID] {
+		return buffer.Lock{}, false
+	}
+	return lock, true
+}
+
+// sendSessionLocked refuses a request on a session locked by another client,
+// telling the client when the lease runs out
+func (c *Client) sendSessionLocked(requestID string, lock buffer.Lock) {
+	c.sendErrorPayload(requestID, ErrorPayload{
+		Error:        "session locked by another client",
+		Code:         CodeSessionLocked,
+		Retryable:    true,
+		RetryAfterMs: max(time.Until(time.UnixMilli(lock.ExpiresAt)).Milliseconds(), 0),
+	})
+}
+
+// newLockToken returns a random session lock token
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
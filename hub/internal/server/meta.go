@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// maxMetaKeys bounds the fields one session.setmeta may write
+const maxMetaKeys = 64
+
+// handleSessionMeta serves session.setmeta and session.getmeta. Metadata is
+// stored by the hub, independent of OpenCode, until the buffer TTL passes
+// without a change or the session is deleted.
+func (c *Client) handleSessionMeta(requestID, action string, payload SessionPayload) {
+	if c.server.metadata == nil {
+		c.sendError(requestID, "Session metadata requires a buffer backend")
+		return
+	}
+	if !sessionIDPattern.MatchString(payload.SessionID) {
+		c.sendError(requestID, "Invalid session ID format")
+		return
+	}
+	if !c.server.sessionInGroup(c.group, payload.SessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	if action == "session.setmeta" {
+		if len(payload.Meta) == 0 || len(payload.Meta) > maxMetaKeys {
+			c.sendError(requestID, "meta must have between 1 and 64 keys")
+			return
+		}
+		if err := c.server.metadata.SetMeta(ctx, payload.SessionID, payload.Meta); err != nil {
+			c.sendError(requestID, err.Error())
+			return
+		}
+	}
+
+	meta, err := c.server.metadata.GetMeta(ctx, payload.SessionID)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: map[string]interface{}{"sessionId": payload.SessionID, "meta": meta},
+	})
+}
+
+// decorateSessionList prepares a session.list payload for clients: it drops
+// soft-deleted sessions, attaches each session's hub metadata, and sets
+// lastActivity to the later of OpenCode's update time and the newest
+// buffered message
+func (s *Server) decorateSessionList(ctx context.Context, payload json.RawMessage) json.RawMessage {
+	hidden := s.tombstonedSessions(ctx)
+
+	var sessions []map[string]json.RawMessage
+	if json.Unmarshal(payload, &sessions) != nil {
+		return payload
+	}
+
+	visible := make([]map[string]json.RawMessage, 0, len(sessions))
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		var id string
+		json.Unmarshal(session["id"], &id)
+		if hidden[id] {
+			continue
+		}
+		visible = append(visible, session)
+		ids = append(ids, id)
+	}
+
+	if s.metadata != nil && len(ids) > 0 {
+		metas, err := s.metadata.GetMetaMany(ctx, ids)
+		if err != nil {
+			log.Printf("Failed to load session metadata: %v", err)
+		}
+		for i, session := range visible {
+			if meta, ok := metas[ids[i]]; ok {
+				session["metadata"], _ = json.Marshal(meta)
+			}
+		}
+	}
+
+	var activity map[string]int64
+	if s.activity != nil && len(ids) > 0 {
+		var err error
+		activity, err = s.activity.LastActivity(ctx, ids)
+		if err != nil {
+			log.Printf("Failed to load session activity: %v", err)
+		}
+	}
+	for i, session := range visible {
+		var times struct {
+			Updated int64 `json:"updated"`
+		}
+		json.Unmarshal(session["time"], &times)
+		last := max(times.Updated, activity[ids[i]])
+		if last > 0 {
+			session["lastActivity"], _ = json.Marshal(last)
+		}
+	}
+
+	data, err := json.Marshal(visible)
+	if err != nil {
+		return payload
+	}
+	return data
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/openvibe/hub/internal/config"
+)
+
+// memoryMeta is a buffer.Metadata kept in a map
+type memoryMeta map[string]map[string]json.RawMessage
+
+func (m memoryMeta) SetMeta(ctx context.Context, sessionID string, meta map[string]json.RawMessage) error {
+	if m[sessionID] == nil {
+		m[sessionID] = make(map[string]json.RawMessage)
+	}
+	for k, v := range meta {
+		m[sessionID][k] = v
+	}
+	return nil
+}
+
+func (m memoryMeta) GetMeta(ctx context.Context, sessionID string) (map[string]json.RawMessage, error) {
+	return m[sessionID], nil
+}
+
+func (m memoryMeta) GetMetaMany(ctx context.Context, sessionIDs []string) (map[string]map[string]json.RawMessage, error) {
+	result := make(map[string]map[string]json.RawMessage)
+	for _, id := range sessionIDs {
+		if meta, ok := m[id]; ok {
+			result[id] = meta
+		}
+	}
+	return result, nil
+}
+
+// metaServer returns a server whose session ses_a belongs to group "team-a",
+// holding {"tag": "mine"}
+func metaServer() *Server {
+	return &Server{
+		config:        &config.Config{ActionTimeout: time.Second},
+		metadata:      memoryMeta{"ses_a": {"tag": json.RawMessage(`"mine"`)}},
+		sessionGroups: map[string]string{"ses_a": "team-a"},
+	}
+}
+
+// reply runs a session.setmeta or session.getmeta as a client of group and
+// returns what it was sent
+func reply(t *testing.T, s *Server, group, action string, payload SessionPayload) ServerMessage {
+	t.Helper()
+	c := testClient(s, group)
+	c.handleSessionMeta("req-1", action, payload)
+	return nextMessage(t, c)
+}
+
+func TestSessionMetaOtherGroup(t *testing.T) {
+	s := metaServer()
+
+	if msg := reply(t, s, "team-b", "session.getmeta", SessionPayload{SessionID: "ses_a"}); msg.Type != "error" {
+		t.Errorf("getmeta from another group: got %s, want error", msg.Type)
+	}
+
+	meta := map[string]json.RawMessage{"tag": json.RawMessage(`"theirs"`)}
+	if msg := reply(t, s, "team-b", "session.setmeta", SessionPayload{SessionID: "ses_a", Meta: meta}); msg.Type != "error" {
+		t.Errorf("setmeta from another group: got %s, want error", msg.Type)
+	}
+	if got := string(s.metadata.(memoryMeta)["ses_a"]["tag"]); got != `"mine"` {
+		t.Errorf("tag overwritten by another group: %s", got)
+	}
+}
+
+func TestSessionMetaOwnGroup(t *testing.T) {
+	s := metaServer()
+
+	meta := map[string]json.RawMessage{"pinned": json.RawMessage(`true`)}
+	msg := reply(t, s, "team-a", "session.setmeta", SessionPayload{SessionID: "ses_a", Meta: meta})
+	if msg.Type != "response" {
+		t.Fatalf("setmeta from own group: got %s %v", msg.Type, msg.Payload)
+	}
+	var payload struct {
+		Meta map[string]json.RawMessage `json:"meta"`
+	}
+	decodeInto(t, msg.Payload, &payload)
+	if string(payload.Meta["tag"]) != `"mine"` || string(payload.Meta["pinned"]) != "true" {
+		t.Errorf("meta = %v", payload.Meta)
+	}
+}
+
+func TestSessionMetaUnboundSession(t *testing.T) {
+	// Sessions the hub hasn't seen created belong to no group yet
+	s := metaServer()
+	if msg := reply(t, s, "team-b", "session.getmeta", SessionPayload{SessionID: "ses_new"}); msg.Type != "response" {
+		t.Errorf("getmeta of unbound session: got %s", msg.Type)
+	}
+}
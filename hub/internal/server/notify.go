@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification kinds carried in NotificationPayload.Kind
+const (
+	NotifyAgentOnline  = "agent.online"
+	NotifyAgentOffline = "agent.offline"
+	NotifyBroadcast    = "broadcast"
+)
+
+// NotificationPayload is the payload of a "notification" ServerMessage.
+// Notifications are pushed unprompted and carry no request ID.
+type NotificationPayload struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message,omitempty"`
+	AgentID string `json:"agentId,omitempty"`
+	Time    int64  `json:"time"` // Unix milliseconds
+}
+
+// ProjectStatusPayload is pushed to the agent's group when it reports a
+// project status change
+type ProjectStatusPayload struct {
+	AgentID string          `json:"agentId"`
+	Project json.RawMessage `json:"project"`
+}
+
+// deliverProjectStatus pushes agent project status changes to the clients of
+// the agent's group
+func (s *Server) deliverProjectStatus() {
+	for event := range s.tunnelMgr.ProjectStatus() {
+		var status struct {
+			Project json.RawMessage `json:"project"`
+		}
+		if err := json.Unmarshal(event.Payload, &status); err != nil || len(status.Project) == 0 {
+			log.Printf("Invalid project status from agent %s", event.AgentID)
+			continue
+		}
+
+		s.broadcast(event.Group, ServerMessage{
+			Type:    "project.status.changed",
+			Payload: ProjectStatusPayload{AgentID: event.AgentID, Project: status.Project},
+		})
+		s.publishAdmin(ServerMessage{
+			Type:    AdminMsgProject,
+			Payload: AdminProjectPayload{AgentID: event.AgentID, Group: event.Group, Project: status.Project, Time: time.Now().UnixMilli()},
+		})
+	}
+}
+
+// broadcast sends msg to every connected client in group
+func (s *Server) broadcast(group string, msg ServerMessage) {
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		if client.group == group {
+			clients = append(clients, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, client := range clients {
+		client.sendMessage(msg)
+	}
+}
+
+// notify pushes a notification to every client in group
+func (s *Server) notify(group string, n NotificationPayload) {
+	n.Time = time.Now().UnixMilli()
+	s.broadcast(group, ServerMessage{Type: "notification", Payload: n})
+}
+
+// notifyAll pushes a notification to every connected client
+func (s *Server) notifyAll(n NotificationPayload) {
+	n.Time = time.Now().UnixMilli()
+	msg := ServerMessage{Type: "notification", Payload: n}
+
+	s.mu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.RUnlock()
+
+	for _, client := range clients {
+		client.sendMessage(msg)
+	}
+}
+
+// deliverPresence tells each group's clients when its agents come and go
+func (s *Server) deliverPresence() {
+	for event := range s.tunnelMgr.Presence() {
+		n := NotificationPayload{Kind: NotifyAgentOffline, AgentID: event.AgentID, Message: "Agent " + event.AgentID + " went offline"}
+		if event.Online {
+			n.Kind = NotifyAgentOnline
+			n.Message = "Agent " + event.AgentID + " is online"
+		}
+		s.notify(event.Group, n)
+		s.publishAdmin(ServerMessage{
+			Type:    AdminMsgAgent,
+			Payload: AdminAgentPayload{AgentID: event.AgentID, Group: event.Group, Online: event.Online, Time: time.Now().UnixMilli()},
+		})
+	}
+}
+
+// broadcastRequest is the body of a POST to HandleBroadcast
+type broadcastRequest struct {
+	Message string  `json:"message"`
+	Group   *string `json:"group,omitempty"` // Only this group's clients ("" = default group), nil = everyone
+}
+
+// HandleBroadcast pushes an admin message to connected clients as a
+// "broadcast" notification. Callers must wrap it in token auth.
+func (s *Server) HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req broadcastRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil || req.Message == "" {
+		http.Error(w, "Expected JSON {message, group?}", http.StatusBadRequest)
+		return
+	}
+
+	n := NotificationPayload{Kind: NotifyBroadcast, Message: req.Message}
+	if req.Group != nil {
+		s.notify(*req.Group, n)
+	} else {
+		s.notifyAll(n)
+	}
+	log.Printf("Broadcast sent: %q", req.Message)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultSearchLimit and maxSearchLimit bound the messages one
+	// session.search response returns
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// maxSearchQuery is the longest query accepted
+	maxSearchQuery = 256
+	// searchContext is how many bytes of text surround a match in a snippet
+	searchContext = 80
+	// maxSnippets is the most snippets returned per message
+	maxSnippets = 3
+)
+
+// SearchResult is a message matching a session.search query
+type SearchResult struct {
+	ID       string   `json:"id"`
+	Role     string   `json:"role"`
+	Created  int64    `json:"created,omitempty"` // Unix milliseconds
+	Matches  int      `json:"matches"`
+	Snippets []string `json:"snippets"` // Matches with surrounding text, at most maxSnippets
+}
+
+// handleSessionSearch finds a session's messages matching payload.Query,
+// newest first. Matching is case-insensitive unless payload.CaseSensitive;
+// payload.Regex treats the query as a regular expression. Pass the returned
+// cursor as before to continue with older messages.
+func (c *Client) handleSessionSearch(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.LongActionTimeout)
+	defer cancel()
+
+	sessionID := payload.SessionID
+	if sessionID == "" {
+		sessionID = c.currentSession()
+	}
+	if !sessionIDPattern.MatchString(sessionID) {
+		c.sendError(requestID, "Invalid session ID format")
+		return
+	}
+
+	pattern, err := searchPattern(payload.Query, payload.Regex, payload.CaseSensitive)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	history, truncated, err := c.server.messageHistory(ctx, c.group, sessionID, payload.BaseURL)
+	if err != nil {
+		if errors.Is(err, errSessionAgentOffline) {
+			c.sendSessionError(requestID, err)
+			return
+		}
+		c.sendError(requestID, "Failed to search session: "+err.Error())
+		return
+	}
+	messages, err := parseHistory(history)
+	if err != nil {
+		c.sendError(requestID, "Failed to search session: "+err.Error())
+		return
+	}
+
+	// Newest first, starting below the cursor
+	end := len(messages)
+	if payload.Before != "" {
+		end = 0
+		for i, m := range messages {
+			if m.ID == payload.Before {
+				end = i
+				break
+			}
+		}
+	}
+
+	results := []SearchResult{}
+	cursor := ""
+	for i := end - 1; i >= 0; i-- {
+		m := messages[i]
+		locs := pattern.FindAllStringIndex(m.Text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		if len(results) == limit {
+			cursor = results[len(results)-1].ID
+			break
+		}
+		result := SearchResult{ID: m.ID, Role: m.Role, Created: m.Created, Matches: len(locs)}
+		for _, loc := range locs[:min(len(locs), maxSnippets)] {
+			result.Snippets = append(result.Snippets, snippet(m.Text, loc[0], loc[1]))
+		}
+		results = append(results, result)
+	}
+
+	response := map[string]interface{}{
+		"sessionId": sessionID,
+		"results":   results,
+		"hasMore":   cursor != "",
+	}
+	if cursor != "" {
+		response["cursor"] = cursor
+	}
+	if truncated {
+		response["truncated"] = true
+	}
+	c.sendMessage(ServerMessage{Type: "response", ID: requestID, Payload: response})
+}
+
+// searchPattern compiles a session.search query
+func searchPattern(query string, isRegex, caseSensitive bool) (*regexp.Regexp, error) {
+	if query == "" {
+		return nil, errors.New("query is required")
+	}
+	if len(query) > maxSearchQuery {
+		return nil, errors.New("query too long")
+	}
+	if !isRegex {
+		query = regexp.QuoteMeta(query)
+	}
+	if !caseSensitive {
+		query = "(?i)" + query
+	}
+	pattern, err := regexp.Compile(query)
+	if err != nil {
+		return nil, errors.New("invalid regex: " + err.Error())
+	}
+	return pattern, nil
+}
+
+// snippet returns text[start:end] with up to searchContext bytes either side,
+// cut on character boundaries and marked with ellipses where trimmed
+func snippet(text string, start, end int) string {
+	from := max(start-searchContext, 0)
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from++
+	}
+	to := min(end+searchContext, len(text))
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to--
+	}
+
+	s := strings.Join(strings.Fields(text[from:to]), " ")
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(text) {
+		s += "…"
+	}
+	return s
+}
@@ -1,20 +1,30 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/openvibe/hub/internal/audit"
 	"github.com/openvibe/hub/internal/buffer"
 	"github.com/openvibe/hub/internal/config"
+	"github.com/openvibe/hub/internal/metrics"
 	"github.com/openvibe/hub/internal/proxy"
 	"github.com/openvibe/hub/internal/tunnel"
+	"github.com/openvibe/hub/internal/webhooks"
 )
 
 const (
@@ -24,32 +34,84 @@ const (
 	maxMessageSize = 1024 * 1024
 )
 
-var (
-	upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
-	sessionIDPattern = regexp.MustCompile(`^ses_[a-zA-Z0-9]+$`)
-)
+var sessionIDPattern = regexp.MustCompile(`^ses_[a-zA-Z0-9]+$`)
+
+// duplicatePrompts counts prompts dropped because their request ID was still streaming
+var duplicatePrompts = metrics.NewCounter("server_duplicate_prompts_total")
 
 type Server struct {
 	config    *config.Config
+	upgrader  websocket.Upgrader
 	proxy     *proxy.OpenCodeProxy
 	buffer    buffer.Buffer
 	tunnelMgr *tunnel.Manager
 	clients   map[*Client]bool
 	mu        sync.RWMutex
+
+	sessionAgents map[string]string // sessionID -> agentID
+	sessionGroups map[string]string // sessionID -> group of its agent
+	sessionPaths  map[string]string // sessionID -> project path it was created in
+	affinityMu    sync.RWMutex
+
+	fanout   buffer.Fanout               // nil when the buffer can't fan out
+	watchers map[string]map[*Client]bool // sessionID -> clients, guarded by mu
+
+	untitled map[string]bool // Sessions awaiting a first-prompt title
+	titleMu  sync.Mutex
+
+	webhooks *webhooks.Dispatcher // nil when webhooks are off
+	audit    *audit.Logger        // nil when prompt auditing is off
+
+	tombstones buffer.Tombstones  // nil when soft delete is off
+	metadata   buffer.Metadata    // nil without a buffer backend
+	activity   buffer.Activity    // nil without a buffer backend
+	locks      buffer.Locks       // nil without a buffer backend
+	usage      buffer.UsageTotals // nil unless the buffer keeps usage totals
+	purger     buffer.Purger      // nil unless the buffer keeps per-session data
+
+	agentList agentListCache // Recent agent.list results per group
+
+	admins  map[*adminConn]bool // Admin feed connections
+	adminMu sync.Mutex
 }
 
 type Client struct {
 	server    *Server
 	conn      *websocket.Conn
 	send      chan []byte
+	lastAckID int64  // For Mosh-style sync
+	watched   string // Session receiving fan-out from other hub instances
+	group     string // Agent group the client's token grants, "" by default
+
+	readOnlyToken bool // Connected with the read-only token
+
+	slowWrites atomic.Int32 // Consecutive slow writes
+	dropped    atomic.Int32 // Messages dropped for a full send buffer
+
+	prompts   map[string]context.CancelFunc // In-flight prompts by request ID
+	promptsMu sync.Mutex
+
+	lockTokens map[string]string // Tokens of the session locks this connection holds, by session ID
+
+	// sessionID is the session last created on this connection, the default
+	// for requests naming none. Handlers running off the read loop read it,
+	// so it is only accessed through currentSession and setSession.
 	sessionID string
-	lastAckID int64 // For Mosh-style sync
+	sessionMu sync.Mutex
+}
+
+// currentSession returns the connection's default session
+func (c *Client) currentSession() string {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	return c.sessionID
+}
+
+// setSession makes id the connection's default session
+func (c *Client) setSession(id string) {
+	c.sessionMu.Lock()
+	c.sessionID = id
+	c.sessionMu.Unlock()
 }
 
 type ClientMessage struct {
@@ -62,12 +124,57 @@ type PromptPayload struct {
 	SessionID   string `json:"sessionId"`
 	Content     string `json:"content"`
 	ProjectPath string `json:"projectPath,omitempty"`
+	BaseURL     string `json:"baseUrl,omitempty"` // Explicit OpenCode server, checked by the agent
+
+	// Directory runs this prompt alone in a directory of an agent project,
+	// e.g. a subdirectory; the agent routes it to the project containing it
+	Directory string `json:"directory,omitempty"`
 }
 
 type SessionPayload struct {
 	SessionID string `json:"sessionId,omitempty"`
 	Title     string `json:"title,omitempty"`
 	Directory string `json:"directory,omitempty"`
+	BaseURL   string `json:"baseUrl,omitempty"`
+
+	// ProjectPath for session.create selects the agent project the session
+	// lives in (default Directory); later requests for the session go there
+	ProjectPath string `json:"projectPath,omitempty"`
+
+	// Pagination for session.messages
+	Limit  int    `json:"limit,omitempty"`
+	Before string `json:"before,omitempty"`
+
+	// Metadata for session.setmeta
+	Meta map[string]json.RawMessage `json:"meta,omitempty"`
+
+	// LockToken for session.acquire and session.release names a lock taken
+	// before reconnecting
+	LockToken string `json:"lockToken,omitempty"`
+
+	// Format for session.export: "markdown" (default) or "json"
+	Format string `json:"format,omitempty"`
+
+	// Query for session.search, a substring unless Regex
+	Query         string `json:"query,omitempty"`
+	Regex         bool   `json:"regex,omitempty"`
+	CaseSensitive bool   `json:"caseSensitive,omitempty"`
+}
+
+type ProjectPayload struct {
+	Path string `json:"path"`
+}
+
+// FilePayload selects a file or directory within a project
+type FilePayload struct {
+	ProjectPath string `json:"projectPath"`
+	Path        string `json:"path,omitempty"` // Relative to ProjectPath, default the root
+}
+
+// target selects which OpenCode instance on the agent serves a request
+type target struct {
+	ProjectPath string
+	BaseURL     string
 }
 
 type SyncPayload struct {
@@ -75,42 +182,121 @@ type SyncPayload struct {
 	LastAckID int64  `json:"lastAckId"`
 }
 
+// Error codes carried in ErrorPayload.Code
+const (
+	CodeNoAgent           = "no_agent"
+	CodeAgentReconnecting = "agent_reconnecting"
+	// CodeSessionAgentOffline means the session lives on an agent that is not
+	// connected; other agents can't serve it
+	CodeSessionAgentOffline = "session_agent_offline"
+	// CodeReadOnly refuses a mutating action from a read-only client
+	CodeReadOnly = "read_only"
+	// CodeForbidden refuses an admin action from a group token client
+	CodeForbidden = "forbidden"
+	// CodeSessionLocked refuses a prompt on a session another client has
+	// locked with session.acquire
+	CodeSessionLocked = "session_locked"
+)
+
+// ErrorPayload is the payload of an "error" ServerMessage
+type ErrorPayload struct {
+	Error        string `json:"error"`
+	Code         string `json:"code,omitempty"`
+	Retryable    bool   `json:"retryable,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+	AgentID      string `json:"agentId,omitempty"` // With CodeSessionAgentOffline
+}
+
 type ServerMessage struct {
 	Type    string      `json:"type"`
 	ID      string      `json:"id,omitempty"`
 	MsgID   int64       `json:"msgId,omitempty"` // Buffer message ID
 	Payload interface{} `json:"payload"`
+
+	// AgentID is the agent that served the request, empty in direct mode
+	AgentID string `json:"agentId,omitempty"`
 }
 
-func NewServer(cfg *config.Config, p *proxy.OpenCodeProxy, buf buffer.Buffer, tm *tunnel.Manager) *Server {
-	return &Server{
-		config:    cfg,
+func NewServer(cfg *config.Config, p *proxy.OpenCodeProxy, buf buffer.Buffer, tm *tunnel.Manager, hooks *webhooks.Dispatcher) *Server {
+	s := &Server{
+		config:   cfg,
+		webhooks: hooks,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin: func(r *http.Request) bool {
+				// No allowlist keeps the historical allow-all behavior
+				if len(cfg.AllowedOrigins) == 0 {
+					return true
+				}
+				return originAllowed(cfg.AllowedOrigins, r.Header.Get("Origin"))
+			},
+		},
 		proxy:     p,
 		buffer:    buf,
 		tunnelMgr: tm,
 		clients:   make(map[*Client]bool),
+
+		sessionAgents: make(map[string]string),
+		sessionGroups: make(map[string]string),
+		sessionPaths:  make(map[string]string),
+		watchers:      make(map[string]map[*Client]bool),
+		untitled:      make(map[string]bool),
+		admins:        make(map[*adminConn]bool),
+	}
+
+	if fanout, ok := buf.(buffer.Fanout); ok {
+		s.fanout = fanout
+		go s.deliverRemote()
+	}
+	if metadata, ok := buf.(buffer.Metadata); ok {
+		s.metadata = metadata
 	}
+	if activity, ok := buf.(buffer.Activity); ok {
+		s.activity = activity
+	}
+	if locks, ok := buf.(buffer.Locks); ok {
+		s.locks = locks
+	}
+	if usage, ok := buf.(buffer.UsageTotals); ok {
+		s.usage = usage
+	}
+	if purger, ok := buf.(buffer.Purger); ok {
+		s.purger = purger
+	}
+	if tombstones, ok := buf.(buffer.Tombstones); ok && cfg.SessionDeleteGrace > 0 {
+		s.tombstones = tombstones
+		go s.purgeTombstones()
+	}
+	go s.deliverProjectStatus()
+	go s.deliverPresence()
+
+	return s
 }
 
 func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	if s.config.Token != "" {
-		token := r.URL.Query().Get("token")
-		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	group, readOnly, ok := s.clientGroup(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
 	client := &Client{
-		server: s,
-		conn:   conn,
-		send:   make(chan []byte, 256),
+		server:  s,
+		conn:    conn,
+		group:   group,
+		send:    make(chan []byte, 256),
+		prompts: make(map[string]context.CancelFunc),
+
+		lockTokens: make(map[string]string),
+
+		readOnlyToken: readOnly,
 	}
 
 	s.mu.Lock()
@@ -118,6 +304,7 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	s.mu.Unlock()
 
 	log.Printf("Client connected: %s", conn.RemoteAddr())
+	s.webhooks.Fire(webhooks.Event{Type: webhooks.EventClientConnected, Client: conn.RemoteAddr().String()})
 
 	go client.writePump()
 	go client.readPump()
@@ -125,17 +312,19 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 func (c *Client) readPump() {
 	defer func() {
+		c.unwatchSession()
 		c.server.mu.Lock()
 		delete(c.server.clients, c)
 		c.server.mu.Unlock()
 		c.conn.Close()
 		log.Printf("Client disconnected: %s", c.conn.RemoteAddr())
+		c.server.webhooks.Fire(webhooks.Event{Type: webhooks.EventClientDisconnected, Client: c.conn.RemoteAddr().String()})
 	}()
 
 	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.extendReadDeadline()
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.extendReadDeadline()
 		return nil
 	})
 
@@ -152,6 +341,12 @@ func (c *Client) readPump() {
 	}
 }
 
+// extendReadDeadline keeps the connection alive for another pongWait. Only
+// the read loop may call it.
+func (c *Client) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
@@ -162,19 +357,18 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.write(websocket.TextMessage, message); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.write(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
@@ -188,85 +382,269 @@ func (c *Client) handleMessage(data []byte) {
 		return
 	}
 
+	if mutatingActions[msg.Type] && c.readOnly() {
+		c.sendErrorPayload(msg.ID, ErrorPayload{Error: "read-only mode: " + msg.Type + " not allowed", Code: CodeReadOnly})
+		return
+	}
+	if adminActions[msg.Type] && !c.admin() {
+		c.sendErrorPayload(msg.ID, ErrorPayload{Error: msg.Type + " requires the hub token", Code: CodeForbidden})
+		return
+	}
+
 	switch msg.Type {
 	case "ping":
+		// JSON pings keep clients alive that can't answer WebSocket pings
+		c.extendReadDeadline()
 		c.sendMessage(ServerMessage{Type: "pong", ID: msg.ID, Payload: nil})
 
 	case "session.list":
-		c.handleSessionList(msg.ID)
+		var payload SessionPayload
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			if err := decodePayload(msg.Payload, &payload); err != nil {
+				c.sendError(msg.ID, "Invalid payload: "+err.Error())
+				return
+			}
+		}
+		c.handleSessionList(msg.ID, payload)
+
+	case "session.export":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		go c.handleSessionExport(msg.ID, payload)
+
+	case "session.search":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		go c.handleSessionSearch(msg.ID, payload)
+
+	case "file.list", "file.read":
+		var payload FilePayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		go c.handleFileAction(msg.ID, msg.Type, payload)
+
+	case "provider.list":
+		var payload SessionPayload
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			if err := decodePayload(msg.Payload, &payload); err != nil {
+				c.sendError(msg.ID, "Invalid payload: "+err.Error())
+				return
+			}
+		}
+		c.handleProviderList(msg.ID, payload)
+
+	case "agent.list":
+		// Waits on every agent's project.list; keep reading meanwhile
+		go c.handleAgentList(msg.ID)
+
+	case "agent.stats":
+		var payload SessionPayload
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			if err := decodePayload(msg.Payload, &payload); err != nil {
+				c.sendError(msg.ID, "Invalid payload: "+err.Error())
+				return
+			}
+		}
+		c.handleAgentStats(msg.ID, payload)
 
 	case "session.create":
 		var payload SessionPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			c.sendError(msg.ID, "Invalid payload format")
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
 			return
 		}
-		c.handleSessionCreate(msg.ID, payload.Title, payload.Directory)
+		c.handleSessionCreate(msg.ID, payload)
 
 	case "prompt":
 		var payload PromptPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			c.sendError(msg.ID, "Invalid payload format")
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
 			return
 		}
 		c.handlePrompt(msg.ID, payload)
 
+	case "prompt.cancel":
+		var payload struct {
+			RequestID string `json:"requestId"`
+		}
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handlePromptCancel(msg.ID, payload.RequestID)
+
 	case "sync":
 		var payload SyncPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			c.sendError(msg.ID, "Invalid payload format")
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
 			return
 		}
 		c.handleSync(msg.ID, payload)
 
+	case "sync.stats":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handleSyncStats(msg.ID, payload.SessionID)
+
 	case "ack":
-		// Client acknowledging receipt of message
+		// Client acknowledging receipt of message, which also proves liveness
+		c.extendReadDeadline()
 		var payload struct {
 			MsgID int64 `json:"msgId"`
 		}
-		if err := json.Unmarshal(msg.Payload, &payload); err == nil {
-			c.lastAckID = payload.MsgID
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
 		}
+		c.lastAckID = payload.MsgID
 
 	case "session.messages":
 		var payload SessionPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			c.sendError(msg.ID, "Invalid payload format")
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
 			return
 		}
-		c.handleSessionMessages(msg.ID, payload.SessionID)
+		c.handleSessionMessages(msg.ID, payload)
+
+	case "session.rename":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handleSessionRename(msg.ID, payload)
 
 	case "session.delete":
 		var payload SessionPayload
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			c.sendError(msg.ID, "Invalid payload format")
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handleSessionDelete(msg.ID, payload)
+
+	case "session.restore":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handleSessionRestore(msg.ID, payload)
+
+	case "session.setmeta", "session.getmeta":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		c.handleSessionMeta(msg.ID, msg.Type, payload)
+
+	case "session.acquire", "session.release":
+		var payload SessionPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
 			return
 		}
-		c.handleSessionDelete(msg.ID, payload.SessionID)
+		c.handleSessionLock(msg.ID, msg.Type, payload)
 
 	case "project.list":
 		c.handleProjectList(msg.ID)
 
-	case "project.start", "project.stop":
-		c.handleProjectAction(msg.ID, msg.Type, msg.Payload)
+	case "project.start", "project.start.cancel", "project.stop", "project.pin", "project.unpin":
+		var payload ProjectPayload
+		if err := decodePayload(msg.Payload, &payload); err != nil {
+			c.sendError(msg.ID, "Invalid payload: "+err.Error())
+			return
+		}
+		// project.start can take minutes while an image pulls; keep reading meanwhile
+		go c.handleProjectAction(msg.ID, msg.Type, msg.Payload)
+
+	case "project.restart-all":
+		// Streams each instance's outcome as progress; rolling restarts are slow
+		go c.handleProjectAction(msg.ID, msg.Type, msg.Payload)
 
 	default:
 		c.sendError(msg.ID, "Unknown message type: "+msg.Type)
 	}
 }
 
-func (c *Client) handleSessionList(requestID string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// decodePayload strictly decodes a client payload so misspelled fields
+// (e.g. sessionID for sessionId) are reported instead of silently ignored
+func decodePayload(data json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return errors.New("missing payload")
+		}
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	// A second value means the client glued messages together or mangled one
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after payload")
+	}
+	return checkFieldCase(data, v)
+}
+
+// checkFieldCase rejects keys that only match a field of v ignoring case,
+// such as sessionID for sessionId, which encoding/json would quietly accept
+func checkFieldCase(data json.RawMessage, v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var fields map[string]json.RawMessage
+	if t.Kind() != reflect.Struct || json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		known[name] = true
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if known[name] {
+			continue
+		}
+		for field := range known {
+			if strings.EqualFold(field, name) {
+				return fmt.Errorf("unknown field %q (did you mean %q?)", name, field)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) handleSessionList(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
 	defer cancel()
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		c.handleViaAgent(ctx, requestID, agent.ID, "session.list", "", nil)
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		c.handleViaAgent(ctx, requestID, agent.ID, "session.list", target{BaseURL: payload.BaseURL}, nil)
 		return
 	}
 
 	// Check if direct mode is available
-	if err := c.server.proxy.Health(ctx); err != nil {
-		c.sendError(requestID, "No agent connected and OpenCode is not available. Please start an agent or ensure OpenCode is running locally.")
+	if !c.directAvailable(ctx) {
+		c.sendNoAgent(requestID, "No agent connected and OpenCode is not available. Please start an agent or ensure OpenCode is running locally.")
 		return
 	}
 
@@ -275,27 +653,89 @@ func (c *Client) handleSessionList(requestID string) {
 		c.sendError(requestID, "Failed to list sessions: "+err.Error())
 		return
 	}
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		c.sendError(requestID, "Failed to list sessions: "+err.Error())
+		return
+	}
+
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: c.server.decorateSessionList(ctx, data),
+	})
+}
+
+func (c *Client) handleProviderList(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		c.handleViaAgent(ctx, requestID, agent.ID, "provider.list", target{BaseURL: payload.BaseURL}, nil)
+		return
+	}
+
+	if !c.directAvailable(ctx) {
+		c.sendNoAgent(requestID, "No agent connected and OpenCode is not available. Please start an agent or ensure OpenCode is running locally.")
+		return
+	}
+
+	providers, err := c.server.proxy.ListProviders(ctx)
+	if err != nil {
+		c.sendError(requestID, "Failed to list providers: "+err.Error())
+		return
+	}
 
 	c.sendMessage(ServerMessage{
 		Type:    "response",
 		ID:      requestID,
-		Payload: sessions,
+		Payload: providers,
 	})
 }
 
-func (c *Client) handleSessionCreate(requestID string, title string, directory string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// handleAgentStats reports an agent OpenCode instance's session count and
+// limit. There is no direct-mode equivalent.
+func (c *Client) handleAgentStats(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
 	defer cancel()
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		data, _ := json.Marshal(map[string]string{"title": title, "directory": directory})
-		c.handleViaAgent(ctx, requestID, agent.ID, "session.create", directory, data)
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		c.handleViaAgent(ctx, requestID, agent.ID, "agent.stats", target{ProjectPath: payload.Directory, BaseURL: payload.BaseURL}, nil)
+		return
+	}
+
+	c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+}
+
+func (c *Client) handleSessionCreate(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	title := payload.Title
+	if title == "" {
+		title = c.server.defaultTitle(time.Now())
+	}
+
+	projectPath := payload.ProjectPath
+	if projectPath == "" {
+		projectPath = payload.Directory
+	}
+
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		data, _ := json.Marshal(map[string]string{"title": title, "directory": payload.Directory})
+		c.handleViaAgent(ctx, requestID, agent.ID, "session.create", target{ProjectPath: projectPath, BaseURL: payload.BaseURL}, data)
+		return
+	}
+
+	// Projects only exist on agents
+	if payload.ProjectPath != "" {
+		c.sendNoAgent(requestID, "No agent connected to serve project: "+payload.ProjectPath)
 		return
 	}
 
 	// Check if direct mode is available
-	if err := c.server.proxy.Health(ctx); err != nil {
-		c.sendError(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+	if !c.directAvailable(ctx) {
+		c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
 		return
 	}
 
@@ -304,8 +744,11 @@ func (c *Client) handleSessionCreate(requestID string, title string, directory s
 		c.sendError(requestID, "Failed to create session: "+err.Error())
 		return
 	}
+	if title == "" {
+		c.server.markUntitled(session.ID)
+	}
 
-	c.sessionID = session.ID
+	c.setSession(session.ID)
 	c.sendMessage(ServerMessage{
 		Type:    "response",
 		ID:      requestID,
@@ -313,73 +756,163 @@ func (c *Client) handleSessionCreate(requestID string, title string, directory s
 	})
 }
 
-func (c *Client) handleSessionMessages(requestID string, sessionID string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (c *Client) handleSessionMessages(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.LongActionTimeout)
 	defer cancel()
 
+	sessionID := payload.SessionID
 	if sessionID == "" {
-		sessionID = c.sessionID
+		sessionID = c.currentSession()
 	}
 	if sessionID == "" {
 		c.sendError(requestID, "No session ID provided")
 		return
 	}
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		data, _ := json.Marshal(map[string]string{"sessionId": sessionID})
-		c.handleViaAgent(ctx, requestID, agent.ID, "session.messages", "", data)
+	agent, ok, err := c.server.agentForSession(c.group, sessionID)
+	if err != nil {
+		c.sendSessionError(requestID, err)
+		return
+	}
+	if ok {
+		data, _ := json.Marshal(map[string]interface{}{
+			"sessionId": sessionID,
+			"limit":     payload.Limit,
+			"before":    payload.Before,
+		})
+		c.handleViaAgent(ctx, requestID, agent.ID, "session.messages", c.server.sessionTarget(sessionID, target{BaseURL: payload.BaseURL}), data)
 		return
 	}
 
-	c.sendError(requestID, "No agent connected")
+	c.sendNoAgent(requestID, "No agent connected")
 }
 
-func (c *Client) handleSessionDelete(requestID string, sessionID string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (c *Client) handleSessionRename(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
 	defer cancel()
 
+	if payload.SessionID == "" || payload.Title == "" {
+		c.sendError(requestID, "sessionId and title are required")
+		return
+	}
+
+	agent, ok, err := c.server.agentForSession(c.group, payload.SessionID)
+	if err != nil {
+		c.sendSessionError(requestID, err)
+		return
+	}
+	if ok {
+		data, _ := json.Marshal(map[string]string{"sessionId": payload.SessionID, "title": payload.Title})
+		c.handleViaAgent(ctx, requestID, agent.ID, "session.rename", c.server.sessionTarget(payload.SessionID, target{BaseURL: payload.BaseURL}), data)
+		return
+	}
+
+	if !c.directAllowed() {
+		c.sendNoAgent(requestID, "No agent connected")
+		return
+	}
+
+	if err := c.server.proxy.RenameSession(ctx, payload.SessionID, payload.Title); err != nil {
+		c.sendError(requestID, "Failed to rename session: "+err.Error())
+		return
+	}
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: SessionPayload{SessionID: payload.SessionID, Title: payload.Title},
+	})
+}
+
+func (c *Client) handleSessionDelete(requestID string, payload SessionPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	sessionID := payload.SessionID
 	if sessionID == "" {
 		c.sendError(requestID, "No session ID provided")
 		return
 	}
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
+	if c.server.tombstones != nil {
+		c.softDeleteSession(ctx, requestID, sessionID, payload.BaseURL)
+		return
+	}
+
+	agent, ok, err := c.server.agentForSession(c.group, sessionID)
+	if err != nil {
+		c.sendSessionError(requestID, err)
+		return
+	}
+	if ok {
 		data, _ := json.Marshal(map[string]string{"sessionId": sessionID})
-		c.handleViaAgent(ctx, requestID, agent.ID, "session.delete", "", data)
+		c.handleViaAgent(ctx, requestID, agent.ID, "session.delete", c.server.sessionTarget(sessionID, target{BaseURL: payload.BaseURL}), data)
 		return
 	}
 
-	c.sendError(requestID, "No agent connected")
+	c.sendNoAgent(requestID, "No agent connected")
 }
 
 func (c *Client) handleProjectList(requestID string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
 	defer cancel()
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		c.handleViaAgent(ctx, requestID, agent.ID, "project.list", "", nil)
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		c.handleViaAgent(ctx, requestID, agent.ID, "project.list", target{}, nil)
 		return
 	}
 
-	c.sendError(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+	c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
 }
 
 func (c *Client) handleProjectAction(requestID string, action string, payload json.RawMessage) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	timeout := c.server.config.LongActionTimeout
+	if action == "project.start" || action == "project.restart-all" {
+		timeout = c.server.config.ProjectStartTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		c.handleViaAgent(ctx, requestID, agent.ID, action, "", payload)
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		c.handleViaAgent(ctx, requestID, agent.ID, action, target{}, payload)
 		return
 	}
 
-	c.sendError(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+	c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+}
+
+// handleFileAction reads project files through the agent, which confines
+// paths to the project root
+func (c *Client) handleFileAction(requestID, action string, payload FilePayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.LongActionTimeout)
+	defer cancel()
+
+	if payload.ProjectPath == "" {
+		c.sendError(requestID, "projectPath is required")
+		return
+	}
+
+	if agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group); ok {
+		data, _ := json.Marshal(map[string]string{"path": payload.Path})
+		c.handleViaAgent(ctx, requestID, agent.ID, action, target{ProjectPath: payload.ProjectPath}, data)
+		return
+	}
+
+	c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
 }
 
 func (c *Client) handlePrompt(requestID string, payload PromptPayload) {
+	timer := newPromptTimer(requestID, payload.SessionID)
 	sessionID := payload.SessionID
 	if sessionID == "" {
-		sessionID = c.sessionID
+		sessionID = c.currentSession()
+	}
+	if sessionID == "" && c.server.config.AutoCreateSession {
+		created, err := c.autoCreateSession(requestID, payload)
+		if err != nil {
+			c.sendError(requestID, "Failed to create session: "+err.Error())
+			return
+		}
+		sessionID = created
 	}
 	if sessionID == "" {
 		c.sendError(requestID, "No session ID provided")
@@ -390,23 +923,142 @@ func (c *Client) handlePrompt(requestID string, payload PromptPayload) {
 		c.sendError(requestID, "Invalid session ID format")
 		return
 	}
+	if !c.server.sessionInGroup(c.group, sessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+	if lock, locked := c.lockedByOther(sessionID); locked {
+		c.sendSessionLocked(requestID, lock)
+		return
+	}
+	c.watchSession(sessionID)
+	timer.sessionID = sessionID
+
+	// Stream in the background so the read loop can still receive prompt.cancel.
+	// Disconnecting does not cancel: the buffer lets the client resync later.
+	ctx, cancel := context.WithCancel(context.Background())
+	c.promptsMu.Lock()
+	if _, dup := c.prompts[requestID]; dup {
+		// A retry of a prompt still streaming: the original stream already
+		// reaches this connection under the same ID, so don't generate twice
+		c.promptsMu.Unlock()
+		cancel()
+		duplicatePrompts.Inc()
+		log.Printf("Ignoring duplicate prompt %s, original still streaming", requestID)
+		return
+	}
+	c.prompts[requestID] = cancel
+	c.promptsMu.Unlock()
+
+	go func() {
+		defer func() {
+			c.promptsMu.Lock()
+			delete(c.prompts, requestID)
+			c.promptsMu.Unlock()
+			cancel()
+		}()
+		c.firePromptEvent(webhooks.EventPromptStarted, requestID, sessionID)
+		c.auditPrompt(requestID, sessionID, payload.Content)
+		reply := c.newReplyRecorder()
+		c.runPrompt(ctx, requestID, sessionID, payload, timer, reply)
+		c.auditReply(requestID, sessionID, reply)
+		c.firePromptEvent(webhooks.EventPromptCompleted, requestID, sessionID)
+	}()
+}
+
+func (c *Client) firePromptEvent(eventType, requestID, sessionID string) {
+	c.server.webhooks.Fire(webhooks.Event{
+		Type:      eventType,
+		Client:    c.conn.RemoteAddr().String(),
+		SessionID: sessionID,
+		RequestID: requestID,
+	})
+}
+
+// handlePromptCancel aborts an in-flight prompt started by this client
+func (c *Client) handlePromptCancel(requestID, promptID string) {
+	c.promptsMu.Lock()
+	cancel, ok := c.prompts[promptID]
+	c.promptsMu.Unlock()
 
-	ctx := context.Background()
+	if !ok {
+		c.sendError(requestID, "No in-flight prompt with ID: "+promptID)
+		return
+	}
 
+	cancel()
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: map[string]interface{}{"cancelled": true, "requestId": promptID},
+	})
+}
+
+// runPrompt streams a prompt's reply from the session's agent, or from
+// OpenCode directly, recording its latency with timer and its text with reply
+func (c *Client) runPrompt(ctx context.Context, requestID, sessionID string, payload PromptPayload, timer *promptTimer, reply *replyRecorder) {
 	// Try agent first, fallback to direct
-	if agent, ok := c.server.tunnelMgr.GetAnyAgent(); ok {
-		data, _ := json.Marshal(map[string]string{"content": payload.Content})
-		c.handleViaAgentStream(ctx, requestID, agent.ID, sessionID, "prompt", payload.ProjectPath, data)
+	agent, ok, err := c.server.agentForSession(c.group, sessionID)
+	if err != nil {
+		c.sendSessionError(requestID, err)
+		return
+	}
+	if ok {
+		c.server.bindSession(sessionID, agent.ID)
+		c.server.autoTitle(sessionID, agent.ID, payload.Content)
+		prompt := map[string]string{"content": payload.Content}
+		if c.server.config.SystemPreamble != "" {
+			prompt["system"] = c.server.config.SystemPreamble
+		}
+		if payload.Directory != "" {
+			prompt["directory"] = payload.Directory
+		}
+		data, _ := json.Marshal(prompt)
+		tgt := c.server.sessionTarget(sessionID, target{ProjectPath: payload.ProjectPath, BaseURL: payload.BaseURL})
+		c.handleViaAgentStream(ctx, requestID, agent.ID, sessionID, "prompt", tgt, data, timer, reply)
+		return
+	}
+
+	if !c.directAllowed() {
+		c.sendNoAgent(requestID, "No agent connected. Please start the OpenVibe agent on your development server.")
+		return
+	}
+	if payload.Directory != "" {
+		// Only agents know which directories are allowed
+		c.sendNoAgent(requestID, "A prompt directory needs a connected agent")
 		return
 	}
 
-	// Direct mode (fallback)
-	err := c.server.proxy.SendMessage(ctx, sessionID, payload.Content, func(eventType string, data []byte) error {
+	// Direct mode (fallback). The idle timer cancels the request if OpenCode
+	// goes silent; a prompt.cancel shows up as ctx.Err on the parent.
+	c.server.autoTitle(sessionID, "", payload.Content)
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	idle := newIdleTimer(c.server.config.StreamIdleTimeout)
+	defer idle.Stop()
+	go func() {
+		select {
+		case <-idle.C():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = c.server.proxy.SendMessage(ctx, sessionID, payload.Content, func(eventType string, data []byte) error {
+		idle.Reset()
+		if eventType == "usage" {
+			c.sendStreamUsage(ctx, requestID, sessionID, "", data)
+			return nil
+		}
+		timer.chunk()
+		reply.chunk(data)
 		// Buffer the message
 		bufMsg := buffer.Message{
 			Type:      "stream",
 			RequestID: requestID,
 			Payload:   data,
+			Actor:     c.group,
 		}
 		msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
 
@@ -419,6 +1071,14 @@ func (c *Client) handlePrompt(requestID string, payload PromptPayload) {
 		return nil
 	})
 
+	if parent.Err() != nil {
+		c.sendError(requestID, "Request cancelled")
+		return
+	}
+	if ctx.Err() != nil {
+		c.sendError(requestID, "Stream idle timeout")
+		return
+	}
 	if err != nil {
 		c.sendError(requestID, "Failed to send message: "+err.Error())
 		return
@@ -428,6 +1088,7 @@ func (c *Client) handlePrompt(requestID string, payload PromptPayload) {
 	bufMsg := buffer.Message{
 		Type:      "stream.end",
 		RequestID: requestID,
+		Actor:     c.group,
 	}
 	msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
 
@@ -437,16 +1098,23 @@ func (c *Client) handlePrompt(requestID string, payload PromptPayload) {
 		MsgID:   msgID,
 		Payload: nil,
 	})
+	timer.done(c.server.config.SlowPromptThreshold)
+	reply.end()
 }
 
 func (c *Client) handleSync(requestID string, payload SyncPayload) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
 	defer cancel()
 
 	sessionID := payload.SessionID
 	if sessionID == "" {
-		sessionID = c.sessionID
+		sessionID = c.currentSession()
+	}
+	if !c.server.sessionInGroup(c.group, sessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
 	}
+	c.watchSession(sessionID)
 
 	// Get messages since lastAckID
 	messages, err := c.server.buffer.GetSince(ctx, sessionID, payload.LastAckID)
@@ -465,10 +1133,52 @@ func (c *Client) handleSync(requestID string, payload SyncPayload) {
 			"latestId": latestID,
 		},
 	})
+
+	// Buffered messages replay regardless, but a session whose agent went
+	// away can't continue until that agent reconnects
+	if agentID, offline := c.server.offlineAgent(sessionID); offline {
+		c.sendSessionError(requestID, &agentOfflineError{agentID})
+	}
 }
 
-func (c *Client) handleViaAgent(ctx context.Context, requestID, agentID, action string, projectPath string, data json.RawMessage) {
-	sessionID := c.sessionID
+// handleSyncStats reports what the buffer holds for a session, to explain
+// resyncs that return fewer messages than expected
+func (c *Client) handleSyncStats(requestID, sessionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	if sessionID == "" {
+		sessionID = c.currentSession()
+	}
+	if !c.server.sessionInGroup(c.group, sessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+
+	stats, err := c.server.buffer.Stats(ctx, sessionID)
+	if err != nil {
+		c.sendError(requestID, "Failed to get buffer stats: "+err.Error())
+		return
+	}
+	response := map[string]interface{}{
+		"sessionId": sessionID,
+		"stats":     stats,
+	}
+	// The running usage total, for clients that weren't there for its stream.usage
+	if c.server.usage != nil {
+		if usage, err := c.server.usage.GetUsage(ctx, sessionID); err == nil {
+			response["usage"] = usage
+		}
+	}
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: response,
+	})
+}
+
+func (c *Client) handleViaAgent(ctx context.Context, requestID, agentID, action string, tgt target, data json.RawMessage) {
+	sessionID := c.currentSession()
 	if data != nil {
 		var dataMap map[string]interface{}
 		if json.Unmarshal(data, &dataMap) == nil {
@@ -482,7 +1192,8 @@ func (c *Client) handleViaAgent(ctx context.Context, requestID, agentID, action
 		SessionID:   sessionID,
 		Action:      action,
 		Data:        data,
-		ProjectPath: projectPath,
+		ProjectPath: tgt.ProjectPath,
+		BaseURL:     tgt.BaseURL,
 	}
 
 	respCh, err := c.server.tunnelMgr.Forward(ctx, agentID, requestID, req)
@@ -491,47 +1202,89 @@ func (c *Client) handleViaAgent(ctx context.Context, requestID, agentID, action
 		return
 	}
 
-	select {
-	case msg := <-respCh:
-		if msg != nil {
+	for {
+		select {
+		case msg := <-respCh:
+			if msg == nil {
+				return
+			}
+			if msg.Type == tunnel.MsgTypeResponse {
+				c.observeAgentResponse(action, agentID, sessionID, tgt, data, msg.Payload)
+				if action == "session.list" {
+					msg.Payload = c.server.decorateSessionList(ctx, msg.Payload)
+				}
+			}
+
 			switch msg.Type {
-			case tunnel.MsgTypeResponse:
-				c.sendMessage(ServerMessage{
-					Type:    "response",
-					ID:      requestID,
-					Payload: json.RawMessage(msg.Payload),
-				})
 			case tunnel.MsgTypeStream:
+				// Progress ahead of the final response, e.g. project.start
+				// stages, or the contents of a file.read
+				msgType := "progress"
+				if action == "file.read" {
+					msgType = "file.chunk"
+				}
 				c.sendMessage(ServerMessage{
-					Type:    "response",
+					Type:    msgType,
 					ID:      requestID,
 					Payload: json.RawMessage(msg.Payload),
+					AgentID: agentID,
 				})
+				continue
 			case tunnel.MsgTypeError:
 				c.sendMessage(ServerMessage{
 					Type:    "error",
 					ID:      requestID,
 					Payload: json.RawMessage(msg.Payload),
+					AgentID: agentID,
 				})
 			default:
 				c.sendMessage(ServerMessage{
 					Type:    "response",
 					ID:      requestID,
 					Payload: json.RawMessage(msg.Payload),
+					AgentID: agentID,
 				})
 			}
+			return
+		case <-ctx.Done():
+			c.sendError(requestID, "Request timeout")
+			return
 		}
-	case <-ctx.Done():
-		c.sendError(requestID, "Request timeout")
 	}
 }
 
-func (c *Client) handleViaAgentStream(ctx context.Context, requestID, agentID, sessionID, action string, projectPath string, data json.RawMessage) {
+// observeAgentResponse updates per-session state from a successful agent response
+func (c *Client) observeAgentResponse(action, agentID, sessionID string, tgt target, data, payload json.RawMessage) {
+	switch action {
+	case "session.create":
+		var session struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(payload, &session) == nil {
+			c.server.bindSession(session.ID, agentID)
+			c.server.bindProject(session.ID, tgt.ProjectPath)
+
+			var requested struct {
+				Title string `json:"title"`
+			}
+			if json.Unmarshal(data, &requested) == nil && requested.Title == "" {
+				c.server.markUntitled(session.ID)
+			}
+		}
+	case "session.messages":
+		c.server.bindSession(sessionID, agentID)
+	case "session.delete":
+		c.server.forgetSession(sessionID)
+	}
+}
+
+func (c *Client) handleViaAgentStream(ctx context.Context, requestID, agentID, sessionID, action string, tgt target, data json.RawMessage, timer *promptTimer, reply *replyRecorder) {
 	req := &tunnel.RequestPayload{
 		SessionID:   sessionID,
 		Action:      action,
 		Data:        data,
-		ProjectPath: projectPath,
+		ProjectPath: tgt.ProjectPath,
+		BaseURL:     tgt.BaseURL,
 	}
 
 	respCh, err := c.server.tunnelMgr.Forward(ctx, agentID, requestID, req)
@@ -540,19 +1293,43 @@ func (c *Client) handleViaAgentStream(ctx context.Context, requestID, agentID, s
 		return
 	}
 
-	// Stream responses
-	for msg := range respCh {
+	idle := newIdleTimer(c.server.config.StreamIdleTimeout)
+	defer idle.Stop()
+
+	// Stream responses until the agent ends the stream, the prompt is
+	// cancelled, or the stream goes silent
+	for {
+		var msg *tunnel.Message
+		select {
+		case m, ok := <-respCh:
+			if !ok {
+				return
+			}
+			msg = m
+			idle.Reset()
+		case <-ctx.Done():
+			c.server.tunnelMgr.Cancel(agentID, requestID)
+			c.sendError(requestID, "Request cancelled")
+			return
+		case <-idle.C():
+			c.server.tunnelMgr.Cancel(agentID, requestID)
+			c.sendError(requestID, "Stream idle timeout")
+			return
+		}
 		if msg == nil {
 			continue
 		}
 
 		switch msg.Type {
 		case tunnel.MsgTypeStream:
+			timer.chunk()
+			reply.chunk(msg.Payload)
 			// Buffer the message
 			bufMsg := buffer.Message{
 				Type:      "stream",
 				RequestID: requestID,
 				Payload:   msg.Payload,
+				Actor:     c.group,
 			}
 			msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
 
@@ -561,13 +1338,18 @@ func (c *Client) handleViaAgentStream(ctx context.Context, requestID, agentID, s
 				ID:      requestID,
 				MsgID:   msgID,
 				Payload: json.RawMessage(msg.Payload),
+				AgentID: agentID,
 			})
 
+		case tunnel.MsgTypeStreamUsage:
+			c.sendStreamUsage(ctx, requestID, sessionID, agentID, msg.Payload)
+
 		case tunnel.MsgTypeStreamEnd:
 			// Buffer stream end
 			bufMsg := buffer.Message{
 				Type:      "stream.end",
 				RequestID: requestID,
+				Actor:     c.group,
 			}
 			msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
 
@@ -576,18 +1358,61 @@ func (c *Client) handleViaAgentStream(ctx context.Context, requestID, agentID, s
 				ID:      requestID,
 				MsgID:   msgID,
 				Payload: nil,
+				AgentID: agentID,
 			})
+			timer.done(c.server.config.SlowPromptThreshold)
+			reply.end()
+			return
 
 		case tunnel.MsgTypeError:
+			if isAgentDisconnect(msg.Payload) {
+				c.finalizeInterruptedStream(ctx, requestID, sessionID)
+				return
+			}
 			c.sendMessage(ServerMessage{
 				Type:    "error",
 				ID:      requestID,
 				Payload: json.RawMessage(msg.Payload),
+				AgentID: agentID,
 			})
+			return
 		}
 	}
 }
 
+// StreamEndPayload is sent with a stream.end that did not complete normally
+type StreamEndPayload struct {
+	Resumable bool   `json:"resumable"`
+	Reason    string `json:"reason"`
+}
+
+func isAgentDisconnect(payload json.RawMessage) bool {
+	var e ErrorPayload
+	return json.Unmarshal(payload, &e) == nil && e.Code == tunnel.CodeAgentDisconnected
+}
+
+// finalizeInterruptedStream ends a prompt whose agent disconnected mid-stream.
+// OpenCode cannot resume a prompt on a new connection, so the partial reply
+// already in the buffer stands and the stream.end tells the client to re-prompt.
+// The end marker is buffered so clients that sync later see it too.
+func (c *Client) finalizeInterruptedStream(ctx context.Context, requestID, sessionID string) {
+	payload, _ := json.Marshal(StreamEndPayload{Resumable: false, Reason: "agent disconnected"})
+	bufMsg := buffer.Message{
+		Type:      "stream.end",
+		RequestID: requestID,
+		Payload:   payload,
+		Actor:     c.group,
+	}
+	msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
+
+	c.sendMessage(ServerMessage{
+		Type:    "stream.end",
+		ID:      requestID,
+		MsgID:   msgID,
+		Payload: json.RawMessage(payload),
+	})
+}
+
 func (c *Client) sendMessage(msg ServerMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -598,16 +1423,63 @@ func (c *Client) sendMessage(msg ServerMessage) {
 	select {
 	case c.send <- data:
 	default:
-		log.Printf("Client send buffer full, dropping message")
+		log.Printf("Client %s send buffer full, dropping message", c.conn.RemoteAddr())
+		c.markDropped()
 	}
 }
 
 func (c *Client) sendError(requestID string, errMsg string) {
+	c.sendErrorPayload(requestID, ErrorPayload{Error: errMsg})
+}
+
+func (c *Client) sendErrorPayload(requestID string, payload ErrorPayload) {
 	c.sendMessage(ServerMessage{
-		Type: "error",
-		ID:   requestID,
-		Payload: map[string]string{
-			"error": errMsg,
-		},
+		Type:    "error",
+		ID:      requestID,
+		Payload: payload,
 	})
 }
+
+// directAllowed reports whether c may fall back to the hub's own OpenCode
+// connection, which belongs to the default group
+func (c *Client) directAllowed() bool {
+	return c.group == ""
+}
+
+// directAvailable reports whether direct mode can serve c right now
+func (c *Client) directAvailable(ctx context.Context) bool {
+	return c.directAllowed() && c.server.proxy.Health(ctx) == nil
+}
+
+// sendNoAgent reports that no agent can serve the request. If an agent was
+// connected within the retry window it is probably reconnecting, so the error
+// is marked retryable; otherwise errMsg is sent as a terminal error.
+func (c *Client) sendNoAgent(requestID string, errMsg string) {
+	lastSeen := c.server.tunnelMgr.LastAgentSeen()
+	if !lastSeen.IsZero() && time.Since(lastSeen) < c.server.config.AgentRetryWindow {
+		c.sendErrorPayload(requestID, ErrorPayload{
+			Error:        "Agent is reconnecting, please retry shortly",
+			Code:         CodeAgentReconnecting,
+			Retryable:    true,
+			RetryAfterMs: 2000,
+		})
+		return
+	}
+
+	c.sendErrorPayload(requestID, ErrorPayload{Error: errMsg, Code: CodeNoAgent})
+}
+
+// sendSessionError reports an agentForSession error, flagging an offline
+// agent so the UI can ask for it to be brought back
+func (c *Client) sendSessionError(requestID string, err error) {
+	var offline *agentOfflineError
+	if errors.As(err, &offline) {
+		c.sendErrorPayload(requestID, ErrorPayload{
+			Error:   err.Error(),
+			Code:    CodeSessionAgentOffline,
+			AgentID: offline.agentID,
+		})
+		return
+	}
+	c.sendError(requestID, err.Error())
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openvibe/hub/internal/buffer"
+	"github.com/openvibe/hub/internal/config"
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// testClient returns a client of group on s with room for a few replies
+func testClient(s *Server, group string) *Client {
+	return &Client{server: s, group: group, send: make(chan []byte, 8)}
+}
+
+// nextMessage decodes the next message sent to c
+func nextMessage(t *testing.T, c *Client) ServerMessage {
+	t.Helper()
+	select {
+	case data := <-c.send:
+		var msg ServerMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message sent")
+		return ServerMessage{}
+	}
+}
+
+// decodeInto re-decodes a sent payload into v
+func decodeInto(t *testing.T, payload interface{}, v interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(payload)
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncStats(t *testing.T) {
+	buf := buffer.NewMemoryBuffer(time.Minute, 10)
+	s := &Server{
+		config:        &config.Config{ActionTimeout: time.Second},
+		buffer:        buf,
+		sessionGroups: map[string]string{"ses_a": "team-a"},
+	}
+	for i := 0; i < 2; i++ {
+		buf.Push(context.Background(), "ses_a", buffer.Message{Type: "stream"})
+	}
+
+	c := testClient(s, "team-a")
+	c.setSession("ses_a")
+	c.handleSyncStats("req-1", "") // Defaults to the connection's session
+	msg := nextMessage(t, c)
+	if msg.Type != "response" {
+		t.Fatalf("sync.stats: got %s %v", msg.Type, msg.Payload)
+	}
+	var reply struct {
+		SessionID string       `json:"sessionId"`
+		Stats     buffer.Stats `json:"stats"`
+	}
+	decodeInto(t, msg.Payload, &reply)
+	if reply.SessionID != "ses_a" || reply.Stats.Count != 2 || reply.Stats.LatestID != 2 {
+		t.Errorf("sync.stats = %+v", reply)
+	}
+
+	other := testClient(s, "team-b")
+	other.handleSyncStats("req-2", "ses_a")
+	if msg := nextMessage(t, other); msg.Type != "error" {
+		t.Errorf("sync.stats from another group: got %s, want error", msg.Type)
+	}
+}
+
+// fakeAgent registers an agent with mgr that never answers, and returns the
+// requests forwarded to it
+func fakeAgent(t *testing.T, mgr *tunnel.Manager) <-chan tunnel.Message {
+	t.Helper()
+	_, requests := connectFakeAgent(t, mgr, "agent-1")
+	return requests
+}
+
+// connectFakeAgent registers agentID with mgr and returns its connection, for
+// answering or dropping, and the requests forwarded to it
+func connectFakeAgent(t *testing.T, mgr *tunnel.Manager, agentID string) (*websocket.Conn, <-chan tunnel.Message) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(mgr.HandleAgentWebSocket))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.WriteJSON(tunnel.Message{
+		Type:    tunnel.MsgTypeRegister,
+		Payload: tunnel.MustMarshal(tunnel.RegisterPayload{AgentID: agentID}),
+	})
+	var registered tunnel.Message
+	if err := conn.ReadJSON(&registered); err != nil || registered.Type != tunnel.MsgTypeRegistered {
+		t.Fatalf("register %s: %v %s", agentID, err, registered.Type)
+	}
+
+	requests := make(chan tunnel.Message, 16)
+	go func() {
+		for {
+			var msg tunnel.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == tunnel.MsgTypeRequest {
+				requests <- msg
+			}
+		}
+	}()
+	return conn, requests
+}
+
+func TestDuplicatePromptConcurrent(t *testing.T) {
+	mgr := tunnel.NewManager(&tunnel.Config{})
+	requests := fakeAgent(t, mgr)
+	s := &Server{
+		config:        &config.Config{ActionTimeout: time.Second, StreamIdleTimeout: time.Minute},
+		buffer:        &buffer.NoopBuffer{},
+		tunnelMgr:     mgr,
+		sessionAgents: make(map[string]string),
+		sessionGroups: make(map[string]string),
+		sessionPaths:  make(map[string]string),
+		untitled:      make(map[string]bool),
+	}
+	conn, _ := wsPair(t)
+	c := &Client{server: s, conn: conn, send: make(chan []byte, 64), prompts: make(map[string]context.CancelFunc)}
+
+	// Both arrive at the duplicate check together, as a retry racing the
+	// original on another goroutine would
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			c.handlePrompt("req-1", PromptPayload{SessionID: "ses_a", Content: "hello"})
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	select {
+	case req := <-requests:
+		if req.ID != "req-1" {
+			t.Errorf("forwarded %s, want req-1", req.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("prompt never reached the agent")
+	}
+	select {
+	case req := <-requests:
+		t.Errorf("duplicate prompt forwarded too: %s", req.ID)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	c.promptsMu.Lock()
+	n := len(c.prompts)
+	c.promptsMu.Unlock()
+	if n != 1 {
+		t.Errorf("%d prompts in flight, want 1", n)
+	}
+	c.handlePromptCancel("req-2", "req-1")
+}
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string // Substring naming the problem; empty for success
+	}{
+		{"valid", `{"sessionId":"ses_a","content":"hi"}`, ""},
+		{"trailing whitespace", "{\"sessionId\":\"ses_a\"}\n", ""},
+		{"wrong case field", `{"sessionID":"ses_a","content":"hi"}`, `unknown field "sessionID" (did you mean "sessionId"?)`},
+		{"unknown field", `{"sessionId":"ses_a","prompt":"hi"}`, `unknown field "prompt"`},
+		{"wrong type", `{"sessionId":"ses_a","content":42}`, "PromptPayload.content"},
+		{"trailing value", `{"sessionId":"ses_a"}{"sessionId":"ses_b"}`, "unexpected data after payload"},
+		{"trailing garbage", `{"sessionId":"ses_a"} x`, "unexpected data after payload"},
+		{"empty", ``, "missing payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload PromptPayload
+			err := decodePayload(json.RawMessage(tt.data), &payload)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if payload.SessionID != "ses_a" {
+					t.Errorf("sessionId = %q", payload.SessionID)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+const (
+	// slowWriteThreshold is how long a single write may take before it counts as slow
+	slowWriteThreshold = 2 * time.Second
+	// maxSlowWrites is how many consecutive slow writes a client may make
+	// before it is disconnected
+	maxSlowWrites = 5
+	// maxDroppedMessages is how many messages may be dropped for a full send
+	// buffer over a connection's life before it is disconnected
+	maxDroppedMessages = 5
+)
+
+// Slow-client metrics
+var (
+	slowWrites        = metrics.NewCounter("server_slow_writes_total")
+	sendBufferFull    = metrics.NewCounter("server_send_buffer_full_total")
+	slowClientsClosed = metrics.NewCounter("server_slow_clients_closed_total")
+	writeTimeouts     = metrics.NewCounter("server_write_timeouts_total")
+)
+
+// write sends one frame with the write deadline and tracks how long it took.
+// Consecutive slow writes count towards maxSlowWrites; a fast write resets the count.
+func (c *Client) write(messageType int, data []byte) error {
+	start := time.Now()
+	c.conn.SetWriteDeadline(start.Add(writeWait))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			writeTimeouts.Inc()
+			log.Printf("Client %s write timed out after %v", c.conn.RemoteAddr(), writeWait)
+			c.closeWithReason(CloseSlowClient, "write timeout")
+		}
+		return err
+	}
+
+	if time.Since(start) < slowWriteThreshold {
+		c.slowWrites.Store(0)
+		return nil
+	}
+
+	slowWrites.Inc()
+	c.slowWrites.Add(1)
+	return c.checkSlow()
+}
+
+// markDropped records a message dropped because the send buffer was full.
+// Drops are never forgiven: the client has missed a message whatever its
+// writes do afterwards.
+func (c *Client) markDropped() {
+	sendBufferFull.Inc()
+	c.dropped.Add(1)
+	c.checkSlow()
+}
+
+// checkSlow disconnects the client once it has made maxSlowWrites slow
+// writes in a row or had maxDroppedMessages messages dropped
+func (c *Client) checkSlow() error {
+	slow, dropped := c.slowWrites.Load(), c.dropped.Load()
+	if slow < maxSlowWrites && dropped < maxDroppedMessages {
+		return nil
+	}
+
+	slowClientsClosed.Inc()
+	log.Printf("Client %s too slow (%d slow writes, %d dropped messages), disconnecting", c.conn.RemoteAddr(), slow, dropped)
+	c.closeWithReason(CloseSlowClient, "client too slow")
+	return websocket.ErrCloseSent
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the server side of a live websocket connection and the
+// client side dialled to it
+func wsPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upgrader websocket.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+// closeCode reads from conn until it is closed, returning the close code
+func closeCode(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			return ce.Code
+		}
+		if err != nil {
+			return 0
+		}
+	}
+}
+
+func TestDroppedMessagesSurviveFastWrites(t *testing.T) {
+	server, client := wsPair(t)
+	c := &Client{conn: server, send: make(chan []byte)} // Unbuffered: every send drops
+
+	for i := 0; i < maxDroppedMessages-1; i++ {
+		c.sendMessage(ServerMessage{Type: "stream"})
+		// A fast write in between must not forgive the drop
+		if err := c.write(websocket.TextMessage, []byte("{}")); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if n := c.dropped.Load(); n != maxDroppedMessages-1 {
+		t.Fatalf("dropped = %d, want %d", n, maxDroppedMessages-1)
+	}
+
+	c.sendMessage(ServerMessage{Type: "stream"})
+	if code := closeCode(t, client); code != CloseSlowClient {
+		t.Errorf("close code = %d, want %d", code, CloseSlowClient)
+	}
+}
+
+func TestFastWriteResetsSlowWrites(t *testing.T) {
+	server, _ := wsPair(t)
+	c := &Client{conn: server, send: make(chan []byte, 1)}
+
+	c.slowWrites.Store(maxSlowWrites - 1)
+	if err := c.write(websocket.TextMessage, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.slowWrites.Load(); n != 0 {
+		t.Errorf("slowWrites = %d after a fast write, want 0", n)
+	}
+	if err := c.checkSlow(); err != nil {
+		t.Errorf("checkSlow after a fast write: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/internal/buffer"
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+const (
+	// tombstonePurgeInterval is how often expired tombstones are deleted for real
+	tombstonePurgeInterval = 10 * time.Second
+	// tombstoneRetryDelay postpones a real delete that could not reach OpenCode
+	tombstoneRetryDelay = time.Minute
+)
+
+// softDeleteSession hides sessionID for the grace period instead of deleting it
+func (c *Client) softDeleteSession(ctx context.Context, requestID, sessionID, baseURL string) {
+	if !c.server.sessionInGroup(c.group, sessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+	agentID, bound := c.server.boundAgent(sessionID)
+	if !bound && c.group != "" {
+		// The purge falls back to default group agents, so pin the agent now
+		agent, ok := c.server.tunnelMgr.GetAnyAgent(c.group)
+		if !ok {
+			c.sendNoAgent(requestID, "No agent connected")
+			return
+		}
+		agentID = agent.ID
+	}
+	deleteAt := time.Now().Add(c.server.config.SessionDeleteGrace).UnixMilli()
+
+	// Kept with the tombstone so the purge reaches the session's project
+	tgt := c.server.sessionTarget(sessionID, target{BaseURL: baseURL})
+	err := c.server.tombstones.Tombstone(ctx, buffer.Tombstone{
+		SessionID:   sessionID,
+		AgentID:     agentID,
+		ProjectPath: tgt.ProjectPath,
+		BaseURL:     tgt.BaseURL,
+		DeleteAt:    deleteAt,
+	})
+	if err != nil {
+		c.sendError(requestID, "Failed to delete session: "+err.Error())
+		return
+	}
+
+	c.sendMessage(ServerMessage{
+		Type: "response",
+		ID:   requestID,
+		Payload: map[string]interface{}{
+			"success":      true,
+			"sessionId":    sessionID,
+			"restoreUntil": deleteAt,
+		},
+	})
+}
+
+func (c *Client) handleSessionRestore(requestID string, payload SessionPayload) {
+	if c.server.tombstones == nil {
+		c.sendError(requestID, "Session restore is not enabled")
+		return
+	}
+	if payload.SessionID == "" {
+		c.sendError(requestID, "No session ID provided")
+		return
+	}
+	if !c.server.sessionInGroup(c.group, payload.SessionID) {
+		c.sendError(requestID, errSessionNotFound.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.server.config.ActionTimeout)
+	defer cancel()
+
+	restored, err := c.server.tombstones.Restore(ctx, payload.SessionID)
+	if err != nil {
+		c.sendError(requestID, err.Error())
+		return
+	}
+	if !restored {
+		c.sendError(requestID, "Session not deleted or grace period elapsed")
+		return
+	}
+
+	c.sendMessage(ServerMessage{
+		Type:    "response",
+		ID:      requestID,
+		Payload: map[string]interface{}{"success": true, "sessionId": payload.SessionID},
+	})
+}
+
+// tombstonedSessions returns the soft-deleted session IDs, or nil if soft
+// delete is off or the lookup fails
+func (s *Server) tombstonedSessions(ctx context.Context) map[string]bool {
+	if s.tombstones == nil {
+		return nil
+	}
+	hidden, err := s.tombstones.Tombstoned(ctx)
+	if err != nil {
+		log.Printf("Failed to load tombstones: %v", err)
+		return nil
+	}
+	return hidden
+}
+
+// purgeTombstones deletes sessions whose grace period has ended
+func (s *Server) purgeTombstones() {
+	ticker := time.NewTicker(tombstonePurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), tombstonePurgeInterval)
+		expired, err := s.tombstones.ClaimExpired(ctx)
+		if err != nil {
+			log.Printf("Failed to claim expired tombstones: %v", err)
+		}
+		for _, t := range expired {
+			if err := s.deleteSession(ctx, t); err != nil {
+				log.Printf("Delete of session %s failed, retrying in %v: %v", t.SessionID, tombstoneRetryDelay, err)
+				t.DeleteAt = time.Now().Add(tombstoneRetryDelay).UnixMilli()
+				s.tombstones.Tombstone(ctx, t)
+				continue
+			}
+			s.forgetSession(t.SessionID)
+			log.Printf("Deleted session %s after grace period", t.SessionID)
+		}
+		cancel()
+	}
+}
+
+// deleteSession permanently deletes a tombstoned session through its agent,
+// any default group agent, or direct mode
+func (s *Server) deleteSession(ctx context.Context, t buffer.Tombstone) error {
+	agentID := t.AgentID
+	if agentID == "" {
+		if agent, ok := s.tunnelMgr.GetAnyAgent(""); ok {
+			agentID = agent.ID
+		}
+	} else if _, ok := s.tunnelMgr.GetAgent(agentID); !ok {
+		return &agentOfflineError{agentID}
+	}
+
+	if agentID == "" {
+		return s.proxy.DeleteSession(ctx, t.SessionID)
+	}
+
+	data, _ := json.Marshal(map[string]string{"sessionId": t.SessionID})
+	requestID := fmt.Sprintf("purge-%s-%d", t.SessionID, time.Now().UnixNano())
+	tgt := s.sessionTarget(t.SessionID, target{ProjectPath: t.ProjectPath, BaseURL: t.BaseURL})
+	return s.forwardAndWait(ctx, agentID, requestID, &tunnel.RequestPayload{
+		SessionID:   t.SessionID,
+		Action:      "session.delete",
+		Data:        data,
+		ProjectPath: tgt.ProjectPath,
+		BaseURL:     tgt.BaseURL,
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+// Default session title strategies, selected by config.SessionTitle
+const (
+	TitleNone        = "none"         // Leave untitled sessions to the backend
+	TitleTimestamp   = "timestamp"    // "Session 2006-01-02 15:04"
+	TitleFirstPrompt = "first-prompt" // Rename from the first line of the first prompt
+)
+
+const maxTitleLength = 60
+
+// defaultTitle returns the title to use for a session created without one
+func (s *Server) defaultTitle(now time.Time) string {
+	if s.config.SessionTitle == TitleTimestamp {
+		return "Session " + now.Format("2006-01-02 15:04")
+	}
+	return ""
+}
+
+// titleFromPrompt derives a session title from the first non-empty line of content
+func titleFromPrompt(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if utf8.RuneCountInString(line) > maxTitleLength {
+			line = string([]rune(line)[:maxTitleLength-1]) + "…"
+		}
+		return line
+	}
+	return ""
+}
+
+// markUntitled records that sessionID should be renamed after its first prompt
+func (s *Server) markUntitled(sessionID string) {
+	if s.config.SessionTitle != TitleFirstPrompt || sessionID == "" {
+		return
+	}
+	s.titleMu.Lock()
+	s.untitled[sessionID] = true
+	s.titleMu.Unlock()
+}
+
+// takeUntitled reports whether sessionID awaits a title and clears the mark
+func (s *Server) takeUntitled(sessionID string) bool {
+	s.titleMu.Lock()
+	defer s.titleMu.Unlock()
+	if !s.untitled[sessionID] {
+		return false
+	}
+	delete(s.untitled, sessionID)
+	return true
+}
+
+// autoTitle renames an untitled session from its first prompt in the background
+func (s *Server) autoTitle(sessionID, agentID, content string) {
+	if !s.takeUntitled(sessionID) {
+		return
+	}
+	title := titleFromPrompt(content)
+	if title == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.ActionTimeout)
+		defer cancel()
+		if err := s.renameSession(ctx, sessionID, agentID, title); err != nil {
+			log.Printf("Auto-title failed for session %s: %v", sessionID, err)
+		}
+	}()
+}
+
+// renameSession sets a session title via agentID, or directly when agentID is empty
+func (s *Server) renameSession(ctx context.Context, sessionID, agentID, title string) error {
+	if agentID == "" {
+		return s.proxy.RenameSession(ctx, sessionID, title)
+	}
+
+	data, _ := json.Marshal(map[string]string{"sessionId": sessionID, "title": title})
+	requestID := fmt.Sprintf("autotitle-%s-%d", sessionID, time.Now().UnixNano())
+	tgt := s.sessionTarget(sessionID, target{})
+	return s.forwardAndWait(ctx, agentID, requestID, &tunnel.RequestPayload{
+		SessionID:   sessionID,
+		Action:      "session.rename",
+		Data:        data,
+		ProjectPath: tgt.ProjectPath,
+		BaseURL:     tgt.BaseURL,
+	})
+}
+
+// forwardAndWait forwards a hub-initiated request to an agent and waits for
+// its single response, returning the agent's error if it sent one
+func (s *Server) forwardAndWait(ctx context.Context, agentID, requestID string, req *tunnel.RequestPayload) error {
+	_, err := s.forwardForResponse(ctx, agentID, requestID, req)
+	return err
+}
+
+// forwardForResponse is forwardAndWait returning the response payload
+func (s *Server) forwardForResponse(ctx context.Context, agentID, requestID string, req *tunnel.RequestPayload) (json.RawMessage, error) {
+	respCh, err := s.tunnelMgr.Forward(ctx, agentID, requestID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg, ok := <-respCh:
+		if !ok || msg == nil {
+			return nil, nil
+		}
+		if msg.Type == tunnel.MsgTypeError {
+			return nil, fmt.Errorf("agent error: %s", string(msg.Payload))
+		}
+		return msg.Payload, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openvibe/hub/internal/tunnel"
+)
+
+const (
+	// projectListTimeout bounds each agent's project.list when aggregating
+	// agents; slower agents are reported without their projects
+	projectListTimeout = 5 * time.Second
+	// agentListTTL is how long a complete agent.list is reused per group
+	agentListTTL = 5 * time.Second
+)
+
+// AgentListEntry is one agent in an agent.list response
+type AgentListEntry struct {
+	ID              string          `json:"id"`
+	Version         string          `json:"version"`
+	OpenCodeVersion string          `json:"opencodeVersion,omitempty"`
+	Draining        bool            `json:"draining"`
+	Paths           []string        `json:"paths,omitempty"`    // Project paths the agent registered
+	Projects        json.RawMessage `json:"projects,omitempty"` // Its project.list, omitted if it failed
+	Error           string          `json:"error,omitempty"`    // Why Projects is missing
+}
+
+// AgentListPayload is the response to agent.list. Partial is set when some
+// agent's projects are missing.
+type AgentListPayload struct {
+	Agents  []AgentListEntry `json:"agents"`
+	Partial bool             `json:"partial"`
+}
+
+// agentListCache holds the last complete agent.list of each group
+type agentListCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedAgentList
+}
+
+type cachedAgentList struct {
+	payload AgentListPayload
+	expires time.Time
+}
+
+func (c *agentListCache) get(group string) (AgentListPayload, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[group]
+	if !ok || time.Now().After(cached.expires) {
+		return AgentListPayload{}, false
+	}
+	return cached.payload, true
+}
+
+func (c *agentListCache) put(group string, payload AgentListPayload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedAgentList)
+	}
+	c.entries[group] = cachedAgentList{payload: payload, expires: time.Now().Add(agentListTTL)}
+}
+
+// handleAgentList answers agent.list with the agents of the client's group
+// and their projects
+func (c *Client) handleAgentList(requestID string) {
+	payload, ok := c.server.agentList.get(c.group)
+	if !ok {
+		payload = c.server.listAgents(context.Background(), c.group)
+		// Partial results are not reused, so a retry asks slow agents again
+		if !payload.Partial {
+			c.server.agentList.put(c.group, payload)
+		}
+	}
+	c.sendMessage(ServerMessage{Type: "response", ID: requestID, Payload: payload})
+}
+
+// listAgents collects the connected agents of group and asks each for its
+// projects in parallel, reporting agents that fail or are slow without them
+func (s *Server) listAgents(ctx context.Context, group string) AgentListPayload {
+	var agents []AgentListEntry
+	for _, info := range s.tunnelMgr.ListAgentInfo() {
+		if info.Group != group {
+			continue
+		}
+		agents = append(agents, AgentListEntry{
+			ID:              info.ID,
+			Version:         info.Version,
+			OpenCodeVersion: info.OpenCodeVersion,
+			Draining:        info.Draining,
+			Paths:           info.Projects,
+		})
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+
+	var wg sync.WaitGroup
+	for i := range agents {
+		wg.Add(1)
+		go func(agent *AgentListEntry) {
+			defer wg.Done()
+			projects, err := s.fetchProjectList(ctx, agent.ID)
+			if err != nil {
+				agent.Error = err.Error()
+				return
+			}
+			agent.Projects = projects
+		}(&agents[i])
+	}
+	wg.Wait()
+
+	payload := AgentListPayload{Agents: agents}
+	if payload.Agents == nil {
+		payload.Agents = []AgentListEntry{}
+	}
+	for _, agent := range agents {
+		if agent.Projects == nil {
+			payload.Partial = true
+		}
+	}
+	return payload
+}
+
+// fetchProjectList returns an agent's project.list, waiting at most
+// projectListTimeout
+func (s *Server) fetchProjectList(ctx context.Context, agentID string) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, projectListTimeout)
+	defer cancel()
+	requestID := fmt.Sprintf("projects-%s-%d", agentID, time.Now().UnixNano())
+	resp, err := s.forwardForResponse(ctx, agentID, requestID, &tunnel.RequestPayload{Action: "project.list"})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.New("agent did not answer in time")
+		}
+		return nil, err
+	}
+	if resp == nil {
+		return nil, errors.New("agent closed the request")
+	}
+	var list struct {
+		Projects json.RawMessage `json:"projects"`
+		Error    string          `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &list); err != nil {
+		return nil, err
+	}
+	if list.Projects == nil {
+		if list.Error != "" {
+			return nil, errors.New(list.Error)
+		}
+		return nil, errors.New("agent returned no projects")
+	}
+	return list.Projects, nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/openvibe/hub/internal/buffer"
+)
+
+// StreamUsagePayload reports what a prompt's reply cost, sent as stream.usage
+// just before its stream.end
+type StreamUsagePayload struct {
+	buffer.Usage
+	ModelID    string `json:"modelId,omitempty"`
+	ProviderID string `json:"providerId,omitempty"`
+
+	// SessionTotal is the session's running total including this reply,
+	// absent when the buffer keeps no totals
+	SessionTotal *buffer.Usage `json:"sessionTotal,omitempty"`
+}
+
+// sendStreamUsage buffers and relays a reply's usage report, adding it to the
+// session's running total first. agentID is empty in direct mode.
+func (c *Client) sendStreamUsage(ctx context.Context, requestID, sessionID, agentID string, data json.RawMessage) {
+	var usage StreamUsagePayload
+	if err := json.Unmarshal(data, &usage); err != nil {
+		log.Printf("Dropping malformed usage report for session %s: %v", sessionID, err)
+		return
+	}
+	if c.server.usage != nil {
+		total, err := c.server.usage.AddUsage(ctx, sessionID, usage.Usage)
+		if err != nil {
+			log.Printf("Failed to add usage for session %s: %v", sessionID, err)
+		} else {
+			usage.SessionTotal = &total
+		}
+	}
+	payload, _ := json.Marshal(usage)
+
+	bufMsg := buffer.Message{
+		Type:      "stream.usage",
+		RequestID: requestID,
+		Payload:   payload,
+		Actor:     c.group,
+	}
+	msgID, _ := c.server.buffer.Push(ctx, sessionID, bufMsg)
+
+	c.sendMessage(ServerMessage{
+		Type:    "stream.usage",
+		ID:      requestID,
+		MsgID:   msgID,
+		Payload: json.RawMessage(payload),
+		AgentID: agentID,
+	})
+}
@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// maxReassembledSize bounds a message rebuilt from chunks
+const maxReassembledSize = 64 * 1024 * 1024
+
+// partialMessage is a chunked message being reassembled
+type partialMessage struct {
+	msgType string
+	nextSeq int
+	data    bytes.Buffer
+}
+
+// reassemble adds a chunk to its partial message. It returns the complete
+// message once the last chunk arrives, and nil while more are expected. On a
+// malformed sequence the partial message is discarded and an agent.error for
+// the request is returned instead. Only the agent's readPump calls it.
+func (a *Agent) reassemble(msg *Message) *Message {
+	var chunk ChunkPayload
+	if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+		return chunkError(msg.ID, fmt.Sprintf("invalid chunk: %v", err))
+	}
+
+	partial, ok := a.partials[msg.ID]
+	if !ok {
+		partial = &partialMessage{msgType: chunk.Type}
+		a.partials[msg.ID] = partial
+	}
+
+	if chunk.Seq != partial.nextSeq || chunk.Type != partial.msgType {
+		delete(a.partials, msg.ID)
+		return chunkError(msg.ID, fmt.Sprintf("chunk out of sequence: got %d, want %d", chunk.Seq, partial.nextSeq))
+	}
+	if partial.data.Len()+len(chunk.Data) > maxReassembledSize {
+		delete(a.partials, msg.ID)
+		return chunkError(msg.ID, fmt.Sprintf("response exceeds %d bytes", maxReassembledSize))
+	}
+
+	partial.data.Write(chunk.Data)
+	partial.nextSeq++
+	if !chunk.Last {
+		return nil
+	}
+
+	delete(a.partials, msg.ID)
+	return &Message{Type: partial.msgType, ID: msg.ID, Payload: partial.data.Bytes()}
+}
+
+func chunkError(requestID, reason string) *Message {
+	log.Printf("Dropping chunked message %s: %s", requestID, reason)
+	return &Message{
+		Type:    MsgTypeError,
+		ID:      requestID,
+		Payload: MustMarshal(map[string]string{"error": reason}),
+	}
+}
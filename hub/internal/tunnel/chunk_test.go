@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// agentChunkSize is the agent's chunk data size (chunkDataSize in the agent)
+const agentChunkSize = 512 * 1024
+
+// connectAgent serves one agent connection through readPump, as
+// HandleAgentWebSocket does after registration, and returns the registered
+// agent and the agent's end of the connection
+func connectAgent(t *testing.T, m *Manager, id string) (*Agent, *websocket.Conn) {
+	t.Helper()
+	agents := make(chan *Agent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.SetReadLimit(maxMessageSize)
+		agent := testAgent(m, id)
+		agent.Conn = conn
+		agent.partials = make(map[string]*partialMessage)
+		agents <- agent
+		m.readPump(agent)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return <-agents, conn
+}
+
+// writeChunks sends payload as the agent does when it exceeds a frame
+func writeChunks(t *testing.T, conn *websocket.Conn, msgType, id string, payload []byte) {
+	t.Helper()
+	for seq := 0; len(payload) > 0 || seq == 0; seq++ {
+		n := min(len(payload), agentChunkSize)
+		chunk, _ := json.Marshal(ChunkPayload{Type: msgType, Seq: seq, Last: n == len(payload), Data: payload[:n]})
+		payload = payload[n:]
+		if err := conn.WriteJSON(Message{Type: MsgTypeChunk, ID: id, Payload: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func nextResponse(t *testing.T, ch <-chan *Message) *Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response")
+		return nil
+	}
+}
+
+func TestReassembleLargeResponse(t *testing.T) {
+	m := NewManager(&Config{})
+	agent, conn := connectAgent(t, m, "agent-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := m.Forward(ctx, agent.ID, "req-1", &RequestPayload{Action: "session.messages"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	b.WriteString(`{"messages":["`)
+	for i := 0; b.Len() < 5*1024*1024; i++ {
+		fmt.Fprintf(&b, "message %d of a long session. ", i)
+	}
+	b.WriteString(`"]}`)
+	payload := []byte(b.String())
+	writeChunks(t, conn, MsgTypeResponse, "req-1", payload)
+
+	msg := nextResponse(t, ch)
+	if msg.Type != MsgTypeResponse || !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("got %s of %d bytes, want the %d byte response", msg.Type, len(msg.Payload), len(payload))
+	}
+}
+
+func TestReassembleOutOfSequence(t *testing.T) {
+	m := NewManager(&Config{})
+	agent, conn := connectAgent(t, m, "agent-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := m.Forward(ctx, agent.ID, "req-1", &RequestPayload{Action: "session.messages"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, seq := range []int{0, 2} {
+		chunk, _ := json.Marshal(ChunkPayload{Type: MsgTypeResponse, Seq: seq, Data: []byte("part")})
+		conn.WriteJSON(Message{Type: MsgTypeChunk, ID: "req-1", Payload: chunk})
+	}
+
+	if msg := nextResponse(t, ch); msg.Type != MsgTypeError {
+		t.Errorf("got %s, want %s for a skipped chunk", msg.Type, MsgTypeError)
+	}
+}
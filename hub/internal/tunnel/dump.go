@@ -0,0 +1,40 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// dumpPayloadLimit caps how much of each payload a protocol dump logs
+const dumpPayloadLimit = 256
+
+// dump logs a tunnel message when Config.Debug is set. dir is "<-" for
+// messages from the agent and "->" for messages to it. Register payloads
+// carry the agent token and are never logged.
+func (m *Manager) dump(dir, agentID string, msg *Message) {
+	if !m.config.Debug {
+		return
+	}
+
+	payload := string(msg.Payload)
+	if msg.Type == MsgTypeRegister {
+		payload = "[redacted]"
+	} else if len(payload) > dumpPayloadLimit {
+		payload = payload[:dumpPayloadLimit] + "...(truncated)"
+	}
+	log.Printf("[tunnel] %s %s type=%s id=%s payload=%s", dir, agentID, msg.Type, msg.ID, payload)
+}
+
+// dumpRaw is dump for an already encoded message
+func (m *Manager) dumpRaw(dir, agentID string, data []byte) {
+	if !m.config.Debug {
+		return
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("[tunnel] %s %s undecodable message (%d bytes)", dir, agentID, len(data))
+		return
+	}
+	m.dump(dir, agentID, &msg)
+}
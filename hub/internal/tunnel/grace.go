@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"log"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+	"github.com/openvibe/hub/internal/webhooks"
+)
+
+// Offline grace metrics
+var (
+	agentsSuspected = metrics.NewGauge("tunnel_agents_suspected_offline")
+	agentsResumed   = metrics.NewCounter("tunnel_agents_resumed_total")
+)
+
+// suspect holds a disconnected agent's pending requests for
+// Config.OfflineGrace instead of failing them, in case it reconnects.
+// Callers hold m.mu and have removed the agent from m.agents.
+func (m *Manager) suspect(agent *Agent) {
+	m.suspected[agent.ID] = agent
+	agentsSuspected.Set(int64(len(m.suspected)))
+	agent.graceTimer = time.AfterFunc(m.config.OfflineGrace, func() { m.expire(agent) })
+}
+
+// expire gives up on a suspected agent that didn't reconnect in time,
+// failing its requests and announcing it offline
+func (m *Manager) expire(agent *Agent) {
+	m.mu.Lock()
+	if m.suspected[agent.ID] != agent {
+		// Resumed by a new connection, or replaced
+		m.mu.Unlock()
+		return
+	}
+	delete(m.suspected, agent.ID)
+	agentsSuspected.Set(int64(len(m.suspected)))
+	m.mu.Unlock()
+
+	agent.failRequests()
+	log.Printf("Agent disconnected: %s (did not reconnect within %v)", agent.ID, m.config.OfflineGrace)
+	m.config.Webhooks.Fire(webhooks.Event{Type: webhooks.EventAgentDisconnected, AgentID: agent.ID})
+	m.notifyPresence(agent, false)
+}
+
+// resume hands a suspected agent's requests to its new connection and
+// reports whether there was one to resume. Callers hold m.mu.
+func (m *Manager) resume(agent *Agent) bool {
+	old, ok := m.suspected[agent.ID]
+	if !ok || old.Group != agent.Group {
+		return false
+	}
+	delete(m.suspected, agent.ID)
+	agentsSuspected.Set(int64(len(m.suspected)))
+	old.graceTimer.Stop()
+	n := agent.adopt(old)
+	agentsResumed.Inc()
+	log.Printf("Agent %s reconnected within grace, resuming %d pending requests", agent.ID, n)
+	return true
+}
+
+// adopt moves old's pending requests to a, so responses the agent sends on
+// its new connection reach the handlers still waiting on them. It returns
+// how many were moved.
+func (a *Agent) adopt(old *Agent) int {
+	old.mu.Lock()
+	requests := old.requests
+	old.requests = make(map[string]chan *Message)
+	old.mu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, ch := range requests {
+		a.requests[id] = ch
+	}
+	return len(requests)
+}
+
+// dropRequest forgets a finished request on agent, and on the connection
+// that took it over if the agent reconnected since
+func (m *Manager) dropRequest(agent *Agent, requestID string) {
+	agent.mu.Lock()
+	delete(agent.requests, requestID)
+	agent.mu.Unlock()
+
+	m.mu.RLock()
+	current, ok := m.agents[agent.ID]
+	if !ok {
+		current, ok = m.suspected[agent.ID]
+	}
+	m.mu.RUnlock()
+	if ok && current != agent {
+		current.mu.Lock()
+		delete(current.requests, requestID)
+		current.mu.Unlock()
+	}
+}
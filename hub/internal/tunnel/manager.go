@@ -6,12 +6,18 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/openvibe/hub/internal/config"
+	"github.com/openvibe/hub/internal/metrics"
+	"github.com/openvibe/hub/internal/webhooks"
 )
 
 // Errors
@@ -20,6 +26,8 @@ var (
 	ErrAgentOffline  = errors.New("agent offline")
 	ErrUnauthorized  = errors.New("unauthorized")
 	ErrTimeout       = errors.New("request timeout")
+
+	errSendQueueFull = errors.New("agent send buffer full")
 )
 
 // Constants for WebSocket handling
@@ -28,6 +36,32 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 1024 * 1024
+
+	// DefaultSendQueueSize is the outbound message buffer per agent
+	DefaultSendQueueSize = 256
+	// DefaultResponseQueueSize is the response buffer per forwarded request
+	DefaultResponseQueueSize = 100
+	// projectStatusBuffer is the capacity of the ProjectStatus channel
+	projectStatusBuffer = 64
+	// DefaultFlapWindow is how soon a re-registration of a connected ID counts as flapping
+	DefaultFlapWindow = time.Minute
+	// DefaultRequestLifetime is how long a forwarded request may stay pending
+	DefaultRequestLifetime = time.Hour
+)
+
+// Queue metrics, used to tune SendQueueSize and ResponseQueueSize
+var (
+	sendQueueHighWater     = metrics.NewGauge("tunnel_send_queue_high_water")
+	sendQueueFull          = metrics.NewCounter("tunnel_send_queue_full_total")
+	responseQueueHighWater = metrics.NewGauge("tunnel_response_queue_high_water")
+	responseQueueFull      = metrics.NewCounter("tunnel_response_queue_full_total")
+	agentIDFlaps           = metrics.NewCounter("tunnel_agent_id_flaps_total")
+	agentIDRejected        = metrics.NewCounter("tunnel_agent_id_rejected_total")
+	agentsConnected        = metrics.NewGauge("tunnel_agents_connected")
+	agentsMax              = metrics.NewGauge("tunnel_agents_max")
+	agentsAtCapacity       = metrics.NewCounter("tunnel_agents_rejected_capacity_total")
+	agentsDeregistered     = metrics.NewCounter("tunnel_agents_deregistered_total")
+	requestsExpired        = metrics.NewCounter("tunnel_requests_expired_total")
 )
 
 var upgrader = websocket.Upgrader{
@@ -40,27 +74,69 @@ var upgrader = websocket.Upgrader{
 
 // Config holds tunnel manager configuration
 type Config struct {
-	AgentToken   string        // Pre-shared secret for agent auth
+	AgentToken string // Pre-shared secret for agent auth
+	// LiveAgentToken is AgentToken as rotated by a token file, old and new
+	// both valid during the grace window (nil = AgentToken as is)
+	LiveAgentToken *config.RotatingToken
+	// AgentGroups maps further agent tokens to the group their agents join.
+	// Agents using AgentToken join the default group "".
+	AgentGroups  map[string]string
 	PingInterval time.Duration // How often to ping agents
 	PongTimeout  time.Duration // How long to wait for pong
+
+	SendQueueSize     int // Outbound messages buffered per agent (default 256)
+	ResponseQueueSize int // Responses buffered per forwarded request (default 100)
+
+	MaxAgents int // Concurrent agents allowed; further registrations are rejected (0 = unlimited)
+
+	RejectDuplicateIDs bool          // Reject a registration whose ID is already connected instead of replacing it
+	FlapWindow         time.Duration // Re-registrations of a connected ID within this window are flapping (default 1m)
+
+	// OfflineGrace holds a dropped agent's pending requests this long before
+	// failing them; if it reconnects meanwhile they continue on the new
+	// connection (0 = fail them at once)
+	OfflineGrace time.Duration
+
+	// RequestLifetime bounds how long a forwarded request stays pending
+	// whatever its context, so a stream that never ends can't hold its
+	// entry forever (default DefaultRequestLifetime)
+	RequestLifetime time.Duration
+
+	Webhooks *webhooks.Dispatcher // Notified of agent connects and disconnects (nil = off)
+
+	Debug bool // Log every tunnel message (type, ID, truncated payload); off in production
 }
 
 // Manager manages agent connections
 type Manager struct {
-	config *Config
-	agents map[string]*Agent
-	mu     sync.RWMutex
+	config         *Config
+	agents         map[string]*Agent
+	lastRegistered map[string]time.Time // agentID -> last successful registration
+	lastSeen       time.Time            // When an agent was last connected
+	suspected      map[string]*Agent    // Dropped agents within OfflineGrace, by ID
+	projectStatus  chan ProjectStatusEvent
+	presence       chan PresenceEvent
+	mu             sync.RWMutex
 }
 
 // Agent represents a connected agent
 type Agent struct {
 	ID           string
+	Group        string // Clients only reach agents of their own group
 	Conn         *websocket.Conn
 	Capabilities []string
 	LastSeen     time.Time
-	send         chan []byte
-	requests     map[string]chan *Message // requestID -> response channel
-	mu           sync.RWMutex
+	Version      string
+	// OpenCodeVersion is the agent's default OpenCode instance version
+	OpenCodeVersion string
+	Projects        []string    // Project paths the agent serves, from registration
+	Draining        bool        // Finishing in-flight work, not taking new requests
+	graceTimer      *time.Timer // Runs out OfflineGrace while suspected offline
+	send            chan []byte
+	sendClosed      bool                       // send is closed; guarded by mu
+	requests        map[string]chan *Message   // requestID -> response channel
+	partials        map[string]*partialMessage // requestID -> chunks so far, readPump only
+	mu              sync.RWMutex
 }
 
 // NewManager creates a new tunnel manager
@@ -71,12 +147,58 @@ func NewManager(cfg *Config) *Manager {
 	if cfg.PongTimeout == 0 {
 		cfg.PongTimeout = pongWait
 	}
+	if cfg.SendQueueSize == 0 {
+		cfg.SendQueueSize = DefaultSendQueueSize
+	}
+	if cfg.ResponseQueueSize == 0 {
+		cfg.ResponseQueueSize = DefaultResponseQueueSize
+	}
+	if cfg.FlapWindow == 0 {
+		cfg.FlapWindow = DefaultFlapWindow
+	}
+	if cfg.RequestLifetime <= 0 {
+		cfg.RequestLifetime = DefaultRequestLifetime
+	}
+	agentsMax.Set(int64(cfg.MaxAgents))
 	return &Manager{
-		config: cfg,
-		agents: make(map[string]*Agent),
+		config:         cfg,
+		agents:         make(map[string]*Agent),
+		lastRegistered: make(map[string]time.Time),
+		suspected:      make(map[string]*Agent),
+		projectStatus:  make(chan ProjectStatusEvent, projectStatusBuffer),
+		presence:       make(chan PresenceEvent, projectStatusBuffer),
+	}
+}
+
+// ProjectStatus returns project status changes pushed by agents
+func (m *Manager) ProjectStatus() <-chan ProjectStatusEvent {
+	return m.projectStatus
+}
+
+// Presence returns agents coming online and going offline
+func (m *Manager) Presence() <-chan PresenceEvent {
+	return m.presence
+}
+
+// notifyPresence publishes a presence change, dropping it if nobody keeps up
+func (m *Manager) notifyPresence(agent *Agent, online bool) {
+	select {
+	case m.presence <- PresenceEvent{AgentID: agent.ID, Group: agent.Group, Online: online}:
+	default:
+		log.Printf("Presence channel full, dropping update for agent %s", agent.ID)
 	}
 }
 
+// rejectRegister answers a register with a failure and closes the connection
+func rejectRegister(conn *websocket.Conn, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteJSON(Message{
+		Type:    MsgTypeRegistered,
+		Payload: MustMarshal(RegisteredPayload{Success: false, Error: reason, ProtocolVersion: ProtocolVersion}),
+	})
+	conn.Close()
+}
+
 // HandleAgentWebSocket handles agent WebSocket connections
 func (m *Manager) HandleAgentWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -94,58 +216,116 @@ func (m *Manager) HandleAgentWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Rejections are answered before closing so the agent can log the reason
 	var msg Message
 	if err := json.Unmarshal(data, &msg); err != nil {
 		log.Printf("Agent invalid register message: %v", err)
-		conn.Close()
+		rejectRegister(conn, "malformed register: "+err.Error())
 		return
 	}
+	m.dump("<-", conn.RemoteAddr().String(), &msg)
 
 	if msg.Type != MsgTypeRegister {
 		log.Printf("Agent expected register, got: %s", msg.Type)
-		conn.Close()
+		rejectRegister(conn, "expected "+MsgTypeRegister+", got "+msg.Type)
 		return
 	}
 
 	var payload RegisterPayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		log.Printf("Agent invalid register payload: %v", err)
-		conn.Close()
+		rejectRegister(conn, "malformed register: "+err.Error())
+		return
+	}
+	if payload.AgentID == "" {
+		log.Printf("Agent register from %s without an agent ID", conn.RemoteAddr())
+		rejectRegister(conn, "malformed register: missing agentId")
+		return
+	}
+	if payload.ProtocolVersion > ProtocolVersion {
+		log.Printf("Agent %s speaks protocol %d, hub supports up to %d", payload.AgentID, payload.ProtocolVersion, ProtocolVersion)
+		rejectRegister(conn, fmt.Sprintf("protocol version mismatch: agent %d, hub supports up to %d; upgrade the hub", payload.ProtocolVersion, ProtocolVersion))
 		return
 	}
 
 	// Validate token
-	if m.config.AgentToken != "" {
-		if subtle.ConstantTimeCompare([]byte(payload.Token), []byte(m.config.AgentToken)) != 1 {
-			log.Printf("Agent unauthorized: %s", payload.AgentID)
-			conn.WriteJSON(Message{
-				Type:    MsgTypeRegistered,
-				Payload: MustMarshal(RegisteredPayload{Success: false, Error: "unauthorized"}),
-			})
-			conn.Close()
-			return
-		}
+	group, ok := m.authenticate(payload.Token)
+	if !ok {
+		log.Printf("Agent unauthorized: %s", payload.AgentID)
+		rejectRegister(conn, "unauthorized")
+		return
 	}
 
 	agent := &Agent{
-		ID:           payload.AgentID,
-		Conn:         conn,
-		Capabilities: payload.Capabilities,
-		LastSeen:     time.Now(),
-		send:         make(chan []byte, 256),
-		requests:     make(map[string]chan *Message),
+		ID:              payload.AgentID,
+		Group:           group,
+		Conn:            conn,
+		Capabilities:    payload.Capabilities,
+		LastSeen:        time.Now(),
+		Version:         payload.Version,
+		OpenCodeVersion: payload.OpenCodeVersion,
+		Projects:        payload.Projects,
+		send:            make(chan []byte, m.config.SendQueueSize),
+		requests:        make(map[string]chan *Message),
+		partials:        make(map[string]*partialMessage),
 	}
 
 	// Register agent
 	m.mu.Lock()
-	// Close existing connection if any
-	if existing, ok := m.agents[agent.ID]; ok {
+	existing, replacing := m.agents[agent.ID]
+	if replacing && existing.Group != agent.Group {
+		// IDs are global; never let one group displace another's agent
+		m.mu.Unlock()
+		agentIDRejected.Inc()
+		log.Printf("WARNING: Rejected agent %s from %s: ID in use by another group", agent.ID, conn.RemoteAddr())
+		rejectRegister(conn, "agent id already connected")
+		return
+	}
+	if !replacing && m.config.MaxAgents > 0 && len(m.agents) >= m.config.MaxAgents {
+		m.mu.Unlock()
+		agentsAtCapacity.Inc()
+		log.Printf("WARNING: Rejected agent %s from %s: capacity reached (%d agents)",
+			agent.ID, conn.RemoteAddr(), m.config.MaxAgents)
+		rejectRegister(conn, "capacity reached")
+		return
+	}
+	if replacing {
+		if m.config.RejectDuplicateIDs {
+			m.mu.Unlock()
+			agentIDRejected.Inc()
+			log.Printf("WARNING: Rejected duplicate agent ID %s from %s (already connected from %s)",
+				agent.ID, conn.RemoteAddr(), existing.Conn.RemoteAddr())
+			rejectRegister(conn, "agent id already connected")
+			return
+		}
+
+		// Two machines sharing an ID keep kicking each other off
+		if time.Since(m.lastRegistered[agent.ID]) < m.config.FlapWindow {
+			agentIDFlaps.Inc()
+			log.Printf("WARNING: Agent ID %s re-registered from %s within %v while connected from %s; multiple agents may share this ID",
+				agent.ID, conn.RemoteAddr(), m.config.FlapWindow, existing.Conn.RemoteAddr())
+		}
+
+		// Close existing connection
 		existing.Conn.Close()
 	}
+	resumed := m.resume(agent)
+	if replacing && m.config.OfflineGrace > 0 {
+		// Usually the old connection died without the hub noticing yet;
+		// its requests continue on this one
+		agent.adopt(existing)
+	}
 	m.agents[agent.ID] = agent
+	m.lastRegistered[agent.ID] = time.Now()
+	agentsConnected.Set(int64(len(m.agents)))
 	m.mu.Unlock()
 
-	log.Printf("Agent registered: %s from %s", agent.ID, conn.RemoteAddr())
+	log.Printf("Agent registered: %s from %s (agent %s, opencode %s)",
+		agent.ID, conn.RemoteAddr(), agent.Version, agent.OpenCodeVersion)
+	m.config.Webhooks.Fire(webhooks.Event{Type: webhooks.EventAgentConnected, AgentID: agent.ID})
+	if !replacing && !resumed {
+		m.notifyPresence(agent, true)
+	}
 
 	// Send success response
 	conn.WriteJSON(Message{
@@ -170,18 +350,45 @@ func (m *Manager) HandleAgentWebSocket(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Manager) readPump(agent *Agent) {
+	var readErr error
+	deregistered := false
 	defer func() {
 		m.mu.Lock()
-		delete(m.agents, agent.ID)
+		// A replacement connection may already own this ID
+		current := m.agents[agent.ID] == agent
+		if current {
+			delete(m.agents, agent.ID)
+		}
+		// A deregistration or clean close is an agent shutting down, not a blip
+		held := current && m.config.OfflineGrace > 0 && !deregistered && !websocket.IsCloseError(readErr, websocket.CloseNormalClosure)
+		if held {
+			m.suspect(agent)
+		}
+		agentsConnected.Set(int64(len(m.agents)))
+		m.lastSeen = time.Now()
 		m.mu.Unlock()
 		agent.Conn.Close()
-		close(agent.send)
-		log.Printf("Agent disconnected: %s", agent.ID)
+		agent.closeSend()
+		if held {
+			log.Printf("Agent connection lost: %s, holding its requests for %v", agent.ID, m.config.OfflineGrace)
+			return
+		}
+		agent.failRequests()
+		if deregistered {
+			log.Printf("Agent deregistered: %s (clean shutdown)", agent.ID)
+		} else {
+			log.Printf("Agent disconnected: %s", agent.ID)
+		}
+		m.config.Webhooks.Fire(webhooks.Event{Type: webhooks.EventAgentDisconnected, AgentID: agent.ID})
+		if current {
+			m.notifyPresence(agent, false)
+		}
 	}()
 
 	for {
 		_, data, err := agent.Conn.ReadMessage()
 		if err != nil {
+			readErr = err
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("Agent read error: %v", err)
 			}
@@ -193,6 +400,23 @@ func (m *Manager) readPump(agent *Agent) {
 			log.Printf("Agent invalid message: %v", err)
 			continue
 		}
+		m.dump("<-", agent.ID, &msg)
+
+		// Large messages arrive in chunks under the read limit
+		if msg.Type == MsgTypeChunk {
+			full := agent.reassemble(&msg)
+			if full == nil {
+				continue
+			}
+			msg = *full
+		}
+
+		// Remove a departing agent now rather than when its socket closes
+		if msg.Type == MsgTypeDeregister {
+			deregistered = true
+			agentsDeregistered.Inc()
+			return
+		}
 
 		m.handleAgentMessage(agent, &msg)
 	}
@@ -214,6 +438,7 @@ func (m *Manager) writePump(agent *Agent) {
 				return
 			}
 
+			m.dumpRaw("->", agent.ID, message)
 			if err := agent.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
@@ -234,19 +459,46 @@ func (m *Manager) handleAgentMessage(agent *Agent, msg *Message) {
 		agent.LastSeen = time.Now()
 		agent.mu.Unlock()
 
-	case MsgTypeResponse, MsgTypeStream, MsgTypeStreamEnd, MsgTypeError:
+	case MsgTypeDraining:
+		agent.mu.Lock()
+		agent.Draining = true
+		agent.mu.Unlock()
+		log.Printf("Agent draining: %s", agent.ID)
+
+	case MsgTypeInfo:
+		var info InfoPayload
+		if err := json.Unmarshal(msg.Payload, &info); err != nil {
+			log.Printf("Agent %s invalid info payload: %v", agent.ID, err)
+			return
+		}
+		agent.mu.Lock()
+		agent.Version = info.Version
+		agent.OpenCodeVersion = info.OpenCodeVersion
+		agent.mu.Unlock()
+
+	case MsgTypeProjectStatus:
+		select {
+		case m.projectStatus <- ProjectStatusEvent{AgentID: agent.ID, Group: agent.Group, Payload: msg.Payload}:
+		default:
+			log.Printf("Project status channel full, dropping update from agent %s", agent.ID)
+		}
+
+	case MsgTypeResponse, MsgTypeStream, MsgTypeStreamEnd, MsgTypeStreamUsage, MsgTypeError:
 		// Route to waiting request
+		// The send happens under the lock so Forward's cleanup, which
+		// drops the request before closing its channel, can't close it mid-send
 		if msg.ID != "" {
 			agent.mu.RLock()
-			ch, ok := agent.requests[msg.ID]
-			agent.mu.RUnlock()
-			if ok {
+			if ch, ok := agent.requests[msg.ID]; ok {
 				select {
 				case ch <- msg:
+					responseQueueHighWater.SetMax(int64(len(ch)))
 				default:
+					responseQueueFull.Inc()
 					log.Printf("Agent response channel full for request: %s", msg.ID)
 				}
 			}
+			agent.mu.RUnlock()
 		}
 	}
 }
@@ -261,7 +513,7 @@ func (m *Manager) Forward(ctx context.Context, agentID string, requestID string,
 		return nil, ErrAgentNotFound
 	}
 
-	responseCh := make(chan *Message, 100)
+	responseCh := make(chan *Message, m.config.ResponseQueueSize)
 
 	agent.mu.Lock()
 	agent.requests[requestID] = responseCh
@@ -275,28 +527,100 @@ func (m *Manager) Forward(ctx context.Context, agentID string, requestID string,
 	}
 
 	data, _ := json.Marshal(msg)
-	select {
-	case agent.send <- data:
-	default:
+	if err := agent.enqueue(data); err != nil {
 		agent.mu.Lock()
 		delete(agent.requests, requestID)
 		agent.mu.Unlock()
 		close(responseCh)
-		return nil, errors.New("agent send buffer full")
+		return nil, err
 	}
 
-	// Cleanup when context done
+	// Cleanup when context done, or when the request outlives
+	// RequestLifetime: the agent is told to stop and the handler gets an error
 	go func() {
-		<-ctx.Done()
-		agent.mu.Lock()
-		delete(agent.requests, requestID)
-		agent.mu.Unlock()
+		lifetime := time.NewTimer(m.config.RequestLifetime)
+		defer lifetime.Stop()
+		select {
+		case <-ctx.Done():
+		case <-lifetime.C:
+			requestsExpired.Inc()
+			log.Printf("Request %s to agent %s still pending after %v, abandoning it", requestID, agentID, m.config.RequestLifetime)
+			m.Cancel(agentID, requestID)
+			payload := MustMarshal(map[string]string{
+				"error": "request exceeded its maximum lifetime",
+				"code":  CodeRequestExpired,
+			})
+			select {
+			case responseCh <- &Message{Type: MsgTypeError, ID: requestID, Payload: payload}:
+			default:
+				responseQueueFull.Inc()
+			}
+		}
+		m.dropRequest(agent, requestID)
 		close(responseCh)
 	}()
 
 	return responseCh, nil
 }
 
+// Cancel asks an agent to abort an in-flight request
+func (m *Manager) Cancel(agentID string, requestID string) error {
+	m.mu.RLock()
+	agent, ok := m.agents[agentID]
+	m.mu.RUnlock()
+
+	if !ok {
+		return ErrAgentNotFound
+	}
+
+	data, _ := json.Marshal(Message{Type: MsgTypeCancel, ID: requestID})
+	return agent.enqueue(data)
+}
+
+// enqueue queues data for the agent's write pump without blocking. The
+// connection may have just closed, so the send happens under mu, which
+// closeSend takes before closing the channel.
+func (a *Agent) enqueue(data []byte) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.sendClosed {
+		return ErrAgentOffline
+	}
+	select {
+	case a.send <- data:
+		sendQueueHighWater.SetMax(int64(len(a.send)))
+		return nil
+	default:
+		sendQueueFull.Inc()
+		return errSendQueueFull
+	}
+}
+
+// closeSend closes the agent's send channel, ending its write pump
+func (a *Agent) closeSend() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sendClosed = true
+	close(a.send)
+}
+
+// authenticate returns the group an agent token grants. Without an
+// AgentToken, tokens matching no group join the default group.
+func (m *Manager) authenticate(token string) (string, bool) {
+	for groupToken, group := range m.config.AgentGroups {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(groupToken)) == 1 {
+			return group, true
+		}
+	}
+	if m.config.LiveAgentToken != nil {
+		return "", m.config.LiveAgentToken.Valid(token)
+	}
+	if m.config.AgentToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(m.config.AgentToken)) == 1 {
+		return "", true
+	}
+	return "", false
+}
+
 // GetAgent returns an agent by ID
 func (m *Manager) GetAgent(agentID string) (*Agent, bool) {
 	m.mu.RLock()
@@ -305,16 +629,84 @@ func (m *Manager) GetAgent(agentID string) (*Agent, bool) {
 	return agent, ok
 }
 
-// GetAnyAgent returns any available agent
-func (m *Manager) GetAnyAgent() (*Agent, bool) {
+// GetGroupAgent returns an agent by ID if it belongs to group, so a client
+// can't reach another group's agent by naming it
+func (m *Manager) GetGroupAgent(group, agentID string) (*Agent, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	agent, ok := m.agents[agentID]
+	if !ok || agent.Group != group {
+		return nil, false
+	}
+	return agent, true
+}
+
+// GetAnyAgent returns any available agent in group, skipping agents that
+// are draining
+func (m *Manager) GetAnyAgent(group string) (*Agent, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	for _, agent := range m.agents {
-		return agent, true
+		if agent.Group == group && !agent.IsDraining() {
+			return agent, true
+		}
 	}
 	return nil, false
 }
 
+// GetAgentForProject returns a connected agent of group that registered
+// projectPath and isn't draining
+func (m *Manager) GetAgentForProject(group, projectPath string) (*Agent, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, agent := range m.agents {
+		if agent.Group == group && !agent.IsDraining() && slices.Contains(agent.Projects, projectPath) {
+			return agent, true
+		}
+	}
+	return nil, false
+}
+
+// failRequests ends every pending request with a CodeAgentDisconnected error.
+// The new connection after a reconnect knows nothing of these requests, so
+// without this the waiting handlers would hang until their contexts end.
+func (a *Agent) failRequests() {
+	payload := MustMarshal(map[string]string{
+		"error": "agent disconnected",
+		"code":  CodeAgentDisconnected,
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, ch := range a.requests {
+		select {
+		case ch <- &Message{Type: MsgTypeError, ID: id, Payload: payload}:
+		default:
+			responseQueueFull.Inc()
+			log.Printf("Agent response channel full, cannot fail request: %s", id)
+		}
+		delete(a.requests, id)
+	}
+}
+
+// IsDraining reports whether the agent announced it is draining
+func (a *Agent) IsDraining() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Draining
+}
+
+// LastAgentSeen returns when an agent was last connected: now if any agent
+// is connected, zero if none ever has
+func (m *Manager) LastAgentSeen() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.agents) > 0 {
+		return time.Now()
+	}
+	return m.lastSeen
+}
+
 // ListAgents returns all connected agent IDs
 func (m *Manager) ListAgents() []string {
 	m.mu.RLock()
@@ -325,3 +717,37 @@ func (m *Manager) ListAgents() []string {
 	}
 	return ids
 }
+
+// AgentInfo describes a connected agent for the /agents endpoint
+type AgentInfo struct {
+	ID              string    `json:"id"`
+	Group           string    `json:"group,omitempty"`
+	Version         string    `json:"version"`
+	OpenCodeVersion string    `json:"opencodeVersion,omitempty"`
+	Capabilities    []string  `json:"capabilities"`
+	Projects        []string  `json:"projects,omitempty"`
+	LastSeen        time.Time `json:"lastSeen"`
+	Draining        bool      `json:"draining"`
+}
+
+// ListAgentInfo returns details of all connected agents
+func (m *Manager) ListAgentInfo() []AgentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	infos := make([]AgentInfo, 0, len(m.agents))
+	for _, a := range m.agents {
+		a.mu.RLock()
+		infos = append(infos, AgentInfo{
+			ID:              a.ID,
+			Group:           a.Group,
+			Version:         a.Version,
+			OpenCodeVersion: a.OpenCodeVersion,
+			Capabilities:    a.Capabilities,
+			Projects:        a.Projects,
+			LastSeen:        a.LastSeen,
+			Draining:        a.Draining,
+		})
+		a.mu.RUnlock()
+	}
+	return infos
+}
@@ -0,0 +1,202 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testAgent registers an agent on m with no connection; its send queue is
+// read by nothing
+func testAgent(m *Manager, id string) *Agent {
+	agent := &Agent{
+		ID:       id,
+		send:     make(chan []byte, m.config.SendQueueSize),
+		requests: make(map[string]chan *Message),
+	}
+	m.mu.Lock()
+	m.agents[id] = agent
+	m.mu.Unlock()
+	return agent
+}
+
+func pending(agent *Agent) int {
+	agent.mu.RLock()
+	defer agent.mu.RUnlock()
+	return len(agent.requests)
+}
+
+func TestForwardRemovesCompletedStream(t *testing.T) {
+	m := NewManager(&Config{})
+	agent := testAgent(m, "agent-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := m.Forward(ctx, agent.ID, "req-1", &RequestPayload{Action: "prompt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := pending(agent); n != 1 {
+		t.Fatalf("%d requests pending after Forward, want 1", n)
+	}
+
+	for _, typ := range []string{MsgTypeStream, MsgTypeStream, MsgTypeStreamEnd} {
+		m.handleAgentMessage(agent, &Message{Type: typ, ID: "req-1"})
+	}
+	for msg := range ch {
+		if msg.Type == MsgTypeStreamEnd {
+			// The handler is done with the request once its stream ends
+			cancel()
+		}
+	}
+
+	if n := pending(agent); n != 0 {
+		t.Errorf("%d requests pending after the stream completed, want 0", n)
+	}
+}
+
+func TestForwardFullQueue(t *testing.T) {
+	m := NewManager(&Config{SendQueueSize: 1})
+	agent := testAgent(m, "agent-1")
+
+	ctx := context.Background()
+	if _, err := m.Forward(ctx, agent.ID, "req-1", &RequestPayload{Action: "prompt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Forward(ctx, agent.ID, "req-2", &RequestPayload{Action: "prompt"}); err != errSendQueueFull {
+		t.Errorf("Forward to a full queue = %v, want %v", err, errSendQueueFull)
+	}
+	agent.mu.RLock()
+	_, queued := agent.requests["req-2"]
+	agent.mu.RUnlock()
+	if queued {
+		t.Error("request refused for a full queue left pending")
+	}
+}
+
+func TestCancelAfterDisconnect(t *testing.T) {
+	m := NewManager(&Config{})
+	agent := testAgent(m, "agent-1")
+
+	agent.closeSend()
+	if err := m.Cancel(agent.ID, "req-1"); err != ErrAgentOffline {
+		t.Errorf("Cancel on a closed agent = %v, want %v", err, ErrAgentOffline)
+	}
+	if _, err := m.Forward(context.Background(), agent.ID, "req-2", &RequestPayload{Action: "prompt"}); err != ErrAgentOffline {
+		t.Errorf("Forward to a closed agent = %v, want %v", err, ErrAgentOffline)
+	}
+}
+
+// TestCancelRacesDisconnect cancels while the connection closes, as a
+// request's lifetime running out can; run with -race
+func TestCancelRacesDisconnect(t *testing.T) {
+	m := NewManager(&Config{SendQueueSize: 1024})
+	agent := testAgent(m, "agent-1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Cancel(agent.ID, "req-1")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	agent.closeSend()
+	wg.Wait()
+}
+
+// registerWith connects an agent to m's websocket handler and returns the
+// hub's answer to its registration
+func registerWith(t *testing.T, m *Manager, agentID, token string) RegisteredPayload {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(m.HandleAgentWebSocket))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.WriteJSON(Message{
+		Type:    MsgTypeRegister,
+		Payload: MustMarshal(RegisterPayload{AgentID: agentID, Token: token, Projects: []string{"/work/app"}}),
+	})
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != MsgTypeRegistered {
+		t.Fatalf("register %s: %v %s", agentID, err, msg.Type)
+	}
+	var registered RegisteredPayload
+	if err := json.Unmarshal(msg.Payload, &registered); err != nil {
+		t.Fatal(err)
+	}
+	return registered
+}
+
+func groupManager() *Manager {
+	return NewManager(&Config{
+		AgentToken:  "default-token",
+		AgentGroups: map[string]string{"token-a": "team-a", "token-b": "team-b"},
+	})
+}
+
+func TestGroupIsolation(t *testing.T) {
+	m := groupManager()
+	if reg := registerWith(t, m, "agent-a", "token-a"); !reg.Success {
+		t.Fatalf("register: %s", reg.Error)
+	}
+
+	if agent, ok := m.GetAnyAgent("team-a"); !ok || agent.ID != "agent-a" {
+		t.Error("team-a can't reach its own agent")
+	}
+	if _, ok := m.GetGroupAgent("team-a", "agent-a"); !ok {
+		t.Error("team-a can't reach agent-a by ID")
+	}
+
+	// Team B neither sees agent-a nor reaches it by name or project
+	for _, group := range []string{"team-b", ""} {
+		if agent, ok := m.GetAnyAgent(group); ok {
+			t.Errorf("group %q got %s from GetAnyAgent", group, agent.ID)
+		}
+		if _, ok := m.GetGroupAgent(group, "agent-a"); ok {
+			t.Errorf("group %q reached agent-a by ID", group)
+		}
+		if _, ok := m.GetAgentForProject(group, "/work/app"); ok {
+			t.Errorf("group %q reached agent-a by project", group)
+		}
+	}
+}
+
+func TestGroupCannotTakeOverAgentID(t *testing.T) {
+	m := groupManager()
+	if reg := registerWith(t, m, "agent-a", "token-a"); !reg.Success {
+		t.Fatalf("register: %s", reg.Error)
+	}
+	original, _ := m.GetAgent("agent-a")
+
+	reg := registerWith(t, m, "agent-a", "token-b")
+	if reg.Success || reg.Error != "agent id already connected" {
+		t.Errorf("team-b registering agent-a: %+v, want rejected", reg)
+	}
+	agent, ok := m.GetAgent("agent-a")
+	if !ok || agent != original || agent.Group != "team-a" {
+		t.Error("team-b displaced team-a's agent")
+	}
+	if _, ok := m.GetAnyAgent("team-b"); ok {
+		t.Error("rejected agent joined team-b")
+	}
+
+	// The same group may still replace its own agent
+	if reg := registerWith(t, m, "agent-a", "token-a"); !reg.Success {
+		t.Errorf("team-a re-registering agent-a: %s", reg.Error)
+	}
+}
@@ -8,19 +8,66 @@ import (
 // Message types for Agent ↔ Hub communication
 const (
 	// Agent → Hub
-	MsgTypeRegister  = "agent.register"
-	MsgTypePong      = "agent.pong"
-	MsgTypeResponse  = "agent.response"
-	MsgTypeStream    = "agent.stream"
-	MsgTypeStreamEnd = "agent.stream.end"
-	MsgTypeError     = "agent.error"
+	MsgTypeRegister    = "agent.register"
+	MsgTypePong        = "agent.pong"
+	MsgTypeResponse    = "agent.response"
+	MsgTypeStream      = "agent.stream"
+	MsgTypeStreamEnd   = "agent.stream.end"
+	MsgTypeStreamUsage = "agent.stream.usage" // A prompt reply's token and cost usage, before its stream end
+	MsgTypeError       = "agent.error"
+	MsgTypeDraining    = "agent.draining"   // Agent stops taking new requests before a restart
+	MsgTypeDeregister  = "agent.deregister" // Agent is shutting down cleanly, sent just before it closes
+
+	MsgTypeProjectStatus = "agent.project.status.changed" // Unsolicited project status change
+	MsgTypeChunk         = "agent.chunk"                  // Fragment of a message too large for one frame
+	MsgTypeInfo          = "agent.info"                   // Refreshed version info after registration
 
 	// Hub → Agent
 	MsgTypeRegistered = "agent.registered"
 	MsgTypePing       = "agent.ping"
 	MsgTypeRequest    = "agent.request"
+	MsgTypeCancel     = "agent.cancel" // Abort an in-flight request by ID
 )
 
+// ProtocolVersion is the newest tunnel protocol the hub speaks. Agents that
+// don't send one are treated as version 1.
+const ProtocolVersion = 1
+
+// CodeAgentDisconnected marks the agent.error the hub synthesizes for
+// requests still pending when their agent's connection drops
+const CodeAgentDisconnected = "agent_disconnected"
+
+// CodeRequestExpired marks the agent.error the hub synthesizes for a request
+// still pending after Config.RequestLifetime
+const CodeRequestExpired = "request_expired"
+
+// ChunkPayload carries one fragment of a message the agent split because it
+// exceeded the frame size limit. Fragments share the original message ID;
+// the hub concatenates Data in Seq order and handles the result as a
+// message of Type once Last arrives.
+type ChunkPayload struct {
+	Type string `json:"type"`
+	Seq  int    `json:"seq"`
+	Last bool   `json:"last"`
+	Data []byte `json:"data"` // Base64 in JSON
+}
+
+// ProjectStatusEvent is a project status change pushed by an agent.
+// Payload is the agent's {"project": ...} message payload.
+type ProjectStatusEvent struct {
+	AgentID string
+	Group   string
+	Payload json.RawMessage
+}
+
+// PresenceEvent reports an agent coming online or going offline. An agent
+// replacing a connection with the same ID doesn't go offline.
+type PresenceEvent struct {
+	AgentID string
+	Group   string
+	Online  bool
+}
+
 // Message represents a tunnel protocol message
 type Message struct {
 	Type    string          `json:"type"`
@@ -34,12 +81,25 @@ type RegisterPayload struct {
 	Token        string   `json:"token"`
 	Capabilities []string `json:"capabilities"` // ["opencode", "pty", "file"]
 	Version      string   `json:"version"`
+
+	OpenCodeVersion string `json:"opencodeVersion,omitempty"` // Empty if OpenCode was unreachable
+	ProtocolVersion int    `json:"protocolVersion,omitempty"`
+
+	Projects []string `json:"projects,omitempty"` // Project paths of a multi-project agent
+}
+
+// InfoPayload is sent by Agent when its version info changes after registration
+type InfoPayload struct {
+	Version         string `json:"version"`
+	OpenCodeVersion string `json:"opencodeVersion,omitempty"`
 }
 
 // RegisteredPayload is sent by Hub to confirm registration
 type RegisteredPayload struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+
+	ProtocolVersion int `json:"protocolVersion,omitempty"` // Newest protocol the hub speaks
 }
 
 // RequestPayload is sent by Hub to forward a client request
@@ -48,8 +108,16 @@ type RequestPayload struct {
 	Action      string          `json:"action"` // "prompt", "session.create", "session.list"
 	Data        json.RawMessage `json:"data"`
 	ProjectPath string          `json:"projectPath,omitempty"`
+	BaseURL     string          `json:"baseUrl,omitempty"`  // Explicit OpenCode server, validated by the agent
+	Priority    string          `json:"priority,omitempty"` // PriorityHigh or PriorityLow, agent defaults by action
 }
 
+// Request priorities; agents limiting concurrent requests serve high first
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
 // StreamPayload is sent by Agent for streaming responses
 type StreamPayload struct {
 	RequestID string          `json:"requestId"`
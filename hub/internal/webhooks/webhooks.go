@@ -0,0 +1,126 @@
+// Package webhooks posts hub events to an external HTTP endpoint
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/openvibe/hub/internal/metrics"
+)
+
+// Event types
+const (
+	EventAgentConnected     = "agent.connected"
+	EventAgentDisconnected  = "agent.disconnected"
+	EventClientConnected    = "client.connected"
+	EventClientDisconnected = "client.disconnected"
+	EventPromptStarted      = "prompt.started"
+	EventPromptCompleted    = "prompt.completed"
+)
+
+const (
+	// queueSize is how many events wait for delivery before new ones are dropped
+	queueSize = 256
+	// maxAttempts is how many times an event is posted before giving up
+	maxAttempts = 3
+	// retryDelay is the wait before the first retry, doubling after each
+	retryDelay = time.Second
+	// requestTimeout bounds each POST
+	requestTimeout = 5 * time.Second
+)
+
+var (
+	delivered = metrics.NewCounter("webhooks_delivered_total")
+	failed    = metrics.NewCounter("webhooks_failed_total")
+	dropped   = metrics.NewCounter("webhooks_dropped_total")
+)
+
+// Event is the JSON body of a webhook
+type Event struct {
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	AgentID   string `json:"agentId,omitempty"`
+	Client    string `json:"client,omitempty"` // Client remote address
+	SessionID string `json:"sessionId,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Dispatcher delivers events in the background. A nil Dispatcher ignores
+// events, so callers need not check whether webhooks are configured.
+type Dispatcher struct {
+	url        string
+	httpClient *http.Client
+	queue      chan Event
+}
+
+// New returns a dispatcher posting to url, or nil if url is empty
+func New(url string) *Dispatcher {
+	if url == "" {
+		return nil
+	}
+	d := &Dispatcher{
+		url:        url,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		queue:      make(chan Event, queueSize),
+	}
+	go d.run()
+	return d
+}
+
+// Fire queues an event without blocking. Events are dropped if the queue
+// is full.
+func (d *Dispatcher) Fire(e Event) {
+	if d == nil {
+		return
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+	select {
+	case d.queue <- e:
+	default:
+		dropped.Inc()
+		log.Printf("Webhook queue full, dropping %s event", e.Type)
+	}
+}
+
+func (d *Dispatcher) run() {
+	for e := range d.queue {
+		d.deliver(e)
+	}
+}
+
+// deliver posts e, retrying with backoff
+func (d *Dispatcher) deliver(e Event) {
+	body, _ := json.Marshal(e)
+	delay := retryDelay
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = d.post(body); err == nil {
+			delivered.Inc()
+			return
+		}
+		if attempt < maxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	failed.Inc()
+	log.Printf("Webhook %s failed after %d attempts: %v", e.Type, maxAttempts, err)
+}
+
+func (d *Dispatcher) post(body []byte) error {
+	resp, err := d.httpClient.Post(d.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}